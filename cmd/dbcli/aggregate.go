@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+func runAggregate(db *engine.Driver, args []string) error {
+	fs := flag.NewFlagSet("aggregate", flag.ContinueOnError)
+	by := fs.String("by", "", "comma separated fields to group by")
+	if err := fs.Parse(args); err != nil || fs.NArg() < 1 {
+		return errUsage
+	}
+
+	var accs []engine.Accumulator
+	for _, spec := range fs.Args()[1:] {
+		op, field, ok := strings.Cut(spec, ":")
+		if !ok || field == "" {
+			return fmt.Errorf("invalid accumulator %q, expected op:field", spec)
+		}
+		switch op {
+		case "sum":
+			accs = append(accs, engine.Sum(field))
+		case "avg":
+			accs = append(accs, engine.Avg(field))
+		case "min":
+			accs = append(accs, engine.Min(field))
+		case "max":
+			accs = append(accs, engine.Max(field))
+		default:
+			return fmt.Errorf("unknown accumulator %q", op)
+		}
+	}
+
+	agg := db.Aggregate(fs.Arg(0))
+	if *by != "" {
+		agg.GroupBy(strings.Split(*by, ",")...)
+	}
+	groups, err := agg.Run(accs...)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, g := range groups {
+		if err := enc.Encode(g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// isArchivePath reports whether a backup target names an archive stream
+// rather than a directory
+func isArchivePath(p string) bool {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar"} {
+		if strings.HasSuffix(p, ext) {
+			return true
+		}
+	}
+	return p == "-"
+}
+
+// archiveFlags registers the flags shared by the archive commands
+func archiveFlags(fs *flag.FlagSet) *string {
+	return fs.String("key-file", "", "file holding the hex encoded 16, 24 or 32 byte encryption key")
+}
+
+// readKey loads the key named by -key-file, if any
+func readKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func runBackup(db *engine.Driver, args []string) error {
+	if len(args) > 0 && args[0] == "verify" {
+		return runBackupVerify(args[1:])
+	}
+
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	compression := fs.String("compress", "gzip", "archive compression: gzip or none")
+	keyFile := archiveFlags(fs)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	dest := fs.Arg(0)
+	if !isArchivePath(dest) && key == nil {
+		return db.Backup(dest)
+	}
+
+	out := os.Stdout
+	if dest != "-" {
+		f, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	bw := bufio.NewWriter(out)
+	if err := db.BackupArchive(bw, engine.ArchiveOptions{Compression: *compression, Key: key}); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// runBackupVerify checks an archive against its manifest and prints what
+// it holds
+func runBackupVerify(args []string) error {
+	fs := flag.NewFlagSet("backup verify", flag.ContinueOnError)
+	keyFile := archiveFlags(fs)
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	in, closeIn, err := openInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	manifest, err := engine.VerifyBackup(in, engine.ArchiveOptions{Key: key})
+	if err != nil {
+		return err
+	}
+	return printManifest(os.Stdout, manifest)
+}
+
+func printManifest(w io.Writer, m *engine.Manifest) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "backup ok: engine %s, taken %s\n", m.EngineVersion, m.Created.Format("2006-01-02 15:04:05 MST"))
+	for _, c := range m.Collections {
+		fmt.Fprintf(bw, "  %-24s %8d records %8d files\n", c.Name, c.Records, len(c.Files))
+	}
+	return bw.Flush()
+}
+
+func runRestore(db *engine.Driver, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	keyFile := archiveFlags(fs)
+	verifyOnly := fs.Bool("verify-only", false, "restore an archive into a temporary directory and check it, leaving the database alone")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	src := fs.Arg(0)
+	if !isArchivePath(src) && !*verifyOnly {
+		if info, err := os.Stat(src); err != nil || info.IsDir() {
+			return db.Restore(src)
+		}
+	}
+
+	in, closeIn, err := openInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+	if *verifyOnly {
+		return rehearseRestore(db, in, key)
+	}
+	return db.RestoreArchive(in, engine.ArchiveOptions{Key: key})
+}
+
+// rehearseRestore prints the outcome of a restore rehearsal, failing when
+// it found problems
+func rehearseRestore(db *engine.Driver, in io.Reader, key []byte) error {
+	report, err := db.RehearseRestore(in, engine.ArchiveOptions{Key: key})
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(bw, "rehearsed restore of backup taken %s by engine %s\n",
+		report.Manifest.Created.Format("2006-01-02 15:04:05 MST"), report.Manifest.EngineVersion)
+	for _, c := range report.Collections {
+		mark := "ok"
+		if c.Restored != c.Expected {
+			mark = "MISMATCH"
+		}
+		fmt.Fprintf(bw, "  %-24s %8d of %8d records  %s\n", c.Name, c.Restored, c.Expected, mark)
+	}
+	for _, v := range report.Violations {
+		fmt.Fprintln(bw, "  violation:", v)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("rehearsal found problems; the backup would not restore cleanly")
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+// Command dbcli inspects and edits a database directory from the shell.
+//
+// Usage:
+//
+//	dbcli [-dir path] [-v] [-backlog n] [-replica-of host:port] <command> [arguments]
+//
+// With -replica-of the database is a read-only replica kept in step with
+// the primary served at that address for as long as the command runs,
+// which is mostly useful with serve. The primary's serve should then keep
+// a -backlog of changes, so that replicas can catch up after a disconnect
+// without copying the whole database again.
+//
+// Commands:
+//
+//	get <collection> <resource>            print a record
+//	put <collection> <resource> [file]     write a record from file or stdin
+//	delete <collection> <resource>         remove a record
+//	ls [collection]                        list collections, or resources in one
+//	query <collection> [field=value ...]   print records matching every condition
+//	export [-format f] [collection]        print a collection (jsonl, csv or archive)
+//	import [-format f] <collection> [file] load records from file or stdin
+//	quality [-json] <collection>           report per-field data quality
+//	aggregate [-by fields] <collection> [op:field ...]
+//	                                       count records per group, with sum, avg, min or max of fields
+//	backup [-compress c] [-key-file f] <dir|file.tar.gz|->
+//	                                       take a snapshot of the live database
+//	backup verify [-key-file f] [file]     check an archive against its manifest
+//	restore [-key-file f] [-verify-only] <dir|file.tar.gz|->
+//	                                       replace the database with a backup, or
+//	                                       only check that it would restore cleanly
+//	vacuum [-history] [-trash age] [-temp age]
+//	                                       reclaim space: thin history by the default
+//	                                       retention, purge trash older than age and
+//	                                       remove leftover temporary files
+//	shard <collection>                     move a collection's records into the
+//	                                       sharded directory layout
+//	serve [-addr host:port]                serve the database over gRPC, and change
+//	                                       events at /events/<collection>, until interrupted
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+type command struct {
+	usage string
+	run   func(db *engine.Driver, args []string) error
+}
+
+var commands = map[string]command{
+	"get":       {"get <collection> <resource>", runGet},
+	"put":       {"put <collection> <resource> [file]", runPut},
+	"delete":    {"delete <collection> <resource>", runDelete},
+	"ls":        {"ls [collection]", runList},
+	"query":     {"query <collection> [field=value ...]", runQuery},
+	"export":    {"export [-format jsonl|csv|archive] [collection]", runExport},
+	"quality":   {"quality [-json] <collection>", runQuality},
+	"aggregate": {"aggregate [-by field,...] <collection> [sum|avg|min|max:field ...]", runAggregate},
+	"backup":    {"backup [-compress gzip|none] [-key-file f] <dir|file.tar.gz|-> | backup verify [-key-file f] [file]", runBackup},
+	"restore":   {"restore [-key-file f] [-verify-only] <dir|file.tar.gz|->", runRestore},
+	"vacuum":    {"vacuum [-history] [-trash age] [-temp age]", runVacuum},
+	"shard":     {"shard <collection>", runShard},
+	"serve":     {"serve [-addr host:port]", runServe},
+	"import":    {"import [-format jsonl|csv|archive] [-csv-strings] [-key field] [-workers n] [-on-error skip|abort|deadletter] <collection> [file]", runImport},
+}
+
+// errUsage makes main print the usage line of the failing command
+var errUsage = errors.New("usage")
+
+func main() {
+	dir := flag.String("dir", "./data", "database directory")
+	verbose := flag.Bool("v", false, "log engine activity to stderr")
+	backlog := flag.Int("backlog", 0, "changes kept for replicas to catch up on")
+	replicaOf := flag.String("replica-of", "", "replicate the primary served at `host:port`")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "dbcli: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	var opts []engine.Option
+	if *verbose {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		opts = append(opts, engine.WithLogger(logger))
+	}
+	if *backlog > 0 {
+		opts = append(opts, engine.WithChangeBacklog(*backlog))
+	}
+	if *replicaOf != "" {
+		opts = append(opts, engine.AsReplica())
+	}
+
+	db, err := engine.New(*dir, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dbcli:", err)
+		os.Exit(1)
+	}
+
+	stopReplica := func() {}
+	if *replicaOf != "" {
+		stopReplica = replicate(db, *replicaOf)
+	}
+	err = cmd.run(db, flag.Args()[1:])
+	stopReplica()
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "usage: dbcli", cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "dbcli:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dbcli [-dir path] [-v] [-backlog n] [-replica-of host:port] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
+func runGet(db *engine.Driver, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	var doc json.RawMessage
+	if err := db.Read(args[0], args[1], &doc); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, doc, "", "\t"); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+func runPut(db *engine.Driver, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errUsage
+	}
+
+	in, closeIn, err := openInput(args[2:])
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("reading document: %w", err)
+	}
+	return db.Write(args[0], args[1], doc)
+}
+
+func runDelete(db *engine.Driver, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	return db.Delete(args[0], args[1])
+}
+
+func runShard(db *engine.Driver, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return db.ShardCollection(args[0])
+}
+
+func runList(db *engine.Driver, args []string) error {
+	var (
+		names []string
+		err   error
+	)
+	switch len(args) {
+	case 0:
+		names, err = db.Collections()
+	case 1:
+		names, err = db.List(args[0])
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func runQuery(db *engine.Driver, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+
+	var filters []engine.Filter
+	for _, cond := range args[1:] {
+		field, raw, ok := strings.Cut(cond, "=")
+		if !ok || field == "" {
+			return fmt.Errorf("invalid condition %q, expected field=value", cond)
+		}
+		filters = append(filters, engine.Equal(field, parseValue(raw)))
+	}
+
+	records, err := db.Find(args[0], engine.And(filters...))
+	if err != nil {
+		return err
+	}
+	return writeLines(os.Stdout, records)
+}
+
+func runExport(db *engine.Driver, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "jsonl", "output format: jsonl, csv or archive")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+
+	f, err := engine.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 && f != engine.Archive {
+		return errUsage
+	}
+
+	bw := bufio.NewWriter(os.Stdout)
+	if err := db.Export(fs.Arg(0), bw, f); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func runImport(db *engine.Driver, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "jsonl", "input format: jsonl, csv or archive")
+	csvStrings := fs.Bool("csv-strings", false, "keep CSV values as strings instead of inferring types")
+	key := fs.String("key", "", "field used as the resource name (default: generated id)")
+	workers := fs.Int("workers", 0, "concurrent workers (default: number of CPUs)")
+	onError := fs.String("on-error", "skip", "what to do with bad records: skip, abort or deadletter")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errUsage
+	}
+
+	f, err := engine.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	opts := engine.ImportOptions{Format: f, CSVStrings: *csvStrings, KeyField: *key, Workers: *workers}
+	switch *onError {
+	case "skip":
+		opts.OnError = engine.SkipAndReport
+	case "abort":
+		opts.OnError = engine.Abort
+	case "deadletter":
+		opts.OnError = engine.DeadLetter
+	default:
+		return fmt.Errorf("unknown error policy %q", *onError)
+	}
+
+	in, closeIn, err := openInput(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	summary, err := db.Import(fs.Arg(0), in, opts)
+	if summary != nil {
+		for _, e := range summary.Errors {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		fmt.Fprintf(os.Stderr, "imported %d of %d records (%d failed, %d dead-lettered)\n",
+			summary.Imported, summary.Total, summary.Failed, summary.DeadLettered)
+	}
+	return err
+}
+
+// openInput opens the file named in args, or stdin when there is none
+func openInput(args []string) (io.Reader, func(), error) {
+	if len(args) == 0 || args[0] == "-" {
+		return bufio.NewReader(os.Stdin), func() {}, nil
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return bufio.NewReader(f), func() { f.Close() }, nil
+}
+
+// parseValue interprets a command line value as JSON when it parses as
+// JSON, and as a plain string otherwise
+func parseValue(raw string) interface{} {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return raw
+	}
+	return v
+}
+
+// writeLines prints records as compact JSON, one per line
+func writeLines(w io.Writer, records []engine.Record) error {
+	bw := bufio.NewWriter(w)
+	for _, rec := range records {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, rec.Data); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		if _, err := bw.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+func runQuality(db *engine.Driver, args []string) error {
+	fs := flag.NewFlagSet("quality", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the full report as JSON")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	report, err := db.Quality(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(report)
+	}
+
+	fmt.Printf("%s: %d records\n\n", report.Collection, report.Records)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tPRESENT\tNULL/MISSING\tTYPES\tRANGE\tOUTLIERS\tVIOLATIONS")
+	for _, f := range report.Fields {
+		mark := ""
+		if f.Inconsistent {
+			mark = " (!)"
+		}
+		numRange := "-"
+		if f.Numeric != nil {
+			numRange = fmt.Sprintf("%g..%g", f.Numeric.Min, f.Numeric.Max)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.0f%%\t%s%s\t%s\t%d\t%d\n",
+			f.Path, f.Present, f.NullRate*100, formatTypes(f.Types), mark, numRange, len(f.Outliers), f.ViolationCount)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, f := range report.Fields {
+		for _, o := range f.Outliers {
+			fmt.Printf("\noutlier    %s in %q: %v", f.Path, o.Resource, o.Value)
+		}
+		for _, v := range f.Violations {
+			fmt.Printf("\nviolation  %s in %q: %s", f.Path, v.Resource, v.Error)
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+func formatTypes(types map[string]int) string {
+	names := make([]string, 0, len(types))
+	for t := range types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, t := range names {
+		parts[i] = fmt.Sprintf("%s:%d", t, types[t])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/dbrpc"
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+func runServe(db *engine.Driver, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:7070", "address to listen on")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	srv := dbrpc.NewServer(db)
+	fmt.Fprintf(os.Stderr, "dbcli: serving on %s (engine %s, protocol %d)\n", l.Addr(), engine.EngineVersion, dbrpc.ProtocolVersion)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// replicate follows the primary at addr in the background until the
+// returned function is called
+func replicate(db *engine.Driver, addr string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	replica := dbrpc.NewReplica(db, addr)
+	go func() {
+		defer close(done)
+		replica.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
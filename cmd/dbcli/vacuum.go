@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+func runVacuum(db *engine.Driver, args []string) error {
+	fs := flag.NewFlagSet("vacuum", flag.ContinueOnError)
+	history := fs.Bool("history", false, "thin history versions by the default retention")
+	trash := fs.Duration("trash", -1, "purge soft deleted records deleted longer ago than `age`")
+	temp := fs.Duration("temp", 0, "remove temporary files older than `age` (default 1h)")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	opts := engine.VacuumOptions{TempOlderThan: *temp}
+	if *history {
+		opts.History = engine.DefaultRetention
+	}
+	if *trash >= 0 {
+		opts.Trash, opts.TrashOlderThan = true, *trash
+	}
+	report, err := db.Vacuum(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d history versions, %d trashed records and %d temporary files, %d bytes\n",
+		report.Versions, report.Trashed, report.TempFiles, report.Bytes)
+	return nil
+}
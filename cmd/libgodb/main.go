@@ -0,0 +1,218 @@
+// Command libgodb builds the engine as a C shared library, so programs in
+// Python, Node, Rust or any language with a C FFI can embed it in-process:
+//
+//	go build -buildmode=c-shared -o libgodb.so ./cmd/libgodb
+//
+// which also writes libgodb.h. Every function returns a GODB_* status.
+// Databases are passed as the handle godb_open returns. Strings going in
+// are NUL terminated UTF-8 owned by the caller; strings coming out, the
+// documents and error messages, are allocated with malloc and must be
+// released with godb_free. Error messages are only set, when err isn't
+// NULL, for a status other than GODB_OK. A handle must not be used once
+// godb_close was called with it.
+//
+// Documents are JSON text. godb_find takes its conditions as a JSON object
+// of dotted field paths and the values they must equal, and returns a JSON
+// array of {"resource": ..., "document": ...} objects in resource order.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+enum {
+	GODB_OK = 0,
+	GODB_NOT_FOUND = 1,
+	GODB_INVALID = 2,
+	GODB_READ_ONLY = 3,
+	GODB_ERROR = 4,
+};
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+func main() {}
+
+// godb_open opens the database in dir, read-only when readOnly is not
+// zero, and stores its handle in db
+//
+//export godb_open
+func godb_open(dir *C.char, readOnly C.int, db *C.uintptr_t, errOut **C.char) C.int {
+	var opts []engine.Option
+	if readOnly != 0 {
+		opts = append(opts, engine.ReadOnly())
+	}
+	d, err := engine.New(C.GoString(dir), opts...)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	*db = C.uintptr_t(cgo.NewHandle(d))
+	return C.GODB_OK
+}
+
+// godb_close closes a database and releases its handle
+//
+//export godb_close
+func godb_close(db C.uintptr_t, errOut **C.char) C.int {
+	d, status := driver(db, errOut)
+	if d == nil {
+		return status
+	}
+	cgo.Handle(db).Delete()
+	if err := d.Close(); err != nil {
+		return fail(err, errOut)
+	}
+	return C.GODB_OK
+}
+
+// godb_put writes the JSON document doc under collection/resource
+//
+//export godb_put
+func godb_put(db C.uintptr_t, collection, resource, doc *C.char, errOut **C.char) C.int {
+	d, status := driver(db, errOut)
+	if d == nil {
+		return status
+	}
+	raw := json.RawMessage(C.GoString(doc))
+	if !json.Valid(raw) {
+		return fail(fmt.Errorf("%w: document is not valid JSON", engine.ErrValidation), errOut)
+	}
+	if err := d.Write(C.GoString(collection), C.GoString(resource), raw); err != nil {
+		return fail(err, errOut)
+	}
+	return C.GODB_OK
+}
+
+// godb_get stores in out the JSON document at collection/resource
+//
+//export godb_get
+func godb_get(db C.uintptr_t, collection, resource *C.char, out **C.char, errOut **C.char) C.int {
+	d, status := driver(db, errOut)
+	if d == nil {
+		return status
+	}
+	var doc json.RawMessage
+	if err := d.Read(C.GoString(collection), C.GoString(resource), &doc); err != nil {
+		return fail(err, errOut)
+	}
+	*out = C.CString(string(doc))
+	return C.GODB_OK
+}
+
+// godb_delete removes the record at collection/resource
+//
+//export godb_delete
+func godb_delete(db C.uintptr_t, collection, resource *C.char, errOut **C.char) C.int {
+	d, status := driver(db, errOut)
+	if d == nil {
+		return status
+	}
+	if err := d.Delete(C.GoString(collection), C.GoString(resource)); err != nil {
+		return fail(err, errOut)
+	}
+	return C.GODB_OK
+}
+
+// findResult is an element of the array godb_find returns
+type findResult struct {
+	Resource string          `json:"resource"`
+	Document json.RawMessage `json:"document"`
+}
+
+// godb_find stores in out the records of collection whose fields equal
+// every condition; NULL or empty conditions match every record
+//
+//export godb_find
+func godb_find(db C.uintptr_t, collection, conditions *C.char, out **C.char, errOut **C.char) C.int {
+	d, status := driver(db, errOut)
+	if d == nil {
+		return status
+	}
+	filter, err := conditionFilter(conditions)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	records, err := d.Find(C.GoString(collection), filter)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	results := make([]findResult, 0, len(records))
+	for _, rec := range records {
+		results = append(results, findResult{Resource: rec.Resource, Document: rec.Data})
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	*out = C.CString(string(b))
+	return C.GODB_OK
+}
+
+// godb_free releases a string returned by the library
+//
+//export godb_free
+func godb_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+// conditionFilter is the filter matching documents whose fields equal the
+// values of a JSON object of conditions
+func conditionFilter(conditions *C.char) (engine.Filter, error) {
+	if conditions == nil {
+		return nil, nil
+	}
+	raw := strings.TrimSpace(C.GoString(conditions))
+	if raw == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("%w: conditions: %v", engine.ErrValidation, err)
+	}
+	filters := make([]engine.Filter, 0, len(fields))
+	for path, v := range fields {
+		filters = append(filters, engine.Equal(path, v))
+	}
+	return engine.And(filters...), nil
+}
+
+// driver resolves a database handle
+func driver(db C.uintptr_t, errOut **C.char) (*engine.Driver, C.int) {
+	if db == 0 {
+		return nil, fail(errors.New("no database handle"), errOut)
+	}
+	d, ok := cgo.Handle(db).Value().(*engine.Driver)
+	if !ok {
+		return nil, fail(errors.New("not a database handle"), errOut)
+	}
+	return d, C.GODB_OK
+}
+
+// fail reports err through errOut and returns the status standing for it
+func fail(err error, errOut **C.char) C.int {
+	if errOut != nil {
+		*errOut = C.CString(err.Error())
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return C.GODB_NOT_FOUND
+	case errors.Is(err, engine.ErrInvalidName), errors.Is(err, engine.ErrValidation), errors.Is(err, engine.ErrDuplicate):
+		return C.GODB_INVALID
+	case errors.Is(err, engine.ErrReadOnly):
+		return C.GODB_READ_ONLY
+	}
+	return C.GODB_ERROR
+}
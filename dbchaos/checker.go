@@ -0,0 +1,251 @@
+package dbchaos
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- LINEARIZABILITY CHECKER ---
+
+// maxSearchStates bounds the checker's search of one key's history, past
+// which it gives up rather than run for hours
+const maxSearchStates = 1 << 20
+
+// OpKind is what an operation does to its key
+type OpKind int
+
+const (
+	Read OpKind = iota
+	Write
+	Delete
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Delete:
+		return "delete"
+	}
+	return fmt.Sprintf("OpKind(%d)", int(k))
+}
+
+// Outcome is what a client learnt of its operation
+type Outcome int
+
+const (
+	// OK operations took effect between their invocation and completion
+	OK Outcome = iota
+	// Failed operations certainly didn't take effect
+	Failed
+	// Unknown operations may have taken effect at any time after their
+	// invocation, as when the call timed out or its connection broke
+	Unknown
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OK:
+		return "ok"
+	case Failed:
+		return "failed"
+	case Unknown:
+		return "unknown"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// Op is an operation of a history, on a register named by Key
+type Op struct {
+	Client int
+	Kind   OpKind
+	Key    string
+	// Value is the value written or read; "" for a delete, and for a read
+	// that found nothing
+	Value    string
+	Outcome  Outcome
+	Invoke   time.Time
+	Complete time.Time
+}
+
+func (o Op) String() string {
+	return fmt.Sprintf("client %d %s %s=%q %s", o.Client, o.Kind, o.Key, o.Value, o.Outcome)
+}
+
+// NonLinearizable is the error CheckLinearizable reports
+type NonLinearizable struct {
+	Key string
+	// Ops is the history of the key, in invocation order
+	Ops []Op
+}
+
+func (e *NonLinearizable) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "history of %s is not linearizable:", e.Key)
+	for _, op := range e.Ops {
+		fmt.Fprintf(&b, "\n\t%s", op)
+	}
+	return b.String()
+}
+
+// CheckLinearizable checks that a history of operations on registers could
+// have happened one at a time, each at some instant between its invocation
+// and completion, with every read returning the latest value written. Keys
+// are independent and checked apart. It returns a *NonLinearizable for the
+// first key that fails.
+func CheckLinearizable(ops []Op) error {
+	byKey := make(map[string][]Op)
+	for _, op := range ops {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		history := byKey[k]
+		sort.SliceStable(history, func(i, j int) bool { return history[i].Invoke.Before(history[j].Invoke) })
+		ok, err := checkRegister(history)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		if !ok {
+			return &NonLinearizable{Key: k, Ops: history}
+		}
+	}
+	return nil
+}
+
+// event is an operation prepared for the search, with its times as
+// nanoseconds since the history began
+type event struct {
+	kind     OpKind
+	value    string
+	call     int64
+	ret      int64 // math.MaxInt64 for operations of unknown outcome
+	required bool  // false for those that may never have happened
+}
+
+// checkRegister searches for a linearization of one key's history, after
+// Wing and Gong: at every step, any pending operation invoked before every
+// other pending one returned may go next.
+func checkRegister(history []Op) (bool, error) {
+	observed := make(map[string]bool)
+	for _, op := range history {
+		if op.Kind == Read && op.Outcome == OK {
+			observed[op.Value] = true
+		}
+	}
+
+	var events []event
+	var base time.Time
+	for _, op := range history {
+		if base.IsZero() {
+			base = op.Invoke
+		}
+		e := event{kind: op.Kind, value: op.Value, call: int64(op.Invoke.Sub(base))}
+		switch op.Outcome {
+		case Failed:
+			continue
+		case OK:
+			e.ret, e.required = int64(op.Complete.Sub(base)), true
+		case Unknown:
+			// a read of unknown outcome tells nothing, and neither does a
+			// write no read saw: leaving it out never rules out a
+			// linearization
+			if op.Kind == Read || (op.Kind == Write && !observed[op.Value]) {
+				continue
+			}
+			e.ret = math.MaxInt64
+		}
+		events = append(events, e)
+	}
+
+	s := &registerSearch{events: events, seen: make(map[string]bool)}
+	for _, e := range events {
+		if e.required {
+			s.required++
+		}
+	}
+	done := make([]bool, len(events))
+	ok := s.search(done, 0, "")
+	if s.exhausted {
+		return false, fmt.Errorf("history too large to check, over %d states", maxSearchStates)
+	}
+	return ok, nil
+}
+
+type registerSearch struct {
+	events    []event
+	required  int
+	seen      map[string]bool // states already found to be dead ends
+	exhausted bool
+}
+
+// search tries to linearize the pending events from the register holding
+// state, with linearized of the required ones done
+func (s *registerSearch) search(done []bool, linearized int, state string) bool {
+	if linearized == s.required {
+		return true
+	}
+	key := stateKey(done, state)
+	if s.seen[key] {
+		return false
+	}
+	if len(s.seen) >= maxSearchStates {
+		s.exhausted = true
+		return false
+	}
+	s.seen[key] = true
+
+	horizon := int64(math.MaxInt64)
+	for i, e := range s.events {
+		if !done[i] && e.ret < horizon {
+			horizon = e.ret
+		}
+	}
+	for i, e := range s.events {
+		if done[i] || e.call > horizon {
+			continue
+		}
+		next := state
+		switch e.kind {
+		case Read:
+			if e.value != state {
+				continue
+			}
+		case Write:
+			next = e.value
+		case Delete:
+			next = ""
+		}
+		done[i] = true
+		n := linearized
+		if e.required {
+			n++
+		}
+		ok := s.search(done, n, next)
+		done[i] = false
+		if ok || s.exhausted {
+			return ok
+		}
+	}
+	return false
+}
+
+func stateKey(done []bool, state string) string {
+	b := make([]byte, (len(done)+7)/8, (len(done)+7)/8+len(state))
+	for i, d := range done {
+		if d {
+			b[i/8] |= 1 << (i % 8)
+		}
+	}
+	return string(append(b, state...))
+}
@@ -0,0 +1,374 @@
+// Package dbchaos tests replication under faults, in-process: a Cluster
+// is a primary served over dbrpc and replicas following it, every node
+// reached through links that faults can partition or slow down, and nodes
+// that can be crashed and restarted. Run drives a workload of clients
+// against the primary while injecting faults, then heals the cluster and
+// checks that the history the clients saw is linearizable and that every
+// replica converged on the primary's data.
+//
+// Replication is asynchronous, so reads from replicas are not expected to
+// be linearizable and the workload doesn't make them; replicas are held to
+// converging once the faults stop.
+package dbchaos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/dbrpc"
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// changeBacklog is how many changes the primary keeps for replicas to
+// resume from after a short fault
+const changeBacklog = 4096
+
+// convergePoll is how often Converge compares the replicas with the primary
+const convergePoll = 50 * time.Millisecond
+
+// Cluster is a primary and its replicas running in-process, each in a
+// directory of its own
+type Cluster struct {
+	dir string
+
+	mu       sync.Mutex
+	primary  *primaryNode // nil while crashed
+	addr     string       // where the primary listened last
+	replicas []*replicaNode
+	clients  *link
+}
+
+type primaryNode struct {
+	d      *engine.Driver
+	srv    *dbrpc.Server
+	served chan error
+}
+
+type replicaNode struct {
+	dir  string
+	link *link // from the replica to the primary
+
+	d      *engine.Driver // nil while crashed
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCluster starts a primary and n replicas in directories below dir
+func NewCluster(dir string, n int) (*Cluster, error) {
+	c := &Cluster{dir: dir}
+	if err := c.startPrimary(); err != nil {
+		return nil, err
+	}
+	target := func() string {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.addr
+	}
+	var err error
+	if c.clients, err = newLink(target); err != nil {
+		c.Close()
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		r := &replicaNode{dir: filepath.Join(dir, "replica-"+strconv.Itoa(i))}
+		if r.link, err = newLink(target); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.replicas = append(c.replicas, r)
+		if err := r.start(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close stops every node and link
+func (c *Cluster) Close() error {
+	var errs []error
+	for _, r := range c.replicas {
+		errs = append(errs, r.stop())
+		if r.link != nil {
+			r.link.close()
+		}
+	}
+	if c.clients != nil {
+		c.clients.close()
+	}
+	errs = append(errs, c.stopPrimary())
+	return errors.Join(errs...)
+}
+
+// Primary is the primary's Driver, nil while it is crashed
+func (c *Cluster) Primary() *engine.Driver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.primary == nil {
+		return nil
+	}
+	return c.primary.d
+}
+
+// Client returns a client of the primary that goes through the clients'
+// link; the caller closes it
+func (c *Cluster) Client() *dbrpc.Client {
+	return dbrpc.NewClient(c.clients.addr())
+}
+
+// Replicas is the number of replicas
+func (c *Cluster) Replicas() int {
+	return len(c.replicas)
+}
+
+func (c *Cluster) startPrimary() error {
+	d, err := engine.New(filepath.Join(c.dir, "primary"), engine.WithChangeBacklog(changeBacklog))
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		d.Close()
+		return err
+	}
+	p := &primaryNode{d: d, srv: dbrpc.NewServer(d), served: make(chan error, 1)}
+	go func() { p.served <- p.srv.Serve(l) }()
+
+	c.mu.Lock()
+	c.primary, c.addr = p, l.Addr().String()
+	c.mu.Unlock()
+	return nil
+}
+
+// stopPrimary shuts the primary down without waiting for calls in flight,
+// which the links have cut by then
+func (c *Cluster) stopPrimary() error {
+	c.mu.Lock()
+	p := c.primary
+	c.primary = nil
+	c.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p.srv.Shutdown(ctx)
+	if err := <-p.served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return p.d.Close()
+}
+
+func (r *replicaNode) start() error {
+	d, err := engine.New(r.dir, engine.AsReplica())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.d, r.cancel, r.done = d, cancel, make(chan struct{})
+	rep := dbrpc.NewReplica(d, r.link.addr())
+	go func() {
+		defer close(r.done)
+		rep.Run(ctx)
+	}()
+	return nil
+}
+
+func (r *replicaNode) stop() error {
+	if r.d == nil {
+		return nil
+	}
+	r.cancel()
+	<-r.done
+	err := r.d.Close()
+	r.d = nil
+	return err
+}
+
+// --- FAULTS ---
+
+// PartitionReplica cuts replica i off the primary until HealReplica
+func (c *Cluster) PartitionReplica(i int) { c.replicas[i].link.partition() }
+
+// DelayReplica holds back by d everything between replica i and the
+// primary until HealReplica
+func (c *Cluster) DelayReplica(i int, d time.Duration) { c.replicas[i].link.slow(d) }
+
+// HealReplica ends the partition or delay of replica i
+func (c *Cluster) HealReplica(i int) { c.replicas[i].link.heal() }
+
+// PartitionClients cuts the clients off the primary until HealClients
+func (c *Cluster) PartitionClients() { c.clients.partition() }
+
+// DelayClients holds back by d everything between the clients and the
+// primary until HealClients
+func (c *Cluster) DelayClients(d time.Duration) { c.clients.slow(d) }
+
+// HealClients ends the partition or delay of the clients
+func (c *Cluster) HealClients() { c.clients.heal() }
+
+// CrashReplica stops replica i as a crash would, until RestartReplica
+func (c *Cluster) CrashReplica(i int) error {
+	r := c.replicas[i]
+	r.link.partition()
+	err := r.stop()
+	r.link.heal()
+	return err
+}
+
+// RestartReplica starts a crashed replica i again from its directory
+func (c *Cluster) RestartReplica(i int) error {
+	if c.replicas[i].d != nil {
+		return nil
+	}
+	return c.replicas[i].start()
+}
+
+// CrashPrimary stops the primary as a crash would: the calls in flight and
+// the replicas' streams break. Nothing reaches it until RestartPrimary.
+func (c *Cluster) CrashPrimary() error {
+	c.clients.partition()
+	for _, r := range c.replicas {
+		r.link.partition()
+	}
+	err := c.stopPrimary()
+	c.clients.heal()
+	for _, r := range c.replicas {
+		r.link.heal()
+	}
+	return err
+}
+
+// RestartPrimary starts a crashed primary again from its directory, on a
+// new port the links follow. Its change feed starts a new epoch, so the
+// replicas resync.
+func (c *Cluster) RestartPrimary() error {
+	if c.Primary() != nil {
+		return nil
+	}
+	return c.startPrimary()
+}
+
+// HealAll ends every fault, restarting crashed nodes
+func (c *Cluster) HealAll() error {
+	c.HealClients()
+	if err := c.RestartPrimary(); err != nil {
+		return err
+	}
+	for i := range c.replicas {
+		c.HealReplica(i)
+		if err := c.RestartReplica(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- CONVERGENCE ---
+
+// Converge waits until every replica holds the same records as the
+// primary, as they should shortly after the faults heal, and returns ctx's
+// error, naming a replica that still differs, if they haven't by then
+func (c *Cluster) Converge(ctx context.Context) error {
+	for {
+		diff, err := c.divergence()
+		if err != nil {
+			return err
+		}
+		if diff == "" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", diff, ctx.Err())
+		case <-time.After(convergePoll):
+		}
+	}
+}
+
+// divergence describes a replica whose data differs from the primary's,
+// or returns "" when none does
+func (c *Cluster) divergence() (string, error) {
+	p := c.Primary()
+	if p == nil {
+		return "the primary is down", nil
+	}
+	want, err := dataOf(p)
+	if err != nil {
+		return "", err
+	}
+	for i, r := range c.replicas {
+		if r.d == nil {
+			return fmt.Sprintf("replica %d is down", i), nil
+		}
+		got, err := dataOf(r.d)
+		if err != nil {
+			return "", err
+		}
+		if diff := compareData(got, want); diff != "" {
+			return fmt.Sprintf("replica %d: %s", i, diff), nil
+		}
+	}
+	return "", nil
+}
+
+// dataOf reads the compacted documents of every collection of d
+func dataOf(d *engine.Driver) (map[string]map[string]string, error) {
+	collections, err := d.Collections()
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]map[string]string, len(collections))
+	for _, name := range collections {
+		records, err := d.Find(name, nil)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs := make(map[string]string, len(records))
+		for _, rec := range records {
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, rec.Data); err != nil {
+				return nil, err
+			}
+			docs[rec.Resource] = buf.String()
+		}
+		if len(docs) > 0 {
+			data[name] = docs
+		}
+	}
+	return data, nil
+}
+
+// compareData describes the first difference between got and want
+func compareData(got, want map[string]map[string]string) string {
+	for name, docs := range want {
+		for resource, doc := range docs {
+			if g, ok := got[name][resource]; !ok {
+				return fmt.Sprintf("%s/%s is missing", name, resource)
+			} else if g != doc {
+				return fmt.Sprintf("%s/%s holds %s, not %s", name, resource, g, doc)
+			}
+		}
+	}
+	for name, docs := range got {
+		for resource := range docs {
+			if _, ok := want[name][resource]; !ok {
+				return fmt.Sprintf("%s/%s should be gone", name, resource)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package dbchaos
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// --- FAULTY LINKS ---
+
+// dialTimeout bounds how long a link waits to reach its target
+const dialTimeout = time.Second
+
+// link is a TCP proxy standing for the network between two nodes, which
+// faults can cut or slow down. Every connection through it is relayed in
+// both directions.
+type link struct {
+	l      net.Listener
+	target func() string
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	cut   bool
+	delay time.Duration
+	wg    sync.WaitGroup
+}
+
+// newLink listens on a loopback port for connections to relay to the
+// address target returns when they are made
+func newLink(target func() string) (*link, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	k := &link{l: l, target: target, conns: make(map[net.Conn]bool)}
+	k.wg.Add(1)
+	go k.accept()
+	return k, nil
+}
+
+// addr is where the link listens
+func (k *link) addr() string {
+	return k.l.Addr().String()
+}
+
+func (k *link) accept() {
+	defer k.wg.Done()
+	for {
+		c, err := k.l.Accept()
+		if err != nil {
+			return
+		}
+		k.mu.Lock()
+		if k.cut {
+			k.mu.Unlock()
+			c.Close()
+			continue
+		}
+		k.conns[c] = true
+		k.wg.Add(1)
+		k.mu.Unlock()
+		go k.relay(c)
+	}
+}
+
+// relay connects c to the target and copies between them until either
+// side closes or the link is cut
+func (k *link) relay(c net.Conn) {
+	defer k.wg.Done()
+	defer k.drop(c)
+	up, err := net.DialTimeout("tcp", k.target(), dialTimeout)
+	if err != nil {
+		return
+	}
+	k.mu.Lock()
+	if k.cut {
+		k.mu.Unlock()
+		up.Close()
+		return
+	}
+	k.conns[up] = true
+	k.mu.Unlock()
+	defer k.drop(up)
+
+	done := make(chan struct{})
+	go func() {
+		k.pipe(up, c)
+		close(done)
+	}()
+	k.pipe(c, up)
+	c.Close()
+	up.Close()
+	<-done
+}
+
+// pipe relays src to dst, holding every chunk back by the link's delay
+func (k *link) pipe(dst, src net.Conn) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			k.mu.Lock()
+			delay := k.delay
+			k.mu.Unlock()
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				if tc, ok := dst.(*net.TCPConn); ok {
+					tc.CloseWrite()
+				}
+			}
+			return
+		}
+	}
+}
+
+func (k *link) drop(c net.Conn) {
+	c.Close()
+	k.mu.Lock()
+	delete(k.conns, c)
+	k.mu.Unlock()
+}
+
+// partition cuts every connection through the link and refuses new ones
+// until heal
+func (k *link) partition() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.cut = true
+	for c := range k.conns {
+		c.Close()
+	}
+}
+
+// slow holds back everything relayed from now on by d
+func (k *link) slow(d time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.delay = d
+}
+
+// heal undoes partition and slow
+func (k *link) heal() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.cut = false
+	k.delay = 0
+}
+
+// close stops the link and waits for its relays to end
+func (k *link) close() {
+	k.l.Close()
+	k.partition()
+	k.wg.Wait()
+}
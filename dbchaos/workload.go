@@ -0,0 +1,276 @@
+package dbchaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/dbrpc"
+)
+
+// --- WORKLOAD ---
+
+// chaosCollection is the collection the workload's clients use
+const chaosCollection = "chaos"
+
+// errorBackoff is how long a client waits after a failed call
+const errorBackoff = 10 * time.Millisecond
+
+// Options shape a Run; zero fields take the defaults of DefaultOptions
+type Options struct {
+	// Clients is how many clients call the primary at once
+	Clients int
+	// Keys is how many records they share, the fewer the more contention
+	Keys     int
+	Duration time.Duration
+	// FaultEvery is how often the faults change
+	FaultEvery time.Duration
+	// Timeout bounds each call, past which its outcome is unknown
+	Timeout time.Duration
+	// Seed makes the sequence of faults repeatable
+	Seed uint64
+}
+
+// DefaultOptions are a short run, enough for a test
+func DefaultOptions() Options {
+	return Options{Clients: 4, Keys: 3, Duration: 5 * time.Second, FaultEvery: 500 * time.Millisecond, Timeout: 500 * time.Millisecond}
+}
+
+// FaultKind is a fault Run injects
+type FaultKind string
+
+const (
+	PartitionReplicaFault FaultKind = "partition replica"
+	DelayReplicaFault     FaultKind = "delay replica"
+	CrashReplicaFault     FaultKind = "crash replica"
+	PartitionClientsFault FaultKind = "partition clients"
+	DelayClientsFault     FaultKind = "delay clients"
+	CrashPrimaryFault     FaultKind = "crash primary"
+)
+
+// Fault is a fault of a run, lasting until the next one
+type Fault struct {
+	Time time.Time
+	Kind FaultKind
+	// Node is the replica a replica fault hit
+	Node int
+}
+
+// Report is what a run found
+type Report struct {
+	Ops    []Op
+	Faults []Fault
+	// Linearizable is the checker's verdict on Ops, nil when they are
+	Linearizable error
+	// Converged is nil when every replica caught up with the primary
+	// once the faults healed
+	Converged error
+}
+
+// OK reports whether the run found nothing wrong
+func (r *Report) OK() bool {
+	return r.Linearizable == nil && r.Converged == nil
+}
+
+// Err joins what the run found wrong
+func (r *Report) Err() error {
+	return errors.Join(r.Linearizable, r.Converged)
+}
+
+// Run drives clients writing, reading and deleting a few shared records on
+// the primary for opts.Duration while injecting a fault every
+// opts.FaultEvery, then heals the cluster, waits for it to converge and
+// checks the clients' history. Its error is for a run that couldn't be
+// made; what the run found is in the Report.
+func (c *Cluster) Run(ctx context.Context, opts Options) (*Report, error) {
+	opts = opts.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var (
+		mu  sync.Mutex
+		ops []Op
+		wg  sync.WaitGroup
+	)
+	for i := 0; i < opts.Clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			history := c.client(ctx, i, opts)
+			mu.Lock()
+			ops = append(ops, history...)
+			mu.Unlock()
+		}(i)
+	}
+	faults, err := c.nemesis(ctx, opts)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.HealAll(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Ops: ops, Faults: faults}
+	convergeCtx, cancelConverge := context.WithTimeout(context.Background(), 10*opts.FaultEvery+5*time.Second)
+	defer cancelConverge()
+	report.Converged = c.Converge(convergeCtx)
+	report.Linearizable = CheckLinearizable(ops)
+	return report, nil
+}
+
+func (o Options) withDefaults() Options {
+	def := DefaultOptions()
+	if o.Clients <= 0 {
+		o.Clients = def.Clients
+	}
+	if o.Keys <= 0 {
+		o.Keys = def.Keys
+	}
+	if o.Duration <= 0 {
+		o.Duration = def.Duration
+	}
+	if o.FaultEvery <= 0 {
+		o.FaultEvery = def.FaultEvery
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = def.Timeout
+	}
+	return o
+}
+
+// client runs client i's calls until ctx ends and returns its history.
+// Half the calls read, most of the rest write a value never written
+// before, so reads tell which write they saw.
+func (c *Cluster) client(ctx context.Context, i int, opts Options) []Op {
+	cl := c.Client()
+	defer cl.Close()
+	rng := rand.New(rand.NewPCG(opts.Seed, uint64(i)+1))
+
+	var history []Op
+	for n := 0; ctx.Err() == nil; n++ {
+		op := Op{Client: i, Key: "k" + strconv.Itoa(rng.IntN(opts.Keys))}
+		switch p := rng.IntN(10); {
+		case p < 5:
+			op.Kind = Read
+		case p < 9:
+			op.Kind, op.Value = Write, fmt.Sprintf("c%d-%d", i, n)
+		default:
+			op.Kind = Delete
+		}
+
+		callCtx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		op.Invoke = time.Now()
+		err := call(callCtx, cl, &op)
+		op.Complete = time.Now()
+		cancel()
+		op.Outcome = outcomeOf(err)
+		history = append(history, op)
+		if err != nil {
+			time.Sleep(errorBackoff)
+		}
+	}
+	return history
+}
+
+// call makes op's call, filling in the value a read found. A delete of a
+// missing record changes nothing, so it is recorded as a read finding
+// nothing.
+func call(ctx context.Context, cl *dbrpc.Client, op *Op) error {
+	switch op.Kind {
+	case Write:
+		return cl.Put(ctx, chaosCollection, op.Key, op.Value)
+	case Delete:
+		err := cl.Delete(ctx, chaosCollection, op.Key)
+		if errors.Is(err, fs.ErrNotExist) {
+			op.Kind = Read
+			return nil
+		}
+		return err
+	}
+	var v string
+	err := cl.Get(ctx, chaosCollection, op.Key, &v)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	op.Value = v
+	return err
+}
+
+// outcomeOf tells from a call's error whether it took effect. Only the
+// server's refusals are certain; a call whose connection broke or timed
+// out may have been carried out.
+func outcomeOf(err error) Outcome {
+	if err == nil {
+		return OK
+	}
+	var rpcErr *dbrpc.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case dbrpc.InvalidArgument, dbrpc.NotFound, dbrpc.AlreadyExists, dbrpc.PermissionDenied,
+			dbrpc.FailedPrecondition, dbrpc.Unauthenticated, dbrpc.Unimplemented:
+			return Failed
+		}
+	}
+	return Unknown
+}
+
+// --- NEMESIS ---
+
+// faultDelay is how much a delay fault holds back each message
+const faultDelay = 50 * time.Millisecond
+
+// nemesis injects a fault every opts.FaultEvery until ctx ends, healing
+// each before the next. A crashed primary stays down until the next tick.
+func (c *Cluster) nemesis(ctx context.Context, opts Options) ([]Fault, error) {
+	rng := rand.New(rand.NewPCG(opts.Seed, 0))
+	kinds := []FaultKind{PartitionClientsFault, DelayClientsFault, CrashPrimaryFault}
+	if len(c.replicas) > 0 {
+		kinds = append(kinds, PartitionReplicaFault, DelayReplicaFault, CrashReplicaFault)
+	}
+
+	tick := time.NewTicker(opts.FaultEvery)
+	defer tick.Stop()
+	var faults []Fault
+	for {
+		select {
+		case <-ctx.Done():
+			return faults, nil
+		case <-tick.C:
+		}
+		if err := c.HealAll(); err != nil {
+			return faults, err
+		}
+		f := Fault{Time: time.Now(), Kind: kinds[rng.IntN(len(kinds))]}
+		if len(c.replicas) > 0 {
+			f.Node = rng.IntN(len(c.replicas))
+		}
+		if err := c.inject(f); err != nil {
+			return faults, fmt.Errorf("%s: %w", f.Kind, err)
+		}
+		faults = append(faults, f)
+	}
+}
+
+func (c *Cluster) inject(f Fault) error {
+	switch f.Kind {
+	case PartitionReplicaFault:
+		c.PartitionReplica(f.Node)
+	case DelayReplicaFault:
+		c.DelayReplica(f.Node, faultDelay)
+	case CrashReplicaFault:
+		return c.CrashReplica(f.Node)
+	case PartitionClientsFault:
+		c.PartitionClients()
+	case DelayClientsFault:
+		c.DelayClients(faultDelay)
+	case CrashPrimaryFault:
+		return c.CrashPrimary()
+	}
+	return nil
+}
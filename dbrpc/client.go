@@ -0,0 +1,368 @@
+package dbrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- CLIENT ---
+
+// Client calls a dbrpc server. It is safe for concurrent use; calls share
+// a single HTTP/2 connection.
+type Client struct {
+	base      string
+	key       string
+	transport *http.Transport
+	http      *http.Client
+
+	infoMu sync.Mutex
+	info   *ServerInfo // from the handshake, once made
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithKey sends key, as issued by engine.Driver.IssueKey, with every call,
+// confining the client to the key's namespace: collection names are taken
+// within it
+func WithKey(key string) ClientOption {
+	return func(c *Client) { c.key = key }
+}
+
+// NewClient returns a client for the server at addr, a host:port. No
+// connection is made until the first call.
+func NewClient(addr string, opts ...ClientOption) *Client {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	c := &Client{
+		base:      "http://" + addr + servicePath,
+		transport: transport,
+		http:      &http.Client{Transport: transport},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close drops the client's idle connections
+func (c *Client) Close() error {
+	c.transport.CloseIdleConnections()
+	return nil
+}
+
+// Put stores v, encoded as JSON, under collection/resource
+func (c *Client) Put(ctx context.Context, collection, resource string, v interface{}) error {
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.unary(ctx, "Put", &putRequest{Collection: collection, Resource: resource, Document: doc}, &empty{})
+}
+
+// Get decodes the document at collection/resource into v. With fields,
+// dotted paths, the server sends only those fields, as engine.Fields. A
+// missing record fails with an error wrapping fs.ErrNotExist.
+func (c *Client) Get(ctx context.Context, collection, resource string, v interface{}, fields ...string) error {
+	if len(fields) > 0 {
+		if err := c.require(ctx, CapProjection); err != nil {
+			return err
+		}
+	}
+	var resp document
+	if err := c.unary(ctx, "Get", &recordKey{Collection: collection, Resource: resource, Fields: fields}, &resp); err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.Document, v)
+}
+
+// Delete removes the record at collection/resource
+func (c *Client) Delete(ctx context.Context, collection, resource string) error {
+	return c.unary(ctx, "Delete", &recordKey{Collection: collection, Resource: resource}, &empty{})
+}
+
+// List returns the resource names in a collection in name order
+func (c *Client) List(ctx context.Context, collection string) ([]string, error) {
+	var resp listResponse
+	if err := c.unary(ctx, "List", &collectionRequest{Collection: collection}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Resources, nil
+}
+
+// Query returns the records of collection whose fields, given as dotted
+// paths, equal every value in conditions. With fields, their documents
+// carry only those fields, as for Get.
+func (c *Client) Query(ctx context.Context, collection string, conditions map[string]interface{}, fields ...string) ([]Record, error) {
+	if len(fields) > 0 {
+		if err := c.require(ctx, CapProjection); err != nil {
+			return nil, err
+		}
+	}
+	encoded, err := encodeConditions(conditions)
+	if err != nil {
+		return nil, err
+	}
+	req := &queryRequest{Collection: collection, Conditions: encoded, Fields: fields}
+
+	resp, err := c.call(ctx, "Query", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out []Record
+	for {
+		var rec Record
+		if err := receive(resp, &rec); errors.Is(err, io.EOF) {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+}
+
+// encodeConditions turns the conditions of a Query or Watch call into
+// their wire form
+func encodeConditions(conditions map[string]interface{}) ([]condition, error) {
+	var out []condition
+	for field, v := range conditions {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, condition{Field: field, Value: b})
+	}
+	return out, nil
+}
+
+// WatchStream delivers the events of a Watch call
+type WatchStream struct {
+	resp   *http.Response
+	cancel context.CancelFunc
+}
+
+// WatchOption narrows what a Watch or WatchFrom stream receives. The
+// server applies it, so events left out never cross the wire.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	where  map[string]interface{}
+	fields []string
+}
+
+// WatchWhere passes on only the writes whose fields, given as dotted
+// paths, equal every value in conditions, as for Query. Deletes carry no
+// document and are always passed on.
+func WatchWhere(conditions map[string]interface{}) WatchOption {
+	return func(o *watchOptions) { o.where = conditions }
+}
+
+// WatchFields cuts event documents down to the fields at the given dotted
+// paths
+func WatchFields(paths ...string) WatchOption {
+	return func(o *watchOptions) { o.fields = append(o.fields, paths...) }
+}
+
+// Watch opens a stream of the changes to collection, or to every
+// collection when it is empty. The stream ends when ctx is done, Close is
+// called, or the server ends it.
+func (c *Client) Watch(ctx context.Context, collection string, opts ...WatchOption) (*WatchStream, error) {
+	return c.watch(ctx, &watchRequest{Collection: collection}, opts)
+}
+
+// WatchFrom is Watch resumed just after the change at from. It fails with
+// an error wrapping engine.ErrResyncNeeded when the server no longer has
+// every change since.
+func (c *Client) WatchFrom(ctx context.Context, collection string, from engine.FeedPosition, opts ...WatchOption) (*WatchStream, error) {
+	return c.watch(ctx, &watchRequest{Collection: collection, Resume: true, Epoch: from.Epoch, After: from.Seq}, opts)
+}
+
+func (c *Client) watch(ctx context.Context, req *watchRequest, opts []WatchOption) (*WatchStream, error) {
+	var o watchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	conditions, err := encodeConditions(o.where)
+	if err != nil {
+		return nil, err
+	}
+	req.Conditions, req.Fields = conditions, o.fields
+	if len(req.Conditions) > 0 || len(req.Fields) > 0 {
+		if err := c.require(ctx, CapWatchFilter); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := c.call(ctx, "Watch", req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &WatchStream{resp: resp, cancel: cancel}, nil
+}
+
+// Recv waits for the next event. It returns io.EOF once the server ended
+// the stream cleanly.
+func (s *WatchStream) Recv() (*Event, error) {
+	var ev Event
+	if err := receive(s.resp, &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// Close ends the stream
+func (s *WatchStream) Close() error {
+	s.cancel()
+	return s.resp.Body.Close()
+}
+
+// Snapshot copies a backup archive of the whole database to w, as written
+// by engine.Driver.BackupArchive with default options, and returns the
+// feed position to resume watching from. Naming collections limits the
+// archive to them, as engine.Driver.SnapshotCollections does: the feed
+// position is then exactly where the archive stands.
+func (c *Client) Snapshot(ctx context.Context, w io.Writer, collections ...string) (engine.FeedPosition, error) {
+	if len(collections) > 0 {
+		if err := c.require(ctx, CapCollectionSnapshot); err != nil {
+			return engine.FeedPosition{}, err
+		}
+	}
+	resp, err := c.call(ctx, "Snapshot", &snapshotRequest{Collections: collections})
+	if err != nil {
+		return engine.FeedPosition{}, err
+	}
+	defer resp.Body.Close()
+
+	var head snapshotChunk
+	if err := receive(resp, &head); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = &Error{Code: Internal, Message: "server sent no snapshot"}
+		}
+		return engine.FeedPosition{}, err
+	}
+	for {
+		var chunk snapshotChunk
+		if err := receive(resp, &chunk); errors.Is(err, io.EOF) {
+			return engine.FeedPosition{Epoch: head.Epoch, Seq: head.Seq}, nil
+		} else if err != nil {
+			return engine.FeedPosition{}, err
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return engine.FeedPosition{}, err
+		}
+	}
+}
+
+// unary makes a call with a single response message
+func (c *Client) unary(ctx context.Context, method string, req, resp message) error {
+	r, err := c.call(ctx, method, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if err := receive(r, resp); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &Error{Code: Internal, Message: "server sent no response"}
+		}
+		return err
+	}
+	// drain to the trailers, which carry the outcome
+	var extra empty
+	if err := receive(r, &extra); !errors.Is(err, io.EOF) {
+		if err == nil {
+			return &Error{Code: Internal, Message: "server sent more than one response"}
+		}
+		return err
+	}
+	return nil
+}
+
+// call sends a request and returns the response once its headers arrived
+func (c *Client) call(ctx context.Context, method string, req message) (*http.Response, error) {
+	var body bytes.Buffer
+	if err := writeFrame(&body, req); err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+method, &body)
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/grpc")
+	hreq.Header.Set("Te", "trailers")
+	if c.key != "" && method != "Handshake" {
+		if err := c.require(ctx, CapAccessKeys); err != nil {
+			return nil, err
+		}
+		hreq.Header.Set(keyHeader, "Bearer "+c.key)
+	}
+	meta, _ := ctx.Value(callMetaKey{}).(*callMeta)
+	if meta != nil && meta.send != "" {
+		hreq.Header.Set(tokenHeader, meta.send)
+	}
+
+	resp, err := c.http.Do(hreq)
+	if err != nil {
+		return nil, &Error{Code: Unavailable, Message: err.Error()}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &Error{Code: Unknown, Message: fmt.Sprintf("unexpected HTTP status %s", resp.Status)}
+	}
+	// a trailers-only response carries the status in the headers
+	if err := callStatus(resp.Header); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if meta != nil {
+		meta.received = resp.Header.Get(tokenHeader)
+	}
+	return resp, nil
+}
+
+// receive reads the next message of a response. At the end of the stream
+// it returns io.EOF if the call succeeded and the call's error otherwise.
+func receive(resp *http.Response, m message) error {
+	err := readFrame(resp.Body, m)
+	if !errors.Is(err, io.EOF) {
+		return err
+	}
+	if err := callStatus(resp.Trailer); err != nil {
+		return err
+	}
+	if resp.Trailer.Get("Grpc-Status") == "" && resp.Header.Get("Grpc-Status") == "" {
+		return &Error{Code: Internal, Message: "stream ended without a status"}
+	}
+	return io.EOF
+}
+
+// callStatus turns the grpc-status of h into an error
+func callStatus(h http.Header) error {
+	status := h.Get("Grpc-Status")
+	if status == "" {
+		return nil
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(status))
+	if err != nil {
+		return &Error{Code: Internal, Message: fmt.Sprintf("bad grpc-status %q", status)}
+	}
+	if Code(code) == OK {
+		return nil
+	}
+	return &Error{Code: Code(code), Message: decodeMessage(h.Get("Grpc-Message"))}
+}
@@ -0,0 +1,185 @@
+package dbrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- SERVER-SENT EVENTS ---
+
+// eventsPath prefixes the HTTP path of a collection's event stream
+const eventsPath = "/events/"
+
+// eventsKeepAlive is how often an idle event stream gets a comment, so
+// proxies don't close it
+const eventsKeepAlive = 15 * time.Second
+
+// sseEvent is the data of a change on an event stream
+type sseEvent struct {
+	Seq           uint64          `json:"seq"`
+	Epoch         string          `json:"epoch"`
+	Collection    string          `json:"collection"`
+	Resource      string          `json:"resource"`
+	Time          time.Time       `json:"time"`
+	Document      json.RawMessage `json:"document,omitempty"`
+	CollectionSeq uint64          `json:"collectionSeq,omitempty"`
+}
+
+// serveEvents streams the changes of a collection as server-sent events,
+// for browsers' EventSource and anything else speaking plain HTTP:
+//
+//	GET /events/<collection>?fields=a,b.c&where={"status":"open"}
+//
+// Each change is an event named write or delete whose data is the change
+// as JSON, and whose id is its feed position, so a client reconnecting
+// with Last-Event-ID, or ?from=<epoch>/<seq>, resumes after it or gets a
+// 409 when it can't. An error event ends the stream when the client fell
+// behind or the database closed. The access key, if any, goes in the
+// Authorization header as for gRPC calls.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	collection, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), eventsPath))
+	if err != nil || collection == "" {
+		http.Error(w, "bad collection", http.StatusBadRequest)
+		return
+	}
+	changes, err := s.subscribeEvents(r, collection)
+	if err != nil {
+		rpcErr := publicError(err, collection, "")
+		http.Error(w, rpcErr.Message, httpStatus(rpcErr))
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			writeSSE(w, "", "error", "server is shutting down")
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case c, ok := <-changes:
+			if !ok {
+				writeSSE(w, "", "error", "watch ended: the client fell behind, or the database closed or was restored")
+				flusher.Flush()
+				return
+			}
+			data, err := json.Marshal(sseEvent{Seq: c.Seq, Epoch: c.Epoch, Collection: c.Collection, Resource: c.Resource,
+				Time: c.Time, Document: c.Document, CollectionSeq: c.CollectionSeq})
+			if err != nil {
+				return
+			}
+			id := formatToken(engine.FeedPosition{Epoch: c.Epoch, Seq: c.Seq})
+			if err := writeSSE(w, id, c.Kind.String(), string(data)); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// subscribeEvents watches collection as the query and headers of an
+// event stream request ask
+func (s *Server) subscribeEvents(r *http.Request, collection string) (<-chan engine.Change, error) {
+	q := r.URL.Query()
+	var opts []engine.WatchOption
+	if where := q.Get("where"); where != "" {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(where), &fields); err != nil {
+			return nil, &Error{Code: InvalidArgument, Message: fmt.Sprintf("where: %v", err)}
+		}
+		conditions := make([]condition, 0, len(fields))
+		for field, v := range fields {
+			conditions = append(conditions, condition{Field: field, Value: v})
+		}
+		filter, err := conditionFilter(conditions)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, engine.WatchFilter(filter))
+	}
+	if fields := q.Get("fields"); fields != "" {
+		opts = append(opts, engine.WatchFields(strings.Split(fields, ",")...))
+	}
+
+	sess, err := s.session(r)
+	if err != nil {
+		return nil, err
+	}
+	from := r.Header.Get("Last-Event-ID")
+	if from == "" {
+		from = q.Get("from")
+	}
+	if from == "" {
+		return sess.Watch(r.Context(), collection, opts...)
+	}
+	pos, err := parseToken(from)
+	if err != nil {
+		return nil, err
+	}
+	return sess.WatchFrom(r.Context(), collection, pos, opts...)
+}
+
+// writeSSE writes one event, splitting data over as many data lines as it
+// has lines
+func writeSSE(w http.ResponseWriter, id, event, data string) error {
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteByte('\n')
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
+
+// httpStatus is the HTTP status answering a request that failed with err
+// before its stream began
+func httpStatus(err error) int {
+	switch statusOf(err) {
+	case NotFound:
+		return http.StatusNotFound
+	case InvalidArgument:
+		return http.StatusBadRequest
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case PermissionDenied:
+		return http.StatusForbidden
+	case OutOfRange:
+		return http.StatusConflict
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,166 @@
+package dbrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- REPLICATION ---
+
+// Replica backoff bounds: the first retry after a broken stream waits
+// replicaMinBackoff, doubling up to replicaMaxBackoff while the primary
+// stays unreachable
+const (
+	replicaMinBackoff = 100 * time.Millisecond
+	replicaMaxBackoff = 10 * time.Second
+)
+
+// Replica keeps a Driver opened with engine.AsReplica in step with a
+// primary's Server. It follows the primary's change feed and applies every
+// change; after a disconnect it resumes where it stopped if the primary,
+// opened with engine.WithChangeBacklog, still has the changes since, and
+// otherwise resyncs from a fresh snapshot. Replication is asynchronous:
+// a write acknowledged by the primary reaches the replica a little later.
+//
+// Only the live records are replicated. History, trash and collection
+// settings such as schemas stay local to each Driver.
+type Replica struct {
+	db     *engine.Driver
+	client *Client
+
+	mu     sync.Mutex
+	status ReplicaStatus
+}
+
+// ReplicaStatus describes how a Replica is doing
+type ReplicaStatus struct {
+	// Position is the primary's feed position of the latest change applied
+	Position  engine.FeedPosition
+	Connected bool
+	// Resyncs counts the full copies taken since the Replica started
+	Resyncs int
+	// LastError is why the stream to the primary last broke
+	LastError error
+}
+
+// NewReplica replicates the primary serving at addr, a host:port, into db.
+// Nothing happens until Run.
+func NewReplica(db *engine.Driver, addr string) *Replica {
+	return &Replica{db: db, client: NewClient(addr)}
+}
+
+// Run replicates until ctx is done, reconnecting whenever the stream to
+// the primary breaks, and returns ctx's error
+func (r *Replica) Run(ctx context.Context) error {
+	defer r.client.Close()
+	backoff := replicaMinBackoff
+	for {
+		applied, err := r.follow(ctx)
+		r.mu.Lock()
+		r.status.Connected = false
+		r.status.LastError = err
+		r.mu.Unlock()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if applied {
+			backoff = replicaMinBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, replicaMaxBackoff)
+	}
+}
+
+// Status reports the replica's position and connection
+func (r *Replica) Status() ReplicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	s.Position = r.db.ReplicaPosition()
+	return s
+}
+
+// follow streams and applies changes until the stream breaks, reporting
+// whether it got anywhere
+func (r *Replica) follow(ctx context.Context) (bool, error) {
+	pos := r.db.ReplicaPosition()
+	if pos.Epoch != "" {
+		applied, err := r.apply(ctx, pos)
+		if !errors.Is(err, engine.ErrResyncNeeded) {
+			return applied, err
+		}
+	}
+	pos, err := r.resync(ctx)
+	if err != nil {
+		return false, err
+	}
+	return r.apply(ctx, pos)
+}
+
+// apply resumes the primary's feed at pos and applies the changes until
+// the stream breaks
+func (r *Replica) apply(ctx context.Context, pos engine.FeedPosition) (bool, error) {
+	stream, err := r.client.WatchFrom(ctx, "", pos)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+
+	r.mu.Lock()
+	r.status.Connected = true
+	r.mu.Unlock()
+
+	applied := false
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			return applied, err
+		}
+		c := engine.Change{Seq: ev.Seq, Epoch: ev.Epoch, Kind: engine.OpWrite, Collection: ev.Collection,
+			Resource: ev.Resource, Time: ev.Time, Document: ev.Document}
+		if ev.Kind == EventDelete {
+			c.Kind = engine.OpDelete
+		}
+		if err := r.db.Apply(c); err != nil {
+			return applied, err
+		}
+		applied = true
+	}
+}
+
+// resync replaces the replica's data with a snapshot of the primary
+func (r *Replica) resync(ctx context.Context) (engine.FeedPosition, error) {
+	tmp, err := os.CreateTemp("", "replica-snapshot-*.tar.gz")
+	if err != nil {
+		return engine.FeedPosition{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	pos, err := r.client.Snapshot(ctx, tmp)
+	if err != nil {
+		return engine.FeedPosition{}, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return engine.FeedPosition{}, err
+	}
+	if err := r.db.Resync(tmp, engine.ArchiveOptions{}, pos); err != nil {
+		return engine.FeedPosition{}, err
+	}
+
+	r.mu.Lock()
+	r.status.Resyncs++
+	r.mu.Unlock()
+	return pos, nil
+}
@@ -0,0 +1,399 @@
+// Package dbrpc runs the engine as a standalone data service speaking gRPC,
+// as defined in documents.proto, and provides a Go client for it. The
+// server needs no TLS or gRPC library: it serves HTTP/2 over cleartext
+// with net/http, so put a TLS terminating proxy in front of it when the
+// network isn't trusted.
+package dbrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- SERVER ---
+
+// servicePath prefixes the HTTP path of every method
+const servicePath = "/godb.v1.Documents/"
+
+// healthPath answers readiness probes with the Driver's Health
+const healthPath = "/healthz"
+
+// Server serves a Driver over gRPC
+type Server struct {
+	db          *engine.Driver
+	requireKeys bool
+
+	mu       sync.Mutex
+	srv      *http.Server
+	stopping chan struct{}
+}
+
+// ServerOption configures a Server
+type ServerOption func(*Server)
+
+// RequireKeys makes the server refuse calls that carry no access key, so
+// every client is confined to the namespace of its key. Without it, calls
+// carrying a key are confined and the others have the whole database, but
+// only until the first namespace is created: from then on keys are
+// required all the same, so that no client reaches the namespaces' data
+// unconfined.
+func RequireKeys() ServerOption {
+	return func(s *Server) { s.requireKeys = true }
+}
+
+// NewServer wraps db. The Driver stays owned by the caller, who closes it
+// after the server has shut down.
+func NewServer(db *engine.Driver, opts ...ServerOption) *Server {
+	s := &Server{db: db, stopping: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Serve accepts HTTP/2 cleartext connections on l until Shutdown, and
+// HTTP/1 ones for the event streams. Like
+// http.Server.Serve it returns http.ErrServerClosed after Shutdown, even
+// one made before Serve was called.
+func (s *Server) Serve(l net.Listener) error {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	s.mu.Lock()
+	select {
+	case <-s.stopping:
+		s.mu.Unlock()
+		l.Close()
+		return http.ErrServerClosed
+	default:
+	}
+	if s.srv == nil {
+		s.srv = &http.Server{Handler: s, Protocols: &protocols}
+	}
+	srv := s.srv
+	s.mu.Unlock()
+	return srv.Serve(l)
+}
+
+// Shutdown ends open Watch streams and stops the server once the calls in
+// progress have finished, or ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	select {
+	case <-s.stopping:
+	default:
+		close(s.stopping)
+	}
+	srv := s.srv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+// ServeHTTP handles one gRPC call, an event stream below /events/, or a
+// readiness probe on /healthz. It is exported so the service can be
+// mounted on an existing HTTP/2 server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, eventsPath) {
+		s.serveEvents(w, r)
+		return
+	}
+	if r.URL.Path == healthPath {
+		if err := s.db.Health(); err != nil {
+			http.Error(w, publicError(err, "", "").Message, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	err := s.dispatch(strings.TrimPrefix(r.URL.Path, servicePath), w, r)
+	code := OK
+	if err != nil {
+		rpcErr := publicError(err, "", "")
+		code = rpcErr.Code
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeMessage(rpcErr.Message))
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(code)))
+}
+
+func (s *Server) dispatch(method string, w http.ResponseWriter, r *http.Request) error {
+	switch method {
+	case "Put":
+		var req putRequest
+		if err := readRequest(r, &req); err != nil {
+			return err
+		}
+		if !json.Valid(req.Document) {
+			return &Error{Code: InvalidArgument, Message: "document is not valid JSON"}
+		}
+		sess, err := s.session(r)
+		if err != nil {
+			return err
+		}
+		if err := sess.Write(req.Collection, req.Resource, req.Document); err != nil {
+			return publicError(err, req.Collection, req.Resource)
+		}
+		w.Header().Set(tokenHeader, formatToken(sess.Token()))
+		return writeFrame(w, &empty{})
+
+	case "Get":
+		var req recordKey
+		if err := readRequest(r, &req); err != nil {
+			return err
+		}
+		sess, err := s.session(r)
+		if err != nil {
+			return err
+		}
+		var opts []engine.ReadOption
+		if len(req.Fields) > 0 {
+			opts = append(opts, engine.Fields(req.Fields...))
+		}
+		var doc json.RawMessage
+		if err := sess.Read(req.Collection, req.Resource, &doc, opts...); err != nil {
+			return publicError(err, req.Collection, req.Resource)
+		}
+		return writeFrame(w, &document{Document: doc})
+
+	case "Delete":
+		var req recordKey
+		if err := readRequest(r, &req); err != nil {
+			return err
+		}
+		sess, err := s.session(r)
+		if err != nil {
+			return err
+		}
+		if err := sess.Delete(req.Collection, req.Resource); err != nil {
+			return publicError(err, req.Collection, req.Resource)
+		}
+		w.Header().Set(tokenHeader, formatToken(sess.Token()))
+		return writeFrame(w, &empty{})
+
+	case "List":
+		var req collectionRequest
+		if err := readRequest(r, &req); err != nil {
+			return err
+		}
+		sess, err := s.session(r)
+		if err != nil {
+			return err
+		}
+		names, err := sess.List(req.Collection)
+		if err != nil {
+			return publicError(err, req.Collection, "")
+		}
+		return writeFrame(w, &listResponse{Resources: names})
+
+	case "Query":
+		return s.query(w, r)
+
+	case "Watch":
+		return s.watch(w, r)
+
+	case "Snapshot":
+		return s.snapshot(w, r)
+
+	case "Handshake":
+		resp, err := s.handshake(r)
+		if err != nil {
+			return err
+		}
+		return writeFrame(w, resp)
+	}
+	return &Error{Code: Unimplemented, Message: fmt.Sprintf("unknown method %q", method)}
+}
+
+// conditionFilter is the filter matching documents whose fields equal
+// every condition of a Query or Watch request
+func conditionFilter(conditions []condition) (engine.Filter, error) {
+	filters := make([]engine.Filter, 0, len(conditions))
+	for _, c := range conditions {
+		dec := json.NewDecoder(strings.NewReader(string(c.Value)))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, &Error{Code: InvalidArgument, Message: fmt.Sprintf("condition on %q: %v", c.Field, err)}
+		}
+		filters = append(filters, engine.Equal(c.Field, v))
+	}
+	return engine.And(filters...), nil
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request) error {
+	var req queryRequest
+	if err := readRequest(r, &req); err != nil {
+		return err
+	}
+
+	filter, err := conditionFilter(req.Conditions)
+	if err != nil {
+		return err
+	}
+
+	sess, err := s.session(r)
+	if err != nil {
+		return err
+	}
+	var opts []engine.ReadOption
+	if len(req.Fields) > 0 {
+		opts = append(opts, engine.Fields(req.Fields...))
+	}
+	records, err := sess.Find(req.Collection, filter, opts...)
+	if err != nil {
+		return publicError(err, req.Collection, "")
+	}
+	for _, rec := range records {
+		if err := writeFrame(w, &Record{Resource: rec.Resource, Document: rec.Data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) watch(w http.ResponseWriter, r *http.Request) error {
+	var req watchRequest
+	if err := readRequest(r, &req); err != nil {
+		return err
+	}
+	var opts []engine.WatchOption
+	if len(req.Conditions) > 0 {
+		filter, err := conditionFilter(req.Conditions)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, engine.WatchFilter(filter))
+	}
+	if len(req.Fields) > 0 {
+		opts = append(opts, engine.WatchFields(req.Fields...))
+	}
+	sess, err := s.session(r)
+	if err != nil {
+		return err
+	}
+	var changes <-chan engine.Change
+	if req.Resume {
+		changes, err = sess.WatchFrom(r.Context(), req.Collection, engine.FeedPosition{Epoch: req.Epoch, Seq: req.After}, opts...)
+	} else {
+		changes, err = sess.Watch(r.Context(), req.Collection, opts...)
+	}
+	if err != nil {
+		return publicError(err, req.Collection, "")
+	}
+
+	// send the headers now, so the client knows the stream is open
+	flusher, _ := w.(http.Flusher)
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-s.stopping:
+			return &Error{Code: Unavailable, Message: "server is shutting down"}
+		case c, ok := <-changes:
+			if !ok {
+				return &Error{Code: Unavailable, Message: "watch ended: the client fell behind, or the database closed or was restored"}
+			}
+			ev := &Event{Seq: c.Seq, Epoch: c.Epoch, Collection: c.Collection, Resource: c.Resource, Document: c.Document, Time: c.Time, CollectionSeq: c.CollectionSeq}
+			if c.Kind == engine.OpDelete {
+				ev.Kind = EventDelete
+			}
+			if err := writeFrame(w, ev); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// snapshotChunkSize is the most archive bytes sent in one SnapshotChunk
+const snapshotChunkSize = 256 << 10
+
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) error {
+	var req snapshotRequest
+	if err := readRequest(r, &req); err != nil {
+		return err
+	}
+	// a snapshot spans namespaces, so it is for unconfined callers only
+	required, err := s.keysRequired()
+	if err != nil {
+		return err
+	}
+	if required || r.Header.Get(keyHeader) != "" {
+		return &Error{Code: PermissionDenied, Message: "snapshots can't be taken with an access key"}
+	}
+	if len(req.Collections) > 0 {
+		snap, err := s.db.SnapshotCollections(req.Collections...)
+		if err != nil {
+			return err
+		}
+		defer snap.Close()
+		pos := snap.Cut.Position
+		if err := writeFrame(w, &snapshotChunk{Epoch: pos.Epoch, Seq: pos.Seq}); err != nil {
+			return err
+		}
+		cw := bufio.NewWriterSize(chunkWriter{w}, snapshotChunkSize)
+		if err := snap.WriteArchive(cw, engine.ArchiveOptions{}); err != nil {
+			return err
+		}
+		return cw.Flush()
+	}
+
+	// the position comes first: changes made while the archive is written
+	// are then sent again by a watch resumed there, which is harmless
+	pos := s.db.FeedPosition()
+	if err := writeFrame(w, &snapshotChunk{Epoch: pos.Epoch, Seq: pos.Seq}); err != nil {
+		return err
+	}
+	cw := bufio.NewWriterSize(chunkWriter{w}, snapshotChunkSize)
+	if err := s.db.BackupArchive(cw, engine.ArchiveOptions{}); err != nil {
+		return err
+	}
+	return cw.Flush()
+}
+
+// chunkWriter sends every write as the data of a SnapshotChunk
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	if err := writeFrame(c.w, &snapshotChunk{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readRequest reads the single request message of a call
+func readRequest(r *http.Request, m message) error {
+	err := readFrame(r.Body, m)
+	if errors.Is(err, io.EOF) {
+		return &Error{Code: InvalidArgument, Message: "missing request message"}
+	}
+	return err
+}
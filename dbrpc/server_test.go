@@ -0,0 +1,183 @@
+package dbrpc
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// serve runs a Server over the database in dir, opened with opts, behind
+// an httptest server speaking HTTP/2 cleartext, and returns a client of it
+func serve(t *testing.T, dir string, opts ...engine.Option) (*Client, *engine.Driver) {
+	t.Helper()
+	db, err := engine.New(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(NewServer(db))
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	ts.Config.Protocols = &protocols
+	ts.Start()
+
+	c := NewClient(ts.Listener.Addr().String())
+	t.Cleanup(func() {
+		c.Close()
+		ts.Close()
+		db.Close()
+	})
+	return c, db
+}
+
+func TestRoundTrip(t *testing.T) {
+	c, _ := serve(t, t.TempDir())
+	ctx := context.Background()
+
+	for name, n := range map[string]int{"a": 1, "b": 2} {
+		if err := c.Put(ctx, "docs", name, map[string]int{"n": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got map[string]int
+	if err := c.Get(ctx, "docs", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["n"] != 1 {
+		t.Errorf("docs/a = %v", got)
+	}
+	names, err := c.List(ctx, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("List = %v, want [a b]", names)
+	}
+	records, err := c.Query(ctx, "docs", map[string]interface{}{"n": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Resource != "b" {
+		t.Errorf("Query = %v, want docs/b", records)
+	}
+
+	if err := c.Delete(ctx, "docs", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, "docs", "a", &got); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get after Delete = %v, want fs.ErrNotExist", err)
+	}
+	if err := c.Put(ctx, "docs", "../a", map[string]int{"n": 1}); !errors.Is(err, engine.ErrInvalidName) {
+		t.Errorf("Put of a bad name = %v, want ErrInvalidName", err)
+	}
+}
+
+func TestErrorsHidePaths(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := serve(t, dir)
+	ctx := context.Background()
+
+	var v interface{}
+	calls := map[string]error{
+		"Get":    c.Get(ctx, "docs", "missing", &v),
+		"Delete": c.Delete(ctx, "docs", "missing"),
+	}
+	for name, err := range calls {
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s of a missing record = %v, want fs.ErrNotExist", name, err)
+			continue
+		}
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) || rpcErr.Message != "docs/missing: not found" {
+			t.Errorf("%s of a missing record = %v, want the message docs/missing: not found", name, err)
+		}
+		if strings.Contains(err.Error(), dir) {
+			t.Errorf("%s: %v gives away the database directory", name, err)
+		}
+	}
+
+	pathErr := &fs.PathError{Op: "open", Path: dir + "/docs/a.json", Err: fs.ErrPermission}
+	if got := publicError(pathErr, "docs", "a"); got.Message != "docs/a: permission denied" {
+		t.Errorf("publicError(%v) = %q", pathErr, got.Message)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c, _ := serve(t, t.TempDir(), engine.WithChangeBacklog(16))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := c.Watch(ctx, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if err := c.Put(ctx, "docs", "a", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "docs", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	write, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if write.Kind != EventWrite || write.Resource != "a" || !strings.Contains(string(write.Document), `"n"`) {
+		t.Errorf("first event = %+v, want the write of docs/a", write)
+	}
+	del, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if del.Kind != EventDelete || del.Resource != "a" || del.Seq <= write.Seq {
+		t.Errorf("second event = %+v, want the delete of docs/a after the write", del)
+	}
+
+	resumed, err := c.WatchFrom(ctx, "docs", engine.FeedPosition{Epoch: write.Epoch, Seq: write.Seq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if ev, err := resumed.Recv(); err != nil || ev.Seq != del.Seq {
+		t.Errorf("WatchFrom the write = %+v, %v, want the delete", ev, err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	c, db := serve(t, t.TempDir(), engine.WithChangeBacklog(16))
+	ctx := context.Background()
+	sess := c.Session(SessionOptions{Consistency: engine.Causal, Timeout: 10 * time.Second})
+
+	if err := sess.Put(ctx, "docs", "a", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	token := sess.Token()
+	if token != db.FeedPosition() {
+		t.Errorf("token after Put = %v, want the server's position %v", token, db.FeedPosition())
+	}
+	var got map[string]int
+	if err := sess.Get(ctx, "docs", "a", &got); err != nil || got["n"] != 1 {
+		t.Errorf("causal Get = %v, %v", got, err)
+	}
+	if err := sess.Delete(ctx, "docs", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if after := sess.Token(); after.Seq <= token.Seq {
+		t.Errorf("token after Delete = %v, want past %v", after, token)
+	}
+
+	sess.Observe(engine.FeedPosition{Epoch: token.Epoch, Seq: token.Seq + 100})
+	short := c.Session(SessionOptions{Consistency: engine.Causal, Timeout: 200 * time.Millisecond, Token: sess.Token()})
+	if err := short.Get(ctx, "docs", "a", &got); err == nil {
+		t.Error("a causal Get ahead of the server succeeded")
+	}
+}
@@ -0,0 +1,207 @@
+package dbrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- SESSIONS ---
+
+// tokenHeader carries a causal token, the feed position written as
+// "epoch/seq". On a request it is the position the server must have
+// reached before reading; on the response to a write, the position the
+// write brought it to.
+const tokenHeader = "Godb-Token"
+
+func formatToken(t engine.FeedPosition) string {
+	return t.Epoch + "/" + strconv.FormatUint(t.Seq, 10)
+}
+
+func parseToken(s string) (engine.FeedPosition, error) {
+	i := strings.LastIndexByte(s, '/')
+	if i < 0 {
+		return engine.FeedPosition{}, &Error{Code: InvalidArgument, Message: fmt.Sprintf("bad causal token %q", s)}
+	}
+	seq, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		return engine.FeedPosition{}, &Error{Code: InvalidArgument, Message: fmt.Sprintf("bad causal token %q", s)}
+	}
+	return engine.FeedPosition{Epoch: s[:i], Seq: seq}, nil
+}
+
+// keyHeader carries the access key of a call as "Bearer <key>"
+const keyHeader = "Authorization"
+
+// session is the engine session serving a call: causal, from the
+// request's token, when it carries one, and confined to the namespace of
+// its access key, when it carries one
+func (s *Server) session(r *http.Request) (*engine.Session, error) {
+	var opts engine.SessionOptions
+	if h := r.Header.Get(tokenHeader); h != "" {
+		token, err := parseToken(h)
+		if err != nil {
+			return nil, err
+		}
+		opts = engine.SessionOptions{Consistency: engine.Causal, Token: token}
+	}
+	// spans of the engine's calls join the trace of the request, if any
+	opts.Context = r.Context()
+
+	h := r.Header.Get(keyHeader)
+	if h == "" {
+		required, err := s.keysRequired()
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			return nil, &Error{Code: Unauthenticated, Message: "an access key is required"}
+		}
+		return s.db.Session(opts), nil
+	}
+	key, ok := strings.CutPrefix(h, "Bearer ")
+	if !ok {
+		return nil, &Error{Code: Unauthenticated, Message: "bad authorization header"}
+	}
+	sess, err := s.db.Authenticate(key, opts)
+	if errors.Is(err, engine.ErrPermissionDenied) {
+		return nil, &Error{Code: Unauthenticated, Message: err.Error()}
+	}
+	return sess, err
+}
+
+// keysRequired reports whether calls must carry an access key: with
+// RequireKeys, or once the database has namespaces
+func (s *Server) keysRequired() (bool, error) {
+	if s.requireKeys {
+		return true, nil
+	}
+	return s.db.HasNamespaces()
+}
+
+// SessionOptions are the defaults a client Session applies to every call
+type SessionOptions struct {
+	// Namespace, when set, is the record every collection the session
+	// names is a sub-collection of, as for engine.SessionOptions
+	Namespace   string
+	Consistency engine.Consistency
+	// Timeout bounds each call, on top of the context passed to it; zero
+	// means no bound
+	Timeout time.Duration
+	// Token is the causal token to start from
+	Token engine.FeedPosition
+}
+
+// Session is a series of client calls sharing settings and a causal token.
+// Writes advance the token to the position the server reports; causal
+// reads send it, so a server that is a replica answers only once it has
+// caught up. It is safe for concurrent use.
+type Session struct {
+	c    *Client
+	opts SessionOptions
+
+	mu    sync.Mutex
+	token engine.FeedPosition
+}
+
+// Session starts a session on the client
+func (c *Client) Session(opts SessionOptions) *Session {
+	return &Session{c: c, opts: opts, token: opts.Token}
+}
+
+// Token returns the session's causal token
+func (s *Session) Token() engine.FeedPosition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Observe moves the session's token up to t
+func (s *Session) Observe(t engine.FeedPosition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.Epoch != s.token.Epoch || t.Seq > s.token.Seq {
+		s.token = t
+	}
+}
+
+// Put stores v as Client.Put does
+func (s *Session) Put(ctx context.Context, collection, resource string, v interface{}) error {
+	ctx, done := s.begin(ctx, false)
+	defer done()
+	return s.c.Put(ctx, s.collection(collection), resource, v)
+}
+
+// Get decodes a document into v as Client.Get does
+func (s *Session) Get(ctx context.Context, collection, resource string, v interface{}, fields ...string) error {
+	ctx, done := s.begin(ctx, true)
+	defer done()
+	return s.c.Get(ctx, s.collection(collection), resource, v, fields...)
+}
+
+// Delete removes a record as Client.Delete does
+func (s *Session) Delete(ctx context.Context, collection, resource string) error {
+	ctx, done := s.begin(ctx, false)
+	defer done()
+	return s.c.Delete(ctx, s.collection(collection), resource)
+}
+
+// List returns the resource names of a collection as Client.List does
+func (s *Session) List(ctx context.Context, collection string) ([]string, error) {
+	ctx, done := s.begin(ctx, true)
+	defer done()
+	return s.c.List(ctx, s.collection(collection))
+}
+
+// Query returns matching records as Client.Query does
+func (s *Session) Query(ctx context.Context, collection string, conditions map[string]interface{}, fields ...string) ([]Record, error) {
+	ctx, done := s.begin(ctx, true)
+	defer done()
+	return s.c.Query(ctx, s.collection(collection), conditions, fields...)
+}
+
+func (s *Session) collection(collection string) string {
+	if s.opts.Namespace == "" {
+		return collection
+	}
+	return s.opts.Namespace + "/" + collection
+}
+
+// begin prepares the context of one call: its timeout and, for causal
+// reads, the token to send. done picks up the token of the response.
+func (s *Session) begin(ctx context.Context, read bool) (context.Context, func()) {
+	cancel := func() {}
+	if s.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+	}
+	meta := &callMeta{}
+	if token := s.Token(); read && s.opts.Consistency == engine.Causal && token != (engine.FeedPosition{}) {
+		meta.send = formatToken(token)
+	}
+	ctx = context.WithValue(ctx, callMetaKey{}, meta)
+	return ctx, func() {
+		cancel()
+		if meta.received == "" {
+			return
+		}
+		if token, err := parseToken(meta.received); err == nil {
+			s.Observe(token)
+		}
+	}
+}
+
+// callMeta is the session metadata of one call, passed to Client.call in
+// its context
+type callMeta struct {
+	send     string
+	received string
+}
+
+type callMetaKey struct{}
@@ -0,0 +1,205 @@
+package dbrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- GRPC FRAMING AND STATUS ---
+
+// maxFrame bounds the size of a single message either side will accept
+const maxFrame = 64 << 20
+
+// Code is a gRPC status code
+type Code uint32
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	FailedPrecondition Code = 9
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	Unauthenticated    Code = 16
+)
+
+var codeNames = map[Code]string{
+	OK: "OK", Canceled: "CANCELLED", Unknown: "UNKNOWN", InvalidArgument: "INVALID_ARGUMENT", DeadlineExceeded: "DEADLINE_EXCEEDED",
+	NotFound: "NOT_FOUND", AlreadyExists: "ALREADY_EXISTS", PermissionDenied: "PERMISSION_DENIED",
+	FailedPrecondition: "FAILED_PRECONDITION", OutOfRange: "OUT_OF_RANGE", Unimplemented: "UNIMPLEMENTED", Internal: "INTERNAL", Unavailable: "UNAVAILABLE",
+	Unauthenticated: "UNAUTHENTICATED",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("CODE(%d)", uint32(c))
+}
+
+// Error is a failed call as reported by the server. It unwraps to the
+// engine error the code stands for, so errors.Is(err, fs.ErrNotExist)
+// works as it does against a local Driver.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: %s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case NotFound:
+		return fs.ErrNotExist
+	case AlreadyExists:
+		return engine.ErrDuplicate
+	case InvalidArgument:
+		return engine.ErrInvalidName
+	case DeadlineExceeded:
+		return engine.ErrStaleRead
+	case PermissionDenied:
+		return errors.Join(engine.ErrReadOnly, engine.ErrPermissionDenied)
+	case Unauthenticated:
+		return engine.ErrPermissionDenied
+	case FailedPrecondition:
+		return engine.ErrValidation
+	case OutOfRange:
+		return engine.ErrResyncNeeded
+	case Unavailable:
+		return engine.ErrClosed
+	}
+	return nil
+}
+
+// statusOf picks the status code reporting an engine error
+func statusOf(err error) Code {
+	var rpcErr *Error
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr.Code
+	case errors.Is(err, fs.ErrNotExist):
+		return NotFound
+	case errors.Is(err, engine.ErrDuplicate):
+		return AlreadyExists
+	case errors.Is(err, engine.ErrInvalidName):
+		return InvalidArgument
+	case errors.Is(err, engine.ErrStaleRead):
+		return DeadlineExceeded
+	case errors.Is(err, engine.ErrReadOnly), errors.Is(err, engine.ErrPermissionDenied):
+		return PermissionDenied
+	case errors.Is(err, engine.ErrValidation):
+		return FailedPrecondition
+	case errors.Is(err, engine.ErrResyncNeeded):
+		return OutOfRange
+	case errors.Is(err, engine.ErrClosed):
+		return Unavailable
+	}
+	return Unknown
+}
+
+// publicError is err as the server reports it to clients of a call on
+// collection/resource. Engine errors can hold the paths of the server's
+// files, so a missing record is reported from the names the client gave
+// alone, and a failed file operation by what went wrong without its path.
+func publicError(err error, collection, resource string) *Error {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	target := collection
+	if resource != "" {
+		target += "/" + resource
+	}
+	if target != "" {
+		target += ": "
+	}
+	code := statusOf(err)
+	var pathErr *fs.PathError
+	switch {
+	case code == NotFound:
+		return &Error{Code: code, Message: target + "not found"}
+	case errors.As(err, &pathErr):
+		return &Error{Code: code, Message: target + pathErr.Err.Error()}
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
+
+// writeFrame sends a length prefixed, uncompressed message
+func writeFrame(w io.Writer, m message) error {
+	b := marshal(m)
+	var head [5]byte
+	binary.BigEndian.PutUint32(head[1:], uint32(len(b)))
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readFrame receives one message, returning io.EOF when the stream ended
+// cleanly before it
+func readFrame(r io.Reader, m message) error {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return errTruncated
+		}
+		return err
+	}
+	if head[0] != 0 {
+		return &Error{Code: Unimplemented, Message: "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if size > maxFrame {
+		return &Error{Code: InvalidArgument, Message: fmt.Sprintf("message of %d bytes is too large", size)}
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return errTruncated
+	}
+	return unmarshal(b, m)
+}
+
+// encodeMessage percent-encodes a grpc-message header value
+func encodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func decodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+3], "%02X", &c); err == nil {
+				b.WriteByte(c)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
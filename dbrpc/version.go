@@ -0,0 +1,103 @@
+package dbrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- VERSION NEGOTIATION ---
+
+// ProtocolVersion is the version of the protocol this package speaks. It
+// goes up when calls change in ways capability flags can't describe;
+// features added to existing calls are announced as capabilities instead.
+const ProtocolVersion = 2
+
+// minProtocolVersion is the oldest protocol version the client and server
+// still talk to. Version 1 servers predate Handshake.
+const minProtocolVersion = 1
+
+// Capabilities a server may announce. Servers that don't announce one
+// ignore the request fields it stands for, so the client refuses to send
+// them rather than have them silently dropped.
+const (
+	// CapProjection is the fields of Get and Query
+	CapProjection = "projection"
+	// CapWatchFilter is the conditions and fields of Watch
+	CapWatchFilter = "watch-filter"
+	// CapCollectionSnapshot is Snapshot of chosen collections
+	CapCollectionSnapshot = "collection-snapshot"
+	// CapAccessKeys is access keys confining calls to a namespace
+	CapAccessKeys = "access-keys"
+	// CapCollectionSeq is the collection_seq of Event
+	CapCollectionSeq = "collection-seq"
+)
+
+// capabilities are what this server supports
+var capabilities = []string{CapProjection, CapWatchFilter, CapCollectionSnapshot, CapAccessKeys, CapCollectionSeq}
+
+// ServerInfo is what a server tells of itself in the handshake
+type ServerInfo struct {
+	EngineVersion   string
+	ProtocolVersion int
+	Capabilities    []string
+}
+
+// Supports reports whether the server announced a capability
+func (i *ServerInfo) Supports(capability string) bool {
+	return slices.Contains(i.Capabilities, capability)
+}
+
+func (s *Server) handshake(r *http.Request) (*handshakeResponse, error) {
+	var req handshakeRequest
+	if err := readRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if req.ProtocolVersion < minProtocolVersion {
+		return nil, &Error{Code: FailedPrecondition, Message: fmt.Sprintf("protocol version %d is no longer supported, the oldest is %d", req.ProtocolVersion, minProtocolVersion)}
+	}
+	return &handshakeResponse{EngineVersion: engine.EngineVersion, ProtocolVersion: ProtocolVersion, Capabilities: capabilities}, nil
+}
+
+// ServerInfo returns what the server announced in the handshake, made on
+// the first call that needs it and kept for the client's lifetime. A
+// server too old to know the handshake reports protocol version 1 and no
+// capabilities.
+func (c *Client) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	if c.info != nil {
+		return c.info, nil
+	}
+
+	var resp handshakeResponse
+	err := c.unary(ctx, "Handshake", &handshakeRequest{ProtocolVersion: ProtocolVersion, Capabilities: capabilities}, &resp)
+	var rpcErr *Error
+	switch {
+	case errors.As(err, &rpcErr) && rpcErr.Code == Unimplemented:
+		resp = handshakeResponse{ProtocolVersion: 1}
+	case err != nil:
+		return nil, err
+	}
+	if resp.ProtocolVersion < minProtocolVersion {
+		return nil, &Error{Code: FailedPrecondition, Message: fmt.Sprintf("server speaks protocol version %d, the oldest supported is %d", resp.ProtocolVersion, minProtocolVersion)}
+	}
+	c.info = &ServerInfo{EngineVersion: resp.EngineVersion, ProtocolVersion: int(resp.ProtocolVersion), Capabilities: resp.Capabilities}
+	return c.info, nil
+}
+
+// require fails a call that needs a capability the server lacks
+func (c *Client) require(ctx context.Context, capability string) error {
+	info, err := c.ServerInfo(ctx)
+	if err != nil {
+		return err
+	}
+	if !info.Supports(capability) {
+		return &Error{Code: Unimplemented, Message: fmt.Sprintf("server (engine %q, protocol %d) lacks %s", info.EngineVersion, info.ProtocolVersion, capability)}
+	}
+	return nil
+}
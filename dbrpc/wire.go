@@ -0,0 +1,498 @@
+package dbrpc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// --- PROTOBUF ENCODING ---
+
+// The messages of documents.proto, encoded by hand: they only use strings,
+// bytes, varints and repeated fields, which doesn't justify a code
+// generator dependency.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+type message interface {
+	marshal(e *encoder)
+	unmarshal(field int, v uint64, b []byte) error
+}
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) tag(field, wire int) {
+	e.b = binary.AppendUvarint(e.b, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) string(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+// strings encodes a repeated string field, keeping empty elements
+func (e *encoder) strings(field int, ss []string) {
+	for _, s := range ss {
+		e.tag(field, wireBytes)
+		e.b = binary.AppendUvarint(e.b, uint64(len(s)))
+		e.b = append(e.b, s...)
+	}
+}
+
+func (e *encoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.b = binary.AppendUvarint(e.b, v)
+}
+
+func (e *encoder) message(field int, m message) {
+	var inner encoder
+	m.marshal(&inner)
+	e.tag(field, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(inner.b)))
+	e.b = append(e.b, inner.b...)
+}
+
+func marshal(m message) []byte {
+	var e encoder
+	m.marshal(&e)
+	return e.b
+}
+
+// unmarshal walks the fields of b, handing varints as v and length
+// delimited fields as b to m, and skipping fixed width ones
+func unmarshal(b []byte, m message) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		field, wire := int(key>>3), int(key&7)
+
+		var (
+			v    uint64
+			data []byte
+		)
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return errTruncated
+			}
+			data, b = b[n:n+int(size)], b[n+int(size):]
+		case wireFixed64, wireFixed32:
+			width := 8
+			if wire == wireFixed32 {
+				width = 4
+			}
+			if len(b) < width {
+				return errTruncated
+			}
+			b = b[width:]
+			continue
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+		if err := m.unmarshal(field, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type putRequest struct {
+	Collection string
+	Resource   string
+	Document   json.RawMessage
+}
+
+func (m *putRequest) marshal(e *encoder) {
+	e.string(1, m.Collection)
+	e.string(2, m.Resource)
+	e.bytes(3, m.Document)
+}
+
+func (m *putRequest) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Collection = string(b)
+	case 2:
+		m.Resource = string(b)
+	case 3:
+		m.Document = append(json.RawMessage(nil), b...)
+	}
+	return nil
+}
+
+// recordKey is the GetRequest and DeleteRequest message
+type recordKey struct {
+	Collection string
+	Resource   string
+	// Fields projects the document; GetRequest only
+	Fields []string
+}
+
+func (m *recordKey) marshal(e *encoder) {
+	e.string(1, m.Collection)
+	e.string(2, m.Resource)
+	e.strings(3, m.Fields)
+}
+
+func (m *recordKey) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Collection = string(b)
+	case 2:
+		m.Resource = string(b)
+	case 3:
+		m.Fields = append(m.Fields, string(b))
+	}
+	return nil
+}
+
+// empty is PutResponse and DeleteResponse
+type empty struct{}
+
+func (m *empty) marshal(e *encoder)                            {}
+func (m *empty) unmarshal(field int, v uint64, b []byte) error { return nil }
+
+// document is GetResponse
+type document struct {
+	Document json.RawMessage
+}
+
+func (m *document) marshal(e *encoder) {
+	e.bytes(1, m.Document)
+}
+
+func (m *document) unmarshal(field int, v uint64, b []byte) error {
+	if field == 1 {
+		m.Document = append(json.RawMessage(nil), b...)
+	}
+	return nil
+}
+
+// collectionRequest is ListRequest
+type collectionRequest struct {
+	Collection string
+}
+
+func (m *collectionRequest) marshal(e *encoder) {
+	e.string(1, m.Collection)
+}
+
+func (m *collectionRequest) unmarshal(field int, v uint64, b []byte) error {
+	if field == 1 {
+		m.Collection = string(b)
+	}
+	return nil
+}
+
+type watchRequest struct {
+	Collection string
+	Resume     bool
+	Epoch      string
+	After      uint64
+	Conditions []condition
+	Fields     []string
+}
+
+func (m *watchRequest) marshal(e *encoder) {
+	e.string(1, m.Collection)
+	if m.Resume {
+		e.varint(2, 1)
+	}
+	e.string(3, m.Epoch)
+	e.varint(4, m.After)
+	for i := range m.Conditions {
+		e.message(5, &m.Conditions[i])
+	}
+	e.strings(6, m.Fields)
+}
+
+func (m *watchRequest) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Collection = string(b)
+	case 2:
+		m.Resume = v != 0
+	case 3:
+		m.Epoch = string(b)
+	case 4:
+		m.After = v
+	case 5:
+		var c condition
+		if err := unmarshal(b, &c); err != nil {
+			return err
+		}
+		m.Conditions = append(m.Conditions, c)
+	case 6:
+		m.Fields = append(m.Fields, string(b))
+	}
+	return nil
+}
+
+type snapshotRequest struct {
+	// Collections, when set, limits the snapshot to these collections
+	Collections []string
+}
+
+func (m *snapshotRequest) marshal(e *encoder) {
+	e.strings(1, m.Collections)
+}
+
+func (m *snapshotRequest) unmarshal(field int, v uint64, b []byte) error {
+	if field == 1 {
+		m.Collections = append(m.Collections, string(b))
+	}
+	return nil
+}
+
+type snapshotChunk struct {
+	Epoch string
+	Seq   uint64
+	Data  []byte
+}
+
+func (m *snapshotChunk) marshal(e *encoder) {
+	e.string(1, m.Epoch)
+	e.varint(2, m.Seq)
+	e.bytes(3, m.Data)
+}
+
+func (m *snapshotChunk) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Epoch = string(b)
+	case 2:
+		m.Seq = v
+	case 3:
+		m.Data = append([]byte(nil), b...)
+	}
+	return nil
+}
+
+type listResponse struct {
+	Resources []string
+}
+
+func (m *listResponse) marshal(e *encoder) {
+	e.strings(1, m.Resources)
+}
+
+func (m *listResponse) unmarshal(field int, v uint64, b []byte) error {
+	if field == 1 {
+		m.Resources = append(m.Resources, string(b))
+	}
+	return nil
+}
+
+type condition struct {
+	Field string
+	Value json.RawMessage
+}
+
+func (m *condition) marshal(e *encoder) {
+	e.string(1, m.Field)
+	e.bytes(2, m.Value)
+}
+
+func (m *condition) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Field = string(b)
+	case 2:
+		m.Value = append(json.RawMessage(nil), b...)
+	}
+	return nil
+}
+
+type queryRequest struct {
+	Collection string
+	Conditions []condition
+	Fields     []string
+}
+
+func (m *queryRequest) marshal(e *encoder) {
+	e.string(1, m.Collection)
+	for i := range m.Conditions {
+		e.message(2, &m.Conditions[i])
+	}
+	e.strings(3, m.Fields)
+}
+
+func (m *queryRequest) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Collection = string(b)
+	case 2:
+		var c condition
+		if err := unmarshal(b, &c); err != nil {
+			return err
+		}
+		m.Conditions = append(m.Conditions, c)
+	case 3:
+		m.Fields = append(m.Fields, string(b))
+	}
+	return nil
+}
+
+// Record is a document streamed back by Query
+type Record struct {
+	Resource string
+	Document json.RawMessage
+}
+
+func (m *Record) marshal(e *encoder) {
+	e.string(1, m.Resource)
+	e.bytes(2, m.Document)
+}
+
+func (m *Record) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Resource = string(b)
+	case 2:
+		m.Document = append(json.RawMessage(nil), b...)
+	}
+	return nil
+}
+
+// EventKind tells writes from deletes in an Event
+type EventKind int
+
+const (
+	EventWrite EventKind = iota
+	EventDelete
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventWrite:
+		return "write"
+	case EventDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Event is a change streamed by Watch
+type Event struct {
+	Seq        uint64
+	Epoch      string
+	Kind       EventKind
+	Collection string
+	Resource   string
+	// Document is empty for deletes
+	Document json.RawMessage
+	Time     time.Time
+	// CollectionSeq numbers the change within its collection, across
+	// server restarts
+	CollectionSeq uint64
+}
+
+func (m *Event) marshal(e *encoder) {
+	e.varint(1, m.Seq)
+	e.varint(2, uint64(m.Kind))
+	e.string(3, m.Collection)
+	e.string(4, m.Resource)
+	e.bytes(5, m.Document)
+	if !m.Time.IsZero() {
+		e.varint(6, uint64(m.Time.UnixNano()))
+	}
+	e.string(7, m.Epoch)
+	e.varint(8, m.CollectionSeq)
+}
+
+func (m *Event) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.Seq = v
+	case 2:
+		m.Kind = EventKind(v)
+	case 3:
+		m.Collection = string(b)
+	case 4:
+		m.Resource = string(b)
+	case 5:
+		m.Document = append(json.RawMessage(nil), b...)
+	case 6:
+		m.Time = time.Unix(0, int64(v)).UTC()
+	case 7:
+		m.Epoch = string(b)
+	case 8:
+		m.CollectionSeq = v
+	}
+	return nil
+}
+
+type handshakeRequest struct {
+	ProtocolVersion uint64
+	Capabilities    []string
+}
+
+func (m *handshakeRequest) marshal(e *encoder) {
+	e.varint(1, m.ProtocolVersion)
+	e.strings(2, m.Capabilities)
+}
+
+func (m *handshakeRequest) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.ProtocolVersion = v
+	case 2:
+		m.Capabilities = append(m.Capabilities, string(b))
+	}
+	return nil
+}
+
+type handshakeResponse struct {
+	EngineVersion   string
+	ProtocolVersion uint64
+	Capabilities    []string
+}
+
+func (m *handshakeResponse) marshal(e *encoder) {
+	e.string(1, m.EngineVersion)
+	e.varint(2, m.ProtocolVersion)
+	e.strings(3, m.Capabilities)
+}
+
+func (m *handshakeResponse) unmarshal(field int, v uint64, b []byte) error {
+	switch field {
+	case 1:
+		m.EngineVersion = string(b)
+	case 2:
+		m.ProtocolVersion = v
+	case 3:
+		m.Capabilities = append(m.Capabilities, string(b))
+	}
+	return nil
+}
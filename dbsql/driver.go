@@ -0,0 +1,146 @@
+// Package dbsql exposes the engine through database/sql, so tooling built
+// on it can read and write collections. It speaks a small dialect:
+//
+//	SELECT * | field, ... FROM collection [WHERE condition [AND ...]]
+//	INSERT INTO collection (field, ...) VALUES (value, ...), ...
+//	DELETE FROM collection [WHERE condition [AND ...]]
+//
+// where a condition is field = value or field IS [NOT] NULL. Fields are
+// dot separated paths into documents, double quoted when they aren't plain
+// names, and _id stands for the resource name. Values are ? placeholders,
+// 'strings', numbers, TRUE, FALSE and NULL. As in SQL, = NULL is never
+// true; IS NULL matches records whose field is null or missing. WHERE
+// _id = value reads or deletes that one record without scanning the
+// collection. INSERT without an _id generates one, and with a taken _id
+// replaces the record. There are no transactions: every record is written
+// on its own.
+//
+// Importing the package registers the driver as "godb", opening the
+// database directory named by the data source name:
+//
+//	db, err := sql.Open("godb", "/var/lib/mydb")
+//
+// A Driver configured with options is used through NewConnector and
+// sql.OpenDB instead.
+package dbsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- DRIVER ---
+
+// DriverName is the name the driver is registered under
+const DriverName = "godb"
+
+func init() {
+	sql.Register(DriverName, Driver{})
+}
+
+// Driver opens engine databases by directory
+type Driver struct{}
+
+// Open opens a connection to the database in the directory name, closed
+// with it. sql.Open doesn't call it: it goes through OpenConnector, which
+// shares one engine Driver between all of a sql.DB's connections.
+func (Driver) Open(name string) (driver.Conn, error) {
+	db, err := engine.New(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{db: db, owned: true}, nil
+}
+
+// OpenConnector opens the database in the directory name, which is closed
+// again with the sql.DB
+func (Driver) OpenConnector(name string) (driver.Connector, error) {
+	db, err := engine.New(name)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{db: db, owned: true}, nil
+}
+
+// NewConnector serves db to database/sql, for sql.OpenDB. db stays owned
+// by the caller, who closes it after the sql.DB.
+func NewConnector(db *engine.Driver) driver.Connector {
+	return &connector{db: db}
+}
+
+type connector struct {
+	db    *engine.Driver
+	owned bool
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return Driver{} }
+
+// Close is called by sql.DB.Close
+func (c *connector) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// ErrNoTransactions is returned by Begin
+var ErrNoTransactions = errors.New("dbsql: transactions are not supported")
+
+// conn is a connection. Connections hold no state of their own: they all
+// share the engine Driver, which is safe for concurrent use.
+type conn struct {
+	db    *engine.Driver
+	owned bool
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{db: c.db, s: s}, nil
+}
+
+func (c *conn) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.db.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) { return nil, ErrNoTransactions }
+
+type stmt struct {
+	db *engine.Driver
+	s  statement
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return s.s.numInput() }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	params := arguments(args)
+	switch st := s.s.(type) {
+	case *insertStmt:
+		return execInsert(s.db, st, params)
+	case *deleteStmt:
+		return execDelete(s.db, st, params)
+	}
+	return nil, errors.New("dbsql: use Query for SELECT")
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	st, ok := s.s.(*selectStmt)
+	if !ok {
+		return nil, errors.New("dbsql: use Exec for INSERT and DELETE")
+	}
+	return execSelect(s.db, st, arguments(args))
+}
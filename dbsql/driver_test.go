@@ -0,0 +1,246 @@
+package dbsql
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// openDB opens a sql.DB over the database in dir
+func openDB(t *testing.T, dir string) (*sql.DB, *engine.Driver) {
+	t.Helper()
+	d, err := engine.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(NewConnector(d))
+	t.Cleanup(func() {
+		db.Close()
+		d.Close()
+	})
+	return db, d
+}
+
+// ids runs a SELECT _id query and returns the names it finds, sorted
+func ids(t *testing.T, db *sql.DB, query string, args ...interface{}) []string {
+	t.Helper()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"UPDATE users SET age = 1",
+		"SELECT FROM users",
+		"SELECT * users",
+		"SELECT * FROM users WHERE",
+		"SELECT * FROM users WHERE age > 1",
+		"SELECT * FROM users WHERE age IS 1",
+		"SELECT * FROM users WHERE name = 'alice",
+		"SELECT * FROM users extra",
+		"INSERT INTO users (name, age) VALUES ('alice')",
+		"INSERT INTO users name VALUES ('alice')",
+		"INSERT INTO users (name) VALUES (1.2.3)",
+		"DELETE users",
+	} {
+		if _, err := parse(query); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%q: got %v, want ErrSyntax", query, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	db, d := openDB(t, t.TempDir())
+	res, err := db.Exec(`INSERT INTO users (_id, name, age, "address.city") VALUES ('alice', 'Alice', 30, 'Paris'), ('bob', 'Bob', 25, 'Rome')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("INSERT affected %d rows, want 2", n)
+	}
+
+	var got map[string]interface{}
+	if err := d.Read("users", "alice", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "Alice", "age": 30.0, "address": map[string]interface{}{"city": "Paris"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("users/alice = %v, want %v", got, want)
+	}
+
+	var name string
+	var age int
+	var city string
+	row := db.QueryRow(`SELECT name, age, address.city FROM users WHERE _id = 'bob'`)
+	if err := row.Scan(&name, &age, &city); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Bob" || age != 25 || city != "Rome" {
+		t.Errorf("bob = %s, %d, %s", name, age, city)
+	}
+
+	rows, err := db.Query("SELECT * FROM users WHERE age = 30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, _ := rows.Columns()
+	rows.Close()
+	if want := []string{"_id", "address", "age", "name"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("SELECT * columns = %v, want %v", columns, want)
+	}
+
+	res, err = db.Exec("DELETE FROM users WHERE name = 'Alice'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Errorf("DELETE affected %d rows, want 1", n)
+	}
+	if got := ids(t, db, "SELECT _id FROM users"); !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Errorf("after DELETE = %v, want [bob]", got)
+	}
+
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('carol')"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(t, db, "SELECT _id FROM users WHERE name = 'carol'"); len(got) != 1 || got[0] == "" {
+		t.Errorf("INSERT without an _id named the record %v", got)
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	db, _ := openDB(t, t.TempDir())
+	stmt, err := db.Prepare("INSERT INTO users (_id, name, active) VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	for _, u := range []struct {
+		id, name string
+		active   bool
+	}{{"alice", "Alice", true}, {"bob", "Bob", false}, {"carol", "Carol", true}} {
+		if _, err := stmt.Exec(u.id, u.name, u.active); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := ids(t, db, "SELECT _id FROM users WHERE active = ? AND name = ?", true, "Carol"); !reflect.DeepEqual(got, []string{"carol"}) {
+		t.Errorf("active Carol = %v, want [carol]", got)
+	}
+	if _, err := db.Exec("INSERT INTO users (_id, name) VALUES (?, ?)", "dave"); err == nil {
+		t.Error("INSERT with too few arguments succeeded")
+	}
+}
+
+func TestWhereID(t *testing.T) {
+	db, d := openDB(t, t.TempDir())
+	if _, err := db.Exec("INSERT INTO users (_id, name) VALUES ('alice', 'Alice'), ('bob', 'Bob')"); err != nil {
+		t.Fatal(err)
+	}
+	scans := func() int64 { return d.Metrics().Collections["users"].Scans.Count }
+	before := scans()
+
+	tests := []struct {
+		query string
+		args  []interface{}
+		want  []string
+	}{
+		{"SELECT _id FROM users WHERE _id = ?", []interface{}{"alice"}, []string{"alice"}},
+		{"SELECT _id FROM users WHERE _id = 'alice' AND name = 'Bob'", nil, nil},
+		{"SELECT _id FROM users WHERE _id = 'alice' AND _id = 'bob'", nil, nil},
+		{"SELECT _id FROM users WHERE _id = 'nobody'", nil, nil},
+	}
+	for _, tt := range tests {
+		if got := ids(t, db, tt.query, tt.args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v = %v, want %v", tt.query, tt.args, got, tt.want)
+		}
+	}
+
+	for query, want := range map[string]int64{
+		"DELETE FROM users WHERE _id = 'nobody'": 0,
+		"DELETE FROM users WHERE _id = 'alice'":  1,
+	} {
+		res, err := db.Exec(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if n, _ := res.RowsAffected(); n != want {
+			t.Errorf("%s affected %d rows, want %d", query, n, want)
+		}
+	}
+	if ok, _ := d.Exists("users", "alice"); ok {
+		t.Error("users/alice survived its DELETE")
+	}
+	if n := scans() - before; n != 0 {
+		t.Errorf("WHERE _id = value scanned the collection %d times, want a direct read", n)
+	}
+}
+
+func TestNull(t *testing.T) {
+	db, d := openDB(t, t.TempDir())
+	if _, err := db.Exec("INSERT INTO users (_id, email) VALUES ('alice', NULL), ('bob', 'bob@example.com')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("users", "carol", map[string]string{"name": "Carol"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		args  []interface{}
+		want  []string
+	}{
+		// as in SQL, comparing with NULL is never true
+		{"SELECT _id FROM users WHERE email = NULL", nil, nil},
+		{"SELECT _id FROM users WHERE email = ?", []interface{}{nil}, nil},
+		{"SELECT _id FROM users WHERE _id = NULL", nil, nil},
+		{"SELECT _id FROM users WHERE email IS NULL", nil, []string{"alice", "carol"}},
+		{"SELECT _id FROM users WHERE email IS NOT NULL", nil, []string{"bob"}},
+		{"SELECT _id FROM users WHERE _id IS NULL", nil, nil},
+	}
+	for _, tt := range tests {
+		if got := ids(t, db, tt.query, tt.args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v = %v, want %v", tt.query, tt.args, got, tt.want)
+		}
+	}
+
+	res, err := db.Exec("DELETE FROM users WHERE email = NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 0 {
+		t.Errorf("DELETE WHERE email = NULL affected %d rows, want 0", n)
+	}
+}
+
+func TestNoTransactions(t *testing.T) {
+	db, _ := openDB(t, t.TempDir())
+	if _, err := db.Begin(); !errors.Is(err, ErrNoTransactions) {
+		t.Errorf("Begin = %v, want ErrNoTransactions", err)
+	}
+	if _, err := db.Exec("SELECT * FROM users"); err == nil || !strings.Contains(err.Error(), "Query") {
+		t.Errorf("Exec of a SELECT = %v, want a pointer to Query", err)
+	}
+}
@@ -0,0 +1,286 @@
+package dbsql
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// --- EXECUTION ---
+
+// arguments turns placeholder values into document values
+func arguments(args []driver.Value) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case []byte:
+			out[i] = string(v)
+		case time.Time:
+			out[i] = v.Format(time.RFC3339Nano)
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
+
+// resourceName formats a value given for _id
+func resourceName(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number, int64, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("dbsql: %s must be a string or number, got %T", engine.IDField, v)
+}
+
+// nothing matches no record, as = NULL does in SQL
+var nothing = engine.FilterFunc(func(*engine.Record) bool { return false })
+
+// filter matches records meeting every condition
+func filter(conds []condition, args []interface{}) (engine.Filter, error) {
+	if len(conds) == 0 {
+		return nil, nil
+	}
+	filters := make([]engine.Filter, 0, len(conds))
+	for _, c := range conds {
+		if c.null {
+			field, not := c.field, c.not
+			filters = append(filters, engine.FilterFunc(func(r *engine.Record) bool {
+				if field == engine.IDField {
+					return not
+				}
+				v, ok := r.Field(field)
+				return (!ok || v == nil) != not
+			}))
+			continue
+		}
+		v := c.value.resolve(args)
+		if v == nil {
+			filters = append(filters, nothing)
+			continue
+		}
+		if c.field != engine.IDField {
+			filters = append(filters, engine.Equal(c.field, v))
+			continue
+		}
+		name, err := resourceName(v)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, engine.FilterFunc(func(r *engine.Record) bool { return r.Resource == name }))
+	}
+	return engine.And(filters...), nil
+}
+
+// execInsert writes each row as a document, under its _id or a new ID.
+// A row whose _id is taken replaces that record.
+func execInsert(db *engine.Driver, s *insertStmt, args []interface{}) (driver.Result, error) {
+	for i, row := range s.rows {
+		doc := make(map[string]interface{})
+		resource := ""
+		for j, col := range s.columns {
+			v := row[j].resolve(args)
+			if col != engine.IDField {
+				setField(doc, col, v)
+				continue
+			}
+			var err error
+			if resource, err = resourceName(v); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if resource == "" {
+			_, err = db.Insert(s.collection, doc)
+		} else {
+			err = db.Write(s.collection, resource, doc)
+		}
+		if err != nil {
+			return result(i), err
+		}
+	}
+	return result(len(s.rows)), nil
+}
+
+// execDelete deletes the matching records. WHERE _id = value alone
+// deletes that record without scanning the others.
+func execDelete(db *engine.Driver, s *deleteStmt, args []interface{}) (driver.Result, error) {
+	f, err := filter(s.where, args)
+	if err != nil {
+		return nil, err
+	}
+	if name, ok := named(s.where, args); ok && len(s.where) == 1 {
+		err := db.Delete(s.collection, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return result(0), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result(1), nil
+	}
+	n, err := db.DeleteWhere(s.collection, f)
+	return result(n), err
+}
+
+// named returns the resource name of the first _id = value condition,
+// which pins a statement to a single record
+func named(conds []condition, args []interface{}) (string, bool) {
+	for _, c := range conds {
+		if c.null || c.field != engine.IDField {
+			continue
+		}
+		if name, err := resourceName(c.value.resolve(args)); err == nil {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// find returns the records of collection f matches, reading the one record
+// a WHERE _id = value names instead of scanning them all
+func find(db *engine.Driver, collection string, where []condition, args []interface{}, f engine.Filter) ([]engine.Record, error) {
+	name, ok := named(where, args)
+	if !ok {
+		return db.Find(collection, f)
+	}
+	var raw json.RawMessage
+	err := db.Read(collection, name, &raw)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec := engine.Record{Resource: name, Data: raw}
+	if !f.Match(&rec) {
+		return nil, nil
+	}
+	return []engine.Record{rec}, nil
+}
+
+// execSelect reads the matching records. SELECT * has a column for _id and
+// for every top level field of any of them, in name order.
+func execSelect(db *engine.Driver, s *selectStmt, args []interface{}) (driver.Rows, error) {
+	f, err := filter(s.where, args)
+	if err != nil {
+		return nil, err
+	}
+	records, err := find(db, s.collection, s.where, args, f)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := s.columns
+	if columns == nil {
+		seen := make(map[string]bool)
+		for i := range records {
+			doc, _ := records[i].Document()
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				obj = map[string]interface{}{"value": doc}
+			}
+			for field := range obj {
+				if !seen[field] && field != engine.IDField {
+					seen[field] = true
+					columns = append(columns, field)
+				}
+			}
+		}
+		sort.Strings(columns)
+		columns = append([]string{engine.IDField}, columns...)
+	}
+
+	r := &rows{columns: columns, values: make([][]driver.Value, len(records))}
+	for i := range records {
+		rec := &records[i]
+		r.values[i] = make([]driver.Value, len(columns))
+		for j, col := range columns {
+			if col == engine.IDField {
+				r.values[i][j] = rec.Resource
+				continue
+			}
+			v, _ := rec.Field(col)
+			if doc, _ := rec.Document(); col == "value" && s.columns == nil {
+				if _, ok := doc.(map[string]interface{}); !ok {
+					v = doc
+				}
+			}
+			if r.values[i][j], err = sqlValue(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// sqlValue converts a document value for database/sql. Arrays and objects
+// come out as JSON text.
+func sqlValue(v interface{}) (driver.Value, error) {
+	switch v := v.(type) {
+	case nil, string, bool:
+		return v, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// setField sets the value at a dot separated path, creating the objects
+// along it
+func setField(doc map[string]interface{}, path string, v interface{}) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := doc[p].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			doc[p] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = v
+}
+
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("dbsql: records have names, not numeric IDs")
+}
+
+func (r result) RowsAffected() (int64, error) { return int64(r), nil }
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	pos     int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
@@ -0,0 +1,412 @@
+package dbsql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// --- PARSER ---
+
+// ErrSyntax is returned for statements outside the supported dialect
+var ErrSyntax = errors.New("sql syntax error")
+
+type statement interface {
+	numInput() int
+}
+
+// selectStmt is SELECT columns FROM collection [WHERE ...]; no columns
+// means *
+type selectStmt struct {
+	collection string
+	columns    []string
+	where      []condition
+	params     int
+}
+
+// insertStmt is INSERT INTO collection (columns) VALUES (...), ...
+type insertStmt struct {
+	collection string
+	columns    []string
+	rows       [][]operand
+	params     int
+}
+
+// deleteStmt is DELETE FROM collection [WHERE ...]
+type deleteStmt struct {
+	collection string
+	where      []condition
+	params     int
+}
+
+func (s *selectStmt) numInput() int { return s.params }
+func (s *insertStmt) numInput() int { return s.params }
+func (s *deleteStmt) numInput() int { return s.params }
+
+// condition is field = value, or field IS [NOT] NULL when null is set
+type condition struct {
+	field string
+	value operand
+	null  bool
+	not   bool // IS NOT NULL
+}
+
+// operand is a literal, or the placeholder at param when param >= 0
+type operand struct {
+	param int
+	value interface{}
+}
+
+// resolve returns the operand's value for the statement arguments args
+func (o operand) resolve(args []interface{}) interface{} {
+	if o.param >= 0 {
+		return args[o.param]
+	}
+	return o.value
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// quoted identifiers are never keywords
+	quoted bool
+}
+
+// lex splits a statement into tokens. Identifiers may contain dots, for
+// paths into documents, and be double quoted; strings are single quoted
+// with a quote doubled inside them.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(query) {
+					return nil, fmt.Errorf("%w: unterminated quote at offset %d", ErrSyntax, i)
+				}
+				if query[j] == c {
+					if j+1 < len(query) && query[j+1] == c {
+						sb.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteByte(query[j])
+				j++
+			}
+			if c == '\'' {
+				tokens = append(tokens, token{kind: tokString, text: sb.String()})
+			} else {
+				tokens = append(tokens, token{kind: tokIdent, text: sb.String(), quoted: true})
+			}
+			i = j + 1
+
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(query) && strings.IndexByte("0123456789.eE+-", query[j]) >= 0 {
+				if (query[j] == '+' || query[j] == '-') && query[j-1] != 'e' && query[j-1] != 'E' {
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: query[i:j]})
+			i = j
+
+		case c == '_' || c < 0x80 && unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(query) && (query[j] == '_' || query[j] == '.' || query[j] < 0x80 && (unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j])))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: query[i:j]})
+			i = j
+
+		case strings.IndexByte("(),*=?;", c) >= 0:
+			tokens = append(tokens, token{kind: tokSymbol, text: string(c)})
+			i++
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrSyntax, c, i)
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	params int
+}
+
+// parse reads one statement of the dialect
+func parse(query string) (statement, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	var stmt statement
+	switch {
+	case p.keyword("SELECT"):
+		stmt, err = p.selectStmt()
+	case p.keyword("INSERT"):
+		stmt, err = p.insertStmt()
+	case p.keyword("DELETE"):
+		stmt, err = p.deleteStmt()
+	default:
+		return nil, fmt.Errorf("%w: expected SELECT, INSERT or DELETE", ErrSyntax)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.symbol(";")
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("%w: unexpected %q after statement", ErrSyntax, p.peek().text)
+	}
+	return stmt, nil
+}
+
+func (p *parser) selectStmt() (*selectStmt, error) {
+	stmt := &selectStmt{}
+	if !p.symbol("*") {
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			stmt.columns = append(stmt.columns, col)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.collection, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if stmt.where, err = p.where(); err != nil {
+		return nil, err
+	}
+	stmt.params = p.params
+	return stmt, nil
+}
+
+func (p *parser) insertStmt() (*insertStmt, error) {
+	stmt := &insertStmt{}
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.collection, err = p.ident(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		stmt.columns = append(stmt.columns, col)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var row []operand
+		for {
+			v, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, v)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		if len(row) != len(stmt.columns) {
+			return nil, fmt.Errorf("%w: %d values for %d columns", ErrSyntax, len(row), len(stmt.columns))
+		}
+		stmt.rows = append(stmt.rows, row)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	stmt.params = p.params
+	return stmt, nil
+}
+
+func (p *parser) deleteStmt() (*deleteStmt, error) {
+	stmt := &deleteStmt{}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.collection, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if stmt.where, err = p.where(); err != nil {
+		return nil, err
+	}
+	stmt.params = p.params
+	return stmt, nil
+}
+
+// where reads an optional WHERE clause of equalities and null tests
+// joined by AND
+func (p *parser) where() ([]condition, error) {
+	if !p.keyword("WHERE") {
+		return nil, nil
+	}
+	var conds []condition
+	for {
+		field, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if p.keyword("IS") {
+			c := condition{field: field, null: true, not: p.keyword("NOT")}
+			if err := p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			conds = append(conds, c)
+			if !p.keyword("AND") {
+				return conds, nil
+			}
+			continue
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		v, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, condition{field: field, value: v})
+		if !p.keyword("AND") {
+			return conds, nil
+		}
+	}
+}
+
+func (p *parser) operand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokSymbol:
+		if t.text == "?" {
+			p.params++
+			return operand{param: p.params - 1}, nil
+		}
+	case tokString:
+		return operand{param: -1, value: t.text}, nil
+	case tokNumber:
+		if !json.Valid([]byte(t.text)) {
+			return operand{}, fmt.Errorf("%w: bad number %q", ErrSyntax, t.text)
+		}
+		return operand{param: -1, value: json.Number(t.text)}, nil
+	case tokIdent:
+		if !t.quoted {
+			switch strings.ToUpper(t.text) {
+			case "TRUE":
+				return operand{param: -1, value: true}, nil
+			case "FALSE":
+				return operand{param: -1, value: false}, nil
+			case "NULL":
+				return operand{param: -1, value: nil}, nil
+			}
+		}
+	}
+	return operand{}, fmt.Errorf("%w: expected a value, got %q", ErrSyntax, t.text)
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokEOF}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the keyword kw if it comes next
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokIdent && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.keyword(kw) {
+		return fmt.Errorf("%w: expected %s, got %q", ErrSyntax, kw, p.peek().text)
+	}
+	return nil
+}
+
+// symbol consumes the punctuation s if it comes next
+func (p *parser) symbol(s string) bool {
+	if t := p.peek(); t.kind == tokSymbol && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(s string) error {
+	if !p.symbol(s) {
+		return fmt.Errorf("%w: expected %q, got %q", ErrSyntax, s, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", fmt.Errorf("%w: expected a name, got %q", ErrSyntax, t.text)
+	}
+	return t.text, nil
+}
@@ -0,0 +1,448 @@
+// Package dbtest runs databases for tests: embedded ones in a temporary
+// directory, or served over dbrpc on a loopback port, each dropped when
+// its test ends. Fixtures seed them from JSON files, and the assertions
+// compare what collections hold with what a test expects.
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RakshitNotFound/Golang-database/dbrpc"
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// shutdownTimeout bounds how long a Server's cleanup waits for calls in
+// flight
+const shutdownTimeout = 5 * time.Second
+
+// --- INSTANCES ---
+
+// New opens a database in a temporary directory of t with opts, closed and
+// removed when t ends
+func New(t testing.TB, opts ...engine.Option) *engine.Driver {
+	t.Helper()
+	d, err := engine.New(t.TempDir(), opts...)
+	if err != nil {
+		t.Fatalf("dbtest: opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := d.Close(); err != nil {
+			t.Errorf("dbtest: closing database: %v", err)
+		}
+	})
+	return d
+}
+
+// Server is a database served over dbrpc for the length of a test
+type Server struct {
+	// DB is the database behind the server, for seeding and assertions
+	// without going through the network
+	DB *engine.Driver
+	// Addr is the host:port the server listens on
+	Addr string
+	// Client is a client of the server, closed with it
+	Client *dbrpc.Client
+}
+
+// NewServer opens a database as New does and serves it on a loopback port
+// until t ends
+func NewServer(t testing.TB, opts ...engine.Option) *Server {
+	return NewServerWith(t, opts, nil)
+}
+
+// NewServerWith is NewServer with options for the dbrpc server too, such
+// as dbrpc.RequireKeys
+func NewServerWith(t testing.TB, opts []engine.Option, serverOpts []dbrpc.ServerOption) *Server {
+	t.Helper()
+	d := New(t, opts...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("dbtest: listening: %v", err)
+	}
+	srv := dbrpc.NewServer(d, serverOpts...)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	s := &Server{DB: d, Addr: l.Addr().String()}
+	s.Client = dbrpc.NewClient(s.Addr)
+	t.Cleanup(func() {
+		s.Client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("dbtest: shutting down server: %v", err)
+		}
+		if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("dbtest: serving: %v", err)
+		}
+	})
+	return s
+}
+
+// --- FIXTURES ---
+
+// Seed writes the fixtures in the files matching patterns, as for
+// filepath.Glob, into d. Each file holds one collection, named after the
+// file without its .json extension, as a JSON object of resource names
+// and their documents:
+//
+//	{"alice": {"age": 31}, "bob": {"age": 27}}
+//
+// Patterns matching no file fail the test, as a fixture gone missing would
+// otherwise pass unnoticed.
+func Seed(t testing.TB, d *engine.Driver, patterns ...string) {
+	t.Helper()
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatalf("dbtest: %v", err)
+		}
+		if len(files) == 0 {
+			t.Fatalf("dbtest: no fixtures match %s", pattern)
+		}
+		for _, file := range files {
+			if err := seedFile(d, file); err != nil {
+				t.Fatalf("dbtest: seeding %s: %v", file, err)
+			}
+		}
+	}
+}
+
+func seedFile(d *engine.Driver, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var docs map[string]json.RawMessage
+	if err := json.Unmarshal(b, &docs); err != nil {
+		return err
+	}
+	collection := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	for _, resource := range slices.Sorted(maps.Keys(docs)) {
+		if err := d.Write(collection, resource, docs[resource]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- ASSERTIONS ---
+
+// AssertDocument checks that collection/resource holds want, compared as
+// JSON values, so field order and number formatting don't matter
+func AssertDocument(t testing.TB, d *engine.Driver, collection, resource string, want interface{}) {
+	t.Helper()
+	var got json.RawMessage
+	if err := d.Read(collection, resource, &got); err != nil {
+		t.Errorf("dbtest: reading %s/%s: %v", collection, resource, err)
+		return
+	}
+	if diff := compare(got, want); diff != "" {
+		t.Errorf("dbtest: %s/%s: %s", collection, resource, diff)
+	}
+}
+
+// AssertMissing checks that collection/resource doesn't exist
+func AssertMissing(t testing.TB, d *engine.Driver, collection, resource string) {
+	t.Helper()
+	var got json.RawMessage
+	err := d.Read(collection, resource, &got)
+	switch {
+	case err == nil:
+		t.Errorf("dbtest: %s/%s exists, holding %s", collection, resource, compact(got))
+	case !errors.Is(err, fs.ErrNotExist):
+		t.Errorf("dbtest: reading %s/%s: %v", collection, resource, err)
+	}
+}
+
+// AssertCount checks that collection holds n records; a missing collection
+// holds none
+func AssertCount(t testing.TB, d *engine.Driver, collection string, n int) {
+	t.Helper()
+	got, err := contents(d, collection)
+	if err != nil {
+		t.Errorf("dbtest: reading %s: %v", collection, err)
+		return
+	}
+	if len(got) != n {
+		t.Errorf("dbtest: %s holds %d records, want %d", collection, len(got), n)
+	}
+}
+
+// AssertCollection checks that collection holds exactly the records of
+// want, keyed by resource name, with their documents compared as
+// AssertDocument does
+func AssertCollection(t testing.TB, d *engine.Driver, collection string, want map[string]interface{}) {
+	t.Helper()
+	got, err := contents(d, collection)
+	if err != nil {
+		t.Errorf("dbtest: reading %s: %v", collection, err)
+		return
+	}
+	for _, resource := range slices.Sorted(maps.Keys(want)) {
+		doc, ok := got[resource]
+		if !ok {
+			t.Errorf("dbtest: %s/%s is missing", collection, resource)
+			continue
+		}
+		if diff := compare(doc, want[resource]); diff != "" {
+			t.Errorf("dbtest: %s/%s: %s", collection, resource, diff)
+		}
+	}
+	for _, resource := range slices.Sorted(maps.Keys(got)) {
+		if _, ok := want[resource]; !ok {
+			t.Errorf("dbtest: %s/%s is unexpected, holding %s", collection, resource, compact(got[resource]))
+		}
+	}
+}
+
+// contents reads the documents of collection by resource name
+func contents(d *engine.Driver, collection string) (map[string]json.RawMessage, error) {
+	records, err := d.Find(collection, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string]json.RawMessage, len(records))
+	for _, rec := range records {
+		docs[rec.Resource] = rec.Data
+	}
+	return docs, nil
+}
+
+// compare describes how the stored document got differs from want, or
+// returns "" when they are the same JSON value
+func compare(got json.RawMessage, want interface{}) string {
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		return fmt.Sprintf("stored document: %v", err)
+	}
+	wb, err := json.Marshal(want)
+	if err != nil {
+		return fmt.Sprintf("wanted document: %v", err)
+	}
+	if err := json.Unmarshal(wb, &w); err != nil {
+		return fmt.Sprintf("wanted document: %v", err)
+	}
+	if reflect.DeepEqual(g, w) {
+		return ""
+	}
+	gb, _ := json.Marshal(g)
+	return fmt.Sprintf("got %s, want %s", gb, wb)
+}
+
+// compact is a document on one line, for messages
+func compact(doc json.RawMessage) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, doc) != nil {
+		return string(doc)
+	}
+	return buf.String()
+}
+
+// --- DATABASE STATES ---
+
+// updateEnv names the environment variable that makes AssertEqual write
+// golden trees instead of checking them
+const updateEnv = "DBTEST_UPDATE"
+
+// state is the records of a database or tree, by collection and resource
+type state map[string]map[string]json.RawMessage
+
+// Load writes the records of the tree at dir into d. The tree is laid out
+// as a database is on disk: every directory is a collection, named by its
+// path below dir, and every .json file in it a record named after the file.
+// Sub-collections are directories beside their record's file:
+//
+//	users/alice.json
+//	users/alice/orders/1001.json
+//
+// Hidden files and directories and files of other extensions are skipped.
+func Load(t testing.TB, d *engine.Driver, dir string) {
+	t.Helper()
+	tree, err := readTree(dir)
+	if err != nil {
+		t.Fatalf("dbtest: loading %s: %v", dir, err)
+	}
+	for _, collection := range slices.Sorted(maps.Keys(tree)) {
+		for _, resource := range slices.Sorted(maps.Keys(tree[collection])) {
+			if err := d.Write(collection, resource, tree[collection][resource]); err != nil {
+				t.Fatalf("dbtest: loading %s/%s: %v", collection, resource, err)
+			}
+		}
+	}
+}
+
+// AssertEqual checks that d holds exactly the records of the golden tree
+// at dir, laid out as for Load, with documents compared as AssertDocument
+// does. Sub-collections are found under the records that exist. With
+// DBTEST_UPDATE=1 in the environment it replaces the tree with what d
+// holds instead, in a canonical layout with sorted keys, to create golden
+// trees or bring them up to date.
+func AssertEqual(t testing.TB, d *engine.Driver, dir string) {
+	t.Helper()
+	got, err := dbState(d)
+	if err != nil {
+		t.Errorf("dbtest: reading database: %v", err)
+		return
+	}
+	if os.Getenv(updateEnv) == "1" {
+		if err := writeTree(dir, got); err != nil {
+			t.Fatalf("dbtest: updating %s: %v", dir, err)
+		}
+		return
+	}
+	want, err := readTree(dir)
+	if err != nil {
+		t.Errorf("dbtest: reading %s: %v", dir, err)
+		return
+	}
+
+	collections := make(map[string]bool, len(got)+len(want))
+	for c := range got {
+		collections[c] = true
+	}
+	for c := range want {
+		collections[c] = true
+	}
+	for _, collection := range slices.Sorted(maps.Keys(collections)) {
+		g, w := got[collection], want[collection]
+		for _, resource := range slices.Sorted(maps.Keys(w)) {
+			doc, ok := g[resource]
+			if !ok {
+				t.Errorf("dbtest: %s/%s is missing", collection, resource)
+				continue
+			}
+			if diff := compare(doc, w[resource]); diff != "" {
+				t.Errorf("dbtest: %s/%s: %s", collection, resource, diff)
+			}
+		}
+		for _, resource := range slices.Sorted(maps.Keys(g)) {
+			if _, ok := w[resource]; !ok {
+				t.Errorf("dbtest: %s/%s is unexpected, holding %s", collection, resource, compact(g[resource]))
+			}
+		}
+	}
+}
+
+// readTree reads the records of a tree laid out as for Load
+func readTree(dir string) (state, error) {
+	tree := make(state)
+	err := filepath.WalkDir(dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(e.Name(), ".") {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() || filepath.Ext(p) != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		collection := filepath.ToSlash(filepath.Dir(rel))
+		if collection == "." {
+			return fmt.Errorf("%s is not in a collection directory", rel)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(b) {
+			return fmt.Errorf("%s is not valid JSON", rel)
+		}
+		if tree[collection] == nil {
+			tree[collection] = make(map[string]json.RawMessage)
+		}
+		tree[collection][strings.TrimSuffix(e.Name(), ".json")] = b
+		return nil
+	})
+	return tree, err
+}
+
+// writeTree replaces the tree at dir with the records of s
+func writeTree(dir string, s state) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for collection, docs := range s {
+		cdir := filepath.Join(dir, filepath.FromSlash(collection))
+		if err := os.MkdirAll(cdir, 0755); err != nil {
+			return err
+		}
+		for resource, doc := range docs {
+			var v interface{}
+			dec := json.NewDecoder(bytes.NewReader(doc))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err != nil {
+				return fmt.Errorf("%s/%s: %w", collection, resource, err)
+			}
+			b, err := json.MarshalIndent(v, "", "\t")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(cdir, resource+".json"), append(b, '\n'), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dbState reads every record of d: those of its collections, and of the
+// sub-collections under them in turn
+func dbState(d *engine.Driver) (state, error) {
+	collections, err := d.Collections()
+	if err != nil {
+		return nil, err
+	}
+	s := make(state)
+	var add func(collection string) error
+	add = func(collection string) error {
+		docs, err := contents(d, collection)
+		if err != nil || len(docs) == 0 {
+			return err
+		}
+		s[collection] = docs
+		for _, resource := range slices.Sorted(maps.Keys(docs)) {
+			subs, err := d.SubCollections(collection, resource)
+			if err != nil {
+				return err
+			}
+			for _, sub := range subs {
+				if err := add(sub); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, c := range collections {
+		if err := add(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
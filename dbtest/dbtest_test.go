@@ -0,0 +1,85 @@
+package dbtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/RakshitNotFound/Golang-database/dbrpc"
+	"github.com/RakshitNotFound/Golang-database/dbtest"
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+func TestSeedAndAssertions(t *testing.T) {
+	d := dbtest.New(t)
+	dbtest.Seed(t, d, "testdata/fixtures/*.json")
+
+	dbtest.AssertDocument(t, d, "users", "alice", map[string]int{"age": 31})
+	dbtest.AssertMissing(t, d, "users", "carol")
+	dbtest.AssertCount(t, d, "users", 2)
+	dbtest.AssertCount(t, d, "orders", 0)
+	dbtest.AssertCollection(t, d, "users", map[string]interface{}{
+		"alice": map[string]int{"age": 31},
+		"bob":   map[string]int{"age": 27},
+	})
+}
+
+func TestLoadAndAssertEqual(t *testing.T) {
+	d := dbtest.New(t)
+	dbtest.Load(t, d, "testdata/tree")
+	dbtest.AssertDocument(t, d, "users/alice/orders", "1001", map[string]float64{"total": 12.5})
+	dbtest.AssertEqual(t, d, "testdata/tree")
+}
+
+func TestServer(t *testing.T) {
+	s := dbtest.NewServer(t)
+	ctx := context.Background()
+	if err := s.Client.Put(ctx, "users", "alice", map[string]int{"age": 31}); err != nil {
+		t.Fatal(err)
+	}
+	dbtest.AssertDocument(t, s.DB, "users", "alice", map[string]int{"age": 31})
+
+	// once a namespace exists, calls without a key are refused
+	if err := s.DB.CreateNamespace("acme"); err != nil {
+		t.Fatal(err)
+	}
+	key, err := s.DB.IssueKey("acme", engine.AccessReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Get", func() error { return s.Client.Get(ctx, "users", "alice", &got) }},
+		{"Put", func() error { return s.Client.Put(ctx, "namespaces/acme/users", "bob", map[string]int{}) }},
+		{"List", func() error { _, err := s.Client.List(ctx, "namespaces"); return err }},
+	}
+	for _, tt := range tests {
+		var rpcErr *dbrpc.Error
+		if err := tt.call(); !errors.As(err, &rpcErr) || rpcErr.Code != dbrpc.Unauthenticated {
+			t.Errorf("%s without a key: %v, want UNAUTHENTICATED", tt.name, err)
+		}
+	}
+	dbtest.AssertCount(t, s.DB, "namespaces/acme/users", 0)
+
+	keyed := dbrpc.NewClient(s.Addr, dbrpc.WithKey(key))
+	defer keyed.Close()
+	if err := keyed.Put(ctx, "users", "bob", map[string]int{"age": 27}); err != nil {
+		t.Fatal(err)
+	}
+	dbtest.AssertCollection(t, s.DB, "namespaces/acme/users", map[string]interface{}{
+		"bob": map[string]int{"age": 27},
+	})
+}
+
+func TestServerRequireKeys(t *testing.T) {
+	s := dbtest.NewServerWith(t, nil, []dbrpc.ServerOption{dbrpc.RequireKeys()})
+	var rpcErr *dbrpc.Error
+	err := s.Client.Put(context.Background(), "users", "alice", map[string]int{})
+	if !errors.As(err, &rpcErr) || rpcErr.Code != dbrpc.Unauthenticated {
+		t.Errorf("Put without a key: %v, want UNAUTHENTICATED", err)
+	}
+	dbtest.AssertMissing(t, s.DB, "users", "alice")
+}
@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// --- AGGREGATION ---
+
+// Aggregation is a grouped computation over a collection, run in a single
+// scan by the engine. Build one with Driver.Aggregate, narrow it with Where
+// and GroupBy, then finish with Count, Sum, Avg, Min, Max or Run.
+type Aggregation struct {
+	d          *Driver
+	collection string
+	filter     Filter
+	groupBy    []string
+	priority   Priority
+	ctx        context.Context
+}
+
+// Group is one row of an aggregation result
+type Group struct {
+	// Key holds the values of the GroupBy fields, in order; nil where a
+	// record lacks the field. Empty when the aggregation isn't grouped.
+	Key   []interface{} `json:"key"`
+	Count int           `json:"count"`
+	// Results maps accumulator names such as "sum(salary)" to their value.
+	// Accumulators that saw no numeric value are left out.
+	Results map[string]float64 `json:"results,omitempty"`
+}
+
+// Accumulator computes one value per group from a numeric field. Values
+// that aren't numbers (or numeric strings) are ignored.
+type Accumulator struct {
+	Name  string
+	field string
+	init  func(v float64) float64
+	step  func(acc, v float64) float64
+	avg   bool
+}
+
+// Sum adds up a field
+func Sum(field string) Accumulator {
+	return Accumulator{
+		Name:  "sum(" + field + ")",
+		field: field,
+		init:  func(v float64) float64 { return v },
+		step:  func(acc, v float64) float64 { return acc + v },
+	}
+}
+
+// Avg is the mean of a field over the records that have a numeric value
+func Avg(field string) Accumulator {
+	acc := Sum(field)
+	acc.Name = "avg(" + field + ")"
+	acc.avg = true
+	return acc
+}
+
+// Min is the smallest value of a field
+func Min(field string) Accumulator {
+	return Accumulator{
+		Name:  "min(" + field + ")",
+		field: field,
+		init:  func(v float64) float64 { return v },
+		step:  func(acc, v float64) float64 { return min(acc, v) },
+	}
+}
+
+// Max is the largest value of a field
+func Max(field string) Accumulator {
+	return Accumulator{
+		Name:  "max(" + field + ")",
+		field: field,
+		init:  func(v float64) float64 { return v },
+		step:  func(acc, v float64) float64 { return max(acc, v) },
+	}
+}
+
+// Aggregate starts an aggregation over collection. Aggregations read as
+// Batch work unless told otherwise with Priority.
+func (d *Driver) Aggregate(collection string) *Aggregation {
+	return &Aggregation{d: d, collection: collection, priority: Batch}
+}
+
+// Where limits the aggregation to records matching filter
+func (a *Aggregation) Where(filter Filter) *Aggregation {
+	a.filter = filter
+	return a
+}
+
+// Context makes ctx the context of the aggregation, which is authorized as
+// a read of the collection and sees only the records its read Policy
+// shows, as ReadContext does for Find
+func (a *Aggregation) Context(ctx context.Context) *Aggregation {
+	a.ctx = ctx
+	return a
+}
+
+// Priority sets the priority the aggregation reads records at
+func (a *Aggregation) Priority(p Priority) *Aggregation {
+	a.priority = p
+	return a
+}
+
+// GroupBy splits the records by the values of the given (dot separated)
+// fields
+func (a *Aggregation) GroupBy(fields ...string) *Aggregation {
+	a.groupBy = append([]string(nil), fields...)
+	return a
+}
+
+// Count returns the number of records in every group
+func (a *Aggregation) Count() ([]Group, error) { return a.Run() }
+
+// Sum returns the total of field in every group
+func (a *Aggregation) Sum(field string) ([]Group, error) { return a.Run(Sum(field)) }
+
+// Avg returns the mean of field in every group
+func (a *Aggregation) Avg(field string) ([]Group, error) { return a.Run(Avg(field)) }
+
+// Min returns the smallest value of field in every group
+func (a *Aggregation) Min(field string) ([]Group, error) { return a.Run(Min(field)) }
+
+// Max returns the largest value of field in every group
+func (a *Aggregation) Max(field string) ([]Group, error) { return a.Run(Max(field)) }
+
+type groupState struct {
+	Group
+	seen []int // numeric values seen per accumulator
+}
+
+// Run computes the record count and every accumulator for each group. Groups
+// are returned ordered by key.
+func (a *Aggregation) Run(accs ...Accumulator) ([]Group, error) {
+	if err := validateCollection(a.collection); err != nil {
+		return nil, err
+	}
+	if err := a.d.authorize(a.ctx, ActionRead, a.collection, ""); err != nil {
+		return nil, err
+	}
+	for _, acc := range accs {
+		if acc.step == nil {
+			return nil, fmt.Errorf("accumulator %q was not built with Sum, Avg, Min or Max", acc.Name)
+		}
+	}
+
+	groups := make(map[string]*groupState)
+	add := func(field func(path string) (interface{}, bool)) error {
+		key := make([]interface{}, len(a.groupBy))
+		for i, f := range a.groupBy {
+			key[i], _ = field(f)
+		}
+		id, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		g, ok := groups[string(id)]
+		if !ok {
+			g = &groupState{Group: Group{Key: key}, seen: make([]int, len(accs))}
+			groups[string(id)] = g
+		}
+		g.Count++
+
+		for i, acc := range accs {
+			raw, _ := field(acc.field)
+			s, ok := scalarString(raw)
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+			if g.seen[i] == 0 {
+				if g.Results == nil {
+					g.Results = make(map[string]float64)
+				}
+				g.Results[acc.Name] = acc.init(v)
+			} else {
+				g.Results[acc.Name] = acc.step(g.Results[acc.Name], v)
+			}
+			g.seen[i]++
+		}
+		return nil
+	}
+	if err := a.each(accs, add); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	out := make([]Group, 0, len(ids))
+	for _, id := range ids {
+		g := groups[id]
+		for i, acc := range accs {
+			if acc.avg && g.seen[i] > 0 {
+				g.Results[acc.Name] /= float64(g.seen[i])
+			}
+		}
+		out = append(out, g.Group)
+	}
+	return out, nil
+}
+
+// each calls add with the field lookup of every record the aggregation
+// covers. With no filter, and only column fields to look at, the records
+// come from the collection's column index instead of its files.
+func (a *Aggregation) each(accs []Accumulator, add func(field func(path string) (interface{}, bool)) error) error {
+	fields := slices.Clone(a.groupBy)
+	for _, acc := range accs {
+		fields = append(fields, acc.field)
+	}
+	filter := a.filter
+	if guard := a.d.readGuard(a.ctx, a.collection); guard != nil {
+		filter = guard
+		if a.filter != nil {
+			filter = And(a.filter, guard)
+		}
+	}
+	columns := a.d.snapshotConfig(a.collection).columns
+	if filter == nil && columnsCover(columns, fields) {
+		return a.d.eachColumnRow(a.collection, columns, fields, func(_ string, values []interface{}) error {
+			return add(func(path string) (interface{}, bool) {
+				i := slices.Index(fields, path)
+				return values[i], values[i] != nil
+			})
+		})
+	}
+
+	return a.d.scanPlanned(a.collection, filter, a.priority, func(rec *Record) error {
+		if filter != nil && !filter.Match(rec) {
+			return nil
+		}
+		return add(rec.Field)
+	})
+}
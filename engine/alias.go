@@ -0,0 +1,44 @@
+package engine
+
+// --- FIELD ALIASES ---
+
+type fieldAlias struct {
+	from string
+	to   string
+}
+
+// AliasField declares that the field at path from has been renamed to to.
+// Documents still carrying the old name are presented with the new one on
+// Read, ReadAll and Find, and are stored under the new name the next time
+// they are written, so a rename converges without a full rewrite. Both
+// names may be dot separated paths.
+func (d *Driver) AliasField(collection, from, to string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	aliases := make([]fieldAlias, 0, len(cfg.aliases)+1)
+	for _, a := range cfg.aliases {
+		if a.from != from {
+			aliases = append(aliases, a)
+		}
+	}
+	cfg.aliases = append(aliases, fieldAlias{from: from, to: to})
+}
+
+// resolveAliases moves values stored under old names to their new names.
+// When both are present the new name wins and the old value is dropped.
+func resolveAliases(aliases []fieldAlias, doc map[string]interface{}) {
+	for _, a := range aliases {
+		old, ok := lookupPath(doc, a.from)
+		if !ok {
+			continue
+		}
+		if _, exists := lookupPath(doc, a.to); !exists {
+			if !setPath(doc, a.to, old) {
+				continue
+			}
+		}
+		deletePath(doc, a.from)
+	}
+}
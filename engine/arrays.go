@@ -0,0 +1,119 @@
+package engine
+
+import "slices"
+
+// --- ARRAY UPDATES ---
+
+// Push appends values to the array at the (dot separated) path of a
+// record, creating the record or the array when missing. Like Increment it
+// is atomic: no write landing in between is lost. A field holding
+// anything but an array fails with an error wrapping ErrValidation. A
+// WriteOption among values, such as WriteContext, applies to the call
+// rather than being appended.
+func (d *Driver) Push(collection, resource, path string, values ...interface{}) error {
+	values, p := splitWriteOptions(values)
+	add, err := toDocuments(values)
+	if err != nil {
+		return err
+	}
+	return d.update(collection, resource, true, p, func(obj map[string]interface{}) error {
+		arr, err := arrayField(obj, path)
+		if err != nil {
+			return err
+		}
+		return setField(obj, path, append(arr, add...))
+	})
+}
+
+// AddToSet appends those of values the array at path doesn't already
+// hold, each at most once, as Push does. Values compare as with Equal.
+func (d *Driver) AddToSet(collection, resource, path string, values ...interface{}) error {
+	values, p := splitWriteOptions(values)
+	add, err := toDocuments(values)
+	if err != nil {
+		return err
+	}
+	return d.update(collection, resource, true, p, func(obj map[string]interface{}) error {
+		arr, err := arrayField(obj, path)
+		if err != nil {
+			return err
+		}
+		for _, v := range add {
+			if !slices.ContainsFunc(arr, func(e interface{}) bool { return jsonEqual(e, v) }) {
+				arr = append(arr, v)
+			}
+		}
+		return setField(obj, path, arr)
+	})
+}
+
+// Pull removes every element equal to one of values from the array at
+// path, atomically as Push. Values compare as with Equal. Pulling from a
+// record that doesn't exist fails with an error wrapping fs.ErrNotExist;
+// when nothing matches the record isn't written at all.
+func (d *Driver) Pull(collection, resource, path string, values ...interface{}) error {
+	values, p := splitWriteOptions(values)
+	drop, err := toDocuments(values)
+	if err != nil {
+		return err
+	}
+	return d.update(collection, resource, false, p, func(obj map[string]interface{}) error {
+		if _, ok := lookupPath(obj, path); !ok {
+			return errUnchanged
+		}
+		arr, err := arrayField(obj, path)
+		if err != nil {
+			return err
+		}
+		n := len(arr)
+		arr = slices.DeleteFunc(arr, func(e interface{}) bool {
+			return slices.ContainsFunc(drop, func(v interface{}) bool { return jsonEqual(e, v) })
+		})
+		if len(arr) == n {
+			return errUnchanged
+		}
+		return setField(obj, path, arr)
+	})
+}
+
+// arrayField returns the array at path, empty when the field is missing
+// or null
+func arrayField(obj map[string]interface{}, path string) ([]interface{}, error) {
+	v, ok := lookupPath(obj, path)
+	if !ok || v == nil {
+		return []interface{}{}, nil
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, validationError(path, "is not an array")
+	}
+	return arr, nil
+}
+
+// splitWriteOptions takes the WriteOptions out of the values of an array
+// update
+func splitWriteOptions(values []interface{}) ([]interface{}, writeParams) {
+	var opts []WriteOption
+	rest := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if opt, ok := v.(WriteOption); ok {
+			opts = append(opts, opt)
+		} else {
+			rest = append(rest, v)
+		}
+	}
+	return rest, newWriteParams(opts)
+}
+
+// toDocuments converts values to their generic document form
+func toDocuments(values []interface{}) ([]interface{}, error) {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		doc, err := toDocument(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = doc
+	}
+	return out, nil
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// --- AUTHORIZATION ---
+
+// Action is what a call does, as an Authorizer sees it
+type Action int
+
+const (
+	ActionRead Action = iota
+	ActionList
+	ActionWrite
+	ActionDelete
+	ActionWatch
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionRead:
+		return "read"
+	case ActionList:
+		return "list"
+	case ActionWrite:
+		return "write"
+	case ActionDelete:
+		return "delete"
+	case ActionWatch:
+		return "watch"
+	}
+	return "unknown"
+}
+
+// Authorizer decides whether a call may go ahead: nil allows it, an error
+// denies it. ctx is the call's, from WriteContext, ReadContext, the
+// SessionOptions or Watch, and carries the actor set with WithActor;
+// resource is "" for calls on a whole collection, collection "" for a
+// Watch of every collection.
+type Authorizer func(ctx context.Context, action Action, collection, resource string) error
+
+// WithAuthorizer has a checked before every call reading or changing
+// records: Read, ReadAll, ReadMeta, Find, Explain, Search, aggregations,
+// Columns, the estimates, FindDuplicates, Quality, Trash, JSONL and CSV
+// Export, List, the AsOf reads and History as reads; Write, Increment, the array updates,
+// Import and RestoreDeleted as writes; Delete, DeleteSoft and each record
+// of DeleteWhere as deletes; Watch and WatchFrom; the Session calls made
+// of them all; and the namespace calls, as calls on NamespaceRoot. So an
+// app embedding the engine for several users enforces its permissions in
+// one place. Calls it denies fail with its error wrapped in
+// ErrPermissionDenied. Calls without a context see
+// context.Background, and the engine's own _system collections and
+// replicated changes aren't checked. Administrative calls such as Backup,
+// an Archive Export or Migrate are left to the app.
+func WithAuthorizer(a Authorizer) Option {
+	return func(o *options) { o.authorizer = a }
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying actor, the user or principal
+// on whose behalf calls made with it run
+func WithActor(ctx context.Context, actor interface{}) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor ctx carries, nil if none
+func ActorFrom(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(actorKey{})
+}
+
+// authorize asks the Authorizer, if any, whether a call may go ahead
+func (d *Driver) authorize(ctx context.Context, action Action, collection, resource string) error {
+	if d.opts.authorizer == nil || isSystemCollection(collection) {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := d.opts.authorizer(ctx, action, collection, resource); err != nil {
+		target := collection
+		if target == "" {
+			target = "every collection"
+		}
+		return fmt.Errorf("%w: %s %s: %w", ErrPermissionDenied, action, target, err)
+	}
+	return nil
+}
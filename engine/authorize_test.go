@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// denyingDriver opens a database whose Authorizer denies every call once
+// deny is set, seeded with docs/a, a soft deleted docs/gone and namespace
+// acme
+func denyingDriver(t *testing.T) (*Driver, *atomic.Bool) {
+	t.Helper()
+	deny := new(atomic.Bool)
+	d := openTest(t, WithAuthorizer(func(ctx context.Context, action Action, collection, resource string) error {
+		if deny.Load() {
+			return errors.New("denied")
+		}
+		return nil
+	}))
+	d.KeepHistory("docs")
+	d.SearchField("docs", "text")
+	for _, name := range []string{"a", "gone"} {
+		doc := map[string]interface{}{"n": 1, "tags": []string{"x"}, "text": "hello"}
+		if err := d.Write("docs", name, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.DeleteSoft("docs", "gone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateNamespace("acme"); err != nil {
+		t.Fatal(err)
+	}
+	deny.Store(true)
+	return d, deny
+}
+
+func TestAuthorizerDenies(t *testing.T) {
+	var v interface{}
+	tests := []struct {
+		name string
+		call func(d *Driver) error
+	}{
+		{"Read", func(d *Driver) error { return d.Read("docs", "a", &v) }},
+		{"ReadAll", func(d *Driver) error { _, err := d.ReadAll("docs"); return err }},
+		{"Find", func(d *Driver) error { _, err := d.Find("docs", nil); return err }},
+		{"List", func(d *Driver) error { _, err := d.List("docs"); return err }},
+		{"Search", func(d *Driver) error { _, err := d.Search("docs", "hello"); return err }},
+		{"Aggregate", func(d *Driver) error { _, err := d.Aggregate("docs").Run(Sum("n")); return err }},
+		{"FindAsOf", func(d *Driver) error { _, err := d.FindAsOf("docs", time.Now(), nil); return err }},
+		{"ReadAllAsOf", func(d *Driver) error { _, err := d.ReadAllAsOf("docs", time.Now()); return err }},
+		{"ReadAsOf", func(d *Driver) error { return d.ReadAsOf("docs", "a", time.Now(), &v) }},
+		{"History", func(d *Driver) error { _, err := d.History("docs", "a"); return err }},
+		{"ReadMeta", func(d *Driver) error { _, err := d.ReadMeta("docs", "a"); return err }},
+		{"Columns", func(d *Driver) error { _, err := d.Columns("docs", []string{"n"}); return err }},
+		{"ExportJSONL", func(d *Driver) error { return d.Export("docs", io.Discard, JSONL) }},
+		{"ExportCSV", func(d *Driver) error { return d.Export("docs", io.Discard, CSV) }},
+		{"EstimateCount", func(d *Driver) error { _, _, err := d.EstimateCount("docs", nil); return err }},
+		{"EstimateDistinct", func(d *Driver) error { _, _, err := d.EstimateDistinct("docs", "n"); return err }},
+		{"FindDuplicates", func(d *Driver) error { _, err := d.FindDuplicates("docs", []string{"n"}); return err }},
+		{"Quality", func(d *Driver) error { _, err := d.Quality("docs"); return err }},
+		{"Trash", func(d *Driver) error { _, err := d.Trash("docs"); return err }},
+		{"Write", func(d *Driver) error { return d.Write("docs", "a", map[string]int{"n": 2}) }},
+		{"Increment", func(d *Driver) error { _, err := d.Increment("docs", "a", "n", 1); return err }},
+		{"Push", func(d *Driver) error { return d.Push("docs", "a", "tags", "y") }},
+		{"AddToSet", func(d *Driver) error { return d.AddToSet("docs", "a", "tags", "y") }},
+		{"Pull", func(d *Driver) error { return d.Pull("docs", "a", "tags", "x") }},
+		{"Import", func(d *Driver) error {
+			_, err := d.Import("docs", strings.NewReader(`{"_id":"a","n":2}`+"\n"), ImportOptions{OnError: Abort})
+			return err
+		}},
+		{"RestoreDeleted", func(d *Driver) error { return d.RestoreDeleted("docs", "gone") }},
+		{"Delete", func(d *Driver) error { return d.Delete("docs", "a") }},
+		{"DeleteSoft", func(d *Driver) error { return d.DeleteSoft("docs", "a") }},
+		{"DeleteWhere", func(d *Driver) error { _, err := d.DeleteWhere("docs", nil); return err }},
+		{"CreateNamespace", func(d *Driver) error { return d.CreateNamespace("other") }},
+		{"IssueKey", func(d *Driver) error { _, err := d.IssueKey("acme", AccessRead); return err }},
+		{"DropNamespace", func(d *Driver) error { return d.DropNamespace("acme") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, deny := denyingDriver(t)
+			if err := tt.call(d); !errors.Is(err, ErrPermissionDenied) {
+				t.Fatalf("got %v, want ErrPermissionDenied", err)
+			}
+
+			deny.Store(false)
+			var got map[string]interface{}
+			if err := d.Read("docs", "a", &got); err != nil {
+				t.Fatalf("docs/a after the denied call: %v", err)
+			}
+			if got["n"] != 1.0 || len(got["tags"].([]interface{})) != 1 {
+				t.Errorf("docs/a changed to %v", got)
+			}
+			if ok, _ := d.Exists("docs", "gone"); ok {
+				t.Error("docs/gone was restored")
+			}
+			if names, _ := d.Namespaces(); len(names) != 1 {
+				t.Errorf("namespaces are %v, want [acme]", names)
+			}
+		})
+	}
+}
+
+func TestAuthorizerSeesCall(t *testing.T) {
+	type call struct {
+		action               Action
+		collection, resource string
+	}
+	var seen []call
+	d := openTest(t, WithAuthorizer(func(ctx context.Context, action Action, collection, resource string) error {
+		seen = append(seen, call{action, collection, resource})
+		return nil
+	}))
+	if err := d.Write("docs", "a", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		run  func() error
+		want call
+	}{
+		{"Increment", func() error { _, err := d.Increment("docs", "a", "n", 1); return err }, call{ActionWrite, "docs", "a"}},
+		{"Push", func() error { return d.Push("docs", "a", "tags", "y") }, call{ActionWrite, "docs", "a"}},
+		{"Search", func() error { d.SearchField("docs", "n"); _, err := d.Search("docs", "1"); return err }, call{ActionRead, "docs", ""}},
+		{"History", func() error { _, err := d.History("docs", "a"); return err }, call{ActionRead, "docs", "a"}},
+		{"EstimateCount", func() error { _, _, err := d.EstimateCount("docs", nil); return err }, call{ActionRead, "docs", ""}},
+		{"CreateNamespace", func() error { return d.CreateNamespace("acme") }, call{ActionWrite, NamespaceRoot, "acme"}},
+		{"DeleteWhere", func() error { _, err := d.DeleteWhere("docs", nil); return err }, call{ActionDelete, "docs", "a"}},
+	}
+	for _, tt := range tests {
+		seen = nil
+		if err := tt.run(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(seen) == 0 || seen[0] != tt.want {
+			t.Errorf("%s: the Authorizer saw %v, want %v first", tt.name, seen, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// --- BACKUP AND RESTORE ---
+
+// lockCollections takes the locks of the given collections in name order,
+// which keeps concurrent callers from deadlocking, and returns a function
+// releasing them
+func (d *Driver) lockCollections(collections []string, exclusive bool) (func(), error) {
+	sorted := append([]string(nil), collections...)
+	sort.Strings(sorted)
+
+	var releases []func()
+	unlock := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+	for i, c := range sorted {
+		if i > 0 && c == sorted[i-1] {
+			continue
+		}
+		release, err := d.acquire(c, exclusive)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return unlock, nil
+}
+
+// Backup takes a consistent snapshot of the whole database into dest, which
+// must not exist yet or be empty. Every collection is read-locked while the
+// snapshot is taken, but files are hard linked rather than copied where the
+// filesystem allows, so writers are only held up briefly. Records are always
+// replaced through a rename, never rewritten in place, so the links keep
+// the snapshot's contents.
+func (d *Driver) Backup(dest string) error {
+	if err := prepareEmptyDir(dest); err != nil {
+		return err
+	}
+
+	collections, err := d.storedCollections()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lockCollections(collections, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, c := range collections {
+		if err := d.copyTree(d.fs, d.collectionDir(c), localStorage{}, filepath.Join(dest, c), linkable(c)); err != nil {
+			return fmt.Errorf("backing up %s: %w", c, err)
+		}
+	}
+	d.opts.logger.Info("backup taken", "dest", dest, "collections", len(collections))
+	return nil
+}
+
+// BackupTo streams a consistent snapshot of the whole database to w as a
+// gzip compressed tar led by a Manifest, in the same layout as Export with
+// Archive. BackupArchive adds choice of compression and encryption.
+func (d *Driver) BackupTo(w io.Writer) error {
+	return d.BackupArchive(w, ArchiveOptions{})
+}
+
+// Restore replaces the contents of the database with the backup in src.
+// Collections missing from the backup are removed. All affected collections
+// are write-locked for the duration, so readers never see a half restored
+// collection.
+func (d *Driver) Restore(src string) error {
+	if err := d.writable(); err != nil {
+		return err
+	}
+	return d.restore(src)
+}
+
+// restore does the work of Restore, which also resyncs replicas. The
+// change feed starts a new epoch, as no change in it leads to the restored
+// data.
+func (d *Driver) restore(src string) error {
+	backed, err := collectionDirs(localStorage{}, src)
+	if err != nil {
+		return err
+	}
+	for _, c := range backed {
+		if !isSystemCollection(c) {
+			if err := validateCollection(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	live, err := d.storedCollections()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lockCollections(append(live, backed...), true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := d.closeKVs(); err != nil {
+		return err
+	}
+	for _, c := range append(live, backed...) {
+		d.invalidateUnique(c)
+		d.invalidateBloom(c)
+		d.invalidateSearch(c)
+		d.invalidateColumns(c)
+		d.invalidateViews(c)
+		d.invalidateDistinct(c)
+		d.forgetSequence(c)
+		d.forgetUsage(c)
+		d.forgetLayout(c)
+		d.forgetDictionaries(c)
+		d.cache.invalidateCollection(c)
+	}
+	for _, c := range live {
+		if err := d.fs.RemoveAll(d.collectionDir(c)); err != nil {
+			return err
+		}
+		d.forgetPlacement(c)
+	}
+	for _, c := range backed {
+		if err := d.copyTree(localStorage{}, filepath.Join(src, c), d.fs, d.collectionDir(c), false); err != nil {
+			return fmt.Errorf("restoring %s: %w", c, err)
+		}
+		if !isSystemCollection(c) {
+			if _, err := d.loadCollectionOptions(c); err != nil {
+				return err
+			}
+		}
+	}
+	d.watchers.reset()
+	d.opts.logger.Info("restored from backup", "src", src, "collections", len(backed), "removed", len(live))
+	return nil
+}
+
+// RestoreFrom replaces the contents of the database with an unencrypted
+// backup read from r, as written by BackupTo
+func (d *Driver) RestoreFrom(r io.Reader) error {
+	return d.RestoreArchive(r, ArchiveOptions{})
+}
+
+// extractArchive unpacks a database archive into dir, checking it against
+// its manifest, and returns the manifest if the archive has one
+func extractArchive(r io.Reader, dir string, key []byte) (*Manifest, error) {
+	return walkArchive(r, key, func(collection, rel string, r io.Reader) error {
+		dst := filepath.Join(dir, collection, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// prepareEmptyDir creates dir, or checks that an existing dir is empty
+func prepareEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("backup destination %s is not empty", dir)
+	}
+	return nil
+}
+
+func (d *Driver) copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := d.createFile(to, info.Size())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
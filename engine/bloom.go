@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path/filepath"
+)
+
+// --- BLOOM FILTERS ---
+
+// bloomFile holds, in a collection directory, the bloom filter of its
+// record names saved when the Driver last closed. It is removed as soon
+// as it is loaded, so a Driver that never closes leaves no stale filter
+// behind, and by the first write of a Driver keeping no filters.
+const bloomFile = ".bloom"
+
+const (
+	bloomMagic      = "BLM1"
+	bloomHashes     = 7
+	bloomBitsPerKey = 10
+	bloomMinKeys    = 1024
+)
+
+// WithBloomFilters keeps a bloom filter of the record names of every
+// collection, so Read and Exists answer most lookups of missing records
+// without touching the file system, which pays off when misses dominate.
+// A collection's filter is built from a listing of its directory on first
+// use, or loaded from a copy saved by the last Close, and grown again
+// once it fills up. Cached reads and hits cost as before.
+func WithBloomFilters() Option {
+	return func(o *options) { o.bloom = true }
+}
+
+// bloomFilter answers whether a record name may exist. Writers add to it
+// under the collection's write lock; readers test it under the read lock.
+type bloomFilter struct {
+	bits     []uint64
+	capacity int // names it was sized for
+	added    int
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	capacity := max(2*keys, bloomMinKeys)
+	words := (capacity*bloomBitsPerKey + 63) / 64
+	return &bloomFilter{bits: make([]uint64, words), capacity: capacity}
+}
+
+// positions calls fn with each bit of name, by double hashing
+func (b *bloomFilter) positions(name string, fn func(word int, mask uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	n := uint64(len(b.bits) * 64)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) add(name string) {
+	b.positions(name, func(word int, mask uint64) bool {
+		b.bits[word] |= mask
+		return true
+	})
+	b.added++
+}
+
+// mayContain is false only for names never added
+func (b *bloomFilter) mayContain(name string) bool {
+	return b.positions(name, func(word int, mask uint64) bool {
+		return b.bits[word]&mask != 0
+	})
+}
+
+// full reports whether the filter holds more names than it was sized for,
+// and answers too many lookups wrongly
+func (b *bloomFilter) full() bool {
+	return b.added > b.capacity
+}
+
+func (b *bloomFilter) marshal() []byte {
+	out := make([]byte, 0, len(bloomMagic)+16+8*len(b.bits))
+	out = append(out, bloomMagic...)
+	out = binary.LittleEndian.AppendUint64(out, uint64(b.capacity))
+	out = binary.LittleEndian.AppendUint64(out, uint64(b.added))
+	for _, w := range b.bits {
+		out = binary.LittleEndian.AppendUint64(out, w)
+	}
+	return out
+}
+
+func unmarshalBloomFilter(data []byte) (*bloomFilter, error) {
+	if len(data) < len(bloomMagic)+16 || string(data[:len(bloomMagic)]) != bloomMagic || (len(data)-len(bloomMagic)-16)%8 != 0 {
+		return nil, errors.New("not a bloom filter")
+	}
+	data = data[len(bloomMagic):]
+	b := &bloomFilter{
+		capacity: int(binary.LittleEndian.Uint64(data)),
+		added:    int(binary.LittleEndian.Uint64(data[8:])),
+		bits:     make([]uint64, (len(data)-16)/8),
+	}
+	if len(b.bits) == 0 {
+		return nil, errors.New("empty bloom filter")
+	}
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(data[16+8*i:])
+	}
+	return b, nil
+}
+
+// bloomFor returns the filter of collection, loading or building it when
+// missing or full, or nil when the Driver keeps none. Callers must hold
+// the collection lock.
+func (d *Driver) bloomFor(collection string) (*bloomFilter, error) {
+	if !d.opts.bloom || isSystemCollection(collection) {
+		return nil, nil
+	}
+	d.mutex.Lock()
+	b := d.blooms[collection]
+	d.mutex.Unlock()
+	if b != nil && !b.full() {
+		return b, nil
+	}
+
+	path := filepath.Join(d.collectionDir(collection), bloomFile)
+	if b == nil {
+		if data, err := d.fs.ReadFile(path); err == nil {
+			b, err = unmarshalBloomFilter(data)
+			if err != nil {
+				d.opts.logger.Warn("ignoring bloom filter", "collection", collection, "err", err)
+			}
+		}
+	}
+	if b == nil || b.full() {
+		names, err := d.recordNames(collection)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		b = newBloomFilter(len(names))
+		for _, name := range names {
+			b.add(name)
+		}
+	}
+	if !d.opts.readOnly {
+		if err := d.fs.Remove(path, d.opts.durability); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	d.mutex.Lock()
+	d.blooms[collection] = b
+	d.mutex.Unlock()
+	return b, nil
+}
+
+// bloomMisses reports whether the filter of collection rules resource
+// out. Callers must hold the collection lock.
+func (d *Driver) bloomMisses(collection, resource string) (bool, error) {
+	b, err := d.bloomFor(collection)
+	if err != nil || b == nil {
+		return false, err
+	}
+	missing := !b.mayContain(resource)
+	if missing {
+		d.metrics.counters(collection).bloomMisses.Add(1)
+	}
+	return missing, nil
+}
+
+// bloomAdd records that resource exists, or without WithBloomFilters
+// removes the saved filter the write makes stale. Callers must hold the
+// collection's write lock.
+func (d *Driver) bloomAdd(collection, resource string) error {
+	if !d.opts.bloom && !isSystemCollection(collection) {
+		d.mutex.Lock()
+		_, cleared := d.blooms[collection]
+		d.mutex.Unlock()
+		if cleared {
+			return nil
+		}
+		return d.discardBloom(collection)
+	}
+	b, err := d.bloomFor(collection)
+	if err != nil || b == nil {
+		return err
+	}
+	b.add(resource)
+	return nil
+}
+
+// invalidateBloom drops the filter of collection after its files were
+// replaced wholesale
+func (d *Driver) invalidateBloom(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.blooms, collection)
+}
+
+// discardBloom drops the filter of collection and its saved copy, after
+// files were added to the collection behind the filter's back. Callers
+// must hold the collection's write lock.
+func (d *Driver) discardBloom(collection string) error {
+	err := d.fs.Remove(filepath.Join(d.collectionDir(collection), bloomFile), d.opts.durability)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.opts.bloom {
+		delete(d.blooms, collection)
+	} else {
+		d.blooms[collection] = nil
+	}
+	return nil
+}
+
+// saveBlooms writes every filter to its collection for the next Driver to
+// load, when the Driver closes
+func (d *Driver) saveBlooms() error {
+	if d.opts.readOnly {
+		return nil
+	}
+	d.mutex.Lock()
+	blooms := d.blooms
+	d.blooms = make(map[string]*bloomFilter)
+	d.mutex.Unlock()
+
+	var errs []error
+	for c, b := range blooms {
+		if b == nil || b.full() {
+			continue
+		}
+		if _, err := d.fs.Stat(d.collectionDir(c)); err != nil {
+			continue
+		}
+		if err := d.fs.WriteFile(filepath.Join(d.collectionDir(c), bloomFile), b.marshal(), d.opts.durability); err != nil {
+			errs = append(errs, fmt.Errorf("saving bloom filter of %s: %w", c, err))
+		}
+	}
+	return errors.Join(errs...)
+}
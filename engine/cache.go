@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// --- READ CACHE ---
+
+// WithCache keeps up to maxBytes of recently read record files in memory,
+// so repeated Reads of hot records skip the filesystem. Writes and deletes
+// through the Driver invalidate their records; changes made to the files
+// behind the Driver's back are not seen until a record is evicted. Scans
+// bypass the cache.
+func WithCache(maxBytes int64) Option {
+	return func(o *options) { o.cacheBytes = maxBytes }
+}
+
+// CacheStats reports how the read cache is doing
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"maxBytes"`
+}
+
+type cacheKey struct {
+	collection string
+	resource   string
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	data []byte
+}
+
+// recordCache is a size bounded LRU of record files. A nil recordCache
+// caches nothing. Entries are filled and invalidated under the collection
+// lock, so a reader can't put back a file a writer has just replaced.
+type recordCache struct {
+	mu    sync.Mutex
+	max   int64
+	size  int64
+	order *list.List
+	items map[cacheKey]*list.Element
+	stats CacheStats
+}
+
+func newRecordCache(maxBytes int64) *recordCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &recordCache{max: maxBytes, order: list.New(), items: make(map[cacheKey]*list.Element)}
+}
+
+// get returns a copy of a cached file
+func (c *recordCache) get(collection, resource string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[cacheKey{collection, resource}]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(el)
+	return bytes.Clone(el.Value.(*cacheEntry).data), true
+}
+
+func (c *recordCache) put(collection, resource string, data []byte) {
+	if c == nil || int64(len(data)) > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{collection, resource}
+	if el, ok := c.items[key]; ok {
+		c.drop(el)
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, data: bytes.Clone(data)})
+	c.size += int64(len(data))
+	for c.size > c.max {
+		c.drop(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// room reports whether n more bytes fit in the cache without evicting
+func (c *recordCache) room(n int) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size+int64(n) <= c.max
+}
+
+// invalidate forgets a record
+func (c *recordCache) invalidate(collection, resource string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[cacheKey{collection, resource}]; ok {
+		c.drop(el)
+	}
+}
+
+// invalidateCollection forgets every record of a collection
+func (c *recordCache) invalidateCollection(collection string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.items {
+		if key.collection == collection {
+			c.drop(el)
+		}
+	}
+}
+
+func (c *recordCache) drop(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.items, e.key)
+	c.size -= int64(len(e.data))
+}
+
+func (c *recordCache) snapshot() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = len(c.items)
+	s.Bytes = c.size
+	s.MaxBytes = c.max
+	return s
+}
+
+// CacheStats reports the hits, misses and size of the read cache set up by
+// WithCache; without one it is all zeros
+func (d *Driver) CacheStats() CacheStats {
+	return d.cache.snapshot()
+}
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// --- CODECS ---
+
+// recordExt is the extension of record files written without a Codec
+const recordExt = ".json"
+
+// Codec serializes the record files of a collection in place of the
+// indented JSON the Driver writes by default, as set with WithCodec or
+// SetCodec. The Driver still handles documents as JSON: Marshal is given
+// the record, envelope included, as a json.RawMessage, and Unmarshal a
+// *json.RawMessage to fill in again, so a codec for another format
+// converts between it and JSON. History versions, trashed records and
+// the _system collections stay plain JSON.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// Extension is the file name extension of the records, such as ".pb";
+	// a dot and at least one character, with no other dot
+	Extension() string
+}
+
+// WithCodec stores the records of every collection with c, except those
+// SetCodec gives another
+func WithCodec(c Codec) Option {
+	return func(o *options) { o.codec = c }
+}
+
+// WithCodecs makes codecs known to the Driver without using them, for the
+// CollectionOptions stored with a collection to name by their Extension
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *options) { o.codecs = append(o.codecs, codecs...) }
+}
+
+// SetCodec stores the records of collection with c from now on, or with
+// the Driver's codec again when c is nil. Records are looked up by their
+// extension, so those stored with another codec are no longer seen: set
+// it before the collection holds records, or export and import them.
+func (d *Driver) SetCodec(collection string, c Codec) error {
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	if isSystemCollection(collection) {
+		return fmt.Errorf("%s: system collections are always JSON", collection)
+	}
+	if c != nil {
+		if err := checkCodec(c); err != nil {
+			return err
+		}
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).codec = c
+	return nil
+}
+
+// checkCodec rejects extensions record names can't be told apart by
+func checkCodec(c Codec) error {
+	ext := c.Extension()
+	if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./\\") {
+		return fmt.Errorf("codec extension %q must be a dot and a name", ext)
+	}
+	return nil
+}
+
+// codecNamed is the codec given WithCodec or WithCodecs with extension ext
+func (d *Driver) codecNamed(ext string) (Codec, error) {
+	if c := d.opts.codec; c != nil && c.Extension() == ext {
+		return c, nil
+	}
+	for _, c := range d.opts.codecs {
+		if c.Extension() == ext {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no codec with extension %q was given WithCodec or WithCodecs", ext)
+}
+
+// codecOf is the codec of a collection's records, nil for plain JSON
+func (d *Driver) codecOf(collection string, cfg *collectionConfig) Codec {
+	if isSystemCollection(collection) {
+		return nil
+	}
+	if cfg.codec != nil {
+		return cfg.codec
+	}
+	return d.opts.codec
+}
+
+// recordExtension is the extension of a collection's record files
+func (d *Driver) recordExtension(collection string, cfg *collectionConfig) string {
+	if c := d.codecOf(collection, cfg); c != nil {
+		return c.Extension()
+	}
+	return recordExt
+}
+
+// recordPath is the file of a record, in its shard when the collection is
+// sharded
+func (d *Driver) recordPath(collection, resource string, cfg *collectionConfig) string {
+	name := resource + d.recordExtension(collection, cfg)
+	if d.sharded(collection) {
+		return filepath.Join(d.collectionDir(collection), shardOf(resource), name)
+	}
+	return filepath.Join(d.collectionDir(collection), name)
+}
+
+// readLive reads a record file as the JSON it holds
+func (d *Driver) readLive(collection, path string, cfg *collectionConfig) ([]byte, error) {
+	d.io.enter(Interactive)
+	b, err := d.fs.ReadFile(path)
+	d.io.leave(Interactive)
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeFile(collection, cfg, b)
+}
+
+// decodeFile turns the bytes of a record file into the JSON they encode
+func (d *Driver) decodeFile(collection string, cfg *collectionConfig, b []byte) ([]byte, error) {
+	c := d.codecOf(collection, cfg)
+	if c == nil {
+		return d.decompressFile(collection, b)
+	}
+	var raw json.RawMessage
+	if err := c.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: decoding record: %w", collection, err)
+	}
+	return raw, nil
+}
+
+// encodeFile turns the JSON of a record into the bytes of its file
+func (d *Driver) encodeFile(collection string, cfg *collectionConfig, b []byte) ([]byte, error) {
+	c := d.codecOf(collection, cfg)
+	if c == nil {
+		return d.compressFile(collection, cfg, b)
+	}
+	out, err := c.Marshal(json.RawMessage(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: encoding record: %w", collection, err)
+	}
+	return out, nil
+}
+
+// EncryptedJSON is a Codec sealing each record's JSON with AES-GCM under
+// key, of 16, 24 or 32 bytes, so record files are unreadable at rest
+// without it. Files get the .sealed extension.
+func EncryptedJSON(key []byte) (Codec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("record key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return encryptedJSON{aead}, nil
+}
+
+type encryptedJSON struct {
+	aead cipher.AEAD
+}
+
+func (encryptedJSON) Extension() string { return ".sealed" }
+
+// Marshal prefixes the sealed JSON with its random nonce
+func (e encryptedJSON) Marshal(v interface{}) ([]byte, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(plain)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (e encryptedJSON) Unmarshal(data []byte, v interface{}) error {
+	n := e.aead.NonceSize()
+	if len(data) < n {
+		return errors.New("sealed record is truncated")
+	}
+	plain, err := e.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return fmt.Errorf("opening sealed record: %w", err)
+	}
+	return json.Unmarshal(plain, v)
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- READ-TIME COERCION ---
+
+// Coercion converts a stored field value, in generic JSON form, into the
+// shape readers expect. Values it can't convert are left as stored.
+type Coercion func(v interface{}) (interface{}, error)
+
+// CoercionProfile maps dot separated field paths to the coercion applied to
+// them
+type CoercionProfile map[string]Coercion
+
+type fieldCoercion struct {
+	path   string
+	coerce Coercion
+}
+
+// SetCoercions registers a profile of coercions applied to the documents of
+// collection on Read, ReadAll and Find, before they are decoded. It smooths
+// over fields stored inconsistently over time, such as an age held as "23"
+// in older records, without rewriting them. Stored documents are left
+// untouched. Calling SetCoercions again replaces the previous profile.
+func (d *Driver) SetCoercions(collection string, profile CoercionProfile) {
+	list := make([]fieldCoercion, 0, len(profile))
+	for path, c := range profile {
+		list = append(list, fieldCoercion{path: path, coerce: c})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].path < list[j].path })
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).coercions = list
+}
+
+// applyCoercions converts the fields named by the profile in place
+func applyCoercions(coercions []fieldCoercion, doc map[string]interface{}) {
+	for _, fc := range coercions {
+		v, ok := lookupPath(doc, fc.path)
+		if !ok || v == nil {
+			continue
+		}
+		if out, err := fc.coerce(v); err == nil {
+			setPath(doc, fc.path, out)
+		}
+	}
+}
+
+// ToInt turns numeric strings and whole numbers such as "23" or 23.0 into
+// integers
+func ToInt() Coercion {
+	return func(v interface{}) (interface{}, error) {
+		s, ok := scalarString(v)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a number", v)
+		}
+		s = strings.TrimSpace(s)
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(s), nil
+		}
+		f, ok := new(big.Float).SetString(s)
+		if !ok || !f.IsInt() {
+			return nil, fmt.Errorf("%q is not a whole number", s)
+		}
+		n, _ := f.Int(nil)
+		return json.Number(n.String()), nil
+	}
+}
+
+// ToNumber turns numeric strings such as "4.5" into numbers
+func ToNumber() Coercion {
+	return func(v interface{}) (interface{}, error) {
+		s, ok := scalarString(v)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a number", v)
+		}
+		s = strings.TrimSpace(s)
+		if !jsonNumberPattern.MatchString(s) {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		return json.Number(s), nil
+	}
+}
+
+// ToString turns numbers and booleans into their text, so a pincode stored
+// as 560001 in some records reads as "560001" everywhere
+func ToString() Coercion {
+	return func(v interface{}) (interface{}, error) {
+		switch val := v.(type) {
+		case string:
+			return val, nil
+		case json.Number:
+			return val.String(), nil
+		case bool:
+			return strconv.FormatBool(val), nil
+		}
+		return nil, fmt.Errorf("%v is not a scalar", v)
+	}
+}
+
+// ToBool turns "true"/"false", "yes"/"no", "1"/"0" and the numbers 1 and 0
+// into booleans
+func ToBool() Coercion {
+	return func(v interface{}) (interface{}, error) {
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		s, ok := scalarString(v)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a boolean", v)
+		}
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "true", "yes", "1":
+			return true, nil
+		case "false", "no", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%q is not a boolean", s)
+	}
+}
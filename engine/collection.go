@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// --- COLLECTION SETTINGS ---
+
+// systemPrefix namespaces the collections the engine keeps for itself
+const systemPrefix = "_system/"
+
+func isSystemCollection(collection string) bool {
+	return strings.HasPrefix(collection, systemPrefix)
+}
+
+// collectionConfig holds the per-collection behaviour registered on a Driver
+type collectionConfig struct {
+	schema   Schema
+	virtuals []virtualField
+	aliases  []fieldAlias
+	defaults []fieldDefault
+	required []string
+
+	validators []fieldValidator
+	coercions  []fieldCoercion
+	version    int
+	unique     []string
+	across     []*uniqueGroup // UniqueAcross groups the collection is in
+	search     []string
+	columns    []string
+	distinct   []string
+	refs       map[string]string // path -> target collection
+	views      []*view           // materialized views over the collection
+	triggers   []string          // names of the triggers watching the collection
+
+	upgrades        map[int]UpgradeFunc
+	persistUpgrades bool
+
+	history    bool
+	bitemporal bool
+
+	maxSize    int64 // largest document accepted, in bytes; zero for no limit
+	maxRecords int   // most records held; zero for the Driver's quota
+
+	codec      Codec // nil for the Driver's
+	dictionary int   // the dictionary writes compress with; 0 for the latest, -1 for none
+
+	policy *policy // nil for none
+}
+
+// config returns the settings for a collection, creating an empty entry on
+// first use. Callers must hold d.mutex.
+func (d *Driver) config(collection string) *collectionConfig {
+	c, ok := d.collections[collection]
+	if !ok {
+		c = &collectionConfig{}
+		d.collections[collection] = c
+	}
+	return c
+}
+
+// snapshotConfig returns a copy of the collection settings safe to use
+// without holding d.mutex
+func (d *Driver) snapshotConfig(collection string) *collectionConfig {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if c, ok := d.collections[collection]; ok {
+		cp := *c
+		return &cp
+	}
+	return &collectionConfig{}
+}
+
+// reshapesReads reports whether documents need reworking on their way out
+func (c *collectionConfig) reshapesReads() bool {
+	return len(c.virtuals) > 0 || len(c.aliases) > 0 || len(c.coercions) > 0
+}
+
+// reshapeRead applies the collection's read-time transforms to a record
+func (c *collectionConfig) reshapeRead(rec *Record) error {
+	upgrade := c.needsUpgrade(rec)
+	if !upgrade && !c.reshapesReads() {
+		return nil
+	}
+
+	doc, err := rec.Document()
+	if err != nil {
+		return err
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if upgrade {
+		if err := c.upgrade(rec, obj); err != nil {
+			return err
+		}
+	}
+	resolveAliases(c.aliases, obj)
+	applyCoercions(c.coercions, obj)
+	addVirtuals(c.virtuals, obj)
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	rec.Data = b
+	return nil
+}
+
+// reshapeWrite prepares a value for storage, removing anything that is only
+// ever computed on read
+func (c *collectionConfig) reshapeWrite(v interface{}) (interface{}, error) {
+	if !c.reshapesWrites() {
+		return v, nil
+	}
+
+	doc, err := toDocument(v)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return v, nil
+	}
+
+	resolveAliases(c.aliases, obj)
+	stripVirtuals(c.virtuals, obj)
+	if err := applyDefaults(c.defaults, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// reshapesWrites reports whether documents need reworking before storage
+func (c *collectionConfig) reshapesWrites() bool {
+	return len(c.virtuals) > 0 || len(c.aliases) > 0 || len(c.defaults) > 0
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// --- COLUMNAR SIDECAR ---
+
+// ColumnRow holds the column values of one record, in the order the fields
+// were asked for; nil where the record lacks a field
+type ColumnRow struct {
+	Resource string        `json:"resource"`
+	Values   []interface{} `json:"values"`
+}
+
+// columnIndex keeps the values of a collection's column fields for every
+// record, one slice per field, so queries over just those fields don't have
+// to read the documents. Like the search index it is built on first use
+// from the stored records and kept up to date by write and delete, under
+// the collection's write lock.
+type columnIndex struct {
+	fields    []string
+	resources []string
+	rows      map[string]int  // resource -> position in resources and values
+	values    [][]interface{} // field -> position -> value
+}
+
+// ColumnField keeps the values at the (dot separated) path of the documents
+// in collection in a compact in-memory column next to the files. Columns
+// answers from it, and aggregations without a Where filter whose group and
+// accumulator fields are all columns run from it without reading a single
+// document. Find only reads the records passing its conditions on columns,
+// as Plan describes.
+func (d *Driver) ColumnField(collection, path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	if !slices.Contains(cfg.columns, path) {
+		cfg.columns = append(slices.Clone(cfg.columns), path)
+	}
+}
+
+// Columns returns the values of the given column fields for every record of
+// collection, in name order. Every field must have been made a column with
+// ColumnField.
+func (d *Driver) Columns(collection string, fields []string, opts ...ReadOption) ([]ColumnRow, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+	cfg := d.snapshotConfig(collection)
+	if !columnsCover(cfg.columns, fields) {
+		return nil, fmt.Errorf("collection %s has no column for every one of %v", collection, fields)
+	}
+
+	var out []ColumnRow
+	if guard := d.readGuard(p.ctx, collection); guard != nil {
+		// the index doesn't hold what the policy matches on
+		err := d.scanPlanned(collection, guard, Interactive, func(rec *Record) error {
+			if !guard.Match(rec) {
+				return nil
+			}
+			values := make([]interface{}, len(fields))
+			for i, f := range fields {
+				values[i], _ = rec.Field(f)
+			}
+			out = append(out, ColumnRow{Resource: rec.Resource, Values: values})
+			return nil
+		})
+		return out, err
+	}
+	err := d.eachColumnRow(collection, cfg.columns, fields, func(resource string, values []interface{}) error {
+		out = append(out, ColumnRow{Resource: resource, Values: slices.Clone(values)})
+		return nil
+	})
+	return out, err
+}
+
+// columnsCover reports whether every one of fields is among columns
+func columnsCover(columns, fields []string) bool {
+	for _, f := range fields {
+		if !slices.Contains(columns, f) {
+			return false
+		}
+	}
+	return len(columns) > 0
+}
+
+// eachColumnRow calls fn, in name order, with the values of fields for every
+// record of collection, taken from its column index, stopping at the first
+// error. The values slice is reused between calls.
+func (d *Driver) eachColumnRow(collection string, columns, fields []string, fn func(resource string, values []interface{}) error) error {
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	idx, err := d.columnIndexFor(collection, columns)
+	if err != nil {
+		return err
+	}
+	at := make([]int, len(fields))
+	for i, f := range fields {
+		at[i] = slices.Index(idx.fields, f)
+	}
+	order := make([]int, len(idx.resources))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return idx.resources[order[i]] < idx.resources[order[j]] })
+
+	values := make([]interface{}, len(fields))
+	for _, row := range order {
+		for i, col := range at {
+			values[i] = idx.values[col][row]
+		}
+		if err := fn(idx.resources[row], values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnIndexFor returns the column index of collection for fields,
+// building it when missing or stale. Callers must hold the collection lock.
+func (d *Driver) columnIndexFor(collection string, fields []string) (*columnIndex, error) {
+	d.mutex.Lock()
+	idx := d.columns[collection]
+	d.mutex.Unlock()
+	if idx != nil && slices.Equal(idx.fields, fields) {
+		return idx, nil
+	}
+
+	idx = &columnIndex{
+		fields: fields,
+		rows:   make(map[string]int),
+		values: make([][]interface{}, len(fields)),
+	}
+	err := d.walk(collection, func(rec *Record) error {
+		idx.add(rec.Resource, rec.Field)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	d.columns[collection] = idx
+	d.mutex.Unlock()
+	return idx, nil
+}
+
+// invalidateColumns drops the column index of collection after its files
+// were replaced wholesale
+func (d *Driver) invalidateColumns(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.columns, collection)
+}
+
+// reindexColumns brings the column index of collection, if there is one,
+// up to date with the record just written. Callers must hold the
+// collection's write lock.
+func (d *Driver) reindexColumns(collection, resource string, v interface{}) {
+	d.mutex.Lock()
+	idx := d.columns[collection]
+	d.mutex.Unlock()
+	if idx == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		d.invalidateColumns(collection)
+		return
+	}
+	idx.add(resource, func(path string) (interface{}, bool) {
+		raw, ok := extractPath(b, path)
+		if !ok {
+			return nil, false
+		}
+		v, err := decodeDocument(raw)
+		return v, err == nil
+	})
+}
+
+// add sets the row of resource to the values field returns
+func (idx *columnIndex) add(resource string, field func(path string) (interface{}, bool)) {
+	row, ok := idx.rows[resource]
+	if !ok {
+		row = len(idx.resources)
+		idx.rows[resource] = row
+		idx.resources = append(idx.resources, resource)
+		for i := range idx.values {
+			idx.values[i] = append(idx.values[i], nil)
+		}
+	}
+	for i, f := range idx.fields {
+		idx.values[i][row], _ = field(f)
+	}
+}
+
+// remove drops the row of resource, moving the last row into its place
+func (idx *columnIndex) remove(resource string) {
+	row, ok := idx.rows[resource]
+	if !ok {
+		return
+	}
+	last := len(idx.resources) - 1
+	moved := idx.resources[last]
+	idx.resources[row] = moved
+	idx.rows[moved] = row
+	idx.resources = idx.resources[:last]
+	for i := range idx.values {
+		idx.values[i][row] = idx.values[i][last]
+		idx.values[i][last] = nil
+		idx.values[i] = idx.values[i][:last]
+	}
+	delete(idx.rows, resource)
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// --- GROUP COMMIT ---
+
+// minCommitWindow is the first step of a growing group commit window
+const minCommitWindow = 50 * time.Microsecond
+
+// WithGroupCommit batches the directory flushes of writes and deletes at
+// FsyncDir. Each change is written and its file flushed under the
+// collection lock as usual, but the lock is released before the directory
+// flush, which the changes waiting at the same time then share: a
+// collection under many writers costs one flush per round instead of one
+// per write. Calls still return only once their change is durable;
+// readers may see it a little earlier.
+//
+// Rounds also wait for more changes to join. The window adapts to load:
+// it halves whenever a round commits a single change, so a lone writer
+// isn't held up, and doubles, up to maxWindow, whenever a round is bigger
+// than the one before. maxWindow zero never waits, batching only the
+// changes arriving during a flush. Writes to a Storage are unaffected.
+func WithGroupCommit(maxWindow time.Duration) Option {
+	return func(o *options) {
+		o.groupCommit = true
+		o.commitWindow = maxWindow
+	}
+}
+
+// CommitMetrics describes the group commits of WithGroupCommit
+type CommitMetrics struct {
+	// Latency is how long changes waited for their round to be flushed
+	Latency Histogram `json:"latency"`
+	Rounds  int64     `json:"rounds"`
+	Changes int64     `json:"changes"`
+	// Window is how long the next round waits for changes to join
+	Window time.Duration `json:"window"`
+}
+
+// groupCommit collects the directories to flush into rounds. The change
+// that opens a round while no other round is being committed leads it:
+// it waits out the window and flushes the directories of every change
+// that joined meanwhile.
+type groupCommit struct {
+	max time.Duration
+
+	mu      sync.Mutex
+	open    *commitRound
+	leading bool
+	window  time.Duration
+	last    int
+	rounds  int64
+	changes int64
+
+	latency histogram
+}
+
+type commitRound struct {
+	dirs map[string]bool
+	n    int
+	done chan struct{}
+	err  error
+}
+
+func newGroupCommit(max time.Duration) *groupCommit {
+	return &groupCommit{max: max}
+}
+
+// commitLevel is the level a change at level runs at under the collection
+// lock, and whether its directories are then left to a group commit
+func (d *Driver) commitLevel(level Durability) (Durability, bool) {
+	if d.commits == nil || level < FsyncDir || !isLocal(d.fs) {
+		return level, false
+	}
+	return FsyncOnWrite, true
+}
+
+// sync flushes dirs in the next round and returns once it is committed
+func (g *groupCommit) sync(dirs ...string) error {
+	start := time.Now()
+
+	g.mu.Lock()
+	r := g.open
+	if r == nil {
+		r = &commitRound{dirs: make(map[string]bool), done: make(chan struct{})}
+		g.open = r
+	}
+	for _, dir := range dirs {
+		r.dirs[dir] = true
+	}
+	r.n++
+	lead := !g.leading
+	g.leading = true
+	g.mu.Unlock()
+
+	if lead {
+		g.lead()
+	}
+	<-r.done
+	g.latency.observe(time.Since(start))
+	return r.err
+}
+
+// lead commits the open round and hands leadership to the round opened
+// meanwhile, if any
+func (g *groupCommit) lead() {
+	g.mu.Lock()
+	window := g.window
+	g.mu.Unlock()
+	if window > 0 {
+		time.Sleep(window)
+	}
+
+	g.mu.Lock()
+	r := g.open
+	g.open = nil
+	g.mu.Unlock()
+
+	var errs []error
+	for dir := range r.dirs {
+		if err := syncDir(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.err = errors.Join(errs...)
+
+	g.mu.Lock()
+	g.adapt(r.n)
+	next := g.open != nil
+	g.leading = next
+	g.mu.Unlock()
+	close(r.done)
+	if next {
+		go g.lead()
+	}
+}
+
+// adapt sets the window from the size n of the round just committed.
+// Callers must hold g.mu.
+func (g *groupCommit) adapt(n int) {
+	g.rounds++
+	g.changes += int64(n)
+	switch {
+	case n == 1:
+		if g.window /= 2; g.window < minCommitWindow {
+			g.window = 0
+		}
+	case n > g.last:
+		if g.window *= 2; g.window < minCommitWindow {
+			g.window = minCommitWindow
+		}
+		if g.window > g.max {
+			g.window = g.max
+		}
+	}
+	g.last = n
+}
+
+func (g *groupCommit) snapshot() CommitMetrics {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return CommitMetrics{Latency: g.latency.snapshot(), Rounds: g.rounds, Changes: g.changes, Window: g.window}
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// --- SCHEMA COMPATIBILITY ---
+
+// maxCompatibilitySamples caps the violations kept in a CompatibilityReport
+const maxCompatibilitySamples = 10
+
+// CompatibilityReport tells how the stored documents of a collection fare
+// against a proposed schema
+type CompatibilityReport struct {
+	Collection string `json:"collection"`
+	Records    int    `json:"records"`
+	// Compatible is set when every record satisfies the schema, so it can
+	// be attached without rejecting rewrites of existing documents
+	Compatible bool `json:"compatible"`
+	// ViolationCount may exceed len(Violations), which keeps only samples
+	ViolationCount int               `json:"violationCount"`
+	Violations     []SchemaViolation `json:"violations,omitempty"`
+}
+
+// SchemaViolation is a stored record the proposed schema rejects
+type SchemaViolation struct {
+	Resource string `json:"resource"`
+	Error    string `json:"error"`
+}
+
+// CheckCompatibility validates every stored document of collection against
+// s without attaching it, so a tightened schema can be tried before
+// SetSchema enforces it. Documents are checked as they read, after any
+// upgrade to the current schema version.
+func (d *Driver) CheckCompatibility(collection string, s Schema) (*CompatibilityReport, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	if js, ok := s.(*JSONSchema); ok {
+		if err := js.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &CompatibilityReport{Collection: collection}
+	err := d.scan(collection, func(rec *Record) error {
+		report.Records++
+		doc, err := rec.Document()
+		if err != nil {
+			return err
+		}
+		if err := s.Validate(doc); err != nil {
+			report.ViolationCount++
+			if len(report.Violations) < maxCompatibilitySamples {
+				report.Violations = append(report.Violations, SchemaViolation{Resource: rec.Resource, Error: err.Error()})
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	report.Compatible = report.ViolationCount == 0
+	return report, nil
+}
@@ -0,0 +1,392 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// --- CONSUMER GROUPS ---
+
+// ConsumerCollection holds the offsets committed by consumer groups, one
+// record per group
+const ConsumerCollection = systemPrefix + "consumers"
+
+// groupPartitions is how many partitions a group splits a collection's
+// records into. Members own whole partitions, so a group can't usefully
+// have more members than this.
+const groupPartitions = 16
+
+// ErrGroupClosed is returned (wrapped) by Consumer calls once the consumer
+// has left its group, or the group lost its feed because the Driver was
+// closed or restored or the group fell too far behind; join again to
+// carry on from the committed offsets
+var ErrGroupClosed = errors.New("consumer group closed")
+
+// ErrNotOwner is returned by Consumer.Commit for a change of a partition
+// the group has since handed to another member, who receives it again
+var ErrNotOwner = errors.New("partition no longer owned by this consumer")
+
+// Consumer is one member of a consumer group, as returned by JoinGroup
+type Consumer struct {
+	g    *group
+	left chan struct{}
+}
+
+// group is the state shared by the members of a consumer group
+type group struct {
+	d          *Driver
+	name       string
+	collection string
+	cancel     context.CancelFunc
+
+	mu      sync.Mutex
+	members []*Consumer
+	owner   [groupPartitions]*Consumer
+	// queue holds each partition's uncommitted changes, oldest first, of
+	// which the first cursor were delivered to its owner
+	queue   [groupPartitions][]Change
+	cursor  [groupPartitions]int
+	offsets [groupPartitions]uint64
+	// caught holds, per resource, the sequence number of the version the
+	// catch-up scan delivered, so the live feed doesn't repeat it
+	caught map[string]uint64
+	wake   chan struct{}
+	err    error
+}
+
+// groupOffsets is the stored record of a group's committed offsets
+type groupOffsets struct {
+	Collection string   `json:"collection"`
+	Offsets    []uint64 `json:"offsets"`
+}
+
+// JoinGroup adds a consumer to the named group consuming the changes of
+// collection, creating the group if it has no members yet. The group's
+// members share the collection's changes: each owns some of its records,
+// and receives their changes in the order they were applied. Members
+// joining or leaving rebalance ownership, and changes a member was handed
+// but didn't commit go to the new owner.
+//
+// Committed offsets are kept in ConsumerCollection, so a group joined
+// again, after a restart too, resumes just after them: a committed change
+// is never delivered again. A group catches up on what it missed from the
+// records' metadata, see WithMetadata, delivering the current version of
+// each record written since; without metadata it starts from the live
+// feed. Deletes made while no member was running are not replayed.
+//
+// The consumer leaves when ctx is done or Leave is called.
+func (d *Driver) JoinGroup(ctx context.Context, name, collection string) (*Consumer, error) {
+	if err := validateName("group", name); err != nil {
+		return nil, err
+	}
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	g := d.groups[name]
+	d.mutex.Unlock()
+	if g == nil {
+		var err error
+		if g, err = d.openGroup(name, collection); err != nil {
+			return nil, err
+		}
+	}
+	if g.collection != collection {
+		return nil, fmt.Errorf("group %s consumes %s, not %s", name, g.collection, collection)
+	}
+
+	c := &Consumer{g: g, left: make(chan struct{})}
+	g.mu.Lock()
+	if g.err != nil {
+		// lost its feed; the next join opens the group afresh
+		err := g.err
+		g.mu.Unlock()
+		g.forget()
+		return nil, err
+	}
+	g.members = append(g.members, c)
+	g.rebalance()
+	g.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Leave()
+		case <-c.left:
+		}
+	}()
+	return c, nil
+}
+
+// openGroup starts a group: it loads the committed offsets, subscribes to
+// the feed and catches up on the changes made since
+func (d *Driver) openGroup(name, collection string) (*group, error) {
+	g := &group{d: d, name: name, collection: collection, caught: make(map[string]uint64), wake: make(chan struct{})}
+
+	var state groupOffsets
+	rec, err := d.readRecord(ConsumerCollection, name)
+	if err == nil {
+		err = json.Unmarshal(rec.Data, &state)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if state.Collection != "" && state.Collection != collection {
+		return nil, fmt.Errorf("group %s consumes %s, not %s", name, state.Collection, collection)
+	}
+	copy(g.offsets[:], state.Offsets)
+
+	// subscribed before catching up, so nothing falls in between
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d.Watch(ctx, collection)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	g.cancel = cancel
+	if d.opts.metadata {
+		if err := g.catchUp(); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	d.mutex.Lock()
+	if other := d.groups[name]; other != nil {
+		// another member opened it meanwhile
+		d.mutex.Unlock()
+		cancel()
+		return other, nil
+	}
+	d.groups[name] = g
+	d.mutex.Unlock()
+
+	go g.dispatch(ch)
+	return g, nil
+}
+
+// catchUp queues the current version of every record written since the
+// committed offset of its partition
+func (g *group) catchUp() error {
+	var missed []Change
+	err := g.d.scan(g.collection, func(rec *Record) error {
+		if rec.Meta == nil || rec.Meta.Seq <= g.offsets[partitionOf(rec.Resource)] {
+			return nil
+		}
+		missed = append(missed, Change{
+			Kind:          OpWrite,
+			Collection:    g.collection,
+			Resource:      rec.Resource,
+			Time:          rec.Meta.UpdatedAt,
+			Document:      append(json.RawMessage(nil), rec.Data...),
+			CollectionSeq: rec.Meta.Seq,
+		})
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].CollectionSeq < missed[j].CollectionSeq })
+	for _, c := range missed {
+		p := partitionOf(c.Resource)
+		g.queue[p] = append(g.queue[p], c)
+		g.caught[c.Resource] = c.CollectionSeq
+	}
+	return nil
+}
+
+// dispatch queues the changes of the live feed until it ends
+func (g *group) dispatch(ch <-chan Change) {
+	for c := range ch {
+		p := partitionOf(c.Resource)
+		g.mu.Lock()
+		if c.CollectionSeq > g.offsets[p] && c.CollectionSeq > g.caught[c.Resource] {
+			g.queue[p] = append(g.queue[p], c)
+			g.broadcast()
+		}
+		g.mu.Unlock()
+	}
+
+	g.mu.Lock()
+	if g.err == nil {
+		g.err = fmt.Errorf("%w: change feed of %s ended", ErrGroupClosed, g.collection)
+		g.d.opts.logger.Warn("consumer group lost its feed", "group", g.name, "collection", g.collection)
+	}
+	g.broadcast()
+	g.mu.Unlock()
+	g.forget()
+}
+
+// forget removes the group from the Driver, so that joining it again
+// opens it afresh
+func (g *group) forget() {
+	g.d.mutex.Lock()
+	defer g.d.mutex.Unlock()
+	if g.d.groups[g.name] == g {
+		delete(g.d.groups, g.name)
+	}
+}
+
+// rebalance hands the partitions out to the members in turn, in the order
+// they joined. Callers must hold g.mu.
+func (g *group) rebalance() {
+	for p := range g.owner {
+		var owner *Consumer
+		if len(g.members) > 0 {
+			owner = g.members[p%len(g.members)]
+		}
+		if owner != g.owner[p] {
+			g.owner[p] = owner
+			g.cursor[p] = 0
+		}
+	}
+	g.broadcast()
+}
+
+// broadcast wakes the members waiting in Next. Callers must hold g.mu.
+func (g *group) broadcast() {
+	close(g.wake)
+	g.wake = make(chan struct{})
+}
+
+// Group is the name of the consumer's group
+func (c *Consumer) Group() string {
+	return c.g.name
+}
+
+// Partitions are the partitions the consumer currently owns
+func (c *Consumer) Partitions() []int {
+	g := c.g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out []int
+	for p, owner := range g.owner {
+		if owner == c {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Next waits for the next change of the records the consumer owns. The
+// change stays pending until committed: if the consumer leaves first, it
+// is delivered to the member taking over.
+func (c *Consumer) Next(ctx context.Context) (Change, error) {
+	g := c.g
+	for {
+		g.mu.Lock()
+		if g.err != nil {
+			g.mu.Unlock()
+			return Change{}, g.err
+		}
+		if !slices.Contains(g.members, c) {
+			g.mu.Unlock()
+			return Change{}, fmt.Errorf("%w: consumer left", ErrGroupClosed)
+		}
+		next := -1
+		for p, owner := range g.owner {
+			if owner != c || g.cursor[p] >= len(g.queue[p]) {
+				continue
+			}
+			if next < 0 || g.queue[p][g.cursor[p]].CollectionSeq < g.queue[next][g.cursor[next]].CollectionSeq {
+				next = p
+			}
+		}
+		if next >= 0 {
+			change := g.queue[next][g.cursor[next]]
+			g.cursor[next]++
+			g.mu.Unlock()
+			return change, nil
+		}
+		wake := g.wake
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Change{}, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// Commit records that change, and every change of its partition delivered
+// before it, has been processed, durably, so the group never delivers them
+// again. It fails with ErrNotOwner when the partition has moved to
+// another member since.
+func (c *Consumer) Commit(change Change) error {
+	g := c.g
+	p := partitionOf(change.Resource)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		return g.err
+	}
+	if g.owner[p] != c {
+		return ErrNotOwner
+	}
+	if change.CollectionSeq <= g.offsets[p] {
+		return nil
+	}
+
+	offsets := g.offsets
+	offsets[p] = change.CollectionSeq
+	state := groupOffsets{Collection: g.collection, Offsets: offsets[:]}
+	if err := g.d.write(ConsumerCollection, g.name, state); err != nil {
+		return err
+	}
+	g.offsets = offsets
+
+	n := 0
+	for n < len(g.queue[p]) && g.queue[p][n].CollectionSeq <= change.CollectionSeq {
+		if res := g.queue[p][n].Resource; g.caught[res] <= change.CollectionSeq {
+			delete(g.caught, res)
+		}
+		n++
+	}
+	g.queue[p] = g.queue[p][n:]
+	g.cursor[p] = max(g.cursor[p]-n, 0)
+	return nil
+}
+
+// Leave removes the consumer from its group, handing its partitions and
+// their pending changes to the remaining members. The last member leaving
+// closes the group.
+func (c *Consumer) Leave() error {
+	g := c.g
+	g.mu.Lock()
+	i := slices.Index(g.members, c)
+	if i < 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	g.members = slices.Delete(g.members, i, i+1)
+	close(c.left)
+	g.rebalance()
+	last := len(g.members) == 0
+	if last && g.err == nil {
+		g.err = fmt.Errorf("%w: no members left", ErrGroupClosed)
+	}
+	g.mu.Unlock()
+
+	if last {
+		g.forget()
+		g.cancel()
+	}
+	return nil
+}
+
+// partitionOf is the group partition the changes of resource fall into
+func partitionOf(resource string) int {
+	h := fnv.New32a()
+	h.Write([]byte(resource))
+	return int(h.Sum32() % groupPartitions)
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// --- IN-PLACE UPDATES ---
+
+// errPrecondition reports a conditional write that found the record
+// changed since it was read
+var errPrecondition = errors.New("record changed since it was read")
+
+// errUnchanged lets an update function leave the record as it is
+var errUnchanged = errors.New("record unchanged")
+
+// Increment adds delta to the number at the (dot separated) path of a
+// record and returns the new value. A missing record or field counts as 0,
+// so the first Increment creates it. The record is re-read and the update
+// retried whenever another write lands in between, and only written if
+// unchanged since it was read, under the collection lock, so concurrent
+// increments are never lost. The write runs through hooks and validation
+// as with Write; a field holding anything but a number fails with an error
+// wrapping ErrValidation.
+func (d *Driver) Increment(collection, resource, path string, delta float64, opts ...WriteOption) (float64, error) {
+	var n float64
+	err := d.update(collection, resource, true, newWriteParams(opts), func(obj map[string]interface{}) error {
+		n = 0
+		if v, ok := lookupPath(obj, path); ok && v != nil {
+			num, isNum := v.(json.Number)
+			if !isNum {
+				return validationError(path, "is not a number")
+			}
+			f, err := num.Float64()
+			if err != nil {
+				return validationError(path, "is not a number")
+			}
+			n = f
+		}
+		n += delta
+		return setField(obj, path, n)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// update rewrites the document of a record with fn, atomically as
+// Increment describes. With create, a missing record starts out as an
+// empty object; otherwise it fails with an error wrapping fs.ErrNotExist.
+func (d *Driver) update(collection, resource string, create bool, p writeParams, fn func(obj map[string]interface{}) error) error {
+	if err := validateNames(collection, resource); err != nil {
+		return err
+	}
+	if err := d.admit(&p, ActionWrite, collection, resource); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	for {
+		obj, raw, err := d.readObject(collection, resource)
+		if errors.Is(err, fs.ErrNotExist) && create {
+			obj, err = make(map[string]interface{}), nil
+		}
+		if err == nil {
+			err = fn(obj)
+		}
+		if errors.Is(err, errUnchanged) {
+			return nil
+		}
+		if err == nil {
+			p.expect, p.absent = raw, raw == nil
+			err = d.applyWrite(collection, resource, obj, p)
+		}
+		if errors.Is(err, errPrecondition) {
+			continue
+		}
+		d.metrics.counters(collection).writes.done(start, err)
+		if err != nil && obj != nil {
+			d.deadLetterWrite(collection, resource, obj, err)
+		}
+		return err
+	}
+}
+
+// readObject reads the document of a record, which must be an object, and
+// the record's file as stored
+func (d *Driver) readObject(collection, resource string) (map[string]interface{}, []byte, error) {
+	rec, err := d.readRecord(collection, resource)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := rec.Document()
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, nil, validationError("", "is not an object")
+	}
+	return obj, rec.raw, nil
+}
+
+// setField stores val at path for an update, failing validation if a
+// non-object value is in the way
+func setField(obj map[string]interface{}, path string, val interface{}) error {
+	if !setPath(obj, path, val) {
+		return validationError(path, "runs through something other than an object")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// --- ARCHIVE ENCRYPTION ---
+
+// An encrypted archive is encryptMagic and a random salt, followed by
+// chunks of at most encryptChunk plaintext bytes, each sealed with AES-GCM
+// under a key derived from the user's key and the salt. Chunks are
+// numbered through their nonce and the last one is marked in its
+// additional data, so reordered, dropped or truncated chunks fail to open.
+const (
+	encryptMagic = "GDBAES1\n"
+	encryptChunk = 64 << 10
+	saltSize     = 32
+)
+
+func archiveCipher(key, salt []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("backup key must be 16, 24 or 32 bytes, not %d", len(key))
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+var (
+	chunkMore = []byte{0}
+	chunkLast = []byte{1}
+)
+
+type encryptWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := archiveCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encryptMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, encryptChunk)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(encryptChunk-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:k]...)
+		p = p[k:]
+		if len(e.buf) == encryptChunk {
+			if err := e.seal(chunkMore); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close seals the last chunk; it doesn't close the underlying writer
+func (e *encryptWriter) Close() error {
+	return e.seal(chunkLast)
+}
+
+func (e *encryptWriter) seal(mark []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(e.buf)))
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.seq), e.buf, mark)
+	e.seq++
+	e.buf = e.buf[:0]
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+	last bool
+	// err sticks, as bufio.Reader.Peek forgets the errors it runs into
+	err error
+}
+
+func newDecryptReader(r io.Reader, key []byte) (*decryptReader, error) {
+	head := make([]byte, len(encryptMagic)+saltSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("%w: encryption header: %v", ErrCorruptBackup, err)
+	}
+	aead, err := archiveCipher(key, head[len(encryptMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return fmt.Errorf("%w: archive is truncated", ErrCorruptBackup)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > encryptChunk {
+		return fmt.Errorf("%w: bad chunk size %d", ErrCorruptBackup, n)
+	}
+	sealed := make([]byte, int(n)+d.aead.Overhead())
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("%w: archive is truncated", ErrCorruptBackup)
+	}
+
+	nonce := chunkNonce(d.aead, d.seq)
+	plain, err := d.aead.Open(nil, nonce, sealed, chunkMore)
+	if err != nil {
+		if plain, err = d.aead.Open(nil, nonce, sealed, chunkLast); err != nil {
+			return fmt.Errorf("%w: wrong key or damaged data", ErrCorruptBackup)
+		}
+		d.last = true
+	}
+	d.seq++
+	d.buf = plain
+	return nil
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// --- DEAD LETTERS ---
+
+// DeadLetterCollection holds failed operations when WithDeadLetter is set
+const DeadLetterCollection = systemPrefix + "deadletter"
+
+// DeadLetterEntry is a failed operation kept for inspection and retry
+type DeadLetterEntry struct {
+	ID         string          `json:"id"`
+	Op         string          `json:"op"`
+	Collection string          `json:"collection"`
+	Resource   string          `json:"resource,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	Raw        string          `json:"raw,omitempty"`
+	KeyField   string          `json:"keyField,omitempty"`
+	Error      string          `json:"error"`
+	FailedAt   time.Time       `json:"failedAt"`
+	Attempts   int             `json:"attempts"`
+}
+
+// recordDeadLetter stores a failed operation. Failures to record are
+// ignored: the original error has already been returned to the caller.
+func (d *Driver) recordDeadLetter(e DeadLetterEntry) {
+	id, err := NewID()
+	if err != nil {
+		return
+	}
+	e.ID = id
+	e.FailedAt = time.Now().UTC()
+	e.Attempts = 1
+	d.opts.logger.Warn("operation dead-lettered", "id", e.ID, "op", e.Op, "collection", e.Collection, "resource", e.Resource, "err", e.Error)
+	d.write(DeadLetterCollection, e.ID, e)
+}
+
+// deadLetterWrite records a failed Write when dead-lettering is enabled
+func (d *Driver) deadLetterWrite(collection, resource string, v interface{}, cause error) {
+	if !d.opts.deadLetter {
+		return
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	d.recordDeadLetter(DeadLetterEntry{
+		Op:         OpWrite.String(),
+		Collection: collection,
+		Resource:   resource,
+		Payload:    payload,
+		Error:      cause.Error(),
+	})
+}
+
+// deadLetterDelete records a failed Delete when dead-lettering is enabled.
+// Deleting a record that doesn't exist isn't worth retrying.
+func (d *Driver) deadLetterDelete(collection, resource string, cause error) {
+	if !d.opts.deadLetter || errors.Is(cause, fs.ErrNotExist) {
+		return
+	}
+	d.recordDeadLetter(DeadLetterEntry{
+		Op:         OpDelete.String(),
+		Collection: collection,
+		Resource:   resource,
+		Error:      cause.Error(),
+	})
+}
+
+// DeadLetters lists the failed operations waiting in the dead-letter collection
+func (d *Driver) DeadLetters() ([]DeadLetterEntry, error) {
+	var entries []DeadLetterEntry
+	err := d.scan(DeadLetterCollection, func(rec *Record) error {
+		var e DeadLetterEntry
+		if err := rec.Decode(&e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// RetryDeadLetter replays a failed operation. On success the entry is
+// removed; otherwise its attempt count and last error are updated.
+func (d *Driver) RetryDeadLetter(id string) error {
+	if err := validateName("dead-letter id", id); err != nil {
+		return err
+	}
+
+	rec, err := d.readRecord(DeadLetterCollection, id)
+	if err != nil {
+		return err
+	}
+	var e DeadLetterEntry
+	if err := rec.Decode(&e); err != nil {
+		return err
+	}
+
+	retryErr := d.replay(e)
+	if retryErr == nil {
+		return d.delete(DeadLetterCollection, id)
+	}
+
+	e.Attempts++
+	e.Error = retryErr.Error()
+	e.FailedAt = time.Now().UTC()
+	if err := d.write(DeadLetterCollection, id, e); err != nil {
+		return err
+	}
+	return retryErr
+}
+
+// RetryDeadLetters replays every dead-lettered operation and reports how many
+// succeeded. Entries that fail again stay in the collection.
+func (d *Driver) RetryDeadLetters() (retried, failed int, err error) {
+	entries, err := d.DeadLetters()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		if d.RetryDeadLetter(e.ID) != nil {
+			failed++
+			continue
+		}
+		retried++
+	}
+	return retried, failed, nil
+}
+
+// DiscardDeadLetter drops a dead-lettered operation without replaying it
+func (d *Driver) DiscardDeadLetter(id string) error {
+	if err := validateName("dead-letter id", id); err != nil {
+		return err
+	}
+	return d.delete(DeadLetterCollection, id)
+}
+
+// replay applies a failed operation again, through the Authorizer and the
+// Policy as a call without a context, so that an operation they denied
+// doesn't get in by way of its dead letter
+func (d *Driver) replay(e DeadLetterEntry) error {
+	switch e.Op {
+	case OpWrite.String():
+		doc, err := decodeDocument(e.Payload)
+		if err != nil {
+			return err
+		}
+		var p writeParams
+		if err := d.admit(&p, ActionWrite, e.Collection, e.Resource); err != nil {
+			return err
+		}
+		return d.applyWrite(e.Collection, e.Resource, doc, p)
+	case OpDelete.String():
+		var p writeParams
+		if err := d.admit(&p, ActionDelete, e.Collection, e.Resource); err != nil {
+			return err
+		}
+		return d.applyDelete(e.Collection, e.Resource, p)
+	case opImport:
+		return d.importRecord(nil, e.Collection, e.KeyField, []byte(e.Raw))
+	}
+	return fmt.Errorf("unknown dead-letter operation %q", e.Op)
+}
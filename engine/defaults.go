@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"sort"
+	"time"
+)
+
+// --- DEFAULTS AND REQUIRED FIELDS ---
+
+// DefaultFunc computes a default value at write time
+type DefaultFunc func() interface{}
+
+// ServerTimestamp is a default that fills the field with the current UTC time
+var ServerTimestamp DefaultFunc = func() interface{} { return time.Now().UTC() }
+
+type fieldDefault struct {
+	path  string
+	value interface{}
+}
+
+// SetDefaults registers default values applied to documents written into
+// collection when the field is absent. Keys are dot separated paths; values
+// are stored as given unless they are a DefaultFunc, which is called on every
+// write. Calling SetDefaults again replaces the previous set.
+func (d *Driver) SetDefaults(collection string, defaults map[string]interface{}) {
+	list := make([]fieldDefault, 0, len(defaults))
+	for path, v := range defaults {
+		list = append(list, fieldDefault{path: path, value: v})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].path < list[j].path })
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).defaults = list
+}
+
+// RequireFields makes Write reject documents in collection that are missing
+// any of the given (dot separated) fields or have them set to null
+func (d *Driver) RequireFields(collection string, fields ...string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).required = append([]string(nil), fields...)
+}
+
+// applyDefaults fills in missing fields
+func applyDefaults(defaults []fieldDefault, doc map[string]interface{}) error {
+	for _, def := range defaults {
+		if _, ok := lookupPath(doc, def.path); ok {
+			continue
+		}
+
+		v := def.value
+		if fn, ok := v.(DefaultFunc); ok {
+			v = fn()
+		}
+
+		// the value has to be in generic form like the rest of doc
+		generic, err := toDocument(v)
+		if err != nil {
+			return err
+		}
+		setPath(doc, def.path, generic)
+	}
+	return nil
+}
+
+// checkRequired reports the first required field that is missing or null
+func checkRequired(required []string, doc interface{}) error {
+	obj, _ := doc.(map[string]interface{})
+	for _, path := range required {
+		if v, ok := lookupPath(obj, path); !ok || v == nil {
+			return validationError(path, "is required")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- BACKUP DESTINATIONS ---
+
+// DirDestination stores backups as files in a local directory, typically on
+// another disk or a network mount
+func DirDestination(dir string) BackupDestination {
+	return dirDestination(dir)
+}
+
+type dirDestination string
+
+func (dir dirDestination) Put(name string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(string(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(string(dir), "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(string(dir), name))
+}
+
+func (dir dirDestination) List() ([]string, error) {
+	entries, err := os.ReadDir(string(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (dir dirDestination) Delete(name string) error {
+	return os.Remove(filepath.Join(string(dir), name))
+}
+
+// S3Config locates a bucket of an S3 compatible object store
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g.
+	// https://s3.eu-west-1.amazonaws.com; buckets are addressed by path
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every object key, e.g. "db/backups/"
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only needed with temporary credentials
+	SessionToken string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// S3Destination stores backups as objects in an S3 bucket, signing requests
+// with AWS Signature Version 4
+func S3Destination(cfg S3Config) BackupDestination {
+	return &s3Destination{newS3Client(cfg)}
+}
+
+type s3Destination struct {
+	*s3Client
+}
+
+func (s *s3Destination) Put(name string, r io.Reader, size int64) error {
+	_, _, err := s.do(http.MethodPut, s.cfg.Prefix+name, nil, r, size)
+	return err
+}
+
+func (s *s3Destination) Delete(name string) error {
+	_, _, err := s.do(http.MethodDelete, s.cfg.Prefix+name, nil, nil, 0)
+	return err
+}
+
+func (s *s3Destination) List() ([]string, error) {
+	objects, _, err := s.list(s.cfg.Prefix, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		names = append(names, strings.TrimPrefix(obj.Key, s.cfg.Prefix))
+	}
+	return names, nil
+}
+
+// s3Client makes signed requests to a bucket
+type s3Client struct {
+	cfg S3Config
+}
+
+func newS3Client(cfg S3Config) *s3Client {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &s3Client{cfg}
+}
+
+// s3Object is an entry of a bucket listing
+type s3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// list returns the objects whose keys start with prefix and, with a
+// delimiter, the common prefixes that group the keys containing it past
+// prefix. max bounds the entries returned; zero means all.
+func (s *s3Client) list(prefix, delimiter string, max int) ([]s3Object, []string, error) {
+	var (
+		objects  []s3Object
+		prefixes []string
+		token    string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if max > 0 {
+			query.Set("max-keys", strconv.Itoa(max))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, _, err := s.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var page struct {
+			Contents       []s3Object
+			CommonPrefixes []struct {
+				Prefix string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, nil, fmt.Errorf("s3 list: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if max > 0 || !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, prefixes, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// s3Error is a request the service refused. Not found answers unwrap to
+// fs.ErrNotExist.
+type s3Error struct {
+	Method string
+	Path   string
+	Status int
+	Body   string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3 %s %s: %d %s: %s", e.Method, e.Path, e.Status, http.StatusText(e.Status), e.Body)
+}
+
+func (e *s3Error) Unwrap() error {
+	if e.Status == http.StatusNotFound {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// do sends a signed request for key in the bucket and returns the body and
+// headers of a successful response
+func (s *s3Client) do(method, key string, query url.Values, body io.Reader, size int64) ([]byte, http.Header, error) {
+	path := "/" + s.cfg.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	u.RawPath = s3Escape(path)
+	u.Path = path
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, &s3Error{Method: method, Path: path, Status: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	return b, resp.Header, nil
+}
+
+// sign adds an AWS Signature Version 4 authorization to req. The payload is
+// left unsigned, which S3 accepts, so bodies can be streamed.
+func (s *s3Client) sign(req *http.Request, now time.Time) {
+	const payload = "UNSIGNED-PAYLOAD"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signed,
+		payload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), day)
+	for _, part := range []string{s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes a path the way Signature Version 4 expects,
+// keeping only unreserved characters and slashes
+func s3Escape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3EscapeComponent is s3Escape for query keys and values, which escape
+// slashes too
+func s3EscapeComponent(s string) string {
+	return strings.ReplaceAll(s3Escape(s), "/", "%2F")
+}
+
+// s3Query encodes a query string in the sorted, fully escaped form that
+// Signature Version 4 signs
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3EscapeComponent(k)+"="+s3EscapeComponent(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
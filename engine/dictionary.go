@@ -0,0 +1,425 @@
+package engine
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// --- COMPRESSION DICTIONARIES ---
+
+// dictPrefix starts the name of the hidden files in a collection's
+// directory holding its dictionaries, .dict-1 the first trained
+const dictPrefix = ".dict-"
+
+// dictMagic starts a record file compressed with a dictionary, followed
+// by the dictionary's ID and the DEFLATE stream. No JSON starts with it.
+const dictMagic = "\x00JZD"
+
+const (
+	dictMaxSize     = 32 << 10 // the DEFLATE window, all a dictionary can reach back
+	dictSamples     = 1000
+	dictMaxPiece    = 64
+	dictMinDocument = 2 // documents to train on at the least
+)
+
+// DictionaryOptions configures TrainDictionary
+type DictionaryOptions struct {
+	// Samples is how many documents are sampled, evenly spread over the
+	// collection's names; 1000 by default
+	Samples int
+	// Size is the largest dictionary built, in bytes; 32KB, the most
+	// DEFLATE can use, by default
+	Size int
+}
+
+// Dictionary describes a dictionary TrainDictionary built
+type Dictionary struct {
+	ID      int `json:"id"`
+	Size    int `json:"size"`
+	Samples int `json:"samples"`
+	// SampleBytes is the size of the sampled files; Compressed what they
+	// compress to with the dictionary, and Plain without it
+	SampleBytes int `json:"sampleBytes"`
+	Compressed  int `json:"compressed"`
+	Plain       int `json:"plain"`
+}
+
+// dictionary is a trained dictionary of a collection
+type dictionary struct {
+	id      int
+	data    []byte
+	writers sync.Pool // *flate.Writer set up with data
+}
+
+// dictionaries caches the dictionaries of a collection
+type dictionaries struct {
+	latest int // the one writes use, 0 for none
+	byID   map[int]*dictionary
+}
+
+// TrainDictionary samples the documents of collection and builds from
+// the pieces of JSON they share, field names above all, a dictionary
+// that record files are compressed with from then on. Small documents of
+// the same shape, which compress poorly one by one, shrink to a fraction
+// of their size this way. The dictionary is kept in the collection's
+// directory, so backups and archives carry it, and every Driver opening
+// the database reads and writes the collection with it; files written
+// before stay readable, and RewriteFormats compresses them too. Training
+// again later makes a new dictionary for new writes, keeping the old ones
+// for the files compressed with them; CollectionOptions can pin writes to
+// an older one, or turn compression off. Collections with a Codec can't be
+// compressed.
+func (d *Driver) TrainDictionary(collection string, opts DictionaryOptions) (*Dictionary, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	if err := d.writable(); err != nil {
+		return nil, err
+	}
+	cfg := d.snapshotConfig(collection)
+	if isSystemCollection(collection) || d.codecOf(collection, cfg) != nil {
+		return nil, fmt.Errorf("%s: only plain JSON collections can be compressed", collection)
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = dictSamples
+	}
+	if opts.Size <= 0 || opts.Size > dictMaxSize {
+		opts.Size = dictMaxSize
+	}
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	names, err := d.recordNames(collection)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > opts.Samples {
+		picked := make([]string, opts.Samples)
+		for i := range picked {
+			picked[i] = names[i*len(names)/opts.Samples]
+		}
+		names = picked
+	}
+	var samples [][]byte
+	err = d.walkNamesAt(collection, names, Batch, func(rec *Record) error {
+		samples = append(samples, rec.raw)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) < dictMinDocument {
+		return nil, fmt.Errorf("%s: at least %d documents are needed to train a dictionary", collection, dictMinDocument)
+	}
+	data := trainDictionary(samples, opts.Size)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s: the sampled documents share nothing to build a dictionary from", collection)
+	}
+
+	set, err := d.dictionariesOf(collection)
+	if err != nil {
+		return nil, err
+	}
+	dict := &dictionary{id: set.latest + 1, data: data}
+	// durable before the first file compressed with it
+	if err := d.fs.WriteFile(d.dictionaryPath(collection, dict.id), data, FsyncDir); err != nil {
+		return nil, err
+	}
+	d.mutex.Lock()
+	set.byID[dict.id] = dict
+	set.latest = dict.id
+	d.mutex.Unlock()
+
+	out := &Dictionary{ID: dict.id, Size: len(data), Samples: len(samples)}
+	for _, s := range samples {
+		out.SampleBytes += len(s)
+		c, err := dict.compress(s)
+		if err != nil {
+			return nil, err
+		}
+		out.Compressed += len(c)
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		w.Write(s)
+		w.Close()
+		out.Plain += len(dictMagic) + 4 + buf.Len()
+	}
+	d.opts.logger.Info("compression dictionary trained", "collection", collection, "id", dict.id, "size", len(data), "samples", len(samples))
+	return out, nil
+}
+
+// trainDictionary picks the pieces of samples found in the most of them,
+// weighted by length, into a dictionary of up to size bytes. The best go
+// last, where DEFLATE reaches them with the shortest distances.
+func trainDictionary(samples [][]byte, size int) []byte {
+	docs := make(map[string]int)
+	for _, s := range samples {
+		seen := make(map[string]bool)
+		for _, p := range dictionaryPieces(s) {
+			if !seen[p] {
+				seen[p] = true
+				docs[p]++
+			}
+		}
+	}
+	type piece struct {
+		s     string
+		score int
+	}
+	var pieces []piece
+	for s, n := range docs {
+		// a piece of one document alone doesn't repeat across records
+		if n > 1 {
+			pieces = append(pieces, piece{s: s, score: n * len(s)})
+		}
+	}
+	sort.Slice(pieces, func(i, j int) bool {
+		if pieces[i].score != pieces[j].score {
+			return pieces[i].score > pieces[j].score
+		}
+		return pieces[i].s < pieces[j].s
+	})
+
+	var chosen []string
+	total := 0
+	for _, p := range pieces {
+		if total+len(p.s) > size {
+			continue
+		}
+		chosen = append(chosen, p.s)
+		total += len(p.s)
+	}
+	out := make([]byte, 0, total)
+	for i := len(chosen) - 1; i >= 0; i-- {
+		out = append(out, chosen[i]...)
+	}
+	// a whole document in the end puts the pieces in the order records
+	// hold them, if there is room
+	if last := samples[len(samples)-1]; len(out) > 0 && len(out)+len(last) <= size {
+		out = append(out, last...)
+	}
+	return out
+}
+
+// dictionaryPieces cuts JSON as it is stored into pieces: each runs up to
+// the closing quote of a string or up to a comma, so that a field name
+// comes with the indentation and punctuation before it, and a short
+// string value with the colon before it
+func dictionaryPieces(b []byte) []string {
+	var pieces []string
+	start, inString := 0, false
+	cut := func(end int) {
+		if end > start && end-start <= dictMaxPiece {
+			pieces = append(pieces, string(b[start:end]))
+		}
+		start = end
+	}
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			if inString {
+				cut(i + 1)
+			}
+			inString = !inString
+		case !inString && c == ',':
+			cut(i)
+		}
+	}
+	cut(len(b))
+	return pieces
+}
+
+func (d *Driver) dictionaryPath(collection string, id int) string {
+	return filepath.Join(d.collectionDir(collection), dictPrefix+strconv.Itoa(id))
+}
+
+// dictionariesOf returns the dictionaries of collection, finding the
+// latest on first use
+func (d *Driver) dictionariesOf(collection string) (*dictionaries, error) {
+	d.mutex.Lock()
+	set := d.dicts[collection]
+	d.mutex.Unlock()
+	if set != nil {
+		return set, nil
+	}
+
+	set = &dictionaries{byID: make(map[int]*dictionary)}
+	for id := 1; ; id++ {
+		_, err := d.fs.Stat(d.dictionaryPath(collection, id))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		set.latest = id
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if cur := d.dicts[collection]; cur != nil {
+		return cur, nil
+	}
+	d.dicts[collection] = set
+	return set, nil
+}
+
+// dictionary returns dictionary id of collection, loading it on first use
+func (d *Driver) dictionary(collection string, id int) (*dictionary, error) {
+	set, err := d.dictionariesOf(collection)
+	if err != nil {
+		return nil, err
+	}
+	d.mutex.Lock()
+	dict := set.byID[id]
+	d.mutex.Unlock()
+	if dict != nil {
+		return dict, nil
+	}
+
+	data, err := d.fs.ReadFile(d.dictionaryPath(collection, id))
+	if err != nil {
+		return nil, fmt.Errorf("%s: compression dictionary %d: %w", collection, id, err)
+	}
+	dict = &dictionary{id: id, data: data}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if cur := set.byID[id]; cur != nil {
+		return cur, nil
+	}
+	set.byID[id] = dict
+	return dict, nil
+}
+
+// latestDictionary is the dictionary writes to collection compress with,
+// nil for none
+func (d *Driver) latestDictionary(collection string) (*dictionary, error) {
+	set, err := d.dictionariesOf(collection)
+	if err != nil || set.latest == 0 {
+		return nil, err
+	}
+	d.mutex.Lock()
+	latest := set.latest
+	d.mutex.Unlock()
+	return d.dictionary(collection, latest)
+}
+
+// latestDictionaryID is latestDictionary's ID, 0 for none
+func (d *Driver) latestDictionaryID(collection string) int {
+	set, err := d.dictionariesOf(collection)
+	if err != nil {
+		return 0
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return set.latest
+}
+
+// forgetDictionaries lets the dictionaries of a removed or replaced
+// collection be looked up again
+func (d *Driver) forgetDictionaries(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.dicts, collection)
+}
+
+// fileDictionary is the ID of the dictionary a record file was compressed
+// with, 0 for an uncompressed one
+func fileDictionary(b []byte) int {
+	if len(b) < len(dictMagic)+4 || string(b[:len(dictMagic)]) != dictMagic {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b[len(dictMagic):]))
+}
+
+func (dict *dictionary) compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(dictMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(dict.id))
+	w, _ := dict.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriterDict(&buf, flate.DefaultCompression, dict.data); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	defer dict.writers.Put(w)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (dict *dictionary) decompress(b []byte) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(b[len(dictMagic)+4:]), dict.data)
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// writeDictionaryID is the ID of the dictionary writes to collection
+// compress with, 0 for none
+func (d *Driver) writeDictionaryID(collection string, cfg *collectionConfig) int {
+	switch {
+	case cfg.dictionary < 0:
+		return 0
+	case cfg.dictionary > 0:
+		return cfg.dictionary
+	}
+	return d.latestDictionaryID(collection)
+}
+
+// compressFile compresses the JSON of a record file with the dictionary
+// writes to collection use, if there is one
+func (d *Driver) compressFile(collection string, cfg *collectionConfig, b []byte) ([]byte, error) {
+	if isSystemCollection(collection) || cfg.dictionary < 0 {
+		return b, nil
+	}
+	var dict *dictionary
+	var err error
+	if cfg.dictionary > 0 {
+		dict, err = d.dictionary(collection, cfg.dictionary)
+	} else {
+		dict, err = d.latestDictionary(collection)
+	}
+	if err != nil || dict == nil {
+		return b, err
+	}
+	return dict.compress(b)
+}
+
+// decompressFile turns a compressed record file back into its JSON
+func (d *Driver) decompressFile(collection string, b []byte) ([]byte, error) {
+	id := fileDictionary(b)
+	if id == 0 {
+		return b, nil
+	}
+	dict, err := d.dictionary(collection, id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := dict.decompress(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: decompressing record: %w", collection, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// --- DIRECT I/O ---
+
+// directAlign is the alignment O_DIRECT needs of buffers, offsets and
+// lengths; 4096 covers the logical block size of common devices
+const directAlign = 4096
+
+// directBufferSize is how much a direct write collects before writing it
+const directBufferSize = 1 << 20
+
+// defaultDirectMin is the smallest file written directly by default
+const defaultDirectMin = 1 << 20
+
+// WithDirectIO writes files of at least minSize bytes, as copied by
+// restores, collection moves and backups taken across filesystems, and
+// the archives of scheduled backups, bypassing the page cache, so that
+// bulk copies don't evict the records readers are using. minSize zero
+// means 1 MiB: records are far smaller and gain nothing from it. Where the
+// platform or filesystem doesn't support direct I/O, files are written
+// normally.
+func WithDirectIO(minSize int64) Option {
+	return func(o *options) {
+		o.directIO = true
+		o.directMin = minSize
+	}
+}
+
+// directBuffers reuses the aligned buffers of direct writes
+var directBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, directBufferSize+directAlign)
+	off := int(uintptr(unsafe.Pointer(&b[0])) & (directAlign - 1))
+	if off != 0 {
+		off = directAlign - off
+	}
+	b = b[off : off+directBufferSize]
+	return &b
+}}
+
+// createFile creates or truncates path for writing size bytes, -1 when
+// unknown, directly when the Driver is set to and the file is large
+// enough
+func (d *Driver) createFile(path string, size int64) (io.WriteCloser, error) {
+	threshold := d.opts.directMin
+	if threshold <= 0 {
+		threshold = defaultDirectMin
+	}
+	if d.opts.directIO && (size < 0 || size >= threshold) {
+		if f, err := openDirect(path, 0644); err == nil {
+			if err := d.reserve(f, size); err != nil {
+				f.Close()
+				return nil, err
+			}
+			return &directFile{f: f, buf: directBuffers.Get().(*[]byte)}, nil
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.reserve(f, size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// directFile writes a file opened for direct I/O in whole aligned blocks.
+// The last block is padded and the padding cut off again on Close.
+type directFile struct {
+	f    *os.File
+	buf  *[]byte
+	n    int
+	size int64
+}
+
+func (w *directFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		c := copy((*w.buf)[w.n:], p)
+		w.n += c
+		p = p[c:]
+		written += c
+		if w.n == len(*w.buf) {
+			if _, err := w.f.Write(*w.buf); err != nil {
+				return written, err
+			}
+			w.size += int64(w.n)
+			w.n = 0
+		}
+	}
+	return written, nil
+}
+
+func (w *directFile) Close() error {
+	defer directBuffers.Put(w.buf)
+	if w.n > 0 {
+		padded := (w.n + directAlign - 1) &^ (directAlign - 1)
+		clear((*w.buf)[w.n:padded])
+		if _, err := w.f.Write((*w.buf)[:padded]); err != nil {
+			w.f.Close()
+			return err
+		}
+		w.size += int64(w.n)
+		if err := w.f.Truncate(w.size); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect creates or truncates path for writing around the page cache
+func openDirect(path string, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_DIRECT, perm)
+}
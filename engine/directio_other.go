@@ -0,0 +1,14 @@
+//go:build !linux
+
+package engine
+
+import (
+	"errors"
+	"os"
+)
+
+// openDirect is not implemented off linux, where files are written through
+// the page cache
+func openDirect(path string, perm os.FileMode) (*os.File, error) {
+	return nil, errors.New("direct I/O is not supported on this platform")
+}
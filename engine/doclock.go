@@ -0,0 +1,370 @@
+package engine
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// --- DOCUMENT LOCKS ---
+
+// LockCollection holds the advisory document locks, one file per locked
+// record, named by the hash of the record's collection and name
+const LockCollection = systemPrefix + "locks"
+
+// ErrLocked is returned (wrapped) by LockDocument for a record another
+// owner holds the lock of, and by the calls of a DocumentLock that was
+// lost
+var ErrLocked = errors.New("document is locked")
+
+// DocumentLock is an advisory lock on a record, as LockDocument takes it.
+// It keeps nobody from writing the record: applications check it, to show
+// that a record is being edited by someone, say.
+type DocumentLock struct {
+	Collection string    `json:"collection"`
+	Resource   string    `json:"resource"`
+	Owner      string    `json:"owner"`
+	Acquired   time.Time `json:"acquired"`
+	Expires    time.Time `json:"expires"`
+	// Token tells this holding of the lock from any later one
+	Token string `json:"token"`
+
+	d *Driver
+}
+
+// LockOption configures LockDocument
+type LockOption func(*DocumentLock)
+
+// LockOwner names who holds the lock, as LockedBy will tell others. The
+// default is the host name and process ID followed by the lock's token,
+// which no other call shares: only the DocumentLock returned holds the
+// lock, so another goroutine of the process can't take it too.
+func LockOwner(owner string) LockOption {
+	return func(l *DocumentLock) { l.Owner = owner }
+}
+
+// LockDocument takes the advisory lock of a record for ttl, failing with
+// an error wrapping ErrLocked while another owner holds it. Locks are kept
+// in the database directory, so every process sharing it sees them: on
+// the local disk taking one is atomic across processes, whereas with
+// WithStorage it is only within the process. An owner named with LockOwner
+// locking a record it already holds takes the lock anew; without one,
+// extend a lock held with Refresh. An expired lock is free for anyone to
+// take. The record needn't exist.
+func (d *Driver) LockDocument(collection, resource string, ttl time.Duration, opts ...LockOption) (*DocumentLock, error) {
+	if err := validateNames(collection, resource); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lock ttl must be positive, got %s", ttl)
+	}
+	if err := d.writable(); err != nil {
+		return nil, err
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	l := &DocumentLock{
+		Collection: collection,
+		Resource:   resource,
+		Acquired:   now,
+		Expires:    now.Add(ttl),
+		Token:      hex.EncodeToString(token),
+		d:          d,
+	}
+	l.Owner = defaultLockOwner(l.Token)
+	for _, opt := range opts {
+		opt(l)
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquire(LockCollection, true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	path := d.lockPath(collection, resource)
+	for attempt := 0; attempt < 3; attempt++ {
+		err := d.createExclusive(path, b)
+		if err == nil {
+			return l, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		cur, err := d.readLock(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cur.held() && cur.Owner != l.Owner {
+			return nil, cur.lockedErr()
+		}
+		if err := d.breakLock(path, cur); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s/%s: gave up racing other processes for the lock", ErrLocked, collection, resource)
+}
+
+// LockedBy returns the lock held on a record, nil if none is
+func (d *Driver) LockedBy(collection, resource string) (*DocumentLock, error) {
+	if err := validateNames(collection, resource); err != nil {
+		return nil, err
+	}
+	release, err := d.acquire(LockCollection, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	l, err := d.readLock(d.lockPath(collection, resource))
+	if errors.Is(err, fs.ErrNotExist) || err == nil && !l.held() {
+		return nil, nil
+	}
+	return l, err
+}
+
+// Refresh extends the lock to ttl from now. It fails with an error
+// wrapping ErrLocked if the lock expired and was taken by someone else
+// meanwhile, or was unlocked. On the local disk the lock file is briefly
+// aside while it is rewritten: should another process take the lock in
+// that moment, Refresh fails and the lock is theirs.
+func (l *DocumentLock) Refresh(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("lock ttl must be positive, got %s", ttl)
+	}
+	d := l.d
+	release, err := d.acquire(LockCollection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	path := d.lockPath(l.Collection, l.Resource)
+	next := *l
+	next.Expires = time.Now().UTC().Add(ttl)
+	b, err := json.Marshal(&next)
+	if err != nil {
+		return err
+	}
+	if !isLocal(d.fs) {
+		if err := l.check(path); err != nil {
+			return err
+		}
+		if err := d.fs.WriteFile(path, b, d.opts.durability); err != nil {
+			return err
+		}
+		l.Expires = next.Expires
+		return nil
+	}
+	aside, err := l.claim(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(aside)
+	// linked into place rather than written over, so a lock another process
+	// took while the file was aside is left alone
+	if err := d.createExclusive(path, b); err != nil {
+		if !errors.Is(err, fs.ErrExist) {
+			os.Link(aside, path)
+			return err
+		}
+		if cur, err := d.readLock(path); err == nil {
+			return cur.lockedErr()
+		}
+		return fmt.Errorf("%w: %s/%s: taken by another process while being refreshed", ErrLocked, l.Collection, l.Resource)
+	}
+	l.Expires = next.Expires
+	return nil
+}
+
+// Unlock releases the lock. Unlocking a lock already released, or expired
+// and left alone, succeeds; one taken by someone else since fails with an
+// error wrapping ErrLocked.
+func (l *DocumentLock) Unlock() error {
+	d := l.d
+	release, err := d.acquire(LockCollection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	path := d.lockPath(l.Collection, l.Resource)
+	if isLocal(d.fs) {
+		aside, err := l.claim(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path = aside
+	} else if err := l.check(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := d.fs.Remove(path, d.opts.durability); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// check fails unless the lock file at path is still l's
+func (l *DocumentLock) check(path string) error {
+	cur, err := l.d.readLock(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s/%s: the lock was released", fs.ErrNotExist, l.Collection, l.Resource)
+	}
+	if err != nil {
+		return err
+	}
+	if cur.Token != l.Token {
+		return cur.lockedErr()
+	}
+	return nil
+}
+
+// lockClaimed, when set, is called by claim once the lock file is aside,
+// for tests to play another process in between
+var lockClaimed func(path string)
+
+// claim renames the lock file at path aside, as breakLock does, and returns
+// where it went if it is still l's. Otherwise the file is put back and
+// claim fails as check does: no process can swap the lock between the
+// token being read and the file being rewritten or removed.
+func (l *DocumentLock) claim(path string) (string, error) {
+	aside := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+l.Token+"."+strconv.Itoa(os.Getpid())+".tmp")
+	if err := os.Rename(path, aside); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s/%s: the lock was released", fs.ErrNotExist, l.Collection, l.Resource)
+		}
+		return "", err
+	}
+	if err := l.check(aside); err != nil {
+		// another's lock: put it back, unless yet another was taken
+		os.Link(aside, path)
+		os.Remove(aside)
+		return "", err
+	}
+	if lockClaimed != nil {
+		lockClaimed(path)
+	}
+	return aside, nil
+}
+
+func (l *DocumentLock) held() bool {
+	return time.Now().Before(l.Expires)
+}
+
+func (l *DocumentLock) lockedErr() error {
+	return fmt.Errorf("%w: %s/%s is locked by %s until %s", ErrLocked, l.Collection, l.Resource, l.Owner, l.Expires.Format(time.RFC3339))
+}
+
+// lockPath is the file of the lock of a record
+func (d *Driver) lockPath(collection, resource string) string {
+	sum := sha256.Sum256([]byte(collection + "\x00" + resource))
+	return filepath.Join(d.collectionDir(LockCollection), hex.EncodeToString(sum[:])+".json")
+}
+
+func (d *Driver) readLock(path string) (*DocumentLock, error) {
+	b, err := d.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &DocumentLock{d: d}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("lock %s: %w", filepath.Base(path), err)
+	}
+	return l, nil
+}
+
+// createExclusive writes data to path unless a file is there already, in
+// which case it fails with an error wrapping fs.ErrExist. On the local
+// disk the file is written aside and hard linked into place, which only
+// one process can do.
+func (d *Driver) createExclusive(path string, data []byte) error {
+	if !isLocal(d.fs) {
+		if _, err := d.fs.Stat(path); err == nil {
+			return fmt.Errorf("%s: %w", path, fs.ErrExist)
+		}
+		return d.fs.WriteFile(path, data, d.opts.durability)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil && d.opts.durability >= FsyncOnWrite {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Link(tmp.Name(), path); err != nil {
+		return err
+	}
+	if d.opts.durability >= FsyncDir {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// breakLock removes the lock file at path, which held cur, unless another
+// process replaced it since. On the local disk the file is renamed aside
+// first, so of several processes breaking the same lock only one removes
+// it, and a lock taken meanwhile is put back.
+func (d *Driver) breakLock(path string, cur *DocumentLock) error {
+	if !isLocal(d.fs) {
+		return d.fs.Remove(path, d.opts.durability)
+	}
+	aside := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+cur.Token+"."+strconv.Itoa(os.Getpid())+".tmp")
+	if err := os.Rename(path, aside); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(aside)
+	moved, err := d.readLock(aside)
+	if err != nil {
+		return err
+	}
+	if moved.Token != cur.Token {
+		// a fresh lock: put it back, unless yet another was taken
+		os.Link(aside, path)
+	}
+	return nil
+}
+
+// defaultLockOwner names the process and the holding of the lock token
+// stands for
+func defaultLockOwner(token string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + ":" + strconv.Itoa(os.Getpid()) + "/" + token
+}
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockDocument(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  []LockOption
+		second []LockOption
+		want   error
+	}{
+		{"default owners are distinct", nil, nil, ErrLocked},
+		{"other owner", []LockOption{LockOwner("alice")}, []LockOption{LockOwner("bob")}, ErrLocked},
+		{"same owner takes it anew", []LockOption{LockOwner("alice")}, []LockOption{LockOwner("alice")}, nil},
+		{"named owner against default", []LockOption{LockOwner("alice")}, nil, ErrLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := openTest(t)
+			first, err := d.LockDocument("docs", "a", time.Minute, tt.first...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := d.LockDocument("docs", "a", time.Minute, tt.second...)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("second lock: %v, want %v", err, tt.want)
+				}
+				if err := first.Refresh(time.Minute); err != nil {
+					t.Errorf("refreshing the lock held: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("second lock: %v", err)
+			}
+			if first.Token == second.Token {
+				t.Error("taking the lock anew kept its token")
+			}
+			if err := first.Refresh(time.Minute); !errors.Is(err, ErrLocked) {
+				t.Errorf("refreshing the lock replaced: %v, want ErrLocked", err)
+			}
+		})
+	}
+}
+
+func TestLockDocumentGoroutines(t *testing.T) {
+	d := openTest(t)
+	const n = 8
+	got := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := d.LockDocument("docs", "a", time.Minute)
+			got <- err
+		}()
+	}
+	held := 0
+	for i := 0; i < n; i++ {
+		err := <-got
+		switch {
+		case err == nil:
+			held++
+		case !errors.Is(err, ErrLocked):
+			t.Errorf("locking: %v", err)
+		}
+	}
+	if held != 1 {
+		t.Errorf("%d goroutines hold the lock, want 1", held)
+	}
+}
+
+func TestDocumentLockLifecycle(t *testing.T) {
+	d := openTest(t)
+	l, err := d.LockDocument("docs", "a", time.Minute, LockOwner("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if by, err := d.LockedBy("docs", "a"); err != nil || by == nil || by.Owner != "alice" {
+		t.Errorf("LockedBy = %v, %v, want alice", by, err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Errorf("unlocking twice: %v", err)
+	}
+	if err := l.Refresh(time.Minute); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("refreshing a released lock: %v, want fs.ErrNotExist", err)
+	}
+	if by, err := d.LockedBy("docs", "a"); err != nil || by != nil {
+		t.Errorf("LockedBy after Unlock = %v, %v, want none", by, err)
+	}
+
+	expired, err := d.LockDocument("docs", "b", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	taken, err := d.LockDocument("docs", "b", time.Minute)
+	if err != nil {
+		t.Fatalf("taking an expired lock: %v", err)
+	}
+	if err := expired.Unlock(); !errors.Is(err, ErrLocked) {
+		t.Errorf("unlocking a lock taken since: %v, want ErrLocked", err)
+	}
+	if err := taken.Unlock(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDocumentLockSwapped plays another process taking the lock file over
+// while Refresh or Unlock is in the middle of checking and rewriting it
+func TestDocumentLockSwapped(t *testing.T) {
+	d := openTest(t)
+	other, err := json.Marshal(&DocumentLock{Collection: "docs", Resource: "a", Owner: "bob", Token: "other", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := d.lockPath("docs", "a")
+	swap := func(string) {
+		if err := os.WriteFile(path, other, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// survived checks the other lock is in place and nothing was left aside
+	survived := func(t *testing.T) {
+		t.Helper()
+		if cur, err := d.readLock(path); err != nil || cur.Token != "other" {
+			t.Errorf("lock file after the swap = %v, %v, want the other lock", cur, err)
+		}
+		entries, _ := os.ReadDir(filepath.Dir(path))
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), ".tmp") {
+				t.Errorf("%s left aside", e.Name())
+			}
+		}
+		os.Remove(path)
+	}
+
+	tests := []struct {
+		name string
+		// during swaps the lock once the file is aside, not before
+		during bool
+		call   func(*DocumentLock) error
+		want   error
+	}{
+		{"refresh after the swap", false, func(l *DocumentLock) error { return l.Refresh(time.Minute) }, ErrLocked},
+		{"unlock after the swap", false, (*DocumentLock).Unlock, ErrLocked},
+		{"refresh during the swap", true, func(l *DocumentLock) error { return l.Refresh(time.Minute) }, ErrLocked},
+		{"unlock during the swap", true, (*DocumentLock).Unlock, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := d.LockDocument("docs", "a", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expires := l.Expires
+			if tt.during {
+				lockClaimed = swap
+				defer func() { lockClaimed = nil }()
+			} else {
+				swap(path)
+			}
+			if err := tt.call(l); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			if !l.Expires.Equal(expires) {
+				t.Error("the lost lock's expiry moved")
+			}
+			survived(t)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package engine
+
+import "strings"
+
+// --- DOCUMENT PATHS ---
+
+// lookupPath resolves a dot separated field path inside a decoded document
+func lookupPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// setPath stores val at a dot separated path, creating intermediate objects
+// as needed. It fails if a non-object value is in the way.
+func setPath(doc map[string]interface{}, path string, val interface{}) bool {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := cur[part]
+		if !ok {
+			child := make(map[string]interface{})
+			cur[part] = child
+			cur = child
+			continue
+		}
+		if cur, ok = next.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	cur[parts[len(parts)-1]] = val
+	return true
+}
+
+// deletePath removes the value at a dot separated path
+func deletePath(doc map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := cur[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, parts[len(parts)-1])
+}
+
+// flattenDocument records every leaf of doc in out under its dot separated
+// path. Arrays and empty objects count as leaves.
+func flattenDocument(doc map[string]interface{}, prefix string, out map[string]interface{}) {
+	for k, v := range doc {
+		path := joinPath(prefix, k)
+		if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+			flattenDocument(child, path, out)
+			continue
+		}
+		out[path] = v
+	}
+}
@@ -0,0 +1,1192 @@
+// Package engine is a file-backed JSON document store: every collection is a
+// directory under the database root and every record a JSON file inside it.
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// --- DATABASE ENGINE
+
+type Driver struct {
+	mutex   sync.Mutex
+	mutexes map[string]*collectionLock
+	dir     string
+	hooks   hooks
+	opts    options
+	metrics metrics
+	life    lifecycle
+	closers []func() error
+	cache   *recordCache
+	fs      storage
+	io      *ioScheduler
+	commits *groupCommit
+
+	watchers watchers
+	replica  replicaState
+
+	collections map[string]*collectionConfig
+	uniques     map[string]*uniqueIndex
+	searches    map[string]*searchIndex
+	columns     map[string]*columnIndex
+	distincts   map[string]*distinctIndex
+	sequences   map[string]*sequence
+	usage       usage
+	groups      map[string]*group
+	compacted   map[string]time.Time   // when CompactHistory last ran
+	migrating   sync.Mutex             // held by Migrate and Rollback runs
+	temps       map[string]*time.Timer // temporary collections, nil before the first
+
+	uniqueGroups []*uniqueGroup  // UniqueAcross groups, by id
+	layouts      map[string]bool // whether each collection seen is sharded
+	blooms       map[string]*bloomFilter
+	kvs          map[string]*KV // open key-value stores, nil before the first
+	dicts        map[string]*dictionaries
+	views        map[string]*view
+	triggers     map[string]*trigger // triggers whose functions run here
+
+	// volumes are the data directories, d.dir first; placed caches
+	// which one holds each collection
+	volumes []string
+	placed  map[string]string
+	pins    map[string]string
+}
+
+// New initializes a new database at the specified directory
+func New(dir string, opts ...Option) (*Driver, error) {
+	dir = filepath.Clean(dir)
+	driver := Driver{
+		dir:         dir,
+		mutexes:     make(map[string]*collectionLock),
+		collections: make(map[string]*collectionConfig),
+		uniques:     make(map[string]*uniqueIndex),
+		searches:    make(map[string]*searchIndex),
+		columns:     make(map[string]*columnIndex),
+		distincts:   make(map[string]*distinctIndex),
+		sequences:   make(map[string]*sequence),
+		groups:      make(map[string]*group),
+		compacted:   make(map[string]time.Time),
+		placed:      make(map[string]string),
+		layouts:     make(map[string]bool),
+		blooms:      make(map[string]*bloomFilter),
+		views:       make(map[string]*view),
+		dicts:       make(map[string]*dictionaries),
+		triggers:    make(map[string]*trigger),
+	}
+	for _, opt := range opts {
+		opt(&driver.opts)
+	}
+	if driver.opts.logger == nil {
+		driver.opts.logger = slog.New(slog.DiscardHandler)
+	}
+	if driver.opts.codec != nil {
+		if err := checkCodec(driver.opts.codec); err != nil {
+			return &driver, err
+		}
+	}
+	for _, c := range driver.opts.codecs {
+		if err := checkCodec(c); err != nil {
+			return &driver, err
+		}
+	}
+	driver.cache = newRecordCache(driver.opts.cacheBytes)
+	if driver.opts.groupCommit {
+		driver.commits = newGroupCommit(driver.opts.commitWindow)
+	}
+	driver.watchers.limit = driver.opts.changeBacklog
+
+	driver.volumes = []string{dir}
+	for _, v := range driver.opts.volumes {
+		if v = filepath.Clean(v); !slices.Contains(driver.volumes, v) {
+			driver.volumes = append(driver.volumes, v)
+		}
+	}
+	driver.io = newIOScheduler(driver.opts.ioSlots)
+	driver.fs = localStorage{}
+	if driver.opts.storage != nil {
+		if len(driver.volumes) > 1 {
+			return &driver, fmt.Errorf("storage can't be combined with volumes")
+		}
+		driver.fs = rootedStorage{s: driver.opts.storage, root: dir}
+	}
+	for _, v := range driver.volumes {
+		if driver.opts.readOnly {
+			if _, err := driver.fs.Stat(v); err != nil {
+				return &driver, err
+			}
+			continue
+		}
+		if err := driver.fs.MkdirAll(v); err != nil {
+			return &driver, err
+		}
+	}
+	if err := driver.loadPins(); err != nil {
+		return &driver, err
+	}
+	if err := driver.loadAllCollectionOptions(); err != nil {
+		return &driver, err
+	}
+	if err := driver.loadTriggers(); err != nil {
+		return &driver, err
+	}
+	if !driver.opts.readOnly && !driver.opts.replica {
+		if err := driver.sweepTempCollections(); err != nil {
+			return &driver, err
+		}
+	}
+	if driver.opts.bloom {
+		driver.onClose(driver.saveBlooms)
+	}
+	if driver.opts.replica {
+		return &driver, driver.loadReplicaPosition()
+	}
+	return &driver, nil
+}
+
+// collectionLock guards a collection. It stays in Driver.mutexes only while
+// callers hold or wait for it, so every collection name ever used, typos
+// and dropped collections included, doesn't keep an entry for good.
+type collectionLock struct {
+	sync.RWMutex
+	refs int // callers between lockFor and unref
+}
+
+// lockFor returns the lock of a collection, counting the caller in until
+// it calls unref. Writers take the lock exclusively; readers share it.
+func (d *Driver) lockFor(collection string) *collectionLock {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	l, ok := d.mutexes[collection]
+	if !ok {
+		l = &collectionLock{}
+		d.mutexes[collection] = l
+	}
+	l.refs++
+	return l
+}
+
+// unref counts out a caller of lockFor, once it has released the lock,
+// dropping the lock when no caller is left
+func (d *Driver) unref(collection string, l *collectionLock) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if l.refs--; l.refs == 0 {
+		delete(d.mutexes, collection)
+	}
+}
+
+// Write saves a JSON file into a collection
+func (d *Driver) Write(collection, resource string, v interface{}, opts ...WriteOption) error {
+	return d.writeWith(collection, resource, v, newWriteParams(opts))
+}
+
+// WriteOption adjusts a single Write or Delete call
+type WriteOption func(*writeParams)
+
+// Durable overrides the Driver's durability for one call
+func Durable(level Durability) WriteOption {
+	return func(p *writeParams) {
+		p.durability = level
+		p.durable = true
+	}
+}
+
+func newWriteParams(opts []WriteOption) writeParams {
+	var p writeParams
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return p
+}
+
+// writeParams carries the per-call settings of a write or delete
+type writeParams struct {
+	// expect, when non-nil, makes the write conditional on the record's
+	// file still holding exactly these bytes; absent on there being none
+	expect []byte
+	absent bool
+	// quiet writes raise no change event, for rewrites that change no
+	// document a reader sees
+	quiet bool
+	// validFrom is when the change takes effect in a bitemporal collection;
+	// zero means now
+	validFrom time.Time
+	// soft keeps a deleted record in the collection's trash
+	soft bool
+	// durability replaces the Driver's durability when durable is set
+	durability Durability
+	durable    bool
+	// replicated marks a change applied from a primary's feed, which skips
+	// the unique checks and is allowed on a replica
+	replicated bool
+	// ctx holds the parent of the call's span, trace the span if traced
+	ctx   context.Context
+	trace *opTrace
+	// guard is the write policy bound to the caller, nil for none
+	guard Filter
+	// batch runs the call as Batch work whatever its context says
+	batch bool
+}
+
+// priority is the class the call's IO runs in
+func (p writeParams) priority() Priority {
+	if p.batch {
+		return Batch
+	}
+	return PriorityFrom(p.ctx)
+}
+
+// durability is the level a write or delete with params p runs at
+func (d *Driver) durability(p writeParams) Durability {
+	if p.durable {
+		return p.durability
+	}
+	return d.opts.durability
+}
+
+func (d *Driver) writeWith(collection, resource string, v interface{}, p writeParams) error {
+	if collection == "" || resource == "" {
+		return fmt.Errorf("missing collection or resource")
+	}
+	if err := validateNames(collection, resource); err != nil {
+		return err
+	}
+	if err := d.admit(&p, ActionWrite, collection, resource); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	p.trace = d.startSpan(p.ctx, "Write", collection, resource)
+	err := d.applyWrite(collection, resource, v, p)
+	p.trace.end(err)
+	d.metrics.counters(collection).writes.done(start, err)
+	if err != nil {
+		d.deadLetterWrite(collection, resource, v, err)
+		return err
+	}
+	return nil
+}
+
+// admit asks the Authorizer whether the change p describes may go ahead
+// and binds the caller's write policy to p. Every write and delete passes
+// through it, except those applied from a primary's feed.
+func (d *Driver) admit(p *writeParams, action Action, collection, resource string) error {
+	if p.replicated {
+		return nil
+	}
+	if err := d.authorize(p.ctx, action, collection, resource); err != nil {
+		return err
+	}
+	p.guard = d.writeGuard(p.ctx, collection)
+	return nil
+}
+
+// applyWrite runs a write through hooks and validation and persists it
+func (d *Driver) applyWrite(collection, resource string, v interface{}, p writeParams) error {
+	op := &Operation{Kind: OpWrite, Collection: collection, Resource: resource, Value: v}
+	if err := d.runHooks(func(h *hooks) []Hook { return h.beforeWrite }, op); err != nil {
+		return err
+	}
+
+	v, err := d.snapshotConfig(collection).reshapeWrite(op.Value)
+	if err != nil {
+		return err
+	}
+
+	if err := d.validate(collection, v); err != nil {
+		return err
+	}
+
+	written, err := d.store(collection, resource, v, p)
+	if err != nil {
+		return err
+	}
+	if !written {
+		return errPrecondition
+	}
+
+	return d.runHooks(func(h *hooks) []Hook { return h.afterWrite }, op)
+}
+
+// validate checks a value against the required fields, field validators and
+// schema attached to its collection
+func (d *Driver) validate(collection string, v interface{}) error {
+	cfg := d.snapshotConfig(collection)
+	if cfg.schema == nil && len(cfg.required) == 0 && len(cfg.validators) == 0 {
+		return nil
+	}
+
+	doc, err := toDocument(v)
+	if err != nil {
+		return err
+	}
+	if err := checkRequired(cfg.required, doc); err != nil {
+		return err
+	}
+	if err := checkFields(cfg.validators, doc); err != nil {
+		return err
+	}
+	if cfg.schema == nil {
+		return nil
+	}
+	return cfg.schema.Validate(doc)
+}
+
+func (d *Driver) write(collection, resource string, v interface{}) error {
+	_, err := d.store(collection, resource, v, writeParams{})
+	return err
+}
+
+// store persists v under the collection lock and reports whether it was
+// written, which only a failed p.expect or p.absent precondition prevents
+func (d *Driver) store(collection, resource string, v interface{}, p writeParams) (written bool, err error) {
+	if !p.replicated {
+		if err := d.writable(); err != nil {
+			return false, err
+		}
+		if err := d.notView(collection); err != nil {
+			return false, err
+		}
+	}
+	cfg := d.snapshotConfig(collection)
+	raw, err := preEncode(v)
+	if err != nil {
+		return false, err
+	}
+	if p.guard != nil {
+		if err := checkNew(p.guard, collection, resource, raw); err != nil {
+			return false, err
+		}
+	}
+	if limit := d.maxDocumentSize(cfg); limit > 0 && int64(len(raw)) > limit && !p.replicated && !isSystemCollection(collection) {
+		return false, fmt.Errorf("%w: document is %d bytes, over the limit of %d", ErrQuotaExceeded, len(raw), limit)
+	}
+	v = raw
+	var keys map[string]string
+	if len(cfg.unique) > 0 && !p.replicated {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			return false, err
+		}
+		keys = uniqueKeys(cfg.unique, doc)
+	}
+	var across []*uniqueGroup
+	if len(cfg.across) > 0 && keys != nil {
+		var unlock func()
+		across, unlock = lockAcross(cfg, keys)
+		defer unlock()
+	}
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return false, err
+	}
+	dir := d.collectionDir(collection)
+	level, group := d.commitLevel(d.durability(p))
+	defer func() {
+		release()
+		if group && written && err == nil {
+			err = d.commits.sync(d.changedDirs(collection, resource, cfg.history, false)...)
+		}
+	}()
+
+	fnlPath := d.recordPath(collection, resource, cfg)
+
+	if p.expect != nil {
+		if cur, err := d.readLive(collection, fnlPath, cfg); err != nil || !bytes.Equal(cur, p.expect) {
+			return false, nil
+		}
+	}
+	if p.absent {
+		if _, err := d.fs.Stat(fnlPath); !errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+	}
+	if p.guard != nil {
+		if err := d.checkExisting(p.guard, collection, resource, fnlPath, cfg); err != nil {
+			return false, err
+		}
+	}
+	before, err := d.triggerBefore(collection, resource, fnlPath, cfg, p)
+	if err != nil {
+		return false, err
+	}
+
+	var unique *uniqueIndex
+	if keys != nil {
+		var err error
+		if unique, err = d.uniqueIndexFor(collection, cfg.unique); err != nil {
+			return false, err
+		}
+		if err := unique.check(resource, keys); err != nil {
+			return false, err
+		}
+		if err := d.checkAcross(across, collection, keys); err != nil {
+			return false, err
+		}
+	}
+
+	if !p.replicated {
+		if err := d.checkQuota(collection, fnlPath, cfg, raw); err != nil {
+			return false, err
+		}
+	}
+
+	if err := d.fs.MkdirAll(dir); err != nil {
+		return false, err
+	}
+
+	if cfg.history {
+		cur, err := d.recordVersion(collection, resource, v, false, p.validFrom, level)
+		if err != nil {
+			return false, err
+		}
+		if cur == nil {
+			// the new version is not in effect yet and nothing earlier is
+			if err := d.removeLive(collection, resource, level); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return false, err
+			}
+			d.notify(OpDelete, collection, resource, nil)
+			return true, d.queueTriggers(OpDelete, collection, resource, cfg, p, before, nil)
+		}
+		v = cur.Data
+		if unique != nil {
+			doc, err := decodeDocument(cur.Data)
+			if err != nil {
+				return false, err
+			}
+			keys = uniqueKeys(cfg.unique, doc)
+		}
+	}
+
+	if err := d.writeLive(collection, resource, fnlPath, cfg.version, v, level, p.priority()); err != nil {
+		return false, err
+	}
+	p.trace.addBytes(len(raw))
+	if unique != nil {
+		unique.add(resource, keys)
+	}
+	if !p.quiet {
+		d.notify(OpWrite, collection, resource, v)
+	}
+	if err := d.queueTriggers(OpWrite, collection, resource, cfg, p, before, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeLive replaces a record's file, its IO running at priority pri.
+// Callers must hold the collection lock.
+func (d *Driver) writeLive(collection, resource, path string, version int, v interface{}, level Durability, pri Priority) error {
+	d.cache.invalidate(collection, resource)
+	doc := v
+	var seq uint64
+	if !isSystemCollection(collection) {
+		var err error
+		if seq, err = d.nextSeq(collection, level); err != nil {
+			return err
+		}
+	}
+	cfg := d.snapshotConfig(collection)
+	v = d.wrapEnvelope(collection, path, version, seq, v, cfg)
+
+	buf, err := d.encodeIndented(v)
+	if err != nil {
+		return err
+	}
+	defer putBuffer(buf)
+	data, err := d.encodeFile(collection, cfg, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if err := d.bloomAdd(collection, resource); err != nil {
+		return err
+	}
+	track := d.tracksUsage(collection)
+	var old fs.FileInfo
+	if track {
+		old, _ = d.fs.Stat(path)
+	}
+	d.io.enter(pri)
+	err = d.fs.WriteFile(path, data, level)
+	d.io.leave(pri)
+	if err != nil {
+		d.opts.logger.Debug("write failed", "collection", collection, "resource", resource, "err", err)
+		return err
+	}
+	if track {
+		if old != nil {
+			d.trackWrite(collection, true, old.Size(), int64(len(data)))
+		} else {
+			d.trackWrite(collection, false, 0, int64(len(data)))
+		}
+	}
+	d.metrics.counters(collection).bytesWritten.Add(int64(len(data)))
+	d.reindexSearch(collection, resource, doc)
+	d.reindexColumns(collection, resource, doc)
+	d.reindexDistinct(collection, doc)
+	d.refreshViews(collection, resource, buf.Bytes())
+	d.opts.logger.Debug("write", "collection", collection, "resource", resource, "bytes", len(data))
+	return nil
+}
+
+// Read reads a specific record from a collection
+func (d *Driver) Read(collection, resource string, v interface{}, opts ...ReadOption) error {
+	if err := validateNames(collection, resource); err != nil {
+		return err
+	}
+
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, resource); err != nil {
+		return err
+	}
+	p.guard = d.readGuard(p.ctx, collection)
+	start := time.Now()
+	p.trace = d.startSpan(p.ctx, "Read", collection, resource)
+	err := d.read(collection, resource, v, p)
+	p.trace.end(err)
+	d.metrics.counters(collection).reads.done(start, err)
+	return err
+}
+
+func (d *Driver) read(collection, resource string, v interface{}, p readParams) error {
+	if view := d.viewNamed(collection); view != nil {
+		return d.readView(view, resource, v, p)
+	}
+	rec, err := d.readRecord(collection, resource)
+	if err != nil {
+		return err
+	}
+	if p.guard != nil && !p.guard.Match(rec) {
+		return fmt.Errorf("%s/%s: %w", collection, resource, fs.ErrNotExist)
+	}
+	p.trace.addBytes(len(rec.Data))
+	if len(p.populate) > 0 {
+		if err := d.populate(collection, []*Record{rec}, p.populate); err != nil {
+			return err
+		}
+	}
+	if len(p.fields) > 0 {
+		if err := project(rec, p.fields); err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(rec.Data, &v)
+}
+
+// Exists reports whether a record exists, without reading it unless the
+// collection has a Policy to check it against
+func (d *Driver) Exists(collection, resource string, opts ...ReadOption) (bool, error) {
+	if err := validateNames(collection, resource); err != nil {
+		return false, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, resource); err != nil {
+		return false, err
+	}
+	if guard := d.readGuard(p.ctx, collection); guard != nil {
+		rec, err := d.readRecord(collection, resource)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil && guard.Match(rec), err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	if _, cached := d.cache.get(collection, resource); cached {
+		return true, nil
+	}
+	if missing, err := d.bloomMisses(collection, resource); err != nil || missing {
+		return false, err
+	}
+	_, err = d.fs.Stat(d.recordPath(collection, resource, d.snapshotConfig(collection)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// readRecord loads a single record, unwrapping its envelope if it has one
+func (d *Driver) readRecord(collection, resource string) (*Record, error) {
+	cfg := d.snapshotConfig(collection)
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	b, cached := d.cache.get(collection, resource)
+	if !cached {
+		path := d.recordPath(collection, resource, cfg)
+		var missing bool
+		if missing, err = d.bloomMisses(collection, resource); err == nil && missing {
+			err = &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+		} else if b, err = d.readLive(collection, path, cfg); err == nil {
+			d.cache.put(collection, resource, b)
+		}
+	}
+	release()
+	d.opts.logger.Log(context.Background(), LevelTrace, "read", "collection", collection, "resource", resource, "cached", cached, "err", err)
+	if err != nil {
+		return nil, err
+	}
+	if !cached {
+		d.metrics.counters(collection).bytesRead.Add(int64(len(b)))
+	}
+
+	rec, err := decodeRecord(resource, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.reshapeRead(rec); err != nil {
+		return nil, err
+	}
+	d.persistUpgrade(collection, rec)
+	return rec, nil
+}
+
+// ReadAll reads all files in a collection in name order, loading them in
+// parallel as set by WithReadConcurrency
+func (d *Driver) ReadAll(collection string) ([][]byte, error) {
+	return d.readAll(context.Background(), collection)
+}
+
+// readAll does the work of ReadAll, tracing it as a child of ctx
+func (d *Driver) readAll(ctx context.Context, collection string) (records [][]byte, err error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	if err := d.authorize(ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+	guard := d.readGuard(ctx, collection)
+	trace := d.startSpan(ctx, "ReadAll", collection, "")
+	defer func() { trace.returned(len(records)); trace.end(err) }()
+	if v := d.viewNamed(collection); v != nil {
+		return d.readAllView(v, d.readGuard(ctx, v.collection), trace)
+	}
+
+	err = d.scanPlanned(collection, nil, PriorityFrom(ctx), func(rec *Record) error {
+		if guard != nil && !guard.Match(rec) {
+			return nil
+		}
+		records = append(records, rec.Data)
+		trace.addBytes(len(rec.Data))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Collections lists the collections in the database in name order
+func (d *Driver) Collections() ([]string, error) {
+	if err := d.life.enter(); err != nil {
+		return nil, err
+	}
+	defer d.life.leave()
+
+	stored, err := d.storedCollections()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, c := range stored {
+		if validateCollection(c) == nil {
+			names = append(names, c)
+		}
+	}
+	return names, nil
+}
+
+// List returns the resource names in a collection in name order
+func (d *Driver) List(collection string) ([]string, error) {
+	return d.list(context.Background(), collection)
+}
+
+// list does the work of List, authorized with ctx
+func (d *Driver) list(ctx context.Context, collection string) ([]string, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	if err := d.authorize(ctx, ActionList, collection, ""); err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	names, err := d.recordNames(collection)
+	if err != nil {
+		return nil, err
+	}
+	guard := d.readGuard(ctx, collection)
+	if guard == nil {
+		return names, nil
+	}
+	var visible []string
+	err = d.walkNames(collection, names, func(rec *Record) error {
+		if guard.Match(rec) {
+			visible = append(visible, rec.Resource)
+		}
+		return nil
+	})
+	return visible, err
+}
+
+// scan calls fn for every record in a collection in directory order. The
+// collection is read-locked for the duration, so fn must not write to it.
+func (d *Driver) scan(collection string, fn func(rec *Record) error) error {
+	return d.scanPlanned(collection, nil, Interactive, fn)
+}
+
+// scanPlanned is scan skipping the records the plan of filter rules out,
+// reading at priority pri; fn still has to match the others against filter
+func (d *Driver) scanPlanned(collection string, filter Filter, pri Priority, fn func(rec *Record) error) (err error) {
+	counters := d.metrics.counters(collection)
+	start := time.Now()
+	defer func() { counters.scans.done(start, err) }()
+
+	// upgraded records can only be written back once the read lock is gone
+	var upgraded []*Record
+	defer func() {
+		for _, rec := range upgraded {
+			d.persistUpgrade(collection, rec)
+		}
+	}()
+	return d.planned(collection, filter, func(plan *Plan) error {
+		return d.walkNamesAt(collection, plan.names, pri, func(rec *Record) error {
+			if rec.upgraded != nil {
+				upgraded = append(upgraded, rec)
+			}
+			return fn(rec)
+		})
+	})
+}
+
+// walk does the work of scan. Callers must hold the collection lock.
+func (d *Driver) walk(collection string, fn func(rec *Record) error) error {
+	names, err := d.recordNames(collection)
+	if err != nil {
+		return err
+	}
+	return d.walkNames(collection, names, fn)
+}
+
+// recordNames lists the records of a collection in name order without
+// reading them. Callers must hold the collection lock.
+func (d *Driver) recordNames(collection string) ([]string, error) {
+	files, err := d.recordFiles(collection, d.snapshotConfig(collection))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.resource
+	}
+	return names, nil
+}
+
+// walkNames calls fn for the named records of collection. Records are
+// loaded and decoded by up to readConcurrency workers at once, but fn
+// still sees them one at a time in the order of names. Callers must hold
+// the collection lock.
+func (d *Driver) walkNames(collection string, names []string, fn func(rec *Record) error) error {
+	return d.walkNamesAt(collection, names, Interactive, fn)
+}
+
+// walkNamesAt is walkNames reading the files at priority pri
+func (d *Driver) walkNamesAt(collection string, names []string, pri Priority, fn func(rec *Record) error) error {
+	counters := d.metrics.counters(collection)
+	cfg := d.snapshotConfig(collection)
+	load := func(resource string) (*Record, error) {
+		d.io.enter(pri)
+		b, err := d.fs.ReadFile(d.recordPath(collection, resource, cfg))
+		d.io.leave(pri)
+		if err != nil {
+			return nil, err
+		}
+		counters.bytesRead.Add(int64(len(b)))
+		dict := fileDictionary(b)
+		if b, err = d.decodeFile(collection, cfg, b); err != nil {
+			return nil, err
+		}
+
+		rec, err := decodeRecord(resource, b)
+		if err != nil {
+			return nil, err
+		}
+		rec.dict = dict
+		if err := cfg.reshapeRead(rec); err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+
+	workers := d.readConcurrency()
+	if workers <= 1 || len(names) <= 1 {
+		for _, name := range names {
+			rec, err := load(name)
+			if err != nil {
+				return err
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type result struct {
+		rec *Record
+		err error
+	}
+	type job struct {
+		resource string
+		out      chan result
+	}
+
+	// jobs are queued on pending in name order as they are handed out, so
+	// reading pending yields results in order while at most a few
+	// workers' worth of records wait in memory
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	defer wg.Wait()
+	defer close(done)
+
+	jobs := make(chan job)
+	pending := make(chan job, workers)
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for _, name := range names {
+			j := job{resource: name, out: make(chan result, 1)}
+			select {
+			case pending <- j:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- j:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rec, err := load(j.resource)
+				j.out <- result{rec: rec, err: err}
+			}
+		}()
+	}
+
+	for j := range pending {
+		r := <-j.out
+		if r.err != nil {
+			return r.err
+		}
+		if err := fn(r.rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readConcurrency is how many files walk loads at once
+func (d *Driver) readConcurrency() int {
+	if d.opts.readConcurrency > 0 {
+		return d.opts.readConcurrency
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Delete removes a specific record
+func (d *Driver) Delete(collection, resource string, opts ...WriteOption) error {
+	return d.deleteWith(collection, resource, newWriteParams(opts))
+}
+
+func (d *Driver) deleteWith(collection, resource string, p writeParams) error {
+	if err := validateNames(collection, resource); err != nil {
+		return err
+	}
+	if err := d.admit(&p, ActionDelete, collection, resource); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	p.trace = d.startSpan(p.ctx, "Delete", collection, resource)
+	err := d.applyDelete(collection, resource, p)
+	p.trace.end(err)
+	d.metrics.counters(collection).deletes.done(start, err)
+	if err != nil {
+		d.deadLetterDelete(collection, resource, err)
+		return err
+	}
+	return nil
+}
+
+// applyDelete runs a delete through hooks and removes the record
+func (d *Driver) applyDelete(collection, resource string, p writeParams) error {
+	op := &Operation{Kind: OpDelete, Collection: collection, Resource: resource}
+	if err := d.runHooks(func(h *hooks) []Hook { return h.beforeDelete }, op); err != nil {
+		return err
+	}
+
+	if err := d.remove(collection, resource, p); err != nil {
+		return err
+	}
+
+	return d.runHooks(func(h *hooks) []Hook { return h.afterDelete }, op)
+}
+
+func (d *Driver) delete(collection, resource string) error {
+	return d.remove(collection, resource, writeParams{})
+}
+
+// remove deletes a record's file under the collection lock. In bitemporal
+// collections it records the deletion in the history instead and leaves
+// whatever version is still in effect.
+func (d *Driver) remove(collection, resource string, p writeParams) (err error) {
+	if !p.replicated {
+		if err := d.writable(); err != nil {
+			return err
+		}
+		if err := d.notView(collection); err != nil {
+			return err
+		}
+	}
+	cfg := d.snapshotConfig(collection)
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	level, group := d.commitLevel(d.durability(p))
+	defer func() {
+		release()
+		if group && err == nil {
+			err = d.commits.sync(d.changedDirs(collection, resource, cfg.history, p.soft)...)
+		}
+	}()
+	if p.guard != nil {
+		if err := d.checkExisting(p.guard, collection, resource, d.recordPath(collection, resource, cfg), cfg); err != nil {
+			return err
+		}
+	}
+	return d.removeLocked(collection, resource, cfg, p, level)
+}
+
+// removeLocked does the work of remove at level. Callers must hold the
+// collection's write lock.
+func (d *Driver) removeLocked(collection, resource string, cfg *collectionConfig, p writeParams, level Durability) error {
+	path := d.recordPath(collection, resource, cfg)
+	before, err := d.triggerBefore(collection, resource, path, cfg, p)
+	if err != nil {
+		return err
+	}
+	if p.soft {
+		if err := d.moveToTrash(collection, resource, path, level); err != nil {
+			return err
+		}
+	}
+
+	if cfg.history {
+		// only bitemporal deletes may target a record not currently live
+		if _, err := d.fs.Stat(path); err != nil && (!cfg.bitemporal || !d.hasHistory(collection, resource)) {
+			return err
+		}
+		cur, err := d.recordVersion(collection, resource, nil, true, p.validFrom, level)
+		if err != nil {
+			return err
+		}
+		if cur != nil {
+			if err := d.writeLive(collection, resource, path, cfg.version, cur.Data, level, p.priority()); err != nil {
+				return err
+			}
+			d.notify(OpWrite, collection, resource, cur.Data)
+			return d.queueTriggers(OpWrite, collection, resource, cfg, p, before, cur.Data)
+		}
+		if err := d.removeLive(collection, resource, level); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			// the delete still ends a version in the history
+			if _, err := d.nextSeq(collection, level); err != nil {
+				return err
+			}
+		}
+		d.notify(OpDelete, collection, resource, nil)
+		return d.queueTriggers(OpDelete, collection, resource, cfg, p, before, nil)
+	}
+	if err := d.removeLive(collection, resource, level); err != nil {
+		return err
+	}
+	d.notify(OpDelete, collection, resource, nil)
+	return d.queueTriggers(OpDelete, collection, resource, cfg, p, before, nil)
+}
+
+// changedDirs lists the directories a change to a record writes in
+func (d *Driver) changedDirs(collection, resource string, history, trash bool) []string {
+	dirs := []string{filepath.Dir(d.recordPath(collection, resource, d.snapshotConfig(collection)))}
+	if history {
+		dirs = append(dirs, d.historyPath(collection, resource))
+	}
+	if trash {
+		dirs = append(dirs, filepath.Join(d.collectionDir(collection), trashDir))
+	}
+	return dirs
+}
+
+// removeLive deletes a record's file. Callers must hold the collection lock.
+func (d *Driver) removeLive(collection, resource string, level Durability) error {
+	path := d.recordPath(collection, resource, d.snapshotConfig(collection))
+	d.cache.invalidate(collection, resource)
+	var old fs.FileInfo
+	if d.tracksUsage(collection) {
+		old, _ = d.fs.Stat(path)
+	}
+	err := d.fs.Remove(path, level)
+	d.opts.logger.Debug("delete", "collection", collection, "resource", resource, "err", err)
+	if err == nil && old != nil {
+		d.trackRemove(collection, old.Size())
+	}
+	if err == nil {
+		d.mutex.Lock()
+		if idx := d.uniques[collection]; idx != nil {
+			idx.remove(resource)
+		}
+		if idx := d.searches[collection]; idx != nil {
+			idx.remove(resource)
+		}
+		if idx := d.columns[collection]; idx != nil {
+			idx.remove(resource)
+		}
+		if idx := d.distincts[collection]; idx != nil {
+			idx.removed++
+		}
+		d.mutex.Unlock()
+		d.dropFromViews(collection, resource)
+		if !isSystemCollection(collection) {
+			_, err = d.nextSeq(collection, level)
+		}
+	}
+	return err
+}
+
+// DeleteWhere deletes every record of collection matching filter, nil
+// meaning all of them, and returns how many it deleted. The collection
+// stays write-locked from finding the records until the last is deleted,
+// so no write slips in between. Each record passes through the delete
+// hooks as with Delete, except that before-delete hooks run with the lock
+// held and must not call back into this collection, and is authorized as
+// Delete is, record by record. Matches the collection's write Policy
+// doesn't let the caller change are skipped. DeleteWhere stops at the
+// first record that can't be deleted; those deleted before it stay
+// deleted.
+func (d *Driver) DeleteWhere(collection string, filter Filter, opts ...WriteOption) (n int, err error) {
+	if err := validateCollection(collection); err != nil {
+		return 0, err
+	}
+	if err := d.writable(); err != nil {
+		return 0, err
+	}
+	if err := d.notView(collection); err != nil {
+		return 0, err
+	}
+	p := newWriteParams(opts)
+	cfg := d.snapshotConfig(collection)
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return 0, err
+	}
+	level, group := d.commitLevel(d.durability(p))
+	var deleted []string
+	defer func() {
+		release()
+		if group && len(deleted) > 0 {
+			var dirs []string
+			for _, resource := range deleted {
+				dirs = append(dirs, d.changedDirs(collection, resource, cfg.history, p.soft)...)
+			}
+			if serr := d.commits.sync(dirs...); err == nil {
+				err = serr
+			}
+		}
+		for _, resource := range deleted {
+			op := &Operation{Kind: OpDelete, Collection: collection, Resource: resource}
+			if herr := d.runHooks(func(h *hooks) []Hook { return h.afterDelete }, op); err == nil {
+				err = herr
+			}
+		}
+	}()
+
+	var matches []string
+	err = d.walk(collection, func(rec *Record) error {
+		if filter == nil || filter.Match(rec) {
+			matches = append(matches, rec.Resource)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	counters := d.metrics.counters(collection)
+	for _, resource := range matches {
+		start := time.Now()
+		op := &Operation{Kind: OpDelete, Collection: collection, Resource: resource}
+		err := d.admit(&p, ActionDelete, collection, resource)
+		if err == nil && p.guard != nil {
+			// records outside the caller's write policy are left alone
+			err = d.checkExisting(p.guard, collection, resource, d.recordPath(collection, resource, cfg), cfg)
+			if errors.Is(err, ErrPermissionDenied) {
+				continue
+			}
+		}
+		if err == nil {
+			err = d.runHooks(func(h *hooks) []Hook { return h.beforeDelete }, op)
+		}
+		if err == nil {
+			err = d.removeLocked(collection, resource, cfg, p, level)
+		}
+		counters.deletes.done(start, err)
+		if err != nil {
+			d.deadLetterDelete(collection, resource, err)
+			return len(deleted), err
+		}
+		deleted = append(deleted, resource)
+	}
+	return len(deleted), nil
+}
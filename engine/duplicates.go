@@ -0,0 +1,363 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// --- DUPLICATES AND MERGING ---
+
+// DuplicateGroup is a set of records sharing the same candidate key
+type DuplicateGroup struct {
+	Key       string
+	Resources []string
+}
+
+// DuplicateOption tunes how FindDuplicates compares keys
+type DuplicateOption func(*duplicateOptions)
+
+type duplicateOptions struct {
+	normalize   bool
+	maxDistance int
+	ctx         context.Context
+}
+
+// Normalized compares keys ignoring case, punctuation and extra whitespace,
+// so "John  Doe" and "john doe." are the same key
+func Normalized() DuplicateOption {
+	return func(o *duplicateOptions) { o.normalize = true }
+}
+
+// Fuzzy groups normalized keys that are within maxDistance single character
+// edits of each other. Every pair of distinct keys is compared, so expect
+// quadratic cost in the number of distinct keys.
+func Fuzzy(maxDistance int) DuplicateOption {
+	return func(o *duplicateOptions) {
+		o.normalize = true
+		o.maxDistance = maxDistance
+	}
+}
+
+// DuplicatesContext makes ctx the context of FindDuplicates, which is
+// authorized as a read of the collection and groups only the records its
+// read Policy shows, as ReadContext does for Find
+func DuplicatesContext(ctx context.Context) DuplicateOption {
+	return func(o *duplicateOptions) { o.ctx = ctx }
+}
+
+// FindDuplicates groups the records of collection by the values of fields
+// and returns every group with more than one member. Records missing all of
+// the fields are ignored.
+func (d *Driver) FindDuplicates(collection string, fields []string, opts ...DuplicateOption) ([]DuplicateGroup, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing candidate key fields")
+	}
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	var o duplicateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := d.authorize(o.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+	guard := d.readGuard(o.ctx, collection)
+
+	byKey := make(map[string][]string)
+	var keys []string
+	err := d.scan(collection, func(rec *Record) error {
+		if guard != nil && !guard.Match(rec) {
+			return nil
+		}
+		key, ok := candidateKey(rec, fields, o.normalize)
+		if !ok {
+			return nil
+		}
+		if _, seen := byKey[key]; !seen {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], rec.Resource)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.maxDistance > 0 {
+		byKey, keys = clusterKeys(byKey, keys, o.maxDistance)
+	}
+
+	var groups []DuplicateGroup
+	for _, key := range keys {
+		if members := byKey[key]; len(members) > 1 {
+			sort.Strings(members)
+			groups = append(groups, DuplicateGroup{Key: key, Resources: members})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups, nil
+}
+
+// candidateKey joins the record's values for fields into a single key
+func candidateKey(rec *Record, fields []string, normalize bool) (string, bool) {
+	parts := make([]string, len(fields))
+	found := false
+	for i, f := range fields {
+		v, ok := rec.Field(f)
+		if !ok || v == nil {
+			continue
+		}
+		found = true
+		parts[i] = fmt.Sprint(v)
+		if normalize {
+			parts[i] = normalizeKey(parts[i])
+		}
+	}
+	return strings.Join(parts, "\x1f"), found
+}
+
+// normalizeKey lower-cases s, drops punctuation and collapses whitespace
+func normalizeKey(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r):
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// clusterKeys merges groups whose keys are within maxDistance edits, using
+// the alphabetically first key of each cluster as its name
+func clusterKeys(byKey map[string][]string, keys []string, maxDistance int) (map[string][]string, []string) {
+	sort.Strings(keys)
+	parent := make([]int, len(keys))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			if editDistance(keys[i], keys[j], maxDistance) <= maxDistance {
+				if a, b := find(i), find(j); a != b {
+					if a < b {
+						parent[b] = a
+					} else {
+						parent[a] = b
+					}
+				}
+			}
+		}
+	}
+
+	clustered := make(map[string][]string)
+	var names []string
+	for i, key := range keys {
+		root := keys[find(i)]
+		if _, ok := clustered[root]; !ok {
+			names = append(names, root)
+		}
+		clustered[root] = append(clustered[root], byKey[key]...)
+	}
+	return clustered, names
+}
+
+// editDistance is the Levenshtein distance between a and b, giving up early
+// (returning limit+1) once it is certain to exceed limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > limit || -diff > limit {
+		return limit + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// MergeConflict is a field on which the records being merged disagree
+type MergeConflict struct {
+	Field  string
+	Values map[string]interface{} // resource name -> value
+}
+
+// MergePlan describes how a set of duplicate records will be combined.
+// Merged starts out holding the target's value for every field, filled in
+// from the sources where the target has none; inspect Conflicts and call
+// Resolve to pick different values before passing the plan to
+// MergeDocuments.
+type MergePlan struct {
+	Collection string
+	Target     string
+	Sources    []string
+	Merged     map[string]interface{}
+	Conflicts  []MergeConflict
+}
+
+// Resolve sets the merged value of a (dot separated) field
+func (p *MergePlan) Resolve(field string, value interface{}) error {
+	v, err := toDocument(value)
+	if err != nil {
+		return err
+	}
+	if !setPath(p.Merged, field, v) {
+		return fmt.Errorf("cannot set %q in merged document", field)
+	}
+	return nil
+}
+
+// PlanMerge prepares merging sources into target within collection
+func (d *Driver) PlanMerge(collection, target string, sources ...string) (*MergePlan, error) {
+	if err := validateNames(collection, target); err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("nothing to merge into %q", target)
+	}
+
+	resources := append([]string{target}, sources...)
+	flat := make([]map[string]interface{}, len(resources))
+	for i, res := range resources {
+		if i > 0 && res == target {
+			return nil, fmt.Errorf("cannot merge %q into itself", target)
+		}
+		if err := validateName("resource", res); err != nil {
+			return nil, err
+		}
+		rec, err := d.readRecord(collection, res)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := rec.Document()
+		if err != nil {
+			return nil, err
+		}
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %q is not an object", res)
+		}
+		flat[i] = make(map[string]interface{})
+		flattenDocument(obj, "", flat[i])
+	}
+
+	plan := &MergePlan{
+		Collection: collection,
+		Target:     target,
+		Sources:    append([]string(nil), sources...),
+		Merged:     make(map[string]interface{}),
+	}
+
+	var fields []string
+	seen := make(map[string]bool)
+	for _, f := range flat {
+		for field := range f {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		var chosen interface{}
+		values := make(map[string]interface{})
+		for i, f := range flat {
+			v, ok := f[field]
+			if !ok || isEmptyValue(v) {
+				continue
+			}
+			values[resources[i]] = v
+			if chosen == nil {
+				chosen = v
+			}
+		}
+		if chosen == nil {
+			chosen = flat[0][field]
+		}
+		setPath(plan.Merged, field, chosen)
+
+		if distinctValues(values) > 1 {
+			plan.Conflicts = append(plan.Conflicts, MergeConflict{Field: field, Values: values})
+		}
+	}
+	return plan, nil
+}
+
+// MergeDocuments writes the plan's merged document to its target and deletes
+// the source records
+func (d *Driver) MergeDocuments(plan *MergePlan) error {
+	if err := d.Write(plan.Collection, plan.Target, plan.Merged); err != nil {
+		return err
+	}
+	for _, res := range plan.Sources {
+		if err := d.Delete(plan.Collection, res); err != nil {
+			return fmt.Errorf("merged into %q but could not delete %q: %w", plan.Target, res, err)
+		}
+	}
+	return nil
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return val == ""
+	case []interface{}:
+		return len(val) == 0
+	}
+	return false
+}
+
+func distinctValues(values map[string]interface{}) int {
+	var distinct []interface{}
+outer:
+	for _, v := range values {
+		for _, seen := range distinct {
+			if jsonEqual(seen, v) {
+				continue outer
+			}
+		}
+		distinct = append(distinct, v)
+	}
+	return len(distinct)
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// --- ENCODING ---
+
+// maxPooledBuffer is the largest buffer kept for reuse, so one huge record
+// doesn't pin its memory
+const maxPooledBuffer = 1 << 20
+
+// WithIndent sets how record files are indented, as json.MarshalIndent
+// does: every line after the first starts with prefix, followed by one
+// indent per level of nesting. The default is a tab per level. History
+// versions and trashed records are written the same way. Reads take any
+// layout, so the setting can change at any time; existing files keep
+// theirs until rewritten.
+func WithIndent(prefix, indent string) Option {
+	return func(o *options) {
+		o.indentPrefix, o.indent = prefix, indent
+		o.indentSet = true
+	}
+}
+
+// WithCompactJSON writes record files without any whitespace, which for
+// documents with many small fields takes about half the space of the
+// indented default and encodes faster. It is WithIndent("", "").
+func WithCompactJSON() Option {
+	return WithIndent("", "")
+}
+
+// indentation is the prefix and indent the Driver stores files with
+func (o *options) indentation() (prefix, indent string) {
+	if !o.indentSet {
+		return "", "\t"
+	}
+	return o.indentPrefix, o.indent
+}
+
+var encodeBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// preEncode marshals a document ahead of taking the collection lock, so
+// concurrent writers, such as the workers of an Import, encode in
+// parallel; under the lock only the cheap indenting pass is left
+func preEncode(v interface{}) (json.RawMessage, error) {
+	if raw, ok := v.(json.RawMessage); ok && json.Valid(raw) {
+		return raw, nil
+	}
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+// encodeIndented encodes v as stored on disk into a pooled buffer, which
+// the caller hands back with putBuffer once done with it
+func (d *Driver) encodeIndented(v interface{}) (*bytes.Buffer, error) {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetIndent(d.opts.indentation())
+	if err := enc.Encode(v); err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	buf.Truncate(buf.Len() - 1) // the newline Encode adds
+	return buf, nil
+}
+
+// marshalIndented is json.MarshalIndent with the Driver's indentation
+func (d *Driver) marshalIndented(v interface{}) ([]byte, error) {
+	prefix, indent := d.opts.indentation()
+	if prefix == "" && indent == "" {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, prefix, indent)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	encodeBuffers.Put(buf)
+}
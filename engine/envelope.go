@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// --- METADATA ENVELOPES ---
+
+// ErrNoMetadata is returned by ReadMeta for records stored without an envelope
+var ErrNoMetadata = errors.New("record has no metadata")
+
+// Meta is the bookkeeping kept alongside a document when WithMetadata is set
+type Meta struct {
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Revision  int64     `json:"revision"`
+	// Seq is the collection's sequence number of the write, increasing
+	// with every change committed to the collection
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// envelope is the on-disk layout of a document carrying metadata or a
+// schema version
+type envelope struct {
+	Version *int            `json:"_v"`
+	Meta    *Meta           `json:"_meta"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// envelopeOut is used when writing so the document keeps its own field order
+type envelopeOut struct {
+	Version *int        `json:"_v,omitempty"`
+	Meta    *Meta       `json:"_meta,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+var (
+	envelopePrefix = []byte(`"_meta"`)
+	versionPrefix  = []byte(`"_v"`)
+)
+
+// isEnvelope reports whether b may be an envelope. Envelopes are always
+// written with _v or _meta as the first key, which keeps detection cheap for
+// plain documents.
+func isEnvelope(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	if len(b) == 0 || b[0] != '{' {
+		return false
+	}
+	b = bytes.TrimLeft(b[1:], " \t\r\n")
+	return bytes.HasPrefix(b, envelopePrefix) || bytes.HasPrefix(b, versionPrefix)
+}
+
+// unwrapEnvelope returns the document stored in b along with its envelope,
+// or b itself and a nil envelope for records written without one
+func unwrapEnvelope(b []byte) ([]byte, *envelope, error) {
+	if !isEnvelope(b) {
+		return b, nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, nil, err
+	}
+	var env envelope
+	for k, raw := range fields {
+		var err error
+		switch k {
+		case "_v":
+			err = json.Unmarshal(raw, &env.Version)
+		case "_meta":
+			err = json.Unmarshal(raw, &env.Meta)
+		case "data":
+			env.Data = raw
+		default:
+			// envelopes hold nothing else
+			return b, nil, nil
+		}
+		if err != nil {
+			return b, nil, nil
+		}
+	}
+	if (env.Meta == nil && env.Version == nil) || env.Data == nil {
+		// a document that merely happens to start with a _meta or _v field
+		return b, nil, nil
+	}
+	return env.Data, &env, nil
+}
+
+// decodeRecord builds the Record for the stored bytes of a resource
+func decodeRecord(resource string, b []byte) (*Record, error) {
+	data, env, err := unwrapEnvelope(b)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Record{Resource: resource, Data: data, raw: b}
+	if env != nil {
+		rec.Meta = env.Meta
+		if env.Version != nil {
+			rec.Version = *env.Version
+		}
+	}
+	return rec, nil
+}
+
+// wrapEnvelope puts v in an envelope when the collection keeps metadata or
+// a schema version, or when v itself would read as one. Callers must hold
+// the collection lock.
+func (d *Driver) wrapEnvelope(collection, path string, version int, seq uint64, v interface{}, cfg *collectionConfig) interface{} {
+	if isSystemCollection(collection) {
+		return v
+	}
+	if !d.opts.metadata && version == 0 {
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return v
+		}
+		if _, env, err := unwrapEnvelope(raw); err != nil || env == nil {
+			return v
+		}
+		// a document shaped like an envelope is escaped in one, stamped
+		// with version 0, so that it reads back whole
+		return envelopeOut{Version: &version, Data: v}
+	}
+	env := envelopeOut{Data: v}
+	if version != 0 {
+		env.Version = &version
+	}
+	if d.opts.metadata {
+		env.Meta = d.nextMeta(collection, path, cfg, time.Now().UTC())
+		env.Meta.Seq = seq
+	}
+	return env
+}
+
+// SetSchemaVersion stamps every document written into collection from now on
+// with version, kept in the envelope's _v field next to the metadata.
+// Readers see the plain document as before; Record.Version reports the
+// stamp, and VersionBelow finds documents still on an older one. Records
+// written before any version was set read as version 0.
+func (d *Driver) SetSchemaVersion(collection string, version int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).version = version
+}
+
+// VersionBelow matches records stamped with a schema version lower than v,
+// including unversioned ones
+func VersionBelow(v int) Filter {
+	return FilterFunc(func(r *Record) bool { return r.Version < v })
+}
+
+// nextMeta builds the metadata for a new revision of the record at path.
+// Callers must hold the collection lock.
+func (d *Driver) nextMeta(collection, path string, cfg *collectionConfig, now time.Time) *Meta {
+	meta := &Meta{CreatedAt: now, UpdatedAt: now, Revision: 1}
+
+	b, err := d.readLive(collection, path, cfg)
+	if err != nil {
+		return meta
+	}
+	if _, prev, err := unwrapEnvelope(b); err == nil && prev != nil && prev.Meta != nil {
+		meta.CreatedAt = prev.Meta.CreatedAt
+		meta.Revision = prev.Meta.Revision + 1
+	}
+	return meta
+}
+
+// ReadMeta returns the metadata of a record written with WithMetadata,
+// authorized as a Read of it
+func (d *Driver) ReadMeta(collection, resource string, opts ...ReadOption) (*Meta, error) {
+	if err := validateNames(collection, resource); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, resource); err != nil {
+		return nil, err
+	}
+
+	rec, err := d.readRecord(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	if guard := d.readGuard(p.ctx, collection); guard != nil && !guard.Match(rec) {
+		return nil, fmt.Errorf("%s/%s: %w", collection, resource, fs.ErrNotExist)
+	}
+	if rec.Meta == nil {
+		return nil, ErrNoMetadata
+	}
+	return rec.Meta, nil
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// documents user code may store that look like envelopes
+var envelopeLookalikes = []string{
+	`{"_v":2,"data":"hello","other":1}`,
+	`{"_v":2,"data":"hello"}`,
+	`{"_v":null,"data":1}`,
+	`{"_meta":{"revision":3},"data":{"x":1}}`,
+	`{"_meta":{"revision":3},"_v":1,"data":[1,2]}`,
+	`{"data":"hello","_v":2}`,
+	`{"a":1}`,
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata bool
+		version  int
+	}{
+		{"plain", false, 0},
+		{"metadata", true, 0},
+		{"schema version", false, 3},
+		{"metadata and schema version", true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.metadata {
+				opts = append(opts, WithMetadata())
+			}
+			d := openTest(t, opts...)
+			if tt.version != 0 {
+				d.SetSchemaVersion("docs", tt.version)
+			}
+			for _, doc := range envelopeLookalikes {
+				if err := d.Write("docs", "r", json.RawMessage(doc)); err != nil {
+					t.Fatalf("writing %s: %v", doc, err)
+				}
+				rec, err := d.readRecord("docs", "r")
+				if err != nil {
+					t.Fatalf("reading %s: %v", doc, err)
+				}
+				if !sameJSON(t, rec.Data, []byte(doc)) {
+					t.Errorf("wrote %s, read back %s", doc, rec.Data)
+				}
+				if rec.Version != tt.version {
+					t.Errorf("%s: version %d, want %d", doc, rec.Version, tt.version)
+				}
+				_, err = d.ReadMeta("docs", "r")
+				if tt.metadata && err != nil {
+					t.Errorf("%s: ReadMeta: %v", doc, err)
+				}
+				if !tt.metadata && !errors.Is(err, ErrNoMetadata) {
+					t.Errorf("%s: ReadMeta = %v, want ErrNoMetadata", doc, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnwrapEnvelope(t *testing.T) {
+	tests := []struct {
+		stored   string
+		envelope bool
+		data     string
+	}{
+		{`{"a":1}`, false, `{"a":1}`},
+		{`{"_v":2,"data":"hello","other":1}`, false, `{"_v":2,"data":"hello","other":1}`},
+		{`{"_v":null,"data":1}`, false, `{"_v":null,"data":1}`},
+		{`{"_v":"two","data":1}`, false, `{"_v":"two","data":1}`},
+		{`{"_meta":{"revision":1}}`, false, `{"_meta":{"revision":1}}`},
+		{`{"_v":0,"data":{"_v":2,"data":"hello"}}`, true, `{"_v":2,"data":"hello"}`},
+		{`{"_meta":{"revision":1},"data":{"a":1}}`, true, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		data, env, err := unwrapEnvelope([]byte(tt.stored))
+		if err != nil {
+			t.Errorf("%s: %v", tt.stored, err)
+			continue
+		}
+		if (env != nil) != tt.envelope {
+			t.Errorf("%s: envelope %v, want %v", tt.stored, env != nil, tt.envelope)
+		}
+		if !sameJSON(t, data, []byte(tt.data)) {
+			t.Errorf("%s: data %s, want %s", tt.stored, data, tt.data)
+		}
+	}
+}
+
+// sameJSON reports whether a and b encode the same value
+func sameJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("decoding %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("decoding %s: %v", b, err)
+	}
+	ea, _ := json.Marshal(va)
+	eb, _ := json.Marshal(vb)
+	return string(ea) == string(eb)
+}
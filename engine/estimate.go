@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// --- APPROXIMATE COUNTS ---
+
+// estimateSample is how many records EstimateCount reads to judge what
+// share of a collection a filter matches
+const estimateSample = 1000
+
+// hllPrecision gives HyperLogLog sketches 2^14 registers, a standard error
+// of about 0.8% in 16 KiB per field
+const hllPrecision = 14
+
+// distinctStale is the share of deletes since a distinct sketch was built
+// past which it is rebuilt, as deleted values can't be taken out of it
+const distinctStale = 0.1
+
+// EstimateCount returns about how many records of collection match filter,
+// and whether the number is exact. With a nil filter the records are
+// counted from the directory listing without reading any, unless a read
+// Policy has to pick out the caller's; otherwise a
+// random sample of the records is read and the matching share of it scaled
+// up to the whole collection. Collections small enough to read whole are
+// counted exactly.
+func (d *Driver) EstimateCount(collection string, filter Filter, opts ...ReadOption) (n int, exact bool, err error) {
+	if err := validateCollection(collection); err != nil {
+		return 0, false, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return 0, false, err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return 0, false, err
+	}
+	defer release()
+
+	names, err := d.recordNames(collection)
+	if err != nil {
+		return 0, false, err
+	}
+	if guard := d.readGuard(p.ctx, collection); guard != nil {
+		if filter == nil {
+			filter = guard
+		} else {
+			filter = And(filter, guard)
+		}
+	}
+	if filter == nil {
+		return len(names), true, nil
+	}
+
+	sample := names
+	if len(names) > estimateSample {
+		sample = slices.Clone(names)
+		for i := 0; i < estimateSample; i++ {
+			j := i + rand.IntN(len(sample)-i)
+			sample[i], sample[j] = sample[j], sample[i]
+		}
+		sample = sample[:estimateSample]
+		sort.Strings(sample)
+	}
+
+	matched := 0
+	err = d.walkNames(collection, sample, func(rec *Record) error {
+		if filter.Match(rec) {
+			matched++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if len(sample) == len(names) {
+		return matched, true, nil
+	}
+	return int(math.Round(float64(matched) * float64(len(names)) / float64(len(sample)))), false, nil
+}
+
+// DistinctField keeps a HyperLogLog sketch of the values at the (dot
+// separated) path of the documents in collection, so EstimateDistinct can
+// answer without reading them. Values are compared as with Equal.
+func (d *Driver) DistinctField(collection, path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	if !slices.Contains(cfg.distinct, path) {
+		cfg.distinct = append(slices.Clone(cfg.distinct), path)
+	}
+}
+
+// EstimateDistinct returns about how many different values the records of
+// collection hold at path, and whether the number is exact. Fields made
+// distinct with DistinctField are answered from their sketch, which is
+// built on first use and fed by every write after; a value a record no
+// longer holds after an update still counts until the sketch is rebuilt
+// following enough deletes. Other fields, and every field of a collection
+// with a read Policy, are counted exactly by reading every record the
+// caller may see. Records without the field are not counted.
+func (d *Driver) EstimateDistinct(collection, path string, opts ...ReadOption) (n int, exact bool, err error) {
+	if err := validateCollection(collection); err != nil {
+		return 0, false, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return 0, false, err
+	}
+	cfg := d.snapshotConfig(collection)
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return 0, false, err
+	}
+	defer release()
+
+	guard := d.readGuard(p.ctx, collection)
+	if i := slices.Index(cfg.distinct, path); i >= 0 && guard == nil {
+		idx, err := d.distinctIndexFor(collection, cfg.distinct)
+		if err != nil {
+			return 0, false, err
+		}
+		return int(math.Round(idx.sketches[i].estimate())), false, nil
+	}
+
+	seen := make(map[string]bool)
+	err = d.walk(collection, func(rec *Record) error {
+		if guard != nil && !guard.Match(rec) {
+			return nil
+		}
+		if v, ok := rec.Field(path); ok {
+			seen[distinctKey(v)] = true
+		}
+		return nil
+	})
+	return len(seen), err == nil, err
+}
+
+// distinctIndex holds the sketches of a collection's distinct fields. Like
+// the unique index it is built on first use from the stored records and
+// fed by write under the collection's write lock; deletes only count
+// against it until it is stale enough to rebuild.
+type distinctIndex struct {
+	fields   []string
+	sketches []*hyperLogLog
+	records  int // records added since the index was built
+	removed  int // records deleted since, whose values still count
+}
+
+// distinctIndexFor returns the distinct index of collection for fields,
+// building it when missing or stale. Callers must hold the collection lock.
+func (d *Driver) distinctIndexFor(collection string, fields []string) (*distinctIndex, error) {
+	d.mutex.Lock()
+	idx := d.distincts[collection]
+	stale := idx != nil && float64(idx.removed) > distinctStale*float64(idx.records)
+	d.mutex.Unlock()
+	if idx != nil && !stale && slices.Equal(idx.fields, fields) {
+		return idx, nil
+	}
+
+	idx = &distinctIndex{fields: fields, sketches: make([]*hyperLogLog, len(fields))}
+	for i := range idx.sketches {
+		idx.sketches[i] = newHyperLogLog()
+	}
+	err := d.walk(collection, func(rec *Record) error {
+		idx.add(rec.Field)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	d.distincts[collection] = idx
+	d.mutex.Unlock()
+	return idx, nil
+}
+
+// invalidateDistinct drops the distinct index of collection after its
+// files were replaced wholesale
+func (d *Driver) invalidateDistinct(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.distincts, collection)
+}
+
+// reindexDistinct feeds the record just written to the distinct index of
+// collection, if there is one. Callers must hold the collection's write
+// lock.
+func (d *Driver) reindexDistinct(collection string, v interface{}) {
+	d.mutex.Lock()
+	idx := d.distincts[collection]
+	d.mutex.Unlock()
+	if idx == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		d.invalidateDistinct(collection)
+		return
+	}
+	idx.add(func(path string) (interface{}, bool) {
+		raw, ok := extractPath(b, path)
+		if !ok {
+			return nil, false
+		}
+		v, err := decodeDocument(raw)
+		return v, err == nil
+	})
+}
+
+// add feeds the values field returns into the sketches
+func (idx *distinctIndex) add(field func(path string) (interface{}, bool)) {
+	idx.records++
+	for i, f := range idx.fields {
+		if v, ok := field(f); ok {
+			idx.sketches[i].add(distinctKey(v))
+		}
+	}
+}
+
+// distinctKey encodes a field value so that values Equal considers the
+// same encode alike
+func distinctKey(v interface{}) string {
+	if n, ok := v.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// hyperLogLog estimates the number of distinct strings added to it
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(s string) {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	x := mix64(f.Sum64())
+
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() float64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small sets
+		e = m * math.Log(m/float64(zeros))
+	}
+	return e
+}
+
+// mix64 spreads the bits of an FNV hash, whose low bits are weak, across
+// the whole word
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
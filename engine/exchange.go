@@ -0,0 +1,509 @@
+package engine
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- IMPORT/EXPORT FORMATS ---
+
+// IDField carries the resource name of a record in exported JSON Lines and
+// CSV, so an export can be imported back under the same names
+const IDField = "_id"
+
+// Format selects the encoding used by Export and Import
+type Format int
+
+const (
+	// JSONL is one compact JSON document per line
+	JSONL Format = iota
+	// CSV is a header row of dot separated field paths followed by one row
+	// per record
+	CSV
+	// Archive is a gzip compressed tar of the raw record files, for backups
+	// and moving whole databases between environments
+	Archive
+)
+
+func (f Format) String() string {
+	switch f {
+	case JSONL:
+		return "jsonl"
+	case CSV:
+		return "csv"
+	case Archive:
+		return "archive"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat maps a format name ("jsonl", "csv" or "archive") to a Format
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "jsonl", "ndjson":
+		return JSONL, nil
+	case "csv":
+		return CSV, nil
+	case "archive", "tar.gz", "tgz":
+		return Archive, nil
+	}
+	return 0, fmt.Errorf("unknown format %q", name)
+}
+
+// Export writes every record of collection to w in the given format. For
+// Archive an empty collection exports the whole database; an Archive copies
+// the files as Backup does, so it is an administrative call the Authorizer
+// doesn't see, while JSONL and CSV are authorized as reads.
+func (d *Driver) Export(collection string, w io.Writer, format Format, opts ...ReadOption) error {
+	if format == Archive {
+		return d.exportArchive(collection, w)
+	}
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return err
+	}
+
+	guard := d.readGuard(p.ctx, collection)
+	switch format {
+	case JSONL:
+		return d.exportJSONL(collection, w, guard)
+	case CSV:
+		return d.exportCSV(collection, w, guard)
+	}
+	return fmt.Errorf("unsupported export format %v", format)
+}
+
+// --- JSON LINES
+
+func (d *Driver) exportJSONL(collection string, w io.Writer, guard Filter) error {
+	bw := bufio.NewWriter(w)
+	err := d.scan(collection, func(rec *Record) error {
+		if guard != nil && !guard.Match(rec) {
+			return nil
+		}
+		line, err := withID(rec)
+		if err != nil {
+			return err
+		}
+		bw.Write(line)
+		return bw.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// withID returns the record as compact JSON with its resource name added as
+// the first field, unless the document already has an _id of its own
+func withID(rec *Record) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, rec.Data); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	if len(b) == 0 || b[0] != '{' {
+		return b, nil
+	}
+	if _, ok := rec.Field(IDField); ok {
+		return b, nil
+	}
+
+	id, _ := json.Marshal(rec.Resource)
+	out := append([]byte(`{"`+IDField+`":`), id...)
+	if len(b) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, b[1:]...), nil
+}
+
+// --- CSV
+
+func (d *Driver) exportCSV(collection string, w io.Writer, guard Filter) error {
+	var rows []map[string]interface{}
+	columns := make(map[string]bool)
+
+	err := d.scan(collection, func(rec *Record) error {
+		if guard != nil && !guard.Match(rec) {
+			return nil
+		}
+		doc, err := rec.Document()
+		if err != nil {
+			return err
+		}
+		row := make(map[string]interface{})
+		if obj, ok := doc.(map[string]interface{}); ok {
+			flattenDocument(obj, "", row)
+		} else {
+			row["value"] = doc
+		}
+		if _, ok := row[IDField]; !ok {
+			row[IDField] = rec.Resource
+		}
+		for col := range row {
+			columns[col] = true
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	header := make([]string, 0, len(columns))
+	for col := range columns {
+		if col != IDField {
+			header = append(header, col)
+		}
+	}
+	sort.Strings(header)
+	header = append([]string{IDField}, header...)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	cells := make([]string, len(header))
+	for _, row := range rows {
+		for i, col := range header {
+			if cells[i], err = csvCell(row[col]); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvCell(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// readCSV converts each CSV row after the header into a JSON object and
+// passes it to fn, stopping when fn returns false. Header names are dot
+// separated paths; empty cells are left out. With infer set, cells that look
+// like numbers, booleans, arrays or objects are decoded as such; numbers with
+// leading zeros (phone numbers, postal codes) stay strings.
+func readCSV(r io.Reader, infer bool, fn recordFunc) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				// hand the broken row to the error policy like any bad record
+				if !fn(perr.Line, []byte(strings.Join(row, ",")), perr.Err) {
+					return nil
+				}
+				continue
+			}
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+
+		doc := make(map[string]interface{})
+		for i, cell := range row {
+			if i >= len(header) || cell == "" {
+				continue
+			}
+			var v interface{} = cell
+			if infer && header[i] != IDField {
+				v = inferCSVValue(cell)
+			}
+			setPath(doc, header[i], v)
+		}
+
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if !fn(line, b, nil) {
+			return nil
+		}
+	}
+}
+
+func inferCSVValue(cell string) interface{} {
+	switch cell {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if cell[0] == '[' || cell[0] == '{' {
+		if doc, err := decodeDocument([]byte(cell)); err == nil {
+			return doc
+		}
+		return cell
+	}
+
+	if jsonNumberPattern.MatchString(cell) {
+		return json.Number(cell)
+	}
+	return cell
+}
+
+// jsonNumberPattern is the JSON number grammar, which conveniently excludes
+// leading zeros
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// --- ARCHIVES
+
+// collectionDirs lists the collection directories under a database root
+func collectionDirs(s storage, root string) ([]string, error) {
+	entries, err := s.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if e.Name()+"/" != systemPrefix {
+			names = append(names, e.Name())
+			continue
+		}
+		sys, err := s.ReadDir(filepath.Join(root, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sys {
+			if s.IsDir() {
+				names = append(names, systemPrefix+s.Name())
+			}
+		}
+	}
+	return names, nil
+}
+
+func (d *Driver) exportArchive(collection string, w io.Writer) error {
+	collections := []string{collection}
+	if collection == "" {
+		var err error
+		if collections, err = d.storedCollections(); err != nil {
+			return err
+		}
+	} else if err := validateCollection(collection); err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, c := range collections {
+		if err := d.archiveCollection(tw, c); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// archiveCollection adds every file under a collection directory to tw while
+// holding the collection's read lock, so the copy is consistent
+func (d *Driver) archiveCollection(tw *tar.Writer, collection string) error {
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return tarTree(tw, d.fs, d.volume(collection), d.collectionDir(collection))
+}
+
+// tarTree adds every regular file below dir in s to tw, named relative to
+// base
+func tarTree(tw *tar.Writer, s storage, base, dir string) error {
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return err
+	}
+	return walkFiles(s, dir, func(name string, isDir bool) error {
+		if isDir {
+			return nil
+		}
+		p := filepath.Join(dir, name)
+		info, err := s.Stat(p)
+		if err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(filepath.Join(rel, name)),
+			Mode:    0644,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if isLocal(s) {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		}
+		b, err := s.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	})
+}
+
+func (d *Driver) importArchive(ctx context.Context, collection string, r io.Reader) (*ImportSummary, error) {
+	if collection != "" {
+		if err := validateCollection(collection); err != nil {
+			return nil, err
+		}
+	}
+
+	var summary ImportSummary
+	authorized := make(map[string]bool)
+	_, err := walkArchive(r, nil, func(owner, rel string, r io.Reader) error {
+		if (collection != "" && owner != collection) || rel == sequenceFile {
+			// the live collection numbers changes on from its own sequence
+			return nil
+		}
+		if !authorized[owner] {
+			if err := d.authorize(ctx, ActionWrite, owner, ""); err != nil {
+				return err
+			}
+			authorized[owner] = true
+		}
+		summary.Total++
+		if err := d.restoreFile(owner, rel, r); err != nil {
+			return fmt.Errorf("restoring: %w", err)
+		}
+		summary.Imported++
+		return nil
+	})
+	if summary.Imported > 0 {
+		// the files bypassed the change feed, so no position in it is valid
+		d.watchers.reset()
+	}
+	return &summary, err
+}
+
+// archiveEntryCollection splits an archive entry name into the collection
+// it belongs to and the file path inside that collection, refusing names
+// that would land outside the data directory
+func archiveEntryCollection(name string) (collection, rel string, err error) {
+	clean := path.Clean(name)
+	if !filepath.IsLocal(filepath.FromSlash(clean)) || strings.Contains(clean, `\`) {
+		return "", "", fmt.Errorf("%w: archive entry %q", ErrInvalidName, name)
+	}
+
+	parts := strings.SplitN(clean, "/", 3)
+	if len(parts) >= 3 && parts[0]+"/" == systemPrefix {
+		return systemPrefix + parts[1], parts[2], nil
+	}
+	parts = strings.SplitN(clean, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("%w: archive entry %q is not inside a collection", ErrInvalidName, name)
+	}
+	if err := validateCollection(parts[0]); err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
+}
+
+// restoreFile writes a file into a collection directory under its lock
+func (d *Driver) restoreFile(collection, rel string, r io.Reader) error {
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer d.invalidateUnique(collection)
+	defer d.invalidateSearch(collection)
+	defer d.invalidateColumns(collection)
+	defer d.invalidateViews(collection)
+	defer d.invalidateDistinct(collection)
+	defer d.forgetUsage(collection)
+	defer d.cache.invalidateCollection(collection)
+
+	dst := filepath.Join(d.collectionDir(collection), filepath.FromSlash(rel))
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// a filter of the archive's records alone would hide the others
+	if err := d.discardBloom(collection); err != nil || rel == bloomFile {
+		return err
+	}
+	dict := strings.HasPrefix(rel, dictPrefix)
+	if dict {
+		// the files compressed with a dictionary are unreadable with another
+		if cur, err := d.fs.ReadFile(dst); err == nil && !bytes.Equal(cur, b) {
+			return fmt.Errorf("%s: compression dictionary %s differs from the collection's own", collection, rel)
+		}
+	}
+	if err := d.fs.WriteFile(dst, b, d.opts.durability); err != nil {
+		return err
+	}
+	if rel == shardMarker {
+		d.forgetLayout(collection)
+	}
+	if dict {
+		d.forgetDictionaries(collection)
+	}
+	if rel == collectionOptionsFile && !isSystemCollection(collection) {
+		_, err = d.loadCollectionOptions(collection)
+	}
+	return err
+}
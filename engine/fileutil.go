@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// --- FILE HELPERS ---
+
+// writeFileAtomic replaces path with data by writing a hidden temporary file
+// next to it and renaming it into place. Readers see either the old or the
+// new contents, and hard links to the old file (as taken by Backup) keep
+// the old contents. level decides what is flushed to disk on the way.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, level Durability) error {
+	dir, name := filepath.Split(path)
+	tmp, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if level >= FsyncOnWrite {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if level >= FsyncDir {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// removeFile deletes path, flushing its directory afterwards at FsyncDir
+func removeFile(path string, level Durability) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if level >= FsyncDir {
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+// syncDir flushes a directory's entries, making renames and removals
+// inside it durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
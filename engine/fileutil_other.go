@@ -0,0 +1,10 @@
+//go:build !unix
+
+package engine
+
+import "errors"
+
+// diskFree is not implemented off unix
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("free space unknown on this platform")
+}
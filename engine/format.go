@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// --- FORMAT CONVERGENCE ---
+
+// Record files come in the formats the Driver's options wrote over time: a
+// bare document, or an envelope led by _v or _meta carrying the schema
+// version and metadata. Reads tell them apart by that leading key, so
+// every historical file reads whichever options are set now; the rewrites
+// below bring old files to the current format.
+
+// staleFormat is a record whose file isn't in its collection's current
+// format, as read by the scan finding it
+type staleFormat struct {
+	resource string
+	raw      []byte
+	data     json.RawMessage
+}
+
+// formatStale reports whether a record was stored in another format than
+// the one writes use now, compression dictionary included. Records on an older schema version are left to
+// the upgrade steps, which rewrite their documents too.
+func (d *Driver) formatStale(collection string, cfg *collectionConfig, rec *Record) bool {
+	if rec.Version != cfg.version {
+		return false
+	}
+	enveloped := !bytes.Equal(rec.raw, rec.Data)
+	wantEnvelope := d.opts.metadata || cfg.version != 0
+	return enveloped != wantEnvelope || d.opts.metadata != (rec.Meta != nil) || rec.dict != d.writeDictionaryID(collection, cfg)
+}
+
+// RewriteFormats rewrites the records of collection stored in an older
+// format, such as those written before WithMetadata was turned on or off
+// or compressed with another dictionary than the one writes use,
+// and returns how many it rewrote. Documents are unchanged: no hooks run
+// and no change events are raised, though each rewrite takes a sequence
+// number. Records changed while the rewrite runs are skipped, being in the
+// current format already.
+func (d *Driver) RewriteFormats(collection string) (int, error) {
+	if err := validateCollection(collection); err != nil {
+		return 0, err
+	}
+	if err := d.writable(); err != nil {
+		return 0, err
+	}
+
+	cfg := d.snapshotConfig(collection)
+	var stale []staleFormat
+	err := d.scanPlanned(collection, nil, Batch, func(rec *Record) error {
+		if d.formatStale(collection, cfg, rec) {
+			stale = append(stale, staleFormat{resource: rec.Resource, raw: rec.raw, data: append(json.RawMessage(nil), rec.Data...)})
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, s := range stale {
+		rewritten, err := d.rewriteFormat(collection, s)
+		if err != nil {
+			return n, fmt.Errorf("rewriting %s/%s: %w", collection, s.resource, err)
+		}
+		if rewritten {
+			n++
+		}
+	}
+	if n > 0 {
+		d.opts.logger.Info("record formats rewritten", "collection", collection, "records", n)
+	}
+	return n, nil
+}
+
+// rewriteFormat writes a stale record again in the current format, unless
+// its file changed since it was read
+func (d *Driver) rewriteFormat(collection string, s staleFormat) (bool, error) {
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	cfg := d.snapshotConfig(collection)
+	path := d.recordPath(collection, s.resource, cfg)
+	cur, err := d.readLive(collection, path, cfg)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(cur, s.raw) {
+		return false, nil
+	}
+	if err := d.writeLive(collection, s.resource, path, cfg.version, s.data, d.opts.durability, Batch); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ScheduleFormatRewrites runs RewriteFormats in the background over every
+// top-level collection, once per interval, until the Driver is closed, so
+// the files of a database whose options changed converge on the current
+// format. Failures are logged and retried at the next interval.
+func (d *Driver) ScheduleFormatRewrites(every time.Duration) error {
+	if every <= 0 {
+		return fmt.Errorf("format rewrite interval must be positive")
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if err := d.rewriteAllFormats(stop); err != nil && !errors.Is(err, ErrClosed) {
+				d.opts.logger.Error("format rewrite failed", "err", err)
+			}
+		}
+	}()
+
+	d.onClose(func() error {
+		close(stop)
+		wg.Wait()
+		return nil
+	})
+	return nil
+}
+
+// rewriteAllFormats runs RewriteFormats over the user collections, giving
+// up early once stop is closed
+func (d *Driver) rewriteAllFormats(stop <-chan struct{}) error {
+	collections, err := d.storedCollections()
+	if err != nil {
+		return err
+	}
+	for _, c := range collections {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if isSystemCollection(c) {
+			continue
+		}
+		if _, err := d.RewriteFormats(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
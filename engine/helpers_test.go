@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+// openTest opens a database with opts in a temporary directory of t, closed
+// when t ends
+func openTest(t *testing.T, opts ...Option) *Driver {
+	t.Helper()
+	return openAt(t, t.TempDir(), opts...)
+}
+
+// openAt opens a database with opts in dir, closed when t ends unless the
+// test closed it first to open dir again
+func openAt(t *testing.T, dir string, opts ...Option) *Driver {
+	t.Helper()
+	d, err := New(dir, opts...)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := d.Close(); err != nil && !errors.Is(err, ErrClosed) {
+			t.Errorf("closing database: %v", err)
+		}
+	})
+	return d
+}
@@ -0,0 +1,86 @@
+package engine
+
+// --- HOOKS ---
+
+// OpKind identifies the kind of operation a hook is running for
+type OpKind int
+
+const (
+	OpWrite OpKind = iota
+	OpDelete
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpWrite:
+		return "write"
+	case OpDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Operation describes a Write or Delete passing through the hook chain.
+// Before-write hooks may replace Value to populate or normalize fields.
+type Operation struct {
+	Kind       OpKind
+	Collection string
+	Resource   string
+	Value      interface{}
+}
+
+// Hook is called around a Write or Delete. An error returned from a
+// before-hook rejects the operation; errors from after-hooks are returned
+// to the caller but the operation has already been applied.
+type Hook func(op *Operation) error
+
+type hooks struct {
+	beforeWrite  []Hook
+	afterWrite   []Hook
+	beforeDelete []Hook
+	afterDelete  []Hook
+}
+
+// BeforeWrite registers a hook that runs before a record is persisted
+func (d *Driver) BeforeWrite(h Hook) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.hooks.beforeWrite = append(d.hooks.beforeWrite, h)
+}
+
+// AfterWrite registers a hook that runs once a record has been persisted
+func (d *Driver) AfterWrite(h Hook) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.hooks.afterWrite = append(d.hooks.afterWrite, h)
+}
+
+// BeforeDelete registers a hook that runs before a record is removed
+func (d *Driver) BeforeDelete(h Hook) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.hooks.beforeDelete = append(d.hooks.beforeDelete, h)
+}
+
+// AfterDelete registers a hook that runs once a record has been removed
+func (d *Driver) AfterDelete(h Hook) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.hooks.afterDelete = append(d.hooks.afterDelete, h)
+}
+
+// runHooks calls each hook in registration order, stopping at the first error.
+// Hooks run outside the collection lock so they are free to call back into
+// the Driver (e.g. an audit hook writing to its own collection).
+func (d *Driver) runHooks(list func(*hooks) []Hook, op *Operation) error {
+	d.mutex.Lock()
+	chain := list(&d.hooks)
+	d.mutex.Unlock()
+
+	for _, h := range chain {
+		if err := h(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- ID GENERATION ---
+
+// idGenerator produces UUIDv7 identifiers. The 12 bits after the version
+// nibble hold a counter that is bumped for IDs generated within the same
+// millisecond, so IDs from one process sort in creation order.
+type idGenerator struct {
+	mu     sync.Mutex
+	lastMS int64
+	seq    uint16
+}
+
+var ids idGenerator
+
+// NewID returns a new time-ordered UUIDv7 string
+func NewID() (string, error) {
+	return ids.next(time.Now())
+}
+
+func (g *idGenerator) next(now time.Time) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generating id: %w", err)
+	}
+
+	g.mu.Lock()
+	ms := now.UnixMilli()
+	if ms <= g.lastMS {
+		ms = g.lastMS
+		g.seq++
+		if g.seq > 0x0fff {
+			// counter exhausted for this millisecond, borrow the next one
+			ms++
+			g.seq = 0
+		}
+	} else {
+		g.seq = uint16(b[6])<<8 | uint16(b[7])
+		g.seq &= 0x07ff // leave headroom for the counter to grow
+	}
+	g.lastMS = ms
+	seq := g.seq
+	g.mu.Unlock()
+
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	b[6] = 0x70 | byte(seq>>8)
+	b[7] = byte(seq)
+	b[8] = 0x80 | (b[8] & 0x3f)
+
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], b[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], b[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], b[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], b[10:])
+	return string(out[:]), nil
+}
+
+// Insert writes v into collection under a newly generated ID and returns it
+func (d *Driver) Insert(collection string, v interface{}) (string, error) {
+	id, err := NewID()
+	if err != nil {
+		return "", err
+	}
+	if err := d.Write(collection, id, v); err != nil {
+		return "", err
+	}
+	return id, nil
+}
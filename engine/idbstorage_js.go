@@ -0,0 +1,251 @@
+//go:build js && wasm
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+// --- INDEXEDDB STORAGE ---
+
+// idbStore is the object store holding the files, keyed by name
+const idbStore = "files"
+
+// IndexedDBStorage keeps a Driver's files in the browser's IndexedDB, for
+// builds with GOOS=js GOARCH=wasm running in a page or a worker, so local
+// first apps keep their data between visits. It opens the IndexedDB
+// database name, creating it on first use. Every file is one record of
+// the database, written in a transaction of its own; directories are the
+// name prefixes that files share. Calls wait for IndexedDB to answer, so
+// the Driver must be used from goroutines other than the one running a
+// JavaScript callback.
+func IndexedDBStorage(name string) (Storage, error) {
+	factory := js.Global().Get("indexedDB")
+	if factory.IsUndefined() {
+		return nil, errors.New("indexedDB is not available")
+	}
+	req := factory.Call("open", name, 1)
+	upgrade := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		req.Get("result").Call("createObjectStore", idbStore)
+		return nil
+	})
+	defer upgrade.Release()
+	req.Set("onupgradeneeded", upgrade)
+
+	results, err := idbAwait(req)
+	if err != nil {
+		return nil, fmt.Errorf("opening IndexedDB %s: %w", name, err)
+	}
+	return &idbStorage{db: results[0]}, nil
+}
+
+type idbStorage struct {
+	db js.Value
+}
+
+// idbFile is the record a file is kept in
+type idbFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// store starts a transaction over the files, "readonly" or "readwrite"
+func (s *idbStorage) store(mode string) (tx, store js.Value) {
+	tx = s.db.Call("transaction", idbStore, mode)
+	return tx, tx.Call("objectStore", idbStore)
+}
+
+// below is the key range of the files under the directory name
+func below(name string) js.Value {
+	if name == "" {
+		return js.Null()
+	}
+	return js.Global().Get("IDBKeyRange").Call("bound", name+"/", name+"/\uffff")
+}
+
+func (s *idbStorage) ReadFile(name string) ([]byte, error) {
+	_, store := s.store("readonly")
+	results, err := idbAwait(store.Call("get", name))
+	if err != nil {
+		return nil, err
+	}
+	if results[0].IsUndefined() {
+		return nil, notExist("read", name)
+	}
+	return decodeIDBFile(results[0]).data, nil
+}
+
+// WriteFile waits for its transaction to commit, not only for the put
+func (s *idbStorage) WriteFile(name string, data []byte) error {
+	buf := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(buf, data)
+	rec := js.Global().Get("Object").New()
+	rec.Set("data", buf)
+	rec.Set("modTime", time.Now().UnixMilli())
+
+	tx, store := s.store("readwrite")
+	store.Call("put", rec, name)
+	return idbCommit(tx)
+}
+
+// Remove checks for the record first, as deleting a missing key succeeds
+func (s *idbStorage) Remove(name string) error {
+	_, store := s.store("readonly")
+	results, err := idbAwait(store.Call("count", name))
+	if err != nil {
+		return err
+	}
+	if results[0].Int() == 0 {
+		return notExist("remove", name)
+	}
+	tx, store := s.store("readwrite")
+	store.Call("delete", name)
+	return idbCommit(tx)
+}
+
+func (s *idbStorage) RemoveAll(name string) error {
+	tx, store := s.store("readwrite")
+	if name == "" {
+		store.Call("clear")
+	} else {
+		store.Call("delete", below(name))
+		store.Call("delete", name)
+	}
+	return idbCommit(tx)
+}
+
+func (s *idbStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	_, store := s.store("readonly")
+	results, err := idbAwait(store.Call("getAllKeys", below(name)), store.Call("getAll", below(name)))
+	if err != nil {
+		return nil, err
+	}
+	keys, values := results[0], results[1]
+
+	prefix := ""
+	if name != "" {
+		prefix = name + "/"
+	}
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for i := 0; i < keys.Length(); i++ {
+		rel := strings.TrimPrefix(keys.Index(i).String(), prefix)
+		if dir, _, nested := strings.Cut(rel, "/"); nested {
+			if !seen[dir] {
+				seen[dir] = true
+				entries = append(entries, fileInfo{name: dir, dir: true})
+			}
+			continue
+		}
+		f := decodeIDBFile(values.Index(i))
+		entries = append(entries, fileInfo{name: rel, size: int64(len(f.data)), modTime: f.modTime})
+	}
+	if len(entries) == 0 && name != "" {
+		return nil, notExist("readdir", name)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *idbStorage) Stat(name string) (fs.FileInfo, error) {
+	if name == "" {
+		return fileInfo{name: ".", dir: true}, nil
+	}
+	_, store := s.store("readonly")
+	results, err := idbAwait(store.Call("get", name), store.Call("count", below(name)))
+	if err != nil {
+		return nil, err
+	}
+	v, n := results[0], results[1]
+	if !v.IsUndefined() {
+		f := decodeIDBFile(v)
+		return fileInfo{name: name, size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+	if n.Int() == 0 {
+		return nil, notExist("stat", name)
+	}
+	return fileInfo{name: name, dir: true}, nil
+}
+
+// decodeIDBFile copies a file record out of JavaScript
+func decodeIDBFile(v js.Value) idbFile {
+	buf := v.Get("data")
+	data := make([]byte, buf.Get("length").Int())
+	js.CopyBytesToGo(data, buf)
+	return idbFile{data: data, modTime: time.UnixMilli(int64(v.Get("modTime").Float()))}
+}
+
+// idbAwait waits for IndexedDB requests and returns their results. The
+// requests of one call must all be made before it, so none can finish
+// before its handlers are set.
+func idbAwait(reqs ...js.Value) ([]js.Value, error) {
+	done := make(chan error, len(reqs))
+	for _, req := range reqs {
+		onSuccess := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			settle(done, nil)
+			return nil
+		})
+		defer onSuccess.Release()
+		onError := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			settle(done, idbError(req.Get("error")))
+			return nil
+		})
+		defer onError.Release()
+		req.Set("onsuccess", onSuccess)
+		req.Set("onerror", onError)
+	}
+
+	for range reqs {
+		if err := <-done; err != nil {
+			return nil, err
+		}
+	}
+	results := make([]js.Value, len(reqs))
+	for i, req := range reqs {
+		results[i] = req.Get("result")
+	}
+	return results, nil
+}
+
+// idbCommit waits for a transaction to commit
+func idbCommit(tx js.Value) error {
+	done := make(chan error, 1)
+	onComplete := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		settle(done, nil)
+		return nil
+	})
+	defer onComplete.Release()
+	onAbort := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		settle(done, idbError(tx.Get("error")))
+		return nil
+	})
+	defer onAbort.Release()
+	tx.Set("oncomplete", onComplete)
+	tx.Set("onabort", onAbort)
+	tx.Set("onerror", onAbort)
+	return <-done
+}
+
+// settle reports the first outcome of a request or transaction; a failed
+// transaction fires both onerror and onabort, and the callbacks running
+// them must not block
+func settle(done chan<- error, err error) {
+	select {
+	case done <- err:
+	default:
+	}
+}
+
+// idbError turns a DOMException into an error
+func idbError(v js.Value) error {
+	if v.IsNull() || v.IsUndefined() {
+		return errors.New("IndexedDB request failed")
+	}
+	return fmt.Errorf("IndexedDB: %s: %s", v.Get("name").String(), v.Get("message").String())
+}
@@ -0,0 +1,285 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// --- IMPORT ---
+
+// ErrorPolicy decides what Import does with a record it cannot store
+type ErrorPolicy int
+
+const (
+	// SkipAndReport leaves the record out and lists it in the summary
+	SkipAndReport ErrorPolicy = iota
+	// Abort stops the import at the first failed record
+	Abort
+	// DeadLetter stores the raw record and its error in a separate collection
+	DeadLetter
+)
+
+// opImport marks dead-letter entries produced by Import
+const opImport = "import"
+
+// ImportOptions configures Import
+type ImportOptions struct {
+	// Format of the input, JSONL by default
+	Format Format
+	// CSVStrings keeps every CSV value as a string instead of inferring
+	// numbers and booleans
+	CSVStrings bool
+	// Workers is the number of records processed concurrently.
+	// Defaults to runtime.NumCPU().
+	Workers int
+	// KeyField is the (dot separated) field used as the resource name.
+	// When empty, records carrying an "_id" field (as written by Export)
+	// are stored under it and the rest under generated IDs.
+	KeyField string
+	// OnError is the per-record error policy
+	OnError ErrorPolicy
+	// DeadLetterCollection receives failed records when OnError is DeadLetter.
+	// When empty they go to the engine's _system/deadletter collection, where
+	// RetryDeadLetter can replay them.
+	DeadLetterCollection string
+	// Context is the context of every record written, as WriteContext
+	// gives it to Write: what the Authorizer and the collection's Policy
+	// see
+	Context context.Context
+}
+
+// ImportError describes a record that could not be imported
+type ImportError struct {
+	Line int
+	Raw  []byte
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ImportError) Unwrap() error { return e.Err }
+
+// ImportSummary reports the outcome of an Import
+type ImportSummary struct {
+	Total        int
+	Imported     int
+	Failed       int
+	DeadLettered int
+	Errors       []*ImportError
+}
+
+// deadLetterRecord is what gets stored for a failed record under the
+// DeadLetter policy
+type deadLetterRecord struct {
+	Collection string `json:"collection"`
+	Line       int    `json:"line"`
+	Raw        string `json:"raw"`
+	Error      string `json:"error"`
+}
+
+type importJob struct {
+	line int
+	raw  []byte
+	err  error // set when the input format itself was broken
+}
+
+// Import reads records from r in opts.Format and writes each into
+// collection, spreading the work across opts.Workers goroutines. Malformed or
+// rejected records are handled according to opts.OnError; the returned error
+// is only non-nil when the input can't be read or the import was aborted.
+//
+// Records are authorized and checked against the collection's Policy as
+// Write does, with opts.Context. Archives are restored file by file
+// without passing through hooks or validation, the Authorizer asked once
+// for writing each collection. An empty collection restores every
+// collection in the archive.
+func (d *Driver) Import(collection string, r io.Reader, opts ImportOptions) (*ImportSummary, error) {
+	if err := d.writable(); err != nil {
+		return nil, err
+	}
+	if opts.Format == Archive {
+		return d.importArchive(opts.Context, collection, r)
+	}
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	var source func(r io.Reader, fn recordFunc) error
+	switch opts.Format {
+	case JSONL:
+		source = readLines
+	case CSV:
+		source = func(r io.Reader, fn recordFunc) error {
+			return readCSV(r, !opts.CSVStrings, fn)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported import format %v", opts.Format)
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	var (
+		summary  ImportSummary
+		mu       sync.Mutex
+		aborted  atomic.Bool
+		imported atomic.Int64
+		wg       sync.WaitGroup
+		jobs     = make(chan importJob, opts.Workers*2)
+	)
+
+	fail := func(job importJob, err error) {
+		ie := &ImportError{Line: job.line, Raw: job.raw, Err: err}
+
+		mu.Lock()
+		defer mu.Unlock()
+		summary.Failed++
+		summary.Errors = append(summary.Errors, ie)
+
+		switch opts.OnError {
+		case Abort:
+			aborted.Store(true)
+		case DeadLetter:
+			if opts.DeadLetterCollection == "" {
+				d.recordDeadLetter(DeadLetterEntry{
+					Op:         opImport,
+					Collection: collection,
+					Raw:        string(job.raw),
+					KeyField:   opts.KeyField,
+					Error:      err.Error(),
+				})
+				summary.DeadLettered++
+				return
+			}
+			rec := deadLetterRecord{Collection: collection, Line: job.line, Raw: string(job.raw), Error: err.Error()}
+			key := fmt.Sprintf("%s-%d", collection, job.line)
+			if dlErr := d.Write(opts.DeadLetterCollection, key, rec, WriteContext(opts.Context)); dlErr == nil {
+				summary.DeadLettered++
+			}
+		}
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if aborted.Load() {
+					continue
+				}
+				if job.err != nil {
+					fail(job, fmt.Errorf("malformed record: %w", job.err))
+					continue
+				}
+				if err := d.importRecord(opts.Context, collection, opts.KeyField, job.raw); err != nil {
+					fail(job, err)
+					continue
+				}
+				imported.Add(1)
+			}
+		}()
+	}
+
+	readErr := source(r, func(line int, raw []byte, err error) bool {
+		summary.Total++
+		jobs <- importJob{line: line, raw: raw, err: err}
+		return !aborted.Load()
+	})
+	close(jobs)
+	wg.Wait()
+
+	summary.Imported = int(imported.Load())
+	sort.Slice(summary.Errors, func(i, j int) bool { return summary.Errors[i].Line < summary.Errors[j].Line })
+
+	if readErr != nil {
+		return &summary, readErr
+	}
+	if aborted.Load() && len(summary.Errors) > 0 {
+		return &summary, fmt.Errorf("import aborted: %w", summary.Errors[0])
+	}
+	return &summary, nil
+}
+
+// importRecord decodes a single JSON record and writes it under its key
+// field, authorized with ctx
+func (d *Driver) importRecord(ctx context.Context, collection, keyField string, raw []byte) error {
+	doc, err := decodeDocument(raw)
+	if err != nil {
+		return fmt.Errorf("malformed record: %w", err)
+	}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("record is not an object")
+	}
+
+	if keyField == "" {
+		if id, ok := obj[IDField].(string); ok && id != "" {
+			delete(obj, IDField)
+			if err := validateName("resource", id); err != nil {
+				return err
+			}
+			return d.importWrite(ctx, collection, id, obj)
+		}
+		id, err := NewID()
+		if err != nil {
+			return err
+		}
+		return d.importWrite(ctx, collection, id, obj)
+	}
+
+	key, ok := lookupPath(obj, keyField)
+	if !ok || key == nil {
+		return fmt.Errorf("missing key field %q", keyField)
+	}
+
+	name := fmt.Sprint(key)
+	if err := validateName("resource", name); err != nil {
+		return err
+	}
+	return d.importWrite(ctx, collection, name, obj)
+}
+
+// importWrite writes one imported record as Batch work, admitted as Write
+// admits it
+func (d *Driver) importWrite(ctx context.Context, collection, resource string, obj map[string]interface{}) error {
+	p := writeParams{ctx: ctx, batch: true}
+	if err := d.admit(&p, ActionWrite, collection, resource); err != nil {
+		return err
+	}
+	return d.applyWrite(collection, resource, obj, p)
+}
+
+// recordFunc receives each raw record read from an import source, or the
+// error that made it unreadable. Returning false stops reading.
+type recordFunc func(line int, raw []byte, err error) bool
+
+// readLines calls fn for every non-blank line in r until fn returns false
+func readLines(r io.Reader, fn recordFunc) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(b)) > 0 {
+			if !fn(line, bytes.TrimSpace(b), nil) {
+				return nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
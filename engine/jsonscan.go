@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// --- RAW JSON SCANNING ---
+
+// extractPath returns the raw JSON of the value at a dot separated path in
+// a JSON document, skipping over every other value without decoding it.
+// As with decoding, a key repeated in an object yields its last value.
+// The document must be valid JSON, as stored records are; ok is false when
+// the path is absent or passes through something other than an object.
+func extractPath(data []byte, path string) (raw []byte, ok bool) {
+	raw = data
+	for {
+		part, rest, more := strings.Cut(path, ".")
+		if raw, ok = objectField(raw, part); !ok {
+			return nil, false
+		}
+		if !more {
+			return raw, true
+		}
+		path = rest
+	}
+}
+
+// objectField returns the raw value of key in the JSON object b
+func objectField(b []byte, key string) ([]byte, bool) {
+	i := skipSpace(b, 0)
+	if i >= len(b) || b[i] != '{' {
+		return nil, false
+	}
+	i = skipSpace(b, i+1)
+	if i < len(b) && b[i] == '}' {
+		return nil, false
+	}
+
+	var found []byte
+	for i < len(b) && b[i] == '"' {
+		end, ok := skipString(b, i)
+		if !ok {
+			return nil, false
+		}
+		match := keyEquals(b[i:end], key)
+
+		i = skipSpace(b, end)
+		if i >= len(b) || b[i] != ':' {
+			return nil, false
+		}
+		start := skipSpace(b, i+1)
+		if end, ok = skipValue(b, start); !ok {
+			return nil, false
+		}
+		if match {
+			found = b[start:end]
+		}
+
+		i = skipSpace(b, end)
+		if i < len(b) && b[i] == ',' {
+			i = skipSpace(b, i+1)
+			continue
+		}
+		if i < len(b) && b[i] == '}' {
+			return found, found != nil
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// keyEquals compares a quoted JSON string with key, unescaping it only
+// when it has escapes
+func keyEquals(quoted []byte, key string) bool {
+	inner := quoted[1 : len(quoted)-1]
+	if bytes.IndexByte(inner, '\\') < 0 {
+		return string(inner) == key
+	}
+	var s string
+	return json.Unmarshal(quoted, &s) == nil && s == key
+}
+
+func skipSpace(b []byte, i int) int {
+	for i < len(b) {
+		switch b[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipString returns the offset just past the string starting at b[i]
+func skipString(b []byte, i int) (int, bool) {
+	i++
+	for {
+		j := bytes.IndexAny(b[i:], `"\`)
+		if j < 0 {
+			return 0, false
+		}
+		i += j
+		if b[i] == '"' {
+			return i + 1, true
+		}
+		i += 2 // the backslash and the character it escapes
+		if i > len(b) {
+			return 0, false
+		}
+	}
+}
+
+// skipValue returns the offset just past the value starting at b[i]
+func skipValue(b []byte, i int) (int, bool) {
+	if i >= len(b) {
+		return 0, false
+	}
+	switch b[i] {
+	case '"':
+		return skipString(b, i)
+	case '{', '[':
+		depth := 0
+		for i < len(b) {
+			switch b[i] {
+			case '"':
+				end, ok := skipString(b, i)
+				if !ok {
+					return 0, false
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1, true
+				}
+			}
+			i++
+		}
+		return 0, false
+	}
+	// numbers, true, false and null run to the next delimiter
+	start := i
+	for i < len(b) {
+		switch b[i] {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			return i, i > start
+		}
+		i++
+	}
+	return i, i > start
+}
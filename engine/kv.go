@@ -0,0 +1,680 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- KEY-VALUE STORES ---
+
+// kvDir holds the key-value stores, one directory each
+const kvDir = systemPrefix + "kv"
+
+const (
+	kvSegmentExt  = ".seg"
+	kvHeaderSize  = 12         // crc, key length, value length
+	kvTombstone   = 0xffffffff // value length of a delete
+	kvSegmentSize = 64 << 20   // size past which a new segment is started
+	kvMaxKeyLen   = 1 << 16
+	kvSpilled     = 1 << 31 // value length flag of an entry pointing at a spill file
+	kvSpillDir    = "spill"
+	kvInlineLimit = 16 << 10 // default largest value kept inline
+)
+
+// KV is a key-value store for many small values, which as records would
+// each take a file and a file system block. Entries are appended to
+// shared segment files, and a hash index in memory, rebuilt from the
+// segments when the store is opened, finds the latest value of each key.
+// Values larger than an inline limit spill into files of their own,
+// which the segment entries point at, so the odd big value doesn't slow
+// opening and compacting the store. Deleted and overwritten values take
+// space until Compact, but their spill files are removed at once. A KV
+// lives in
+// the database directory and is backed up with it, but it has no history,
+// hooks, watchers or indexes, and needs the local disk. Restoring a backup
+// closes the open stores, to be opened again with Driver.KV.
+type KV struct {
+	d      *Driver
+	name   string
+	dir    string
+	inline int // largest value kept in the segments
+
+	mu       sync.RWMutex
+	index    map[string]kvEntry
+	segments map[int]*os.File // open for reading, by id
+	active   int              // the segment appended to
+	w        *os.File
+	size     int64 // of the active segment
+	live     int64 // bytes of the entries the index points at, spill files included
+	total    int64 // bytes of every segment and spill file
+	closed   bool
+}
+
+// kvEntry locates the value of a key
+type kvEntry struct {
+	segment int
+	offset  int64 // of the value, or of the spill pointer
+	size    int64 // of the value
+	// spill names the file holding the value when it didn't fit inline;
+	// the entry then holds the value's length and that name
+	spill string
+}
+
+// stored is the length of the entry's value field in its segment
+func (e kvEntry) stored() uint32 {
+	if e.spill != "" {
+		return uint32(8 + len(e.spill))
+	}
+	return uint32(e.size)
+}
+
+// bytes is what the entry of key takes on disk
+func (e kvEntry) bytes(key string) int64 {
+	n := kvHeaderSize + int64(len(key)) + int64(e.stored())
+	if e.spill != "" {
+		n += e.size
+	}
+	return n
+}
+
+// KVOption configures a KV when it is first opened
+type KVOption func(*KV)
+
+// KVInlineLimit sets the largest value, in bytes, kept inline in the
+// segments, 16KB by default; larger ones spill into files of their own
+func KVInlineLimit(n int) KVOption {
+	return func(kv *KV) { kv.inline = min(max(n, 0), kvSegmentSize/2) }
+}
+
+// KVStats is the size of a KV
+type KVStats struct {
+	Keys int `json:"keys"`
+	// LiveBytes is the entries of the current values, DiskBytes every
+	// segment; the difference is what Compact reclaims
+	LiveBytes int64 `json:"liveBytes"`
+	DiskBytes int64 `json:"diskBytes"`
+	Segments  int   `json:"segments"`
+	// Spilled counts the values kept in files of their own
+	Spilled int `json:"spilled"`
+}
+
+// linkable reports whether the files of collection may be hard linked
+// into a backup, which the segments of key-value stores, appended to in
+// place, may not
+func linkable(collection string) bool {
+	return collection != kvDir
+}
+
+// KV opens the key-value store called name, creating it on first use. The
+// Driver keeps it open until Close, so later calls return the same KV and
+// ignore opts.
+func (d *Driver) KV(name string, opts ...KVOption) (*KV, error) {
+	if err := validateName("key-value store", name); err != nil {
+		return nil, err
+	}
+	if !isLocal(d.fs) {
+		return nil, errors.New("key-value stores need the local disk")
+	}
+	if err := d.life.enter(); err != nil {
+		return nil, err
+	}
+	defer d.life.leave()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if kv := d.kvs[name]; kv != nil {
+		return kv, nil
+	}
+	kv := &KV{
+		d:        d,
+		name:     name,
+		dir:      filepath.Join(d.dir, kvDir, name),
+		inline:   kvInlineLimit,
+		index:    make(map[string]kvEntry),
+		segments: make(map[int]*os.File),
+	}
+	for _, opt := range opts {
+		opt(kv)
+	}
+	if err := kv.open(); err != nil {
+		kv.close()
+		return nil, fmt.Errorf("key-value store %s: %w", name, err)
+	}
+	if d.kvs == nil {
+		d.kvs = make(map[string]*KV)
+		d.closers = append(d.closers, d.closeKVs)
+	}
+	d.kvs[name] = kv
+	return kv, nil
+}
+
+// closeKVs closes the key-value stores when the Driver closes
+func (d *Driver) closeKVs() error {
+	d.mutex.Lock()
+	kvs := d.kvs
+	d.kvs = nil
+	d.mutex.Unlock()
+
+	var errs []error
+	for _, kv := range kvs {
+		errs = append(errs, kv.close())
+	}
+	return errors.Join(errs...)
+}
+
+// open replays the segments in order into the index and opens the last
+// for appending, cutting off a torn entry a crash left at its end
+func (kv *KV) open() error {
+	if !kv.d.opts.readOnly {
+		if err := os.MkdirAll(kv.dir, 0755); err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(kv.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var ids []int
+	for _, e := range entries {
+		id, err := strconv.Atoi(strings.TrimSuffix(e.Name(), kvSegmentExt))
+		if e.IsDir() || !strings.HasSuffix(e.Name(), kvSegmentExt) || err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		f, err := os.Open(kv.segmentPath(id))
+		if err != nil {
+			return err
+		}
+		kv.segments[id] = f
+		end, err := kv.replay(id, f)
+		if err != nil {
+			return err
+		}
+		kv.total += end
+		if i == len(ids)-1 {
+			kv.active, kv.size = id, end
+		}
+	}
+	spills, err := os.ReadDir(filepath.Join(kv.dir, kvSpillDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, e := range spills {
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			kv.total += info.Size()
+		}
+	}
+	if kv.d.opts.readOnly {
+		return nil
+	}
+	if len(ids) == 0 {
+		kv.active = 1
+	}
+	return kv.openActive()
+}
+
+// replay indexes the entries of a segment and returns where the last whole
+// one ends
+func (kv *KV) replay(id int, f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+	var offset int64
+	header := make([]byte, kvHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil
+		}
+		sum := binary.LittleEndian.Uint32(header)
+		keyLen := binary.LittleEndian.Uint32(header[4:])
+		valLen := binary.LittleEndian.Uint32(header[8:])
+		spilled := valLen != kvTombstone && valLen&kvSpilled != 0
+		bodyLen := int64(keyLen)
+		if valLen != kvTombstone {
+			bodyLen += int64(valLen &^ kvSpilled)
+		}
+		if keyLen > kvMaxKeyLen || bodyLen > kvSegmentSize {
+			return offset, nil
+		}
+		body := make([]byte, bodyLen)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return offset, nil
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != sum {
+			return offset, nil
+		}
+		key := string(body[:keyLen])
+		if old, ok := kv.index[key]; ok {
+			kv.live -= old.bytes(key)
+		}
+		e := kvEntry{segment: id, offset: offset + kvHeaderSize + int64(keyLen), size: int64(valLen)}
+		switch {
+		case valLen == kvTombstone:
+			delete(kv.index, key)
+		case spilled:
+			ptr := body[keyLen:]
+			if len(ptr) <= 8 {
+				return offset, nil
+			}
+			e.size, e.spill = int64(binary.LittleEndian.Uint64(ptr)), string(ptr[8:])
+			fallthrough
+		default:
+			kv.index[key] = e
+			kv.live += e.bytes(key)
+		}
+		offset += kvHeaderSize + bodyLen
+	}
+}
+
+func (kv *KV) segmentPath(id int) string {
+	return filepath.Join(kv.dir, strconv.Itoa(id)+kvSegmentExt)
+}
+
+func (kv *KV) spillPath(name string) string {
+	return filepath.Join(kv.dir, kvSpillDir, name)
+}
+
+// openActive opens the active segment for appending, at kv.size
+func (kv *KV) openActive() error {
+	w, err := os.OpenFile(kv.segmentPath(kv.active), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := w.Truncate(kv.size); err != nil {
+		w.Close()
+		return err
+	}
+	if _, err := w.Seek(kv.size, io.SeekStart); err != nil {
+		w.Close()
+		return err
+	}
+	if kv.segments[kv.active] == nil {
+		r, err := os.Open(kv.segmentPath(kv.active))
+		if err != nil {
+			w.Close()
+			return err
+		}
+		kv.segments[kv.active] = r
+	}
+	kv.w = w
+	return nil
+}
+
+func (kv *KV) close() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.closed = true
+	kv.index = make(map[string]kvEntry)
+	var errs []error
+	if kv.w != nil {
+		errs = append(errs, kv.w.Close())
+		kv.w = nil
+	}
+	for id, f := range kv.segments {
+		errs = append(errs, f.Close())
+		delete(kv.segments, id)
+	}
+	return errors.Join(errs...)
+}
+
+// Get returns the value of key, failing with an error wrapping
+// fs.ErrNotExist if it has none
+func (kv *KV) Get(key string) ([]byte, error) {
+	release, err := kv.d.acquire(kvDir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	if kv.closed {
+		return nil, ErrClosed
+	}
+	e, ok := kv.index[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: key %q: %w", kv.name, key, fs.ErrNotExist)
+	}
+	if e.spill != "" {
+		val, err := os.ReadFile(kv.spillPath(e.spill))
+		if err == nil && int64(len(val)) != e.size {
+			err = fmt.Errorf("%s: key %q: spill file %s holds %d bytes, not %d", kv.name, key, e.spill, len(val), e.size)
+		}
+		return val, err
+	}
+	val := make([]byte, e.size)
+	if _, err := kv.segments[e.segment].ReadAt(val, e.offset); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// Has reports whether key has a value, without reading it
+func (kv *KV) Has(key string) bool {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	_, ok := kv.index[key]
+	return ok
+}
+
+// Put sets the value of key
+func (kv *KV) Put(key string, value []byte) error {
+	return kv.append(key, value, false)
+}
+
+// Delete removes key. Deleting a key without a value succeeds.
+func (kv *KV) Delete(key string) error {
+	kv.mu.RLock()
+	_, ok := kv.index[key]
+	kv.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return kv.append(key, nil, true)
+}
+
+// append writes an entry at the end of the active segment and indexes it
+func (kv *KV) append(key string, value []byte, tombstone bool) error {
+	if key == "" || len(key) > kvMaxKeyLen {
+		return fmt.Errorf("%w: key must be 1 to %d bytes", ErrInvalidName, kvMaxKeyLen)
+	}
+	if err := kv.d.writable(); err != nil {
+		return err
+	}
+	// shared: appends only race a backup's copy to a torn last entry,
+	// which opening the copy cuts off. Replacing a spilled value removes
+	// its file, which a copy must not find gone while the entry pointing
+	// at it is still there.
+	kv.mu.RLock()
+	prev := kv.index[key]
+	kv.mu.RUnlock()
+	exclusive := prev.spill != ""
+	release, err := kv.d.acquire(kvDir, exclusive)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	e := kvEntry{size: int64(len(value))}
+	if !tombstone && len(value) > kv.inline {
+		// written before the entry pointing at it, so a crash leaves at
+		// worst a file nothing points at, which Compact removes
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		e.spill = hex.EncodeToString(id)
+		if err := kv.d.fs.WriteFile(kv.spillPath(e.spill), value, kv.d.opts.durability); err != nil {
+			return err
+		}
+		value = binary.LittleEndian.AppendUint64(nil, uint64(e.size))
+		value = append(value, e.spill...)
+	}
+	buf := kvEncode(key, value, e, tombstone)
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return ErrClosed
+	}
+	if kv.size > 0 && kv.size+int64(len(buf)) > kvSegmentSize {
+		if err := kv.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := kv.w.Write(buf); err != nil {
+		// leave no partial entry for the next append to follow
+		kv.w.Truncate(kv.size)
+		kv.w.Seek(kv.size, io.SeekStart)
+		return err
+	}
+	old, replaced := kv.index[key]
+	orphan := exclusive && replaced && old.spill != ""
+	// the entry replacing a spilled value has to be on disk before its
+	// file is removed
+	if kv.d.opts.durability >= FsyncOnWrite || orphan {
+		if err := kv.w.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if replaced {
+		kv.live -= old.bytes(key)
+	}
+	if tombstone {
+		delete(kv.index, key)
+	} else {
+		e.segment, e.offset = kv.active, kv.size+kvHeaderSize+int64(len(key))
+		kv.index[key] = e
+		kv.live += e.bytes(key)
+	}
+	kv.size += int64(len(buf))
+	kv.total += int64(len(buf))
+	if e.spill != "" {
+		kv.total += e.size
+	}
+	// a file left behind is removed by Compact
+	if orphan && os.Remove(kv.spillPath(old.spill)) == nil {
+		kv.total -= old.size
+	}
+	return nil
+}
+
+// kvEncode lays out the entry of key, whose value field is stored
+func kvEncode(key string, stored []byte, e kvEntry, tombstone bool) []byte {
+	valLen := uint32(len(stored))
+	switch {
+	case tombstone:
+		valLen = kvTombstone
+	case e.spill != "":
+		valLen |= kvSpilled
+	}
+	buf := make([]byte, kvHeaderSize, kvHeaderSize+len(key)+len(stored))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[8:], valLen)
+	buf = append(buf, key...)
+	buf = append(buf, stored...)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// rotate starts a new active segment. Callers must hold kv.mu.
+func (kv *KV) rotate() error {
+	if err := kv.w.Close(); err != nil {
+		return err
+	}
+	kv.w = nil
+	kv.active++
+	kv.size = 0
+	if err := kv.openActive(); err != nil {
+		return err
+	}
+	if kv.d.opts.durability >= FsyncDir {
+		return syncDir(kv.dir)
+	}
+	return nil
+}
+
+// Keys lists the keys with values in order
+func (kv *KV) Keys() []string {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	keys := make([]string, 0, len(kv.index))
+	for k := range kv.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len is the number of keys with values
+func (kv *KV) Len() int {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return len(kv.index)
+}
+
+// Stats measures the store
+func (kv *KV) Stats() KVStats {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	s := KVStats{Keys: len(kv.index), LiveBytes: kv.live, DiskBytes: kv.total, Segments: len(kv.segments)}
+	for _, e := range kv.index {
+		if e.spill != "" {
+			s.Spilled++
+		}
+	}
+	return s
+}
+
+// Sync flushes the appended entries to disk, for stores written with a
+// Durability below FsyncOnWrite
+func (kv *KV) Sync() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return ErrClosed
+	}
+	return kv.w.Sync()
+}
+
+// Compact writes the current values into a new segment and removes the
+// older ones, and the spill files of values no longer current, reclaiming
+// the space of deleted and overwritten values. Spilled values stay where
+// they are.
+// Reads and writes wait for it, as does a backup. A crash part way leaves
+// both the old and the new segments, which read the same.
+func (kv *KV) Compact() error {
+	if err := kv.d.writable(); err != nil {
+		return err
+	}
+	release, err := kv.d.acquire(kvDir, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return ErrClosed
+	}
+	old := make([]int, 0, len(kv.segments))
+	for id := range kv.segments {
+		old = append(old, id)
+	}
+	keys := make([]string, 0, len(kv.index))
+	for k := range kv.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if err := kv.rotate(); err != nil {
+		return err
+	}
+	moved := make(map[string]kvEntry, len(keys))
+	w := bufio.NewWriter(kv.w)
+	start := kv.active
+	for _, key := range keys {
+		e := kv.index[key]
+		stored := make([]byte, e.stored())
+		kv.d.io.enter(Batch)
+		_, err := kv.segments[e.segment].ReadAt(stored, e.offset)
+		kv.d.io.leave(Batch)
+		if err != nil {
+			return err
+		}
+		buf := kvEncode(key, stored, e, false)
+		if kv.size > 0 && kv.size+int64(len(buf)) > kvSegmentSize {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if err := kv.rotate(); err != nil {
+				return err
+			}
+			w.Reset(kv.w)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		e.segment, e.offset = kv.active, kv.size+kvHeaderSize+int64(len(key))
+		moved[key] = e
+		kv.size += int64(len(buf))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := kv.w.Sync(); err != nil {
+		return err
+	}
+
+	kv.index = moved
+	kv.live, kv.total = 0, 0
+	for id := start; id <= kv.active; id++ {
+		if info, err := kv.segments[id].Stat(); err == nil {
+			kv.total += info.Size()
+		}
+	}
+	for _, e := range moved {
+		if e.spill != "" {
+			kv.total += e.size
+		}
+	}
+	kv.live = kv.total
+	for _, id := range old {
+		kv.segments[id].Close()
+		delete(kv.segments, id)
+		if err := os.Remove(kv.segmentPath(id)); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(kv.dir); err != nil {
+		return err
+	}
+	return kv.removeSpills(moved)
+}
+
+// removeSpills removes the spill files no entry of index points at
+func (kv *KV) removeSpills(index map[string]kvEntry) error {
+	dir := filepath.Join(kv.dir, kvSpillDir)
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool)
+	for _, e := range index {
+		if e.spill != "" {
+			current[e.spill] = true
+		}
+	}
+	for _, f := range files {
+		if f.IsDir() || current[f.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// openKV opens the key-value store kv of a database in dir
+func openKV(t *testing.T, dir string, opts ...KVOption) (*Driver, *KV) {
+	t.Helper()
+	d := openAt(t, dir)
+	kv, err := d.KV("kv", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, kv
+}
+
+// wantValue fails t unless key holds value in kv
+func wantValue(t *testing.T, kv *KV, key, value string) {
+	t.Helper()
+	got, err := kv.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if string(got) != value {
+		t.Errorf("Get(%q) = %q, want %q", key, got, value)
+	}
+}
+
+func TestKVPutGetDelete(t *testing.T) {
+	_, kv := openKV(t, t.TempDir())
+	if err := kv.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("a", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	wantValue(t, kv, "a", "one")
+	wantValue(t, kv, "b", "2")
+
+	if err := kv.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get("b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get of a deleted key = %v, want fs.ErrNotExist", err)
+	}
+	if kv.Has("b") {
+		t.Error("Has of a deleted key")
+	}
+	if err := kv.Delete("never"); err != nil {
+		t.Errorf("Delete of a key without a value = %v", err)
+	}
+	if got := kv.Keys(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("Keys = %v, want [a]", got)
+	}
+	if err := kv.Put("", []byte("x")); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Put of an empty key = %v, want ErrInvalidName", err)
+	}
+}
+
+func TestKVReopen(t *testing.T) {
+	dir := t.TempDir()
+	d, kv := openKV(t, dir)
+	for i := 0; i < 100; i++ {
+		if err := kv.Put(fmt.Sprintf("k%03d", i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		if err := kv.Delete(fmt.Sprintf("k%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Put("k001", []byte("updated")); err != nil {
+		t.Fatal(err)
+	}
+	before := kv.Stats()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, kv = openKV(t, dir)
+	if kv.Len() != 50 {
+		t.Fatalf("reopened store has %d keys, want 50", kv.Len())
+	}
+	wantValue(t, kv, "k001", "updated")
+	wantValue(t, kv, "k099", "99")
+	if kv.Has("k000") {
+		t.Error("a deleted key came back")
+	}
+	if after := kv.Stats(); after != before {
+		t.Errorf("reopened store measures %+v, want %+v", after, before)
+	}
+}
+
+func TestKVCompact(t *testing.T) {
+	dir := t.TempDir()
+	d, kv := openKV(t, dir)
+	value := bytes.Repeat([]byte("v"), 100)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			if err := kv.Put(fmt.Sprintf("k%02d", i), append(value, byte('0'+round))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 10; i < 20; i++ {
+		if err := kv.Delete(fmt.Sprintf("k%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := kv.Stats()
+	if before.DiskBytes <= before.LiveBytes {
+		t.Fatalf("before Compact %+v, want dead bytes", before)
+	}
+
+	if err := kv.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after := kv.Stats()
+	if after.DiskBytes != after.LiveBytes || after.LiveBytes != before.LiveBytes {
+		t.Errorf("after Compact %+v, want disk bytes equal to the %d live ones", after, before.LiveBytes)
+	}
+	if after.Segments != 1 {
+		t.Errorf("after Compact %d segments, want 1", after.Segments)
+	}
+	want := string(append(value, '4'))
+	wantValue(t, kv, "k00", want)
+	if kv.Len() != 10 {
+		t.Errorf("after Compact %d keys, want 10", kv.Len())
+	}
+
+	// a write after compacting lands in the compacted segment, and both
+	// survive a reopen
+	if err := kv.Put("k00", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, kv = openKV(t, dir)
+	wantValue(t, kv, "k00", "new")
+	wantValue(t, kv, "k09", want)
+	if kv.Has("k10") || kv.Len() != 10 {
+		t.Errorf("reopened compacted store has keys %v", kv.Keys())
+	}
+}
+
+// spillFiles lists the spill files of the store kv in dir
+func spillFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, kvDir, "kv", kvSpillDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestKVSpillThreshold(t *testing.T) {
+	dir := t.TempDir()
+	_, kv := openKV(t, dir, KVInlineLimit(64))
+	inline, spilled := bytes.Repeat([]byte("i"), 64), bytes.Repeat([]byte("s"), 65)
+	if err := kv.Put("inline", inline); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spillFiles(t, dir)); n != 0 || kv.Stats().Spilled != 0 {
+		t.Fatalf("a value at the limit spilled: %d files, %+v", n, kv.Stats())
+	}
+	if err := kv.Put("spilled", spilled); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spillFiles(t, dir)); n != 1 || kv.Stats().Spilled != 1 {
+		t.Fatalf("a value past the limit: %d spill files, %+v, want 1", n, kv.Stats())
+	}
+	wantValue(t, kv, "inline", string(inline))
+	wantValue(t, kv, "spilled", string(spilled))
+}
+
+func TestKVSpillReopen(t *testing.T) {
+	dir := t.TempDir()
+	d, kv := openKV(t, dir, KVInlineLimit(16))
+	big := bytes.Repeat([]byte("b"), 1000)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := kv.Put(key, append([]byte(key), big...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Put("small", []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+	before := kv.Stats()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, kv = openKV(t, dir, KVInlineLimit(16))
+	if after := kv.Stats(); after != before {
+		t.Errorf("reopened store measures %+v, want %+v", after, before)
+	}
+	wantValue(t, kv, "b", "b"+string(big))
+	wantValue(t, kv, "small", "s")
+}
+
+func TestKVSpillDelete(t *testing.T) {
+	dir := t.TempDir()
+	d, kv := openKV(t, dir, KVInlineLimit(16))
+	big := bytes.Repeat([]byte("b"), 1000)
+	for _, key := range []string{"deleted", "overwritten", "kept"} {
+		if err := kv.Put(key, big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := kv.Stats().DiskBytes
+
+	if err := kv.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("overwritten", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spillFiles(t, dir)); n != 1 {
+		t.Errorf("%d spill files after deleting and overwriting spilled values, want the one of kept", n)
+	}
+	if after := kv.Stats().DiskBytes; after >= before {
+		t.Errorf("disk bytes went from %d to %d, want the removed files taken off", before, after)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, kv = openKV(t, dir, KVInlineLimit(16))
+	if kv.Has("deleted") {
+		t.Error("the deleted spilled value came back")
+	}
+	wantValue(t, kv, "overwritten", "small")
+	wantValue(t, kv, "kept", string(big))
+}
+
+func TestKVCompactLeavesSpills(t *testing.T) {
+	dir := t.TempDir()
+	_, kv := openKV(t, dir, KVInlineLimit(16))
+	big := bytes.Repeat([]byte("b"), 1000)
+	if err := kv.Put("big", big); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := kv.Put("small", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := spillFiles(t, dir)
+	path := filepath.Join(dir, kvDir, "kv", kvSpillDir, files[0])
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := spillFiles(t, dir); !reflect.DeepEqual(got, files) {
+		t.Errorf("spill files went from %v to %v", files, got)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(info, after) || !after.ModTime().Equal(info.ModTime()) {
+		t.Error("Compact rewrote the spill file")
+	}
+	wantValue(t, kv, "big", string(big))
+	wantValue(t, kv, "small", "9")
+	if s := kv.Stats(); s.Spilled != 1 || s.DiskBytes != s.LiveBytes {
+		t.Errorf("after Compact %+v", s)
+	}
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+)
+
+// --- LIFECYCLE ---
+
+// ErrClosed is returned by every operation on a Driver after Close
+var ErrClosed = errors.New("database is closed")
+
+// lifecycle counts the operations in progress so Close can wait for them
+type lifecycle struct {
+	mu     sync.Mutex
+	idle   *sync.Cond
+	active int
+	closed bool
+}
+
+// enter registers an operation, failing once the Driver is closed
+func (l *lifecycle) enter() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.active++
+	return nil
+}
+
+func (l *lifecycle) leave() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if l.active == 0 && l.idle != nil {
+		l.idle.Broadcast()
+	}
+}
+
+// close refuses new operations and waits for the running ones to finish
+func (l *lifecycle) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.closed = true
+	if l.idle == nil {
+		l.idle = sync.NewCond(&l.mu)
+	}
+	for l.active > 0 {
+		l.idle.Wait()
+	}
+	return nil
+}
+
+// onClose registers fn to run when the Driver is closed, after the last
+// operation has finished. Closers run in reverse order of registration and
+// must not call back into the Driver's public API, which by then returns
+// ErrClosed.
+func (d *Driver) onClose(fn func() error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.closers = append(d.closers, fn)
+}
+
+// Close waits for operations in progress, stops the Driver's background
+// work and makes every later call fail with ErrClosed. Closing twice
+// returns ErrClosed.
+func (d *Driver) Close() error {
+	if err := d.life.close(); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	closers := d.closers
+	d.closers = nil
+	d.mutex.Unlock()
+
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		errs = append(errs, closers[i]())
+	}
+	d.opts.logger.Info("closed", "dir", d.dir)
+	return errors.Join(errs...)
+}
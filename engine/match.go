@@ -0,0 +1,309 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// --- QUERY DOCUMENTS ---
+
+// ParseFilter builds a Filter from a query document in the style of
+// MongoDB, given as JSON ([]byte or string) or as a value encoding to a
+// JSON object, such as
+//
+//	{"age": {"$gt": 25}, "address.city": "Mumbai"}
+//
+// Keys are dot separated field paths, all of which must match. A plain
+// value matches a field equal to it; an object of operators applies each
+// of them:
+//
+//	$eq, $ne              equal, not equal
+//	$gt, $gte, $lt, $lte  ordered comparison of numbers or of strings
+//	$in, $nin             equal to one of, or none of, an array of values
+//	$regex                a string matching a regular expression, with
+//	                      $options "i" to ignore case
+//	$exists               true if the field must be present, false if not
+//
+// A field holding an array matches when any element does, or when the
+// whole array equals the value. The top level also takes $and and $or,
+// each an array of query documents.
+func ParseFilter(query interface{}) (Filter, error) {
+	var (
+		doc interface{}
+		err error
+	)
+	switch q := query.(type) {
+	case []byte:
+		doc, err = decodeDocument(q)
+	case string:
+		doc, err = decodeDocument([]byte(q))
+	default:
+		doc, err = toDocument(q)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("query must be an object, got %s", jsonType(doc))
+	}
+	return compileQuery(obj)
+}
+
+// compileQuery builds the filter of one query document
+func compileQuery(query map[string]interface{}) (Filter, error) {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	filters := make([]Filter, 0, len(keys))
+	for _, key := range keys {
+		f, err := compileClause(key, query[key])
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return And(filters...), nil
+}
+
+func compileClause(key string, value interface{}) (Filter, error) {
+	switch key {
+	case "$and", "$or":
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("query: %s takes a non-empty array of queries", key)
+		}
+		subs := make([]Filter, len(list))
+		for i, item := range list {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("query: %s[%d] is not an object", key, i)
+			}
+			var err error
+			if subs[i], err = compileQuery(obj); err != nil {
+				return nil, err
+			}
+		}
+		if key == "$and" {
+			return And(subs...), nil
+		}
+		return FilterFunc(func(r *Record) bool {
+			for _, f := range subs {
+				if f.Match(r) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+	if strings.HasPrefix(key, "$") {
+		return nil, fmt.Errorf("query: unknown operator %s", key)
+	}
+
+	ops, ok := value.(map[string]interface{})
+	if !ok || !isOperatorObject(ops) {
+		return &fieldCondition{
+			path: key,
+			test: func(v interface{}, present bool) bool {
+				return present && matchAny(v, func(e interface{}) bool { return jsonEqual(e, value) })
+			},
+			values: lookupValues([]interface{}{value}),
+		}, nil
+	}
+
+	var tests []func(v interface{}, present bool) bool
+	for op, arg := range ops {
+		test, err := compileOperator(op, arg, ops)
+		if err != nil {
+			return nil, fmt.Errorf("query: %s: %w", key, err)
+		}
+		if test != nil {
+			tests = append(tests, test)
+		}
+	}
+	// $eq or $in leave the planner values to look up
+	var values []interface{}
+	if eq, ok := ops["$eq"]; ok {
+		values = lookupValues([]interface{}{eq})
+	} else if in, ok := ops["$in"].([]interface{}); ok {
+		values = lookupValues(in)
+	}
+	return &fieldCondition{
+		path: key,
+		test: func(v interface{}, present bool) bool {
+			for _, test := range tests {
+				if !test(v, present) {
+					return false
+				}
+			}
+			return true
+		},
+		values: values,
+	}, nil
+}
+
+// isOperatorObject reports whether an object in a query is a set of
+// operators rather than a value to compare against
+func isOperatorObject(obj map[string]interface{}) bool {
+	if len(obj) == 0 {
+		return false
+	}
+	for k := range obj {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// compileOperator returns the test of one operator on a field, or nil for
+// an operator that only modifies another
+func compileOperator(op string, arg interface{}, ops map[string]interface{}) (func(v interface{}, present bool) bool, error) {
+	switch op {
+	case "$eq":
+		return func(v interface{}, present bool) bool {
+			return present && matchAny(v, func(e interface{}) bool { return jsonEqual(e, arg) })
+		}, nil
+
+	case "$ne":
+		return func(v interface{}, present bool) bool {
+			return !present || !matchAny(v, func(e interface{}) bool { return jsonEqual(e, arg) })
+		}, nil
+
+	case "$gt", "$gte", "$lt", "$lte":
+		if _, ok := compareJSON(arg, arg); !ok {
+			return nil, fmt.Errorf("%s takes a number or a string", op)
+		}
+		accept := map[string]func(int) bool{
+			"$gt":  func(c int) bool { return c > 0 },
+			"$gte": func(c int) bool { return c >= 0 },
+			"$lt":  func(c int) bool { return c < 0 },
+			"$lte": func(c int) bool { return c <= 0 },
+		}[op]
+		return func(v interface{}, present bool) bool {
+			return present && matchAny(v, func(e interface{}) bool {
+				c, ok := compareJSON(e, arg)
+				return ok && accept(c)
+			})
+		}, nil
+
+	case "$in", "$nin":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s takes an array", op)
+		}
+		in := func(v interface{}, present bool) bool {
+			return present && matchAny(v, func(e interface{}) bool {
+				for _, want := range list {
+					if jsonEqual(e, want) {
+						return true
+					}
+				}
+				return false
+			})
+		}
+		if op == "$in" {
+			return in, nil
+		}
+		return func(v interface{}, present bool) bool { return !in(v, present) }, nil
+
+	case "$regex":
+		expr, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("$regex takes a string")
+		}
+		if opts, ok := ops["$options"].(string); ok && opts != "" {
+			if strings.Trim(opts, "imsU") != "" {
+				return nil, fmt.Errorf("unsupported $options %q", opts)
+			}
+			expr = "(?" + opts + ")" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, present bool) bool {
+			return present && matchAny(v, func(e interface{}) bool {
+				s, ok := e.(string)
+				return ok && re.MatchString(s)
+			})
+		}, nil
+
+	case "$options":
+		if _, ok := ops["$regex"]; !ok {
+			return nil, fmt.Errorf("$options without $regex")
+		}
+		return nil, nil
+
+	case "$exists":
+		want, ok := arg.(bool)
+		if !ok {
+			return nil, fmt.Errorf("$exists takes true or false")
+		}
+		return func(v interface{}, present bool) bool { return present == want }, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// lookupValues returns values when an index can look every one of them
+// up, which only holds for strings, numbers and booleans, and nil
+// otherwise
+func lookupValues(values []interface{}) []interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	for _, v := range values {
+		switch v.(type) {
+		case string, json.Number, bool:
+		default:
+			return nil
+		}
+	}
+	return values
+}
+
+// matchAny applies test to v and, when v is an array, to its elements
+func matchAny(v interface{}, test func(interface{}) bool) bool {
+	if test(v) {
+		return true
+	}
+	if list, ok := v.([]interface{}); ok {
+		for _, e := range list {
+			if test(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compareJSON orders two numbers or two strings; other pairs don't compare
+func compareJSON(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case json.Number:
+		bn, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		af, ok1 := new(big.Float).SetString(a.String())
+		bf, ok2 := new(big.Float).SetString(bn.String())
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		return af.Cmp(bf), true
+	case string:
+		bs, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, bs), true
+	}
+	return 0, false
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// matchDocs are the documents ParseFilter queries are tried against
+var matchDocs = map[string]string{
+	"alice": `{"name": "Alice", "age": 31, "tags": ["admin", "dev"], "address": {"city": "Mumbai", "zip": "400001"}}`,
+	"bob":   `{"name": "bob", "age": 25, "tags": ["dev"], "address": {"city": "Pune"}}`,
+	"carol": `{"name": "Carol", "age": 40, "tags": [], "manager": null}`,
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"equality", `{"name": "Alice"}`, []string{"alice"}},
+		{"every key", `{"name": "Alice", "age": 25}`, nil},
+		{"nested path", `{"address.city": "Pune"}`, []string{"bob"}},
+		{"array element", `{"tags": "admin"}`, []string{"alice"}},
+		{"whole array", `{"tags": ["dev"]}`, []string{"bob"}},
+		{"$eq", `{"age": {"$eq": 40}}`, []string{"carol"}},
+		{"$ne", `{"address.city": {"$ne": "Pune"}}`, []string{"alice", "carol"}},
+		{"$gt", `{"age": {"$gt": 25}}`, []string{"alice", "carol"}},
+		{"$gte and $lt", `{"age": {"$gte": 25, "$lt": 40}}`, []string{"alice", "bob"}},
+		{"$lte on strings", `{"name": {"$lte": "Bz"}}`, []string{"alice"}},
+		{"$in", `{"age": {"$in": [25, 40, 99]}}`, []string{"bob", "carol"}},
+		{"$in on an array", `{"tags": {"$in": ["admin", "ops"]}}`, []string{"alice"}},
+		{"$nin", `{"address.city": {"$nin": ["Mumbai"]}}`, []string{"bob", "carol"}},
+		{"$exists", `{"address.zip": {"$exists": true}}`, []string{"alice"}},
+		{"$exists false", `{"address": {"$exists": false}}`, []string{"carol"}},
+		{"$exists on null", `{"manager": {"$exists": true}}`, []string{"carol"}},
+		{"$regex", `{"name": {"$regex": "^[AB]"}}`, []string{"alice"}},
+		{"$regex $options", `{"name": {"$regex": "^b", "$options": "i"}}`, []string{"bob"}},
+		{"$and", `{"$and": [{"age": {"$gt": 20}}, {"tags": "dev"}]}`, []string{"alice", "bob"}},
+		{"$or", `{"$or": [{"address.city": "Pune"}, {"age": 40}]}`, []string{"bob", "carol"}},
+		{"$or of $and", `{"$or": [{"$and": [{"age": 31}, {"tags": "admin"}]}, {"name": "bob"}]}`, []string{"alice", "bob"}},
+		{"$and beside a field", `{"$and": [{"tags": "dev"}], "age": {"$lt": 30}}`, []string{"bob"}},
+		{"object value", `{"address": {"city": "Pune"}}`, []string{"bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFilter(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, name := range []string{"alice", "bob", "carol"} {
+				if f.Match(&Record{Resource: name, Data: []byte(matchDocs[name])}) {
+					got = append(got, name)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s matches %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterRejects(t *testing.T) {
+	tests := []struct {
+		query interface{}
+		err   string
+	}{
+		{`{"age": {"$between": [1, 2]}}`, "unknown operator $between"},
+		{`{"$nor": [{"age": 1}]}`, "unknown operator $nor"},
+		{`{"$or": []}`, "non-empty array"},
+		{`{"$and": {"age": 1}}`, "non-empty array"},
+		{`{"$or": [1]}`, "is not an object"},
+		{`{"age": {"$gt": true}}`, "a number or a string"},
+		{`{"age": {"$in": 1}}`, "takes an array"},
+		{`{"name": {"$regex": "("}}`, "missing closing )"},
+		{`{"name": {"$regex": "a", "$options": "x"}}`, "unsupported $options"},
+		{`{"name": {"$options": "i"}}`, "$options without $regex"},
+		{`{"name": {"$exists": 1}}`, "true or false"},
+		{`[1, 2]`, "must be an object"},
+		{`{"age": `, "query"},
+		{[]byte(`"text"`), "must be an object"},
+	}
+	for _, tt := range tests {
+		_, err := ParseFilter(tt.query)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseFilter(%s) = %v, want an error about %q", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestParseFilterValue(t *testing.T) {
+	f, err := ParseFilter(map[string]interface{}{"age": map[string]int{"$gt": 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Match(&Record{Data: []byte(matchDocs["alice"])}) || f.Match(&Record{Data: []byte(matchDocs["bob"])}) {
+		t.Error("a query given as a Go value matched the wrong documents")
+	}
+}
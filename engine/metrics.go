@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- METRICS ---
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets used by
+// every latency histogram
+var latencyBuckets = [...]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics is a point-in-time copy of the counters kept by a Driver
+type Metrics struct {
+	Collections map[string]CollectionMetrics `json:"collections"`
+	// LockWait is the time spent waiting for collection locks
+	LockWait Histogram `json:"lockWait"`
+	// Cache is the state of the read cache, if WithCache set one up
+	Cache CacheStats `json:"cache"`
+	// Commits describes group commits, if WithGroupCommit is set
+	Commits CommitMetrics `json:"commits"`
+}
+
+// CollectionMetrics holds the counters of a single collection
+type CollectionMetrics struct {
+	Reads        OpMetrics `json:"reads"`
+	Writes       OpMetrics `json:"writes"`
+	Deletes      OpMetrics `json:"deletes"`
+	Scans        OpMetrics `json:"scans"`
+	BytesRead    int64     `json:"bytesRead"`
+	BytesWritten int64     `json:"bytesWritten"`
+	// BloomMisses counts the lookups of missing records a bloom filter
+	// answered, with WithBloomFilters
+	BloomMisses int64 `json:"bloomMisses,omitempty"`
+}
+
+// OpMetrics counts the calls of one kind of operation and how long they took
+type OpMetrics struct {
+	Count   int64     `json:"count"`
+	Errors  int64     `json:"errors"`
+	Latency Histogram `json:"latency"`
+}
+
+// Histogram is a distribution of durations. Counts[i] is the number of
+// observations no greater than Bounds[i] and above the previous bound; the
+// extra last entry counts those above every bound.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Count  int64     `json:"count"`
+	Sum    float64   `json:"sum"` // seconds
+}
+
+type histogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Int64
+	count  atomic.Int64
+	sum    atomic.Int64 // nanoseconds
+}
+
+func (h *histogram) observe(elapsed time.Duration) {
+	i := sort.SearchFloat64s(latencyBuckets[:], elapsed.Seconds())
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(elapsed))
+}
+
+func (h *histogram) snapshot() Histogram {
+	out := Histogram{
+		Bounds: append([]float64(nil), latencyBuckets[:]...),
+		Counts: make([]int64, len(h.counts)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()).Seconds(),
+	}
+	for i := range h.counts {
+		out.Counts[i] = h.counts[i].Load()
+	}
+	return out
+}
+
+type opCounter struct {
+	count   atomic.Int64
+	errors  atomic.Int64
+	latency histogram
+}
+
+func (c *opCounter) done(start time.Time, err error) {
+	c.count.Add(1)
+	if err != nil {
+		c.errors.Add(1)
+	}
+	c.latency.observe(time.Since(start))
+}
+
+func (c *opCounter) snapshot() OpMetrics {
+	return OpMetrics{Count: c.count.Load(), Errors: c.errors.Load(), Latency: c.latency.snapshot()}
+}
+
+type collectionCounters struct {
+	reads, writes, deletes, scans opCounter
+	bytesRead, bytesWritten       atomic.Int64
+	bloomMisses                   atomic.Int64
+}
+
+type metrics struct {
+	collections sync.Map // collection name -> *collectionCounters
+	lockWait    histogram
+}
+
+// counters returns the counters of a collection, creating them on first use
+func (m *metrics) counters(collection string) *collectionCounters {
+	if c, ok := m.collections.Load(collection); ok {
+		return c.(*collectionCounters)
+	}
+	c, _ := m.collections.LoadOrStore(collection, new(collectionCounters))
+	return c.(*collectionCounters)
+}
+
+// Metrics returns the operation counts, latencies, byte counts and lock
+// wait times recorded since the Driver was created
+func (d *Driver) Metrics() Metrics {
+	out := Metrics{
+		Collections: make(map[string]CollectionMetrics),
+		LockWait:    d.metrics.lockWait.snapshot(),
+		Cache:       d.cache.snapshot(),
+	}
+	if d.commits != nil {
+		out.Commits = d.commits.snapshot()
+	}
+	d.metrics.collections.Range(func(k, v interface{}) bool {
+		c := v.(*collectionCounters)
+		out.Collections[k.(string)] = CollectionMetrics{
+			Reads:        c.reads.snapshot(),
+			Writes:       c.writes.snapshot(),
+			Deletes:      c.deletes.snapshot(),
+			Scans:        c.scans.snapshot(),
+			BytesRead:    c.bytesRead.Load(),
+			BytesWritten: c.bytesWritten.Load(),
+			BloomMisses:  c.bloomMisses.Load(),
+		}
+		return true
+	})
+	return out
+}
+
+// PublishMetrics exposes the Driver's Metrics as an expvar variable, served
+// as JSON on /debug/vars by the expvar package. Like expvar.Publish it
+// panics if name is already in use.
+func (d *Driver) PublishMetrics(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return d.Metrics() }))
+}
+
+// acquire takes a collection's lock, exclusively or shared, recording how
+// long it had to wait, and returns the function releasing it. It fails
+// with ErrClosed once Close has been called.
+func (d *Driver) acquire(collection string, exclusive bool) (func(), error) {
+	if err := d.life.enter(); err != nil {
+		return nil, err
+	}
+	lock := d.lockFor(collection)
+	start := time.Now()
+	if exclusive {
+		lock.Lock()
+		d.metrics.lockWait.observe(time.Since(start))
+		return func() { lock.Unlock(); d.unref(collection, lock); d.life.leave() }, nil
+	}
+	lock.RLock()
+	d.metrics.lockWait.observe(time.Since(start))
+	return func() { lock.RUnlock(); d.unref(collection, lock); d.life.leave() }, nil
+}
@@ -0,0 +1,319 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// --- MIGRATIONS ---
+
+// MigrationCollection records the migrations applied by Migrate, one
+// record per version
+const MigrationCollection = systemPrefix + "migrations"
+
+// Migration is one versioned change to the stored data. Up applies it and
+// Down, when set, undoes it for Rollback.
+type Migration struct {
+	// Version orders migrations; it must be positive and unique
+	Version     int
+	Description string
+	Up          MigrationFunc
+	Down        MigrationFunc
+}
+
+// MigrationFunc carries out one direction of a Migration through m
+type MigrationFunc func(m *MigrationRun) error
+
+// MigrationRun is what a MigrationFunc works through: its transforms are
+// dry runs when the migration is, and resume where they stopped should the
+// migration be run again after failing
+type MigrationRun struct {
+	d       *Driver
+	version int
+	dryRun  bool
+	steps   int
+	result  *MigrationResult
+}
+
+// MigrationResult reports what one migration did, or would do in a dry run
+type MigrationResult struct {
+	Version     int    `json:"version"`
+	Description string `json:"description,omitempty"`
+	Scanned     int    `json:"scanned"`
+	Changed     int    `json:"changed"`
+}
+
+// AppliedMigration is the stored record of an applied migration
+type AppliedMigration struct {
+	Version     int       `json:"version"`
+	Description string    `json:"description,omitempty"`
+	AppliedAt   time.Time `json:"appliedAt"`
+	Changed     int       `json:"changed"`
+}
+
+// Migrate applies, in version order, the migrations not yet recorded in
+// MigrationCollection, recording each once its Up succeeds, and reports
+// what each did. It stops at the first failure: fix the migration and run
+// Migrate again, and its transforms resume after the records they had
+// already written.
+func (d *Driver) Migrate(migrations ...Migration) ([]MigrationResult, error) {
+	return d.migrate(migrations, false)
+}
+
+// MigrateDryRun reports what Migrate would do, running the pending
+// migrations' transforms without writing anything. Each migration sees the
+// data as stored, not as the migrations before it would leave it.
+func (d *Driver) MigrateDryRun(migrations ...Migration) ([]MigrationResult, error) {
+	return d.migrate(migrations, true)
+}
+
+func (d *Driver) migrate(migrations []Migration, dryRun bool) ([]MigrationResult, error) {
+	migrations, err := sortMigrations(migrations)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		if err := d.writable(); err != nil {
+			return nil, err
+		}
+	}
+	d.migrating.Lock()
+	defer d.migrating.Unlock()
+
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	var results []MigrationResult
+	for _, mig := range migrations {
+		if done[mig.Version] {
+			continue
+		}
+		res, err := d.runMigration(mig, mig.Up, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migration %d: %w", mig.Version, err)
+		}
+		if !dryRun {
+			rec := AppliedMigration{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now().UTC(), Changed: res.Changed}
+			if err := d.write(MigrationCollection, strconv.Itoa(mig.Version), rec); err != nil {
+				return results, err
+			}
+			d.opts.logger.Info("migration applied", "version", mig.Version, "changed", res.Changed)
+		}
+		results = append(results, *res)
+	}
+	return results, nil
+}
+
+// Rollback undoes, newest first, the applied migrations above version to,
+// running their Down and dropping their record. Migrations without a Down
+// can't be rolled back: Rollback stops before them.
+func (d *Driver) Rollback(to int, migrations ...Migration) ([]MigrationResult, error) {
+	if _, err := sortMigrations(migrations); err != nil {
+		return nil, err
+	}
+	if err := d.writable(); err != nil {
+		return nil, err
+	}
+	d.migrating.Lock()
+	defer d.migrating.Unlock()
+
+	known := make(map[int]Migration, len(migrations))
+	for _, mig := range migrations {
+		known[mig.Version] = mig
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MigrationResult
+	for i := len(applied) - 1; i >= 0 && applied[i].Version > to; i-- {
+		v := applied[i].Version
+		mig, ok := known[v]
+		if !ok {
+			return results, fmt.Errorf("migration %d is applied but wasn't given", v)
+		}
+		if mig.Down == nil {
+			return results, fmt.Errorf("migration %d can't be rolled back", v)
+		}
+		res, err := d.runMigration(mig, mig.Down, false)
+		if err != nil {
+			return results, fmt.Errorf("rolling back migration %d: %w", v, err)
+		}
+		if err := d.delete(MigrationCollection, strconv.Itoa(v)); err != nil {
+			return results, err
+		}
+		d.opts.logger.Info("migration rolled back", "version", v, "changed", res.Changed)
+		results = append(results, *res)
+	}
+	return results, nil
+}
+
+// AppliedMigrations lists the applied migrations in version order
+func (d *Driver) AppliedMigrations() ([]AppliedMigration, error) {
+	return d.appliedMigrations()
+}
+
+func (d *Driver) appliedMigrations() ([]AppliedMigration, error) {
+	var out []AppliedMigration
+	err := d.scan(MigrationCollection, func(rec *Record) error {
+		var a AppliedMigration
+		if err := json.Unmarshal(rec.Data, &a); err != nil {
+			return fmt.Errorf("migration record %s: %w", rec.Resource, err)
+		}
+		out = append(out, a)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// runMigration runs one direction of a migration
+func (d *Driver) runMigration(mig Migration, fn MigrationFunc, dryRun bool) (*MigrationResult, error) {
+	res := &MigrationResult{Version: mig.Version, Description: mig.Description}
+	if fn == nil {
+		return res, nil
+	}
+	m := &MigrationRun{d: d, version: mig.Version, dryRun: dryRun, result: res}
+	if err := fn(m); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// sortMigrations returns migrations in version order, rejecting bad or
+// repeated versions
+func sortMigrations(migrations []Migration) ([]Migration, error) {
+	sorted := slices.Clone(migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, mig := range sorted {
+		if mig.Version <= 0 {
+			return nil, fmt.Errorf("migration version %d is not positive", mig.Version)
+		}
+		if i > 0 && sorted[i-1].Version == mig.Version {
+			return nil, fmt.Errorf("migration version %d is given twice", mig.Version)
+		}
+	}
+	return sorted, nil
+}
+
+// Driver is the Driver the migration runs against, for changes beyond
+// transforms; they are made even in a dry run unless the migration checks
+// DryRun
+func (m *MigrationRun) Driver() *Driver {
+	return m.d
+}
+
+// DryRun reports whether the migration is only being tried
+func (m *MigrationRun) DryRun() bool {
+	return m.dryRun
+}
+
+// Transform runs fn over every record of collection as Driver.Transform
+// does, checkpointed under the migration's version. A missing collection
+// has nothing to transform.
+func (m *MigrationRun) Transform(collection string, fn TransformFunc) error {
+	m.steps++
+	opts := TransformOptions{
+		DryRun:     m.dryRun,
+		Checkpoint: fmt.Sprintf("migration-%d-%d", m.version, m.steps),
+	}
+	summary, err := m.d.Transform(collection, fn, opts)
+	if errors.Is(err, fs.ErrNotExist) && summary == nil {
+		return nil
+	}
+	if summary != nil {
+		m.result.Scanned += summary.Scanned
+		m.result.Changed += summary.Changed
+	}
+	return err
+}
+
+// Steps runs fns in turn, as one direction of a migration
+func Steps(fns ...MigrationFunc) MigrationFunc {
+	return func(m *MigrationRun) error {
+		for _, fn := range fns {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// TransformCollection is a migration step running fn over collection
+func TransformCollection(collection string, fn TransformFunc) MigrationFunc {
+	return func(m *MigrationRun) error {
+		return m.Transform(collection, fn)
+	}
+}
+
+// RenameField is a migration step moving the field at the dotted path
+// from to the path to in every document of collection that has it. It is
+// its own inverse with the paths swapped.
+func RenameField(collection, from, to string) MigrationFunc {
+	return TransformCollection(collection, func(rec *Record) (interface{}, error) {
+		obj, ok, err := recordObject(rec)
+		if !ok || err != nil {
+			return nil, err
+		}
+		v, found := lookupPath(obj, from)
+		if !found {
+			return nil, nil
+		}
+		deletePath(obj, from)
+		if !setPath(obj, to, v) {
+			return nil, fmt.Errorf("%s: a non-object value is in the way of %s", rec.Resource, to)
+		}
+		return obj, nil
+	})
+}
+
+// ConvertField is a migration step replacing the value at the dotted path
+// in every document of collection that has it with what fn returns, as for
+// changing its type. Values are given in their generic JSON form, numbers
+// as json.Number.
+func ConvertField(collection, path string, fn func(v interface{}) (interface{}, error)) MigrationFunc {
+	return TransformCollection(collection, func(rec *Record) (interface{}, error) {
+		obj, ok, err := recordObject(rec)
+		if !ok || err != nil {
+			return nil, err
+		}
+		v, found := lookupPath(obj, path)
+		if !found {
+			return nil, nil
+		}
+		out, err := fn(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: converting %s: %w", rec.Resource, path, err)
+		}
+		setPath(obj, path, out)
+		return obj, nil
+	})
+}
+
+// recordObject decodes a fresh copy of a record's document, to be edited,
+// reporting whether it is an object, the only kind of document with fields
+func recordObject(rec *Record) (map[string]interface{}, bool, error) {
+	doc, err := decodeDocument(rec.Data)
+	if err != nil {
+		return nil, false, err
+	}
+	obj, ok := doc.(map[string]interface{})
+	return obj, ok, nil
+}
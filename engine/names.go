@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- NAME VALIDATION ---
+
+// ErrInvalidName is returned (wrapped) when a collection or resource name
+// could escape the data directory or can't be stored as a file name
+var ErrInvalidName = errors.New("invalid name")
+
+// maxNameLen leaves room for the ".json" suffix within the usual 255 byte
+// file name limit
+const maxNameLen = 250
+
+// validateName checks a single collection or resource name. Names become
+// path components, so separators, leading dots (which also rules out "."
+// and "..") and control characters are rejected outright.
+func validateName(kind, name string) error {
+	reason := ""
+	switch {
+	case name == "":
+		reason = "is empty"
+	case len(name) > maxNameLen:
+		reason = fmt.Sprintf("is longer than %d bytes", maxNameLen)
+	case !utf8.ValidString(name):
+		reason = "is not valid UTF-8"
+	case strings.HasPrefix(name, "."):
+		reason = "starts with a dot"
+	case strings.ContainsAny(name, `/\`):
+		reason = "contains a path separator"
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		reason = "contains a control character"
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: %s %q %s", ErrInvalidName, kind, name, reason)
+	}
+	return nil
+}
+
+// validateCollection checks a collection name supplied by a caller: a
+// name, or the path of a sub-collection such as "users/John Doe/orders",
+// alternating collections and records. The engine's own collections live
+// under _system/ and can't be addressed directly.
+func validateCollection(collection string) error {
+	parts := strings.Split(collection, "/")
+	for _, part := range parts {
+		if err := validateName("collection", part); err != nil {
+			return err
+		}
+	}
+	if len(parts)%2 == 0 {
+		return fmt.Errorf("%w: collection %q ends in a record rather than a collection", ErrInvalidName, collection)
+	}
+	if parts[0]+"/" == systemPrefix {
+		return fmt.Errorf("%w: collection %q is reserved", ErrInvalidName, collection)
+	}
+	return nil
+}
+
+// validateNames checks a collection and resource pair supplied by a caller
+func validateNames(collection, resource string) error {
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	return validateName("resource", resource)
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+)
+
+// --- OPTIONS ---
+
+// Option configures a Driver at construction time
+type Option func(*options)
+
+type options struct {
+	deadLetter bool
+	metadata   bool
+	logger     *slog.Logger
+	durability Durability
+
+	readConcurrency int
+	cacheBytes      int64
+	ioSlots         int
+
+	volumes   []string
+	placement PlacementPolicy
+
+	replica       bool
+	readOnly      bool
+	changeBacklog int
+
+	directIO  bool
+	directMin int64
+
+	preallocate bool
+
+	groupCommit  bool
+	commitWindow time.Duration
+
+	storage Storage
+
+	quotas Quotas
+
+	indentSet            bool
+	indentPrefix, indent string
+
+	codec  Codec
+	codecs []Codec
+
+	tracer     Tracer
+	authorizer Authorizer
+
+	bloom bool
+}
+
+// WithDeadLetter records writes and deletes that fail irrecoverably (rejected
+// by a hook, failing validation, or failing on disk) in the _system/deadletter
+// collection so they can be inspected and retried later
+func WithDeadLetter() Option {
+	return func(o *options) { o.deadLetter = true }
+}
+
+// WithMetadata stores every document inside an envelope carrying its
+// creation time, last update time and revision number. Records written
+// before the option was enabled are still read as plain documents.
+func WithMetadata() Option {
+	return func(o *options) { o.metadata = true }
+}
+
+// LevelTrace is below slog.LevelDebug and covers per-read logging, which is
+// too chatty for most debugging sessions
+const LevelTrace = slog.LevelDebug - 4
+
+// WithLogger makes the Driver log what it does on disk: writes and deletes
+// at debug level, reads at LevelTrace, and backups, restores, transforms
+// and dead-lettered operations at info or warn. Without it the Driver is
+// silent.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// Durability is how far a write or delete is pushed towards stable storage
+// before it returns
+type Durability int
+
+const (
+	// DurabilityNone leaves flushing to the operating system: a crash can
+	// lose recent changes, but never leaves a half-written record
+	DurabilityNone Durability = iota
+	// FsyncOnWrite flushes every written file to disk before renaming it
+	// into place
+	FsyncOnWrite
+	// FsyncDir also flushes the collection directory after a file is
+	// renamed or removed, so the change itself survives a crash
+	FsyncDir
+)
+
+// WithDurability sets the durability of every write and delete. Single calls
+// can override it with Durable. The default is DurabilityNone.
+func WithDurability(level Durability) Option {
+	return func(o *options) { o.durability = level }
+}
+
+// WithReadConcurrency sets how many files ReadAll and other collection scans
+// load and decode at once. Results keep their name order either way. The
+// default is GOMAXPROCS; 1 reads serially.
+func WithReadConcurrency(n int) Option {
+	return func(o *options) { o.readConcurrency = n }
+}
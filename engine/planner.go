@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// --- QUERY PLANNER ---
+
+// The strategies of a Plan
+const (
+	PlanFullScan          = "full scan"
+	PlanIndexLookup       = "index lookup"
+	PlanIndexIntersection = "index intersection"
+)
+
+// Plan is how Find, and aggregations with a Where filter, go about a
+// filter, as Explain reports it. Of the conditions the filter requires,
+// those on a unique field asking for given strings, numbers or booleans
+// (a plain value, $eq or $in) are looked up in the unique index, and
+// those of any kind on a column field are checked against the column
+// index. One index used makes an index lookup, several an index
+// intersection of what each leaves; with none the plan is a full scan.
+// Either way the records left are read and matched against the whole
+// filter.
+type Plan struct {
+	Collection string     `json:"collection"`
+	Strategy   string     `json:"strategy"`
+	Steps      []PlanStep `json:"steps,omitempty"`
+	// Candidates is how many records are read
+	Candidates int `json:"candidates"`
+
+	names []string // the candidates, in name order
+}
+
+// PlanStep is one index lookup of a Plan
+type PlanStep struct {
+	Index string `json:"index"` // "unique" or "column"
+	Field string `json:"field"`
+	// Remaining is how many records are left after the step
+	Remaining int `json:"remaining"`
+}
+
+// String lays the plan out for people, one step per line
+func (p *Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s, %d candidates", p.Collection, p.Strategy, p.Candidates)
+	for _, s := range p.Steps {
+		fmt.Fprintf(&b, "\n  %s index on %s: %d left", s.Index, s.Field, s.Remaining)
+	}
+	return b.String()
+}
+
+// Explain returns the plan Find would run filter on collection with,
+// without reading a single record, so users can check that their indexes
+// are used. Indexes the plan needs are built, as Find would build them.
+func (d *Driver) Explain(collection string, filter Filter, opts ...ReadOption) (*Plan, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+	var plan *Plan
+	err := d.planned(collection, filter, func(p *Plan) error {
+		plan = p
+		return nil
+	})
+	return plan, err
+}
+
+// planned calls fn with the plan of filter on collection under the
+// collection's read lock. A unique index the plan wants is built first,
+// since that is only ever done under the write lock.
+func (d *Driver) planned(collection string, filter Filter, fn func(plan *Plan) error) error {
+	cfg := d.snapshotConfig(collection)
+	conds := conditions(filter)
+	if wantsUnique(cfg, conds) && d.builtUnique(collection, cfg.unique) == nil {
+		release, err := d.acquire(collection, true)
+		if err != nil {
+			return err
+		}
+		_, err = d.uniqueIndexFor(collection, cfg.unique)
+		release()
+		if err != nil {
+			return err
+		}
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+	plan, err := d.plan(collection, cfg, conds)
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		d.opts.logger.Debug("query plan", "collection", collection, "strategy", plan.Strategy, "candidates", plan.Candidates)
+	}
+	return fn(plan)
+}
+
+// plan works out which records of collection may pass every one of
+// conds. Callers must hold the collection lock.
+func (d *Driver) plan(collection string, cfg *collectionConfig, conds []*fieldCondition) (*Plan, error) {
+	plan := &Plan{Collection: collection, Strategy: PlanFullScan}
+	var candidates map[string]bool // nil while every record is one
+
+	used := make(map[*fieldCondition]bool)
+	if unique := d.builtUnique(collection, cfg.unique); unique != nil {
+		for _, c := range conds {
+			if c.values == nil || !slices.Contains(cfg.unique, c.path) {
+				continue
+			}
+			found := make(map[string]bool)
+			for _, v := range c.values {
+				if owner, ok := unique.owners[c.path][uniqueKey(v)]; ok && (candidates == nil || candidates[owner]) {
+					found[owner] = true
+				}
+			}
+			for resource := range unique.arrays[c.path] {
+				if candidates == nil || candidates[resource] {
+					found[resource] = true
+				}
+			}
+			candidates = found
+			used[c] = true
+			plan.Steps = append(plan.Steps, PlanStep{Index: "unique", Field: c.path, Remaining: len(candidates)})
+		}
+	}
+
+	var columned []*fieldCondition
+	for _, c := range conds {
+		if !used[c] && slices.Contains(cfg.columns, c.path) {
+			columned = append(columned, c)
+		}
+	}
+	if len(columned) > 0 {
+		idx, err := d.columnIndexFor(collection, cfg.columns)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range columned {
+			col := slices.Index(idx.fields, c.path)
+			found := make(map[string]bool)
+			if candidates == nil {
+				for row, resource := range idx.resources {
+					if columnPasses(c, idx.values[col][row]) {
+						found[resource] = true
+					}
+				}
+			} else {
+				for resource := range candidates {
+					if row, ok := idx.rows[resource]; ok && columnPasses(c, idx.values[col][row]) {
+						found[resource] = true
+					}
+				}
+			}
+			candidates = found
+			plan.Steps = append(plan.Steps, PlanStep{Index: "column", Field: c.path, Remaining: len(candidates)})
+		}
+	}
+
+	if candidates == nil {
+		names, err := d.recordNames(collection)
+		if err != nil {
+			return nil, err
+		}
+		plan.names = names
+	} else {
+		// an index lookup fails on a missing collection just as a scan
+		if _, err := d.fs.Stat(d.collectionDir(collection)); err != nil {
+			return nil, err
+		}
+		plan.names = make([]string, 0, len(candidates))
+		for resource := range candidates {
+			plan.names = append(plan.names, resource)
+		}
+		sort.Strings(plan.names)
+		plan.Strategy = PlanIndexLookup
+		if len(plan.Steps) > 1 {
+			plan.Strategy = PlanIndexIntersection
+		}
+	}
+	plan.Candidates = len(plan.names)
+	return plan, nil
+}
+
+// conditions returns the field conditions filter requires every one of
+func conditions(filter Filter) []*fieldCondition {
+	switch f := filter.(type) {
+	case *fieldCondition:
+		return []*fieldCondition{f}
+	case allOf:
+		var out []*fieldCondition
+		for _, sub := range f {
+			out = append(out, conditions(sub)...)
+		}
+		return out
+	}
+	return nil
+}
+
+// wantsUnique reports whether any of conds can be looked up in the unique
+// index
+func wantsUnique(cfg *collectionConfig, conds []*fieldCondition) bool {
+	for _, c := range conds {
+		if c.values != nil && slices.Contains(cfg.unique, c.path) {
+			return true
+		}
+	}
+	return false
+}
+
+// builtUnique returns the unique index of collection when it is built for
+// fields, nil otherwise
+func (d *Driver) builtUnique(collection string, fields []string) *uniqueIndex {
+	if len(fields) == 0 {
+		return nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if idx := d.uniques[collection]; idx != nil && slices.Equal(idx.fields, fields) {
+		return idx
+	}
+	return nil
+}
+
+// columnPasses reports whether a record whose column holds v may pass c.
+// Columns don't tell a missing field from a null one, so nil passes if
+// either would.
+func columnPasses(c *fieldCondition, v interface{}) bool {
+	if v == nil {
+		return c.test(nil, true) || c.test(nil, false)
+	}
+	return c.test(v, true)
+}
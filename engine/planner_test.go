@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// plannedDriver opens a database of 20 users u00 to u19 with a unique
+// email and an age column, and a user nobody without either
+func plannedDriver(t *testing.T) *Driver {
+	t.Helper()
+	d := openTest(t)
+	d.UniqueField("users", "email")
+	d.ColumnField("users", "age")
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("u%02d", i)
+		city := []string{"Mumbai", "Pune"}[i%2]
+		doc := map[string]interface{}{"email": name + "@example.com", "age": i, "city": city}
+		if err := d.Write("users", name, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Write("users", "nobody", map[string]string{"city": "Pune"}); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// mustParse is ParseFilter failing t on a bad query
+func mustParse(t *testing.T, query string) Filter {
+	t.Helper()
+	f, err := ParseFilter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestExplain(t *testing.T) {
+	d := plannedDriver(t)
+	tests := []struct {
+		name       string
+		filter     Filter
+		strategy   string
+		steps      []PlanStep
+		candidates int
+	}{
+		{"unique equality", Equal("email", "u07@example.com"), PlanIndexLookup,
+			[]PlanStep{{Index: "unique", Field: "email", Remaining: 1}}, 1},
+		{"unique $in", mustParse(t, `{"email": {"$in": ["u01@example.com", "u02@example.com", "none"]}}`), PlanIndexLookup,
+			[]PlanStep{{Index: "unique", Field: "email", Remaining: 2}}, 2},
+		{"column range", mustParse(t, `{"age": {"$gte": 5, "$lt": 10}}`), PlanIndexLookup,
+			[]PlanStep{{Index: "column", Field: "age", Remaining: 5}}, 5},
+		{"intersection", And(Equal("email", "u12@example.com"), mustParse(t, `{"age": {"$gt": 10}}`)), PlanIndexIntersection,
+			[]PlanStep{{Index: "unique", Field: "email", Remaining: 1}, {Index: "column", Field: "age", Remaining: 1}}, 1},
+		{"unindexed field", Equal("city", "Pune"), PlanFullScan, nil, 21},
+		{"function filter", FilterFunc(func(*Record) bool { return true }), PlanFullScan, nil, 21},
+		{"$or", mustParse(t, `{"$or": [{"email": "u01@example.com"}, {"age": 2}]}`), PlanFullScan, nil, 21},
+		{"no filter", nil, PlanFullScan, nil, 21},
+	}
+	for _, tt := range tests {
+		plan, err := d.Explain("users", tt.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if plan.Strategy != tt.strategy || plan.Candidates != tt.candidates || !reflect.DeepEqual(plan.Steps, tt.steps) {
+			t.Errorf("%s: plan\n%s\nwant %s with %d candidates and steps %v", tt.name, plan, tt.strategy, tt.candidates, tt.steps)
+		}
+	}
+}
+
+func TestPlannedFindMatchesScan(t *testing.T) {
+	d := plannedDriver(t)
+	for _, query := range []string{
+		`{"email": "u07@example.com"}`,
+		`{"email": {"$eq": "u03@example.com"}, "city": "Pune"}`,
+		`{"email": {"$in": ["u01@example.com", "u02@example.com", "none"]}}`,
+		`{"age": {"$gte": 5, "$lt": 10}}`,
+		`{"age": {"$ne": 3}}`,
+		`{"age": {"$exists": false}}`,
+		`{"age": {"$nin": [1, 2, 3]}, "city": "Mumbai"}`,
+		`{"email": "u12@example.com", "age": {"$gt": 10}}`,
+		`{"email": "u12@example.com", "age": {"$lt": 10}}`,
+	} {
+		filter := mustParse(t, query)
+		planned, err := d.Find("users", filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a function filter hides the conditions from the planner
+		scanned, err := d.Find("users", FilterFunc(filter.Match))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resourcesOf(planned), resourcesOf(scanned); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: planned Find returned %v, a full scan %v", query, got, want)
+		}
+	}
+}
+
+// resourcesOf lists the names of records
+func resourcesOf(records []Record) []string {
+	names := []string{}
+	for _, rec := range records {
+		names = append(names, rec.Resource)
+	}
+	return names
+}
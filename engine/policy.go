@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// --- ROW-LEVEL SECURITY ---
+
+// policyVar prefixes the strings of a policy standing for an attribute of
+// the caller
+const policyVar = "$ctx."
+
+// Policy restricts the records of a collection callers see and change,
+// after the document-level security of SQL databases. Each rule is a
+// query document as ParseFilter takes, in which a string "$ctx.<path>"
+// stands for the field at path of the caller's actor, set with WithActor
+// and seen as JSON, so
+//
+//	Policy{Read: `{"ownerId": "$ctx.userId"}`}
+//
+// shows each user only the records whose ownerId is their userId. A rule
+// naming an attribute the actor lacks, or a call without an actor, matches
+// nothing. A nil rule allows everything.
+type Policy struct {
+	// Read filters the records Read, ReadAll, ReadMeta, Find, List,
+	// Search, the AsOf reads, History, aggregations, Columns, the
+	// estimates, FindDuplicates, Quality, Trash and JSONL and CSV Export
+	// return and the writes Watch passes on; a record it leaves out reads
+	// as missing. Deletes are passed on to
+	// watchers whatever it says.
+	Read interface{}
+	// Write must match the document a Write stores and, when the record
+	// exists, the document it replaces or Delete removes, and so the
+	// documents of Increment, the array updates, Import and RestoreDeleted.
+	// Calls it doesn't allow fail wrapping ErrPermissionDenied; DeleteWhere
+	// skips the records it doesn't allow.
+	Write interface{}
+}
+
+// policy is a Policy decoded, its rules compiled per caller
+type policy struct {
+	read, write map[string]interface{} // nil for none
+}
+
+// SetPolicy makes collection enforce p, or nothing again when both its
+// rules are nil. The _system collections and replicated changes are not
+// subject to policies, nor are Populate's lookups.
+func (d *Driver) SetPolicy(collection string, p Policy) error {
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	if isSystemCollection(collection) {
+		return fmt.Errorf("%s: system collections take no policy", collection)
+	}
+	read, err := decodeRule("read", p.Read)
+	if err != nil {
+		return err
+	}
+	write, err := decodeRule("write", p.Write)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if read == nil && write == nil {
+		d.config(collection).policy = nil
+	} else {
+		d.config(collection).policy = &policy{read: read, write: write}
+	}
+	return nil
+}
+
+// decodeRule decodes a rule and checks it compiles
+func decodeRule(name string, rule interface{}) (map[string]interface{}, error) {
+	if rule == nil {
+		return nil, nil
+	}
+	var (
+		doc interface{}
+		err error
+	)
+	switch r := rule.(type) {
+	case []byte:
+		doc, err = decodeDocument(r)
+	case string:
+		doc, err = decodeDocument([]byte(r))
+	default:
+		doc, err = toDocument(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s policy: %w", name, err)
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s policy must be an object, got %s", name, jsonType(doc))
+	}
+	if _, err := compileQuery(bindRule(obj, nil).(map[string]interface{})); err != nil {
+		return nil, fmt.Errorf("%s policy: %w", name, err)
+	}
+	return obj, nil
+}
+
+// bindRule copies a rule with its attributes replaced by the caller's,
+// nil for those missing; attrs nil leaves them all nil
+func bindRule(v interface{}, attrs map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = bindRule(e, attrs)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = bindRule(e, attrs)
+		}
+		return out
+	case string:
+		if path, ok := strings.CutPrefix(v, policyVar); ok {
+			attr, _ := lookupPath(attrs, path)
+			return attr
+		}
+	}
+	return v
+}
+
+// unbound reports whether v names an attribute missing from attrs
+func unbound(v interface{}, attrs map[string]interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, e := range v {
+			if unbound(e, attrs) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if unbound(e, attrs) {
+				return true
+			}
+		}
+	case string:
+		if path, ok := strings.CutPrefix(v, policyVar); ok {
+			_, found := lookupPath(attrs, path)
+			return !found
+		}
+	}
+	return false
+}
+
+// nothing is the filter of a rule the caller can't satisfy
+var nothing = FilterFunc(func(*Record) bool { return false })
+
+// compileRule is rule bound to the actor of ctx
+func compileRule(ctx context.Context, rule map[string]interface{}) Filter {
+	var attrs map[string]interface{}
+	if actor := ActorFrom(ctx); actor != nil {
+		doc, err := toDocument(actor)
+		if err != nil {
+			return nothing
+		}
+		attrs, _ = doc.(map[string]interface{})
+	}
+	if unbound(rule, attrs) {
+		return nothing
+	}
+	f, err := compileQuery(bindRule(rule, attrs).(map[string]interface{}))
+	if err != nil {
+		return nothing
+	}
+	return f
+}
+
+// readGuard is the filter of the records of collection the caller of ctx
+// may read, nil when all of them
+func (d *Driver) readGuard(ctx context.Context, collection string) Filter {
+	if isSystemCollection(collection) {
+		return nil
+	}
+	p := d.snapshotConfig(collection).policy
+	if p == nil || p.read == nil {
+		return nil
+	}
+	return compileRule(ctx, p.read)
+}
+
+// writeGuard is readGuard for changes
+func (d *Driver) writeGuard(ctx context.Context, collection string) Filter {
+	if isSystemCollection(collection) {
+		return nil
+	}
+	p := d.snapshotConfig(collection).policy
+	if p == nil || p.write == nil {
+		return nil
+	}
+	return compileRule(ctx, p.write)
+}
+
+// checkNew fails unless guard allows storing the document raw as resource
+func checkNew(guard Filter, collection, resource string, raw []byte) error {
+	if !guard.Match(&Record{Resource: resource, Data: raw}) {
+		return fmt.Errorf("%w: %s/%s: the document is outside the write policy", ErrPermissionDenied, collection, resource)
+	}
+	return nil
+}
+
+// checkExisting fails unless guard allows changing the record at path, if
+// there is one. Callers must hold the collection lock.
+func (d *Driver) checkExisting(guard Filter, collection, resource, path string, cfg *collectionConfig) error {
+	b, err := d.readLive(collection, path, cfg)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rec, err := decodeRecord(resource, b)
+	if err != nil {
+		return err
+	}
+	if !guard.Match(rec) {
+		return fmt.Errorf("%w: %s/%s: the record is outside the write policy", ErrPermissionDenied, collection, resource)
+	}
+	return nil
+}
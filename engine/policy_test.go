@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ownedDriver opens a database whose docs collection shows and lets change
+// each user only their own records, seeded with docs/a owned by alice and
+// docs/b owned by bob
+func ownedDriver(t *testing.T) *Driver {
+	t.Helper()
+	d := openTest(t)
+	d.KeepHistory("docs")
+	d.SearchField("docs", "text")
+	for name, owner := range map[string]string{"a": "alice", "b": "bob"} {
+		doc := map[string]interface{}{"owner": owner, "n": 1, "tags": []string{"x"}, "text": "hello"}
+		if err := d.Write("docs", name, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rule := `{"owner": "$ctx.name"}`
+	if err := d.SetPolicy("docs", Policy{Read: rule, Write: rule}); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// as is the context of calls made by user name
+func as(name string) context.Context {
+	return WithActor(context.Background(), map[string]string{"name": name})
+}
+
+func TestPolicyWrites(t *testing.T) {
+	bob := as("bob")
+	tests := []struct {
+		name string
+		call func(d *Driver) error
+	}{
+		{"Write over another's", func(d *Driver) error {
+			return d.Write("docs", "a", map[string]string{"owner": "bob"}, WriteContext(bob))
+		}},
+		{"Write for another", func(d *Driver) error {
+			return d.Write("docs", "c", map[string]string{"owner": "alice"}, WriteContext(bob))
+		}},
+		{"Increment", func(d *Driver) error {
+			_, err := d.Increment("docs", "a", "n", 1, WriteContext(bob))
+			return err
+		}},
+		{"Push", func(d *Driver) error { return d.Push("docs", "a", "tags", "y", WriteContext(bob)) }},
+		{"AddToSet", func(d *Driver) error { return d.AddToSet("docs", "a", "tags", "y", WriteContext(bob)) }},
+		{"Pull", func(d *Driver) error { return d.Pull("docs", "a", "tags", "x", WriteContext(bob)) }},
+		{"Import", func(d *Driver) error {
+			in := strings.NewReader(`{"_id":"a","owner":"bob"}` + "\n")
+			_, err := d.Import("docs", in, ImportOptions{OnError: Abort, Context: bob})
+			return err
+		}},
+		{"Delete", func(d *Driver) error { return d.Delete("docs", "a", WriteContext(bob)) }},
+		{"RestoreDeleted", func(d *Driver) error {
+			if err := d.DeleteSoft("docs", "a", WriteContext(as("alice"))); err != nil {
+				return err
+			}
+			return d.RestoreDeleted("docs", "a", WriteContext(bob))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := ownedDriver(t)
+			if err := tt.call(d); !errors.Is(err, ErrPermissionDenied) {
+				t.Fatalf("got %v, want ErrPermissionDenied", err)
+			}
+			if ok, _ := d.Exists("docs", "c", ReadContext(bob)); ok {
+				t.Error("docs/c was written")
+			}
+			var got map[string]interface{}
+			err := d.Read("docs", "a", &got, ReadContext(as("alice")))
+			if tt.name == "RestoreDeleted" {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("docs/a is back: %v, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["owner"] != "alice" || got["n"] != 1.0 || len(got["tags"].([]interface{})) != 1 {
+				t.Errorf("docs/a changed to %v", got)
+			}
+		})
+	}
+}
+
+func TestPolicyDeleteWhereSkipsOthers(t *testing.T) {
+	d := ownedDriver(t)
+	n, err := d.DeleteWhere("docs", nil, WriteContext(as("bob")))
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteWhere = %d, %v, want 1 deleted", n, err)
+	}
+	if ok, _ := d.Exists("docs", "b", ReadContext(as("bob"))); ok {
+		t.Error("docs/b is still there")
+	}
+	if ok, _ := d.Exists("docs", "a", ReadContext(as("alice"))); !ok {
+		t.Error("docs/a, alice's, was deleted")
+	}
+}
+
+func TestPolicyReads(t *testing.T) {
+	d := ownedDriver(t)
+	d.ColumnField("docs", "owner")
+	bob := ReadContext(as("bob"))
+	resources := func(records []Record) []string {
+		var names []string
+		for _, rec := range records {
+			names = append(names, rec.Resource)
+		}
+		return names
+	}
+	tests := []struct {
+		name string
+		read func() ([]string, error)
+		want string
+	}{
+		{"Find", func() ([]string, error) {
+			records, err := d.Find("docs", nil, bob)
+			return resources(records), err
+		}, "b"},
+		{"Search", func() ([]string, error) {
+			hits, err := d.Search("docs", "hello", bob)
+			var names []string
+			for _, hit := range hits {
+				names = append(names, hit.Resource)
+			}
+			return names, err
+		}, "b"},
+		{"FindAsOf", func() ([]string, error) {
+			records, err := d.FindAsOf("docs", time.Now(), nil, bob)
+			return resources(records), err
+		}, "b"},
+		{"ReadAllAsOf", func() ([]string, error) {
+			docs, err := d.ReadAllAsOf("docs", time.Now(), bob)
+			var owners []string
+			for _, doc := range docs {
+				rec := &Record{Data: doc}
+				owner, _ := rec.Field("owner")
+				owners = append(owners, owner.(string))
+			}
+			return owners, err
+		}, "bob"},
+		{"Aggregate", func() ([]string, error) {
+			groups, err := d.Aggregate("docs").Context(as("bob")).GroupBy("owner").Run(Sum("n"))
+			var owners []string
+			for _, g := range groups {
+				owners = append(owners, g.Key[0].(string))
+			}
+			return owners, err
+		}, "bob"},
+		{"Columns", func() ([]string, error) {
+			rows, err := d.Columns("docs", []string{"owner"}, bob)
+			var names []string
+			for _, row := range rows {
+				names = append(names, row.Resource)
+			}
+			return names, err
+		}, "b"},
+		{"ExportJSONL", func() ([]string, error) {
+			var buf bytes.Buffer
+			err := d.Export("docs", &buf, JSONL, bob)
+			var names []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				rec := &Record{Data: []byte(line)}
+				id, _ := rec.Field(IDField)
+				names = append(names, id.(string))
+			}
+			return names, err
+		}, "b"},
+		{"ExportCSV", func() ([]string, error) {
+			var buf bytes.Buffer
+			if err := d.Export("docs", &buf, CSV, bob); err != nil {
+				return nil, err
+			}
+			rows, err := csv.NewReader(&buf).ReadAll()
+			var names []string
+			for _, row := range rows[1:] {
+				names = append(names, row[0])
+			}
+			return names, err
+		}, "b"},
+	}
+	for _, tt := range tests {
+		got, err := tt.read()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s returned %v, want [%s]", tt.name, got, tt.want)
+		}
+	}
+
+	var v interface{}
+	if err := d.Read("docs", "a", &v, bob); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Read of another's record: %v, want fs.ErrNotExist", err)
+	}
+	if err := d.ReadAsOf("docs", "a", time.Now(), &v, bob); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadAsOf of another's record: %v, want fs.ErrNotExist", err)
+	}
+	if _, err := d.ReadMeta("docs", "a", bob); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadMeta of another's record: %v, want fs.ErrNotExist", err)
+	}
+	if n, exact, err := d.EstimateCount("docs", nil, bob); err != nil || n != 1 || !exact {
+		t.Errorf("EstimateCount = %d, %v, %v, want exactly 1", n, exact, err)
+	}
+	d.DistinctField("docs", "owner")
+	if n, _, err := d.EstimateDistinct("docs", "owner", bob); err != nil || n != 1 {
+		t.Errorf("EstimateDistinct = %d, %v, want 1", n, err)
+	}
+	if groups, err := d.FindDuplicates("docs", []string{"n"}, DuplicatesContext(as("bob"))); err != nil || len(groups) != 0 {
+		t.Errorf("FindDuplicates = %v, %v, want no group of bob's one record", groups, err)
+	}
+	if report, err := d.Quality("docs", bob); err != nil || report.Records != 1 {
+		t.Errorf("Quality = %+v, %v, want 1 record", report, err)
+	}
+	if versions, err := d.History("docs", "a", bob); err != nil || len(versions) != 0 {
+		t.Errorf("History of another's record = %d versions, %v, want none", len(versions), err)
+	}
+	if versions, err := d.History("docs", "b", bob); err != nil || len(versions) != 1 {
+		t.Errorf("History of their own record = %d versions, %v, want 1", len(versions), err)
+	}
+}
+
+func TestPolicyTrash(t *testing.T) {
+	d := ownedDriver(t)
+	for name, owner := range map[string]string{"a": "alice", "b": "bob"} {
+		if err := d.DeleteSoft("docs", name, WriteContext(as(owner))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := d.Trash("docs", ReadContext(as("bob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Resource != "b" {
+		t.Errorf("Trash = %v, want only b", entries)
+	}
+	if entries, _ := d.Trash("docs"); len(entries) != 0 {
+		t.Errorf("Trash without an actor = %v, want none", entries)
+	}
+}
@@ -0,0 +1,25 @@
+package engine
+
+import "os"
+
+// --- PREALLOCATION ---
+
+// WithPreallocation reserves the disk space of files whose size is known
+// before they are written, as copied by restores, collection moves and
+// backups taken across filesystems, in one extent up front. The file then
+// isn't grown and fragmented write by write, and a copy that doesn't fit
+// fails before anything is written rather than part way through. Records
+// are written whole in a single write and gain nothing from it. Where the
+// platform or filesystem can't preallocate, files are written as usual.
+func WithPreallocation() Option {
+	return func(o *options) { o.preallocate = true }
+}
+
+// reserve preallocates size bytes for the new file f when the Driver is
+// set to and the size is known
+func (d *Driver) reserve(f *os.File, size int64) error {
+	if !d.opts.preallocate || size <= 0 {
+		return nil
+	}
+	return fallocate(f, size)
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate allocates the first size bytes of f. Only running out of space
+// is reported; filesystems that can't allocate ahead just skip it.
+func fallocate(f *os.File, size int64) error {
+	var err error
+	for {
+		if err = syscall.Fallocate(int(f.Fd()), 0, 0, size); err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.ENOSPC || err == syscall.EDQUOT {
+		return &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+	}
+	return nil
+}
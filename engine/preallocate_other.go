@@ -0,0 +1,10 @@
+//go:build !linux
+
+package engine
+
+import "os"
+
+// fallocate does nothing off linux, where files grow as they are written
+func fallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// --- PRIORITY CLASSES ---
+
+// Priority is the class of work a call belongs to. Record files are read
+// and written through a fixed number of IO slots shared by the Driver's
+// calls: batch work takes at most half of them, and none while
+// interactive work waits for one, so a running import or analytics scan
+// leaves room for the calls users wait on. Batch work only yields IO; the
+// collection locks it holds are held as long as ever.
+type Priority int
+
+const (
+	// Interactive is the priority of calls unless told otherwise
+	Interactive Priority = iota
+	// Batch is the priority of bulk work: Import, RewriteFormats,
+	// TrainDictionary, Quality, CompactHistory, KV.Compact and
+	// aggregations run as batch work, and calls made with a context from
+	// WithPriority can too
+	Batch
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Interactive:
+		return "interactive"
+	case Batch:
+		return "batch"
+	}
+	return "unknown"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx running the calls made with it, see
+// WriteContext and ReadContext, at priority p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the priority ctx carries, Interactive if none
+func PriorityFrom(ctx context.Context) Priority {
+	if ctx == nil {
+		return Interactive
+	}
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// WithIOSlots sets how many record files the Driver reads and writes at
+// once, batch work taking at most half of them. The default is twice
+// GOMAXPROCS.
+func WithIOSlots(n int) Option {
+	return func(o *options) { o.ioSlots = n }
+}
+
+// ioScheduler hands out the IO slots, interactive waiters first
+type ioScheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	slots   int
+	busy    int
+	batch   int // of busy, the slots batch work holds
+	waiting int // interactive callers waiting for a slot
+}
+
+func newIOScheduler(slots int) *ioScheduler {
+	if slots <= 0 {
+		slots = 2 * runtime.GOMAXPROCS(0)
+	}
+	s := &ioScheduler{slots: max(slots, 2)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// enter waits for a slot for work of priority p. Callers must leave with
+// the same p, and mustn't wait for anything else, a lock above all, while
+// holding the slot.
+func (s *ioScheduler) enter(p Priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p == Batch {
+		for s.busy >= s.slots || s.batch >= s.slots/2 || s.waiting > 0 {
+			s.cond.Wait()
+		}
+		s.batch++
+	} else {
+		s.waiting++
+		for s.busy >= s.slots {
+			s.cond.Wait()
+		}
+		s.waiting--
+	}
+	s.busy++
+}
+
+// leave hands back a slot enter gave
+func (s *ioScheduler) leave(p Priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy--
+	if p == Batch {
+		s.batch--
+	}
+	s.cond.Broadcast()
+}
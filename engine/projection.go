@@ -0,0 +1,48 @@
+package engine
+
+import "encoding/json"
+
+// --- PROJECTION ---
+
+// Fields makes Read and Find return only the fields at the given (dot
+// separated) paths, laid out as in the stored document: Fields("name",
+// "address.city") yields {"name": ..., "address": {"city": ...}}. Fields
+// the document lacks are left out. Only the selected values are picked out
+// of the stored JSON, so large documents aren't decoded in full. Filters
+// still see the whole record; with Populate, references are resolved
+// first, so populated paths must be among the fields to be kept.
+func Fields(paths ...string) ReadOption {
+	return func(p *readParams) { p.fields = append(p.fields, paths...) }
+}
+
+// project cuts the document of rec down to the fields at paths
+func project(rec *Record, paths []string) error {
+	out := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		var v interface{}
+		if rec.doc == nil {
+			raw, ok := extractPath(rec.Data, path)
+			if !ok {
+				continue
+			}
+			v = json.RawMessage(raw)
+		} else {
+			obj, _ := rec.doc.(map[string]interface{})
+			val, ok := lookupPath(obj, path)
+			if !ok {
+				continue
+			}
+			v = val
+		}
+		// a path inside one already kept whole has nothing left to add
+		setPath(out, path, v)
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	rec.Data = b
+	rec.doc = nil
+	return nil
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// --- DATA QUALITY ---
+
+// maxQualitySamples caps the examples kept per field in a QualityReport
+const maxQualitySamples = 10
+
+// QualityReport summarises the health of every field in a collection
+type QualityReport struct {
+	Collection string         `json:"collection"`
+	Records    int            `json:"records"`
+	Fields     []FieldQuality `json:"fields"`
+}
+
+// FieldQuality holds the statistics gathered for one (dot separated) field
+type FieldQuality struct {
+	Path     string  `json:"path"`
+	Present  int     `json:"present"`
+	Missing  int     `json:"missing"`
+	Nulls    int     `json:"nulls"`
+	NullRate float64 `json:"nullRate"` // missing or null, as a fraction of all records
+
+	// Types counts the JSON type of every non-null value; more than one
+	// entry means the field is stored inconsistently
+	Types        map[string]int `json:"types"`
+	Inconsistent bool           `json:"inconsistent"`
+
+	Numeric    *NumericStats    `json:"numeric,omitempty"`
+	Outliers   []FieldSample    `json:"outliers,omitempty"`
+	Violations []FieldViolation `json:"violations,omitempty"`
+	// ViolationCount may exceed len(Violations), which keeps only samples
+	ViolationCount int `json:"violationCount"`
+}
+
+// NumericStats describes the numeric values of a field
+type NumericStats struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+}
+
+// FieldSample points at the value of a field in one record
+type FieldSample struct {
+	Resource string      `json:"resource"`
+	Value    interface{} `json:"value"`
+}
+
+// FieldViolation is a value rejected by the field's registered validators
+type FieldViolation struct {
+	FieldSample
+	Error string `json:"error"`
+}
+
+type fieldStats struct {
+	FieldQuality
+	numbers []FieldSample
+}
+
+// Quality scans a collection and reports per-field null rates, type
+// inconsistencies, numeric outliers (outside 1.5 times the interquartile
+// range) and values failing the validators attached with ValidateField
+func (d *Driver) Quality(collection string, opts ...ReadOption) (*QualityReport, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+
+	cfg := d.snapshotConfig(collection)
+	checks := make(map[string][]Validator)
+	for _, fv := range cfg.validators {
+		checks[fv.path] = append(checks[fv.path], fv.check...)
+	}
+
+	report := &QualityReport{Collection: collection}
+	fields := make(map[string]*fieldStats)
+	stats := func(path string) *fieldStats {
+		fs, ok := fields[path]
+		if !ok {
+			fs = &fieldStats{FieldQuality: FieldQuality{Path: path, Types: make(map[string]int)}}
+			fields[path] = fs
+		}
+		return fs
+	}
+
+	for path := range checks {
+		// validated fields are reported even when no record has them
+		stats(path)
+	}
+
+	guard := d.readGuard(p.ctx, collection)
+	err := d.scanPlanned(collection, guard, Batch, func(rec *Record) error {
+		if guard != nil && !guard.Match(rec) {
+			return nil
+		}
+		report.Records++
+		doc, err := rec.Document()
+		if err != nil {
+			return err
+		}
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		flat := make(map[string]interface{})
+		flattenDocument(obj, "", flat)
+
+		for path, v := range flat {
+			fs := stats(path)
+			fs.Present++
+			if v == nil {
+				fs.Nulls++
+				continue
+			}
+			fs.Types[jsonType(v)]++
+
+			if n, ok := v.(json.Number); ok {
+				fs.numbers = append(fs.numbers, FieldSample{Resource: rec.Resource, Value: n})
+			}
+			for _, check := range checks[path] {
+				if err := check(v); err != nil {
+					fs.ViolationCount++
+					if len(fs.Violations) < maxQualitySamples {
+						fs.Violations = append(fs.Violations, FieldViolation{
+							FieldSample: FieldSample{Resource: rec.Resource, Value: v},
+							Error:       err.Error(),
+						})
+					}
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fs := range fields {
+		fs.Missing = report.Records - fs.Present
+		if report.Records > 0 {
+			fs.NullRate = float64(fs.Missing+fs.Nulls) / float64(report.Records)
+		}
+		// integers are numbers too, so they don't make a field inconsistent
+		kinds := 0
+		for t := range fs.Types {
+			if t != "integer" || fs.Types["number"] == 0 {
+				kinds++
+			}
+		}
+		fs.Inconsistent = kinds > 1
+		fs.Numeric, fs.Outliers = numericStats(fs.numbers)
+		report.Fields = append(report.Fields, fs.FieldQuality)
+	}
+	sort.Slice(report.Fields, func(i, j int) bool { return report.Fields[i].Path < report.Fields[j].Path })
+	return report, nil
+}
+
+// numericStats summarises numeric samples and picks out Tukey outliers
+func numericStats(samples []FieldSample) (*NumericStats, []FieldSample) {
+	if len(samples) == 0 {
+		return nil, nil
+	}
+
+	values := make([]float64, 0, len(samples))
+	kept := samples[:0:0]
+	for _, s := range samples {
+		f, err := strconv.ParseFloat(s.Value.(json.Number).String(), 64)
+		if err != nil {
+			continue
+		}
+		values = append(values, f)
+		kept = append(kept, s)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	st := &NumericStats{Count: len(values), Min: values[0], Max: values[0]}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+		st.Min = math.Min(st.Min, v)
+		st.Max = math.Max(st.Max, v)
+	}
+	st.Mean = sum / float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - st.Mean) * (v - st.Mean)
+	}
+	st.StdDev = math.Sqrt(variance / float64(len(values)))
+
+	// quartiles need a handful of values to mean anything
+	if len(values) < 4 {
+		return st, nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	q1, q3 := quantile(sorted, 0.25), quantile(sorted, 0.75)
+	lo, hi := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+
+	var outliers []FieldSample
+	for i, v := range values {
+		if (v < lo || v > hi) && len(outliers) < maxQualitySamples {
+			outliers = append(outliers, kept[i])
+		}
+	}
+	return st, outliers
+}
+
+// quantile interpolates the q-th quantile of sorted values
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// --- QUERIES ---
+
+// Record is a stored document together with its resource name and metadata
+type Record struct {
+	Resource string
+	Data     []byte
+	Meta     *Meta
+	Version  int // schema version stamp, 0 when unversioned
+	dict     int // compression dictionary of the file, 0 for none
+
+	doc      interface{}
+	raw      []byte // the file as stored
+	upgraded []byte // the document to store back after an upgrade on read
+}
+
+// Decode unmarshals the record's document into v
+func (r *Record) Decode(v interface{}) error {
+	return json.Unmarshal(r.Data, v)
+}
+
+// Document returns the record decoded generically, caching the result
+func (r *Record) Document() (interface{}, error) {
+	if r.doc == nil {
+		doc, err := decodeDocument(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		r.doc = doc
+	}
+	return r.doc, nil
+}
+
+// Field returns the value at a dot separated path in the document. Until
+// the document is decoded for some other reason, only the value at path is
+// picked out of the raw JSON and decoded, which is what filters scanning a
+// collection mostly need.
+func (r *Record) Field(path string) (interface{}, bool) {
+	if r.doc == nil {
+		raw, ok := extractPath(r.Data, path)
+		if !ok {
+			return nil, false
+		}
+		v, err := decodeDocument(raw)
+		return v, err == nil
+	}
+	doc, err := r.Document()
+	if err != nil {
+		return nil, false
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupPath(obj, path)
+}
+
+// Filter selects records in Find
+type Filter interface {
+	Match(r *Record) bool
+}
+
+// FilterFunc adapts an ordinary function to a Filter
+type FilterFunc func(r *Record) bool
+
+func (f FilterFunc) Match(r *Record) bool { return f(r) }
+
+// Equal matches records whose field at path equals value. Numbers compare by
+// value, so Equal("age", 23) matches both 23 and 23.0.
+func Equal(path string, value interface{}) Filter {
+	want, err := toDocument(value)
+	if err != nil {
+		return FilterFunc(func(*Record) bool { return false })
+	}
+	return &fieldCondition{
+		path:   path,
+		test:   func(v interface{}, present bool) bool { return present && jsonEqual(v, want) },
+		values: lookupValues([]interface{}{want}),
+	}
+}
+
+// And matches records matched by every filter
+func And(filters ...Filter) Filter {
+	return allOf(filters)
+}
+
+// fieldCondition matches records whose field at path passes test. The
+// query planner answers it from an index of the field.
+type fieldCondition struct {
+	path string
+	test func(v interface{}, present bool) bool
+	// values, when set, are the only ones the field can hold for test to
+	// pass, barring arrays holding one of them
+	values []interface{}
+}
+
+func (c *fieldCondition) Match(r *Record) bool {
+	v, ok := r.Field(c.path)
+	return c.test(v, ok)
+}
+
+// allOf matches records matched by every filter
+type allOf []Filter
+
+func (a allOf) Match(r *Record) bool {
+	for _, f := range a {
+		if !f.Match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdatedSince matches records whose metadata shows a write at or after t.
+// Records stored without metadata never match.
+func UpdatedSince(t time.Time) Filter {
+	return FilterFunc(func(r *Record) bool {
+		return r.Meta != nil && !r.Meta.UpdatedAt.Before(t)
+	})
+}
+
+// CreatedSince matches records first written at or after t
+func CreatedSince(t time.Time) Filter {
+	return FilterFunc(func(r *Record) bool {
+		return r.Meta != nil && !r.Meta.CreatedAt.Before(t)
+	})
+}
+
+// Find returns the records in a collection matching filter. A nil filter
+// matches everything.
+func (d *Driver) Find(collection string, filter Filter, opts ...ReadOption) ([]Record, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+	p.guard = d.readGuard(p.ctx, collection)
+	p.trace = d.startSpan(p.ctx, "Find", collection, "")
+	out, err := d.find(collection, filter, p)
+	p.trace.returned(len(out))
+	p.trace.end(err)
+	return out, err
+}
+
+func (d *Driver) find(collection string, filter Filter, p readParams) ([]Record, error) {
+	var out []Record
+	err := d.scanPlanned(collection, filter, PriorityFrom(p.ctx), func(rec *Record) error {
+		if p.guard != nil && !p.guard.Match(rec) {
+			return nil
+		}
+		if filter == nil || filter.Match(rec) {
+			p.trace.addBytes(len(rec.Data))
+			if len(p.fields) > 0 && len(p.populate) == 0 {
+				if err := project(rec, p.fields); err != nil {
+					return err
+				}
+			}
+			out = append(out, *rec)
+		}
+		return nil
+	})
+	if err != nil || len(p.populate) == 0 {
+		return out, err
+	}
+
+	// resolved after the scan, whose lock mustn't be held reading others
+	recs := make([]*Record, len(out))
+	for i := range out {
+		recs[i] = &out[i]
+	}
+	if err := d.populate(collection, recs, p.populate); err != nil {
+		return nil, err
+	}
+	for _, rec := range recs {
+		if len(p.fields) > 0 {
+			if err := project(rec, p.fields); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// --- QUOTAS ---
+
+// ErrQuotaExceeded is returned (wrapped) by a write that would take a
+// document, a collection or the database over its limit
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quotas limit what a Driver stores. Zero fields set no limit. System
+// collections are not limited.
+type Quotas struct {
+	// MaxDocumentSize is the most bytes a document's JSON encoding may
+	// take. CollectionOptions.MaxDocumentSize overrides it.
+	MaxDocumentSize int64
+	// MaxRecords is the most records a collection may hold.
+	// CollectionOptions.MaxRecords overrides it.
+	MaxRecords int
+	// MaxBytes is the most bytes the record files of all collections may
+	// take together
+	MaxBytes int64
+}
+
+// WithQuotas makes writes that would exceed q fail with an error wrapping
+// ErrQuotaExceeded. Updates of existing records only count what they add.
+// Usage is measured on first need, by listing the collections concerned,
+// and kept up to date by the Driver's own writes and deletes.
+func WithQuotas(q Quotas) Option {
+	return func(o *options) { o.quotas = q }
+}
+
+// usage is what the Driver has measured of its stored records, for quotas
+type usage struct {
+	mu         sync.Mutex
+	bytesKnown bool
+	bytes      int64
+	records    map[string]int // live records per collection, where counted
+}
+
+// maxDocumentSize is the document size limit of a collection
+func (d *Driver) maxDocumentSize(cfg *collectionConfig) int64 {
+	if cfg.maxSize > 0 {
+		return cfg.maxSize
+	}
+	return d.opts.quotas.MaxDocumentSize
+}
+
+// maxRecords is the record count limit of a collection
+func (d *Driver) maxRecords(cfg *collectionConfig) int {
+	if cfg.maxRecords > 0 {
+		return cfg.maxRecords
+	}
+	return d.opts.quotas.MaxRecords
+}
+
+// tracksUsage reports whether writes to collection need measuring
+func (d *Driver) tracksUsage(collection string) bool {
+	if isSystemCollection(collection) {
+		return false
+	}
+	return d.opts.quotas.MaxBytes > 0 || d.opts.quotas.MaxRecords > 0 || d.snapshotConfig(collection).maxRecords > 0
+}
+
+// checkQuota fails when writing the document raw to the record at path
+// would exceed a quota. Callers must hold the collection's write lock.
+func (d *Driver) checkQuota(collection, path string, cfg *collectionConfig, raw json.RawMessage) error {
+	if isSystemCollection(collection) {
+		return nil
+	}
+	maxRecords, maxBytes := d.maxRecords(cfg), d.opts.quotas.MaxBytes
+	if maxRecords == 0 && maxBytes == 0 {
+		return nil
+	}
+	var old int64
+	info, err := d.fs.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	exists := err == nil
+	if exists {
+		old = info.Size()
+	}
+
+	if maxRecords > 0 && !exists {
+		n, err := d.recordCount(collection)
+		if err != nil {
+			return err
+		}
+		if n >= maxRecords {
+			return fmt.Errorf("%w: %s already holds %d records, the most allowed", ErrQuotaExceeded, collection, n)
+		}
+	}
+	if maxBytes == 0 {
+		return nil
+	}
+	size, err := d.storedSize(collection, path, cfg, raw)
+	if err != nil {
+		return err
+	}
+	if size > old {
+		used, err := d.usedBytes()
+		if err != nil {
+			return err
+		}
+		if used+size-old > maxBytes {
+			return fmt.Errorf("%w: the database would take %d bytes, over the limit of %d", ErrQuotaExceeded, used+size-old, maxBytes)
+		}
+	}
+	return nil
+}
+
+// storedSize is the size of the record file at path once raw is written
+// to it, encoded as writeLive does, so that it compares with the sizes of
+// the files on disk. Callers must hold the collection's write lock.
+func (d *Driver) storedSize(collection, path string, cfg *collectionConfig, raw json.RawMessage) (int64, error) {
+	s, err := d.loadSequence(collection)
+	if err != nil {
+		return 0, err
+	}
+	buf, err := d.encodeIndented(d.wrapEnvelope(collection, path, cfg.version, s.next, raw, cfg))
+	if err != nil {
+		return 0, err
+	}
+	defer putBuffer(buf)
+	data, err := d.encodeFile(collection, cfg, buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// recordCount is the number of live records of collection, counted on
+// first use. Callers must hold the collection's write lock.
+func (d *Driver) recordCount(collection string) (int, error) {
+	u := &d.usage
+	u.mu.Lock()
+	n, ok := u.records[collection]
+	u.mu.Unlock()
+	if ok {
+		return n, nil
+	}
+
+	names, err := d.recordNames(collection)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.records == nil {
+		u.records = make(map[string]int)
+	}
+	u.records[collection] = len(names)
+	return len(names), nil
+}
+
+// usedBytes is the size of every record file of the user collections,
+// measured on first use
+func (d *Driver) usedBytes() (int64, error) {
+	u := &d.usage
+	u.mu.Lock()
+	if u.bytesKnown {
+		defer u.mu.Unlock()
+		return u.bytes, nil
+	}
+	u.mu.Unlock()
+
+	var total int64
+	for _, vol := range d.volumes {
+		n, err := d.treeBytes(vol, true)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes, u.bytesKnown = total, true
+	return total, nil
+}
+
+// treeBytes adds up the record files under dir, skipping the system
+// collections and the engine's own files and directories
+func (d *Driver) treeBytes(dir string, root bool) (int64, error) {
+	entries, err := d.fs.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasPrefix(name, ".") && !(e.IsDir() && isShardDir(name)):
+		case e.IsDir():
+			if root && name+"/" == systemPrefix {
+				continue
+			}
+			n, err := d.treeBytes(filepath.Join(dir, name), false)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		case !root:
+			info, err := e.Info()
+			if err != nil {
+				return 0, err
+			}
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
+
+// trackWrite records that a record file of collection went from old bytes,
+// or not existing, to size bytes
+func (d *Driver) trackWrite(collection string, existed bool, old, size int64) {
+	u := &d.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes += size - old
+	if n, ok := u.records[collection]; ok && !existed {
+		u.records[collection] = n + 1
+	}
+}
+
+// trackRemove records that a record file of collection of size bytes was
+// removed
+func (d *Driver) trackRemove(collection string, size int64) {
+	u := &d.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes -= size
+	if n, ok := u.records[collection]; ok {
+		u.records[collection] = n - 1
+	}
+}
+
+// forgetUsage drops what was measured of collection after its files
+// changed behind the Driver's back, as by a restore
+func (d *Driver) forgetUsage(collection string) {
+	u := &d.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.records, collection)
+	u.bytesKnown = false
+}
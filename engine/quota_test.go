@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordBytes is the size of the record files of collection on disk
+func recordBytes(t *testing.T, dir, collection string) int64 {
+	t.Helper()
+	var n int64
+	err := filepath.WalkDir(filepath.Join(dir, collection), func(path string, e os.DirEntry, err error) error {
+		if err != nil || e.IsDir() || e.Name() == collectionOptionsFile || strings.HasPrefix(e.Name(), ".") {
+			return err
+		}
+		info, err := e.Info()
+		if err == nil {
+			n += info.Size()
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMaxBytesCountsStoredSize(t *testing.T) {
+	const limit = 600
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"plain", nil},
+		{"metadata", []Option{WithMetadata()}},
+		{"indented", []Option{WithIndent("", "        ")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d, err := New(dir, append(tt.opts, WithQuotas(Quotas{MaxBytes: limit}))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			// a record growing one field at a time until the quota stops it
+			var refused bool
+			doc := map[string]string{}
+			for i := 0; i < 100 && !refused; i++ {
+				doc[fmt.Sprint("k", i)] = "v"
+				err := d.Write("docs", "a", doc)
+				if errors.Is(err, ErrQuotaExceeded) {
+					refused = true
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if n := recordBytes(t, dir, "docs"); n > limit {
+					t.Fatalf("the records take %d bytes on disk, over the limit of %d", n, limit)
+				}
+			}
+			if !refused {
+				t.Fatal("the quota never refused a write")
+			}
+			if err := d.Write("docs", "a", map[string]string{"k": "v"}); err != nil {
+				t.Errorf("shrinking the record: %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxRecords(t *testing.T) {
+	d := openTest(t, WithQuotas(Quotas{MaxRecords: 2}))
+	tests := []struct {
+		resource string
+		want     error
+	}{
+		{"a", nil},
+		{"b", nil},
+		{"c", ErrQuotaExceeded},
+		{"a", nil}, // updates add no record
+	}
+	for _, tt := range tests {
+		err := d.Write("docs", tt.resource, map[string]int{"n": 1})
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("writing %s: %v, want %v", tt.resource, err, tt.want)
+		}
+	}
+	if err := d.Delete("docs", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("docs", "c", map[string]int{"n": 1}); err != nil {
+		t.Errorf("writing c after a delete: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+)
+
+// --- REFERENCES ---
+
+// ReadOption adjusts a single Read or Find call
+type ReadOption func(*readParams)
+
+type readParams struct {
+	populate []string
+	fields   []string
+	// ctx holds the parent of the call's span, trace the span if traced
+	ctx   context.Context
+	trace *opTrace
+	// guard is the read policy bound to the caller, nil for none
+	guard Filter
+}
+
+func newReadParams(opts []ReadOption) readParams {
+	var p readParams
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return p
+}
+
+// Populate makes Read and Find replace the reference fields at the given
+// paths, declared with Reference, with the documents they refer to. A field
+// holding an array of names is replaced by an array of documents. Names
+// whose record doesn't exist become null; records lacking the field are
+// left alone.
+func Populate(paths ...string) ReadOption {
+	return func(p *readParams) { p.populate = append(p.populate, paths...) }
+}
+
+// Reference declares that the value at the (dot separated) path of
+// documents in collection names a record of target, as an order's userID
+// names a user, so that Read and Find can resolve it with Populate.
+func (d *Driver) Reference(collection, path, target string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	refs := maps.Clone(cfg.refs)
+	if refs == nil {
+		refs = make(map[string]string)
+	}
+	refs[path] = target
+	cfg.refs = refs
+}
+
+// populate resolves the references at paths in records of collection. Each
+// referenced record is read once however many records refer to it.
+func (d *Driver) populate(collection string, records []*Record, paths []string) error {
+	refs := d.snapshotConfig(collection).refs
+	for _, path := range paths {
+		target, ok := refs[path]
+		if !ok {
+			return fmt.Errorf("%s of collection %s is not a reference", path, collection)
+		}
+		if err := validateCollection(target); err != nil {
+			return err
+		}
+	}
+
+	docs := make([]map[string]interface{}, len(records))
+	for i, rec := range records {
+		doc, err := rec.Document()
+		if err != nil {
+			return err
+		}
+		docs[i], _ = doc.(map[string]interface{})
+	}
+
+	for _, path := range paths {
+		target := refs[path]
+		resolved := make(map[string]interface{})
+		resolve := func(v interface{}) (interface{}, error) {
+			name, ok := v.(string)
+			if !ok || validateName("resource", name) != nil {
+				return nil, nil
+			}
+			if doc, ok := resolved[name]; ok {
+				return doc, nil
+			}
+			var doc interface{}
+			rec, err := d.readRecord(target, name)
+			if err == nil {
+				doc, err = rec.Document()
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			resolved[name] = doc
+			return doc, nil
+		}
+
+		for _, obj := range docs {
+			v, ok := lookupPath(obj, path)
+			if !ok {
+				continue
+			}
+			var err error
+			if names, isList := v.([]interface{}); isList {
+				out := make([]interface{}, len(names))
+				for i, name := range names {
+					if out[i], err = resolve(name); err != nil {
+						return err
+					}
+				}
+				v = out
+			} else if v, err = resolve(v); err != nil {
+				return err
+			}
+			setPath(obj, path, v)
+		}
+	}
+
+	for i, rec := range records {
+		if docs[i] == nil {
+			continue
+		}
+		b, err := json.Marshal(docs[i])
+		if err != nil {
+			return err
+		}
+		rec.Data = b
+		rec.doc = docs[i]
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+)
+
+// --- COLLECTION OPTIONS ---
+
+// collectionOptionsFile holds, in a collection directory, the options set
+// with SetCollectionOptions. Without a .json extension it is never taken
+// for a record.
+const collectionOptionsFile = "_meta"
+
+// CollectionOptions are settings of a collection kept with its data, so
+// they apply to every Driver opening the database without each caller
+// registering them again. Each setting has the effect of the call named
+// next to it.
+type CollectionOptions struct {
+	Schema        *JSONSchema       `json:"schema,omitempty"`        // SetSchema
+	SchemaVersion int               `json:"schemaVersion,omitempty"` // SetSchemaVersion
+	Unique        []string          `json:"unique,omitempty"`        // UniqueField
+	Search        []string          `json:"search,omitempty"`        // SearchField
+	Columns       []string          `json:"columns,omitempty"`       // ColumnField
+	Distinct      []string          `json:"distinct,omitempty"`      // DistinctField
+	References    map[string]string `json:"references,omitempty"`    // Reference
+	History       bool              `json:"history,omitempty"`       // KeepHistory
+	Bitemporal    bool              `json:"bitemporal,omitempty"`    // EnableBitemporal
+	// MaxDocumentSize and MaxRecords, when positive, override the
+	// Driver's Quotas for the collection
+	MaxDocumentSize int64 `json:"maxDocumentSize,omitempty"`
+	MaxRecords      int   `json:"maxRecords,omitempty"`
+	// Codec is the Extension of the codec the records are stored with, as
+	// SetCodec sets it; every Driver opening the database must be given
+	// the codec, with WithCodec or WithCodecs
+	Codec string `json:"codec,omitempty"`
+	// Dictionary, when positive, is the ID of the compression dictionary
+	// writes use in place of the latest TrainDictionary built; -1 leaves
+	// them uncompressed
+	Dictionary int `json:"dictionary,omitempty"`
+}
+
+// SetCollectionOptions stores opts as the options of collection, replacing
+// those stored before, and applies them. New applies the stored options of
+// every top-level collection when the database is opened; a sub-collection's
+// are applied when set, and by CollectionOptions. Settings registered
+// through the individual calls add to the stored ones, and stay in effect
+// until the Driver is closed even when the stored options drop them.
+func (d *Driver) SetCollectionOptions(collection string, opts CollectionOptions) error {
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	if isSystemCollection(collection) {
+		return fmt.Errorf("%s: options can't be set on system collections", collection)
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+	if err := d.checkCollectionOptions(collection, opts); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(opts, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	dir := d.collectionDir(collection)
+	if err := d.fs.MkdirAll(dir); err != nil {
+		return err
+	}
+	if err := d.fs.WriteFile(filepath.Join(dir, collectionOptionsFile), b, d.opts.durability); err != nil {
+		return err
+	}
+	d.applyCollectionOptions(collection, opts)
+	return nil
+}
+
+// CollectionOptions returns the options stored for collection, the zero
+// value when none are, and applies them
+func (d *Driver) CollectionOptions(collection string) (CollectionOptions, error) {
+	if err := validateCollection(collection); err != nil {
+		return CollectionOptions{}, err
+	}
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return CollectionOptions{}, err
+	}
+	defer release()
+	return d.loadCollectionOptions(collection)
+}
+
+// loadCollectionOptions reads and applies the stored options of
+// collection. Callers must hold the collection lock, or have it to
+// themselves as New does.
+func (d *Driver) loadCollectionOptions(collection string) (CollectionOptions, error) {
+	var opts CollectionOptions
+	b, err := d.fs.ReadFile(filepath.Join(d.collectionDir(collection), collectionOptionsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return opts, nil
+	}
+	if err != nil {
+		return opts, err
+	}
+	if err := json.Unmarshal(b, &opts); err != nil {
+		return opts, fmt.Errorf("%s options: %w", collection, err)
+	}
+	if err := d.checkCollectionOptions(collection, opts); err != nil {
+		return opts, fmt.Errorf("%s options: %w", collection, err)
+	}
+	d.applyCollectionOptions(collection, opts)
+	return opts, nil
+}
+
+// checkCollectionOptions fails for options that can't be applied: a schema
+// that doesn't compile, a codec the Driver wasn't given, or a dictionary
+// that wasn't trained
+func (d *Driver) checkCollectionOptions(collection string, opts CollectionOptions) error {
+	if opts.Schema != nil {
+		if err := opts.Schema.compile(); err != nil {
+			return err
+		}
+	}
+	if opts.Codec != "" {
+		if _, err := d.codecNamed(opts.Codec); err != nil {
+			return err
+		}
+	}
+	switch {
+	case opts.Dictionary < -1:
+		return fmt.Errorf("dictionary %d: want an ID, 0 or -1", opts.Dictionary)
+	case opts.Dictionary > 0 && opts.Codec != "":
+		return fmt.Errorf("dictionary %d: only plain JSON collections can be compressed", opts.Dictionary)
+	case opts.Dictionary > 0:
+		if _, err := d.fs.Stat(d.dictionaryPath(collection, opts.Dictionary)); err != nil {
+			return fmt.Errorf("compression dictionary %d: %w", opts.Dictionary, err)
+		}
+	}
+	return nil
+}
+
+// loadAllCollectionOptions applies the stored options of every top-level
+// collection
+func (d *Driver) loadAllCollectionOptions() error {
+	collections, err := d.storedCollections()
+	if err != nil {
+		return err
+	}
+	for _, c := range collections {
+		if isSystemCollection(c) {
+			continue
+		}
+		if _, err := d.loadCollectionOptions(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyCollectionOptions registers opts on collection
+func (d *Driver) applyCollectionOptions(collection string, opts CollectionOptions) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	if opts.Schema != nil {
+		cfg.schema = opts.Schema
+	}
+	if opts.SchemaVersion != 0 {
+		cfg.version = opts.SchemaVersion
+	}
+	cfg.unique = addFields(cfg.unique, opts.Unique)
+	cfg.search = addFields(cfg.search, opts.Search)
+	cfg.columns = addFields(cfg.columns, opts.Columns)
+	cfg.distinct = addFields(cfg.distinct, opts.Distinct)
+	if len(opts.References) > 0 {
+		refs := maps.Clone(cfg.refs)
+		if refs == nil {
+			refs = make(map[string]string)
+		}
+		maps.Copy(refs, opts.References)
+		cfg.refs = refs
+	}
+	cfg.history = cfg.history || opts.History || opts.Bitemporal
+	cfg.bitemporal = cfg.bitemporal || opts.Bitemporal
+	cfg.maxSize = opts.MaxDocumentSize
+	cfg.maxRecords = opts.MaxRecords
+	if c, err := d.codecNamed(opts.Codec); opts.Codec != "" && err == nil {
+		cfg.codec = c
+	}
+	cfg.dictionary = opts.Dictionary
+}
+
+// addFields returns fields with those of more it lacks appended, leaving
+// fields itself untouched for the config snapshots sharing it
+func addFields(fields, more []string) []string {
+	out := fields
+	for _, f := range more {
+		if !slices.Contains(out, f) {
+			if len(out) == len(fields) {
+				out = slices.Clone(fields)
+			}
+			out = append(out, f)
+		}
+	}
+	return out
+}
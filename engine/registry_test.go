@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCollectionOptionsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	sealed, err := EncryptedJSON(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := openAt(t, dir, WithCodecs(sealed))
+	for i := 0; i < 4; i++ {
+		doc := map[string]interface{}{"name": fmt.Sprint("user", i), "email": fmt.Sprint("user", i, "@example.com")}
+		if err := d.Write("docs", fmt.Sprint(i), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.TrainDictionary("docs", DictionaryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := map[string]CollectionOptions{
+		"docs": {
+			SchemaVersion:   2,
+			Unique:          []string{"email"},
+			Search:          []string{"name"},
+			References:      map[string]string{"owner": "users"},
+			History:         true,
+			MaxDocumentSize: 1 << 10,
+			MaxRecords:      10,
+			Dictionary:      1,
+		},
+		"secrets": {Codec: sealed.Extension()},
+	}
+	for collection, opts := range stored {
+		if err := d.SetCollectionOptions(collection, opts); err != nil {
+			t.Fatalf("%s: %v", collection, err)
+		}
+	}
+	if err := d.SetCollectionOptions("other", CollectionOptions{Codec: ".pb"}); err == nil {
+		t.Error("options naming a codec the Driver wasn't given were stored")
+	}
+	if err := d.SetCollectionOptions("other", CollectionOptions{Dictionary: 7}); err == nil {
+		t.Error("options naming a dictionary never trained were stored")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(dir); err == nil {
+		t.Error("opening without the stored codec succeeded")
+	}
+	d = openAt(t, dir, WithCodecs(sealed))
+	for collection, want := range stored {
+		if got, err := d.CollectionOptions(collection); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s options after reopening = %+v, %v, want %+v", collection, got, err, want)
+		}
+	}
+
+	// both apply to the reopened database: the pinned dictionary over one
+	// trained since, the codec without SetCodec being called again
+	if _, err := d.TrainDictionary("docs", DictionaryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("docs", "new", map[string]string{"name": "user9", "email": "user9@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "docs", "new.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := fileDictionary(b); id != 1 {
+		t.Errorf("docs/new compressed with dictionary %d, want the pinned 1", id)
+	}
+	if err := d.Write("secrets", "a", map[string]string{"pin": "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secrets", "a"+sealed.Extension())); err != nil {
+		t.Errorf("secrets/a wasn't stored with the codec: %v", err)
+	}
+}
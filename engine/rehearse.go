@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- INVARIANTS AND RESTORE REHEARSAL ---
+
+// Violation is a broken invariant found by CheckInvariants
+type Violation struct {
+	Collection string `json:"collection"`
+	Resource   string `json:"resource,omitempty"`
+	Problem    string `json:"problem"`
+}
+
+func (v Violation) String() string {
+	if v.Resource == "" {
+		return v.Collection + ": " + v.Problem
+	}
+	return v.Collection + "/" + v.Resource + ": " + v.Problem
+}
+
+// CheckInvariants reads every file of collection and reports what the
+// engine would trip over: records that don't decode, current records that
+// fail the collection's required fields, validators or schema, records
+// sharing a unique key, and history or trash entries that don't decode.
+// The error is only for failing to read the collection at all.
+func (d *Driver) CheckInvariants(collection string) ([]Violation, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return d.checkInvariants(collection)
+}
+
+// checkInvariants does the work of CheckInvariants. Callers must hold the
+// collection lock.
+func (d *Driver) checkInvariants(collection string) ([]Violation, error) {
+	dir := d.collectionDir(collection)
+	cfg := d.snapshotConfig(collection)
+	files, err := d.recordFiles(collection, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Violation
+	report := func(resource, format string, args ...interface{}) {
+		out = append(out, Violation{Collection: collection, Resource: resource, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	owners := make(map[string]map[string]string, len(cfg.unique))
+	for _, f := range cfg.unique {
+		owners[f] = make(map[string]string)
+	}
+	for _, file := range files {
+		resource := file.resource
+		b, err := d.readLive(collection, file.path, cfg)
+		if err != nil {
+			return nil, err
+		}
+		rec, err := decodeRecord(resource, b)
+		if err != nil {
+			report(resource, "envelope: %v", err)
+			continue
+		}
+		if !json.Valid(rec.Data) {
+			report(resource, "document is not valid JSON")
+			continue
+		}
+		if rec.Version >= cfg.version {
+			if err := d.validate(collection, json.RawMessage(rec.Data)); err != nil {
+				report(resource, "%v", err)
+			}
+		}
+
+		if len(cfg.unique) == 0 {
+			continue
+		}
+		doc, err := rec.Document()
+		if err != nil {
+			report(resource, "%v", err)
+			continue
+		}
+		for field, key := range uniqueKeys(cfg.unique, doc) {
+			if other, taken := owners[field][key]; taken {
+				report(resource, "%s is also held by %q", field, other)
+				continue
+			}
+			owners[field][key] = resource
+		}
+	}
+
+	if dirs, err := d.fs.ReadDir(filepath.Join(dir, historyDir)); err == nil {
+		for _, h := range dirs {
+			if !h.IsDir() {
+				continue
+			}
+			if _, err := d.loadHistory(collection, h.Name()); err != nil {
+				report(h.Name(), "%v", err)
+			}
+		}
+	}
+	if _, err := d.readTrash(collection, nil); err != nil {
+		report("", "%v", err)
+	}
+	return out, nil
+}
+
+// RehearsalReport is the outcome of RehearseRestore
+type RehearsalReport struct {
+	Manifest    *Manifest             `json:"manifest"`
+	Collections []RehearsedCollection `json:"collections"`
+	Violations  []Violation           `json:"violations,omitempty"`
+}
+
+// RehearsedCollection compares the records restored for a collection with
+// the count in the manifest
+type RehearsedCollection struct {
+	Name     string `json:"name"`
+	Expected int    `json:"expected"`
+	Restored int    `json:"restored"`
+}
+
+// OK reports whether the rehearsal found nothing wrong
+func (r *RehearsalReport) OK() bool {
+	for _, c := range r.Collections {
+		if c.Expected != c.Restored {
+			return false
+		}
+	}
+	return len(r.Violations) == 0
+}
+
+// RehearseRestore restores a backup archive into a temporary directory
+// instead of the database, checks the restored collections with the
+// Driver's collection settings as CheckInvariants does, and compares their
+// record counts with the manifest. The live data is never touched. The
+// error is for archives that can't be restored at all; problems found in
+// a restorable archive are in the report.
+func (d *Driver) RehearseRestore(r io.Reader, opts ArchiveOptions) (*RehearsalReport, error) {
+	if err := d.life.enter(); err != nil {
+		return nil, err
+	}
+	defer d.life.leave()
+
+	staging, err := os.MkdirTemp("", "rehearsal-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := extractArchive(r, staging, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: archive has no manifest", ErrCorruptBackup)
+	}
+
+	scratch, err := New(staging)
+	if err != nil {
+		return nil, err
+	}
+	defer scratch.Close()
+	scratch.opts = d.opts
+	d.mutex.Lock()
+	for name, cfg := range d.collections {
+		cp := *cfg
+		scratch.collections[name] = &cp
+	}
+	d.mutex.Unlock()
+
+	report := &RehearsalReport{Manifest: manifest}
+	for _, cm := range manifest.Collections {
+		restored, err := countRecords(filepath.Join(staging, cm.Name))
+		if err != nil {
+			return nil, err
+		}
+		report.Collections = append(report.Collections, RehearsedCollection{Name: cm.Name, Expected: cm.Records, Restored: restored})
+
+		if isSystemCollection(cm.Name) {
+			continue
+		}
+		violations, err := scratch.CheckInvariants(cm.Name)
+		if err != nil {
+			return nil, err
+		}
+		report.Violations = append(report.Violations, violations...)
+	}
+	sort.Slice(report.Collections, func(i, j int) bool { return report.Collections[i].Name < report.Collections[j].Name })
+	d.opts.logger.Info("restore rehearsed", "collections", len(report.Collections), "violations", len(report.Violations), "ok", report.OK())
+	return report, nil
+}
+
+// countRecords counts the record files directly inside a collection
+// directory, or inside its shards
+func countRecords(dir string) (int, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, file := range files {
+		if file.IsDir() && isShardDir(file.Name()) {
+			in, err := countRecords(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return 0, err
+			}
+			n += in
+			continue
+		}
+		if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+			n++
+		}
+	}
+	return n, nil
+}
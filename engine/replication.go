@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// --- REPLICATION ---
+
+// ErrReadOnly is returned (possibly wrapped) by every change attempted on a
+// replica other than through Apply and Resync, and by every change at all
+// on a Driver opened with ReadOnly
+var ErrReadOnly = errors.New("database is read-only")
+
+// ErrResyncNeeded is returned by WatchFrom when the feed can't be resumed
+// where asked
+var ErrResyncNeeded = errors.New("change feed can't be resumed, resync needed")
+
+// ReplicationCollection holds the feed position a replica has applied
+const ReplicationCollection = systemPrefix + "replication"
+
+// replicaSaveEvery bounds how often Apply records the replica's position
+// on disk. Changes applied since the last save are applied again after a
+// crash, which is harmless.
+const replicaSaveEvery = time.Second
+
+// AsReplica opens the Driver as a replica of another, fed through Apply
+// and Resync: every other write, delete, restore or import fails with
+// ErrReadOnly. The position reached in the primary's feed is kept in the
+// _system/replication collection across restarts.
+func AsReplica() Option {
+	return func(o *options) { o.replica = true }
+}
+
+// ReadOnly opens the Driver for reading only, as for analytics or a replica
+// run against a directory another process owns: it creates no directory,
+// the database must exist, and every change, Apply and Resync included,
+// fails with an error wrapping ErrReadOnly. Temporary files, such as
+// those of backups, go to the system's temporary directory. Changes made by
+// the owning process show once the Driver's caches and indexes catch up,
+// so keep WithCache off for fresh reads.
+func ReadOnly() Option {
+	return func(o *options) { o.readOnly = true }
+}
+
+// WithChangeBacklog keeps at least the latest n changes in memory so that
+// WatchFrom can resume a feed, typically a replica's, that was briefly
+// cut off. Without it every resumption needs a resync.
+func WithChangeBacklog(n int) Option {
+	return func(o *options) { o.changeBacklog = n }
+}
+
+// replicaState is where a replica stands in its primary's feed
+type replicaState struct {
+	mu    sync.Mutex
+	pos   FeedPosition
+	saved time.Time
+}
+
+// writable fails with ErrReadOnly on a replica or a read-only Driver
+func (d *Driver) writable() error {
+	if d.opts.readOnly {
+		return fmt.Errorf("%w: opened with ReadOnly", ErrReadOnly)
+	}
+	if d.opts.replica {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// Apply makes a change read from another Driver's feed, as a replica does:
+// the document is stored exactly as the primary stored it, without hooks,
+// validation or unique checks, which the primary already ran. Deleting a
+// record that is already gone succeeds, so changes may be applied more
+// than once. The change's position becomes the replica's position.
+func (d *Driver) Apply(c Change) error {
+	if err := validateNames(c.Collection, c.Resource); err != nil {
+		return err
+	}
+	if d.opts.readOnly {
+		return d.writable()
+	}
+
+	p := writeParams{replicated: true}
+	switch c.Kind {
+	case OpWrite:
+		if _, err := d.store(c.Collection, c.Resource, c.Document, p); err != nil {
+			return err
+		}
+		if len(d.snapshotConfig(c.Collection).unique) > 0 {
+			d.invalidateUnique(c.Collection)
+		}
+	case OpDelete:
+		if err := d.remove(c.Collection, c.Resource, p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown change kind %v", c.Kind)
+	}
+
+	r := &d.replica
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pos = FeedPosition{Epoch: c.Epoch, Seq: c.Seq}
+	if time.Since(r.saved) >= replicaSaveEvery {
+		return d.saveReplicaPosition()
+	}
+	return nil
+}
+
+// Resync replaces the contents of the Driver with a backup archive of the
+// primary, as written by BackupArchive, and makes at the replica's
+// position. at must be the primary's FeedPosition from before the backup
+// started: the changes made while it was taken are then applied again,
+// which converges on the same data since every change carries the whole
+// document.
+func (d *Driver) Resync(r io.Reader, opts ArchiveOptions, at FeedPosition) error {
+	if d.opts.readOnly {
+		return d.writable()
+	}
+	staging, err := os.MkdirTemp(d.scratchDir(), ".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	if _, err := extractArchive(r, staging, opts.Key); err != nil {
+		return err
+	}
+	if err := d.restore(staging); err != nil {
+		return err
+	}
+
+	rs := &d.replica
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.pos = at
+	d.opts.logger.Info("replica resynced", "epoch", at.Epoch, "seq", at.Seq)
+	return d.saveReplicaPosition()
+}
+
+// ReplicaPosition is the position in the primary's feed of the latest
+// change applied to this replica; the zero value when it has none
+func (d *Driver) ReplicaPosition() FeedPosition {
+	d.replica.mu.Lock()
+	defer d.replica.mu.Unlock()
+	return d.replica.pos
+}
+
+// saveReplicaPosition records the replica's position. It writes the file
+// directly, as it also runs on close, when the collection can no longer be
+// locked; callers must hold d.replica.mu, which orders the saves.
+func (d *Driver) saveReplicaPosition() error {
+	b, err := json.Marshal(d.replica.pos)
+	if err != nil {
+		return err
+	}
+	if err := d.fs.WriteFile(d.replicaPositionPath(), b, d.opts.durability); err != nil {
+		return err
+	}
+	d.replica.saved = time.Now()
+	return nil
+}
+
+func (d *Driver) replicaPositionPath() string {
+	return filepath.Join(d.collectionDir(ReplicationCollection), "position.json")
+}
+
+// loadReplicaPosition picks up the position a replica recorded last time
+// and keeps it recorded on close
+func (d *Driver) loadReplicaPosition() error {
+	b, err := d.fs.ReadFile(d.replicaPositionPath())
+	if err == nil {
+		err = json.Unmarshal(b, &d.replica.pos)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	d.onClose(func() error {
+		d.replica.mu.Lock()
+		defer d.replica.mu.Unlock()
+		if d.replica.pos == (FeedPosition{}) {
+			return nil
+		}
+		return d.saveReplicaPosition()
+	})
+	return nil
+}
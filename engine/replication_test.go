@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// follow applies the primary's feed to replica until the test ends
+func follow(t *testing.T, primary, replica *Driver) {
+	t.Helper()
+	feed, err := primary.Watch(t.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for c := range feed {
+			if err := replica.Apply(c); err != nil {
+				t.Errorf("applying %s %s/%s: %v", c.Kind, c.Collection, c.Resource, err)
+			}
+		}
+	}()
+	// the feed ends with the test's context, before the databases close
+	t.Cleanup(func() { <-done })
+}
+
+// caughtUp waits until replica has applied the primary's feed
+func caughtUp(t *testing.T, primary, replica *Driver) {
+	t.Helper()
+	want := primary.FeedPosition()
+	deadline := time.Now().Add(5 * time.Second)
+	for replica.ReplicaPosition() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("the replica is at %v, the primary at %v", replica.ReplicaPosition(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// contentsOf reads the documents of collection by resource name
+func contentsOf(t *testing.T, d *Driver, collection string) map[string]string {
+	t.Helper()
+	records, err := d.Find(collection, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := make(map[string]string, len(records))
+	for _, rec := range records {
+		var v interface{}
+		if err := json.Unmarshal(rec.Data, &v); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(v)
+		docs[rec.Resource] = string(b)
+	}
+	return docs
+}
+
+func TestReplicaFollowsPrimary(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary []Option
+		replica []Option
+	}{
+		{"plain", nil, nil},
+		{"metadata", []Option{WithMetadata()}, []Option{WithMetadata()}},
+		{"metadata on the replica only", nil, []Option{WithMetadata()}},
+		{"guarded replica", nil, []Option{WithAuthorizer(func(ctx context.Context, action Action, collection, resource string) error {
+			if action == ActionWrite || action == ActionDelete {
+				return errors.New("replicas are read-only")
+			}
+			return nil
+		})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := openTest(t, tt.primary...)
+			replica := openTest(t, append(tt.replica, AsReplica())...)
+			if err := replica.SetPolicy("docs", Policy{Write: `{"never": true}`}); err != nil {
+				t.Fatal(err)
+			}
+			follow(t, primary, replica)
+
+			for _, doc := range envelopeLookalikes {
+				if err := primary.Write("docs", "shape", json.RawMessage(doc)); err != nil {
+					t.Fatal(err)
+				}
+				caughtUp(t, primary, replica)
+				if got, want := contentsOf(t, replica, "docs"), contentsOf(t, primary, "docs"); got["shape"] != want["shape"] {
+					t.Errorf("the replica holds %s, the primary %s", got["shape"], want["shape"])
+				}
+			}
+			for _, name := range []string{"a", "b", "c"} {
+				if err := primary.Write("docs", name, map[string]int{"n": 1}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := primary.Increment("docs", "a", "n", 2); err != nil {
+				t.Fatal(err)
+			}
+			if err := primary.Delete("docs", "b"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := primary.DeleteWhere("docs", Equal("n", 1)); err != nil {
+				t.Fatal(err)
+			}
+			caughtUp(t, primary, replica)
+
+			got, want := contentsOf(t, replica, "docs"), contentsOf(t, primary, "docs")
+			if len(got) != len(want) || got["a"] != `{"n":3}` {
+				t.Errorf("the replica holds %v, the primary %v", got, want)
+			}
+			err := replica.Write("docs", "d", map[string]int{})
+			if !errors.Is(err, ErrReadOnly) && !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("writing to the replica: %v, want ErrReadOnly", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// --- HISTORY RETENTION ---
+
+// RetentionRule keeps, among versions recorded less than Within ago, the
+// last one of every Every-long period. A zero Every keeps them all.
+type RetentionRule struct {
+	Within time.Duration
+	Every  time.Duration
+}
+
+// RetentionPolicy decides which versions CompactHistory keeps. Every version
+// falls under the rule with the shortest Within that covers its age;
+// versions older than every rule are dropped.
+type RetentionPolicy []RetentionRule
+
+// DefaultRetention keeps one version an hour for a week and one a day for a
+// year
+var DefaultRetention = RetentionPolicy{
+	{Within: 7 * 24 * time.Hour, Every: time.Hour},
+	{Within: 365 * 24 * time.Hour, Every: 24 * time.Hour},
+}
+
+// CompactHistory thins the history of every record in collection according
+// to policy and reports how many versions it removed. The latest version of
+// a record is always kept. After thinning, ReadAsOf at a time falling
+// between kept versions sees the earlier one. In bitemporal collections
+// the versions of each valid time are thinned separately, so corrections
+// to the past are not lost to later changes.
+func (d *Driver) CompactHistory(collection string, policy RetentionPolicy) (int, error) {
+	if err := validateCollection(collection); err != nil {
+		return 0, err
+	}
+	if err := d.writable(); err != nil {
+		return 0, err
+	}
+	cfg := d.snapshotConfig(collection)
+
+	rules := append(RetentionPolicy(nil), policy...)
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Within < rules[j].Within })
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	dirs, err := d.fs.ReadDir(filepath.Join(d.collectionDir(collection), historyDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	removed := 0
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		n, err := d.thinHistory(collection, dir.Name(), rules, now, cfg.bitemporal)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	d.noteCompaction(collection)
+	if removed > 0 {
+		d.opts.logger.Info("compacted history", "collection", collection, "versions", removed)
+	}
+	return removed, nil
+}
+
+// thinHistory removes the versions of a record rules leave out, taking a
+// Batch IO slot for it
+func (d *Driver) thinHistory(collection, resource string, rules RetentionPolicy, now time.Time, bitemporal bool) (int, error) {
+	d.io.enter(Batch)
+	defer d.io.leave(Batch)
+	versions, err := d.loadHistory(collection, resource)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, ver := range thinVersions(versions, rules, now, bitemporal) {
+		if err := d.fs.Remove(filepath.Join(d.historyPath(collection, resource), versionName(ver)), DurabilityNone); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// thinVersions returns the versions, in transaction time order, that rules
+// leave out
+func thinVersions(versions []Version, rules RetentionPolicy, now time.Time, bitemporal bool) []Version {
+	type bucket struct {
+		rule   int
+		period int64
+		valid  int64
+	}
+
+	// walk from the newest so the first version seen in a bucket is the one
+	// it keeps
+	seen := make(map[bucket]bool)
+	drop := make([]bool, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		latest := i == len(versions)-1
+		ver := versions[i]
+		age := now.Sub(ver.TxTime)
+		rule := sort.Search(len(rules), func(r int) bool { return rules[r].Within > age })
+		if rule == len(rules) {
+			drop[i] = !latest
+			continue
+		}
+		if rules[rule].Every <= 0 {
+			continue
+		}
+		b := bucket{rule: rule, period: ver.TxTime.UnixNano() / int64(rules[rule].Every)}
+		if bitemporal {
+			b.valid = ver.ValidFrom.UnixNano()
+		}
+		drop[i] = seen[b] && !latest
+		seen[b] = true
+	}
+
+	var out []Version
+	for i, ver := range versions {
+		if drop[i] {
+			out = append(out, ver)
+		}
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- S3 STORAGE ---
+
+// S3Storage keeps a Driver's files as objects of an S3 compatible bucket,
+// keyed by their path below cfg.Prefix, for deployments whose local disk
+// doesn't outlive the process. Directories are the key prefixes that
+// objects share. A PUT replaces an object whole, which is all WriteFile
+// promises. Durability levels don't apply: a write is durable once the
+// service acknowledges it.
+func S3Storage(cfg S3Config) Storage {
+	return &s3Storage{newS3Client(cfg)}
+}
+
+type s3Storage struct {
+	*s3Client
+}
+
+func (s *s3Storage) key(name string) string { return s.cfg.Prefix + name }
+
+// dirPrefix is the key prefix of the objects below the directory name
+func (s *s3Storage) dirPrefix(name string) string {
+	if name == "" {
+		return s.cfg.Prefix
+	}
+	return s.cfg.Prefix + name + "/"
+}
+
+func (s *s3Storage) ReadFile(name string) ([]byte, error) {
+	b, _, err := s.do(http.MethodGet, s.key(name), nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, notExist("read", name)
+	}
+	return b, err
+}
+
+func (s *s3Storage) WriteFile(name string, data []byte) error {
+	_, _, err := s.do(http.MethodPut, s.key(name), nil, bytes.NewReader(data), int64(len(data)))
+	return err
+}
+
+// Remove checks for the object first, as a DELETE of a missing key
+// succeeds
+func (s *s3Storage) Remove(name string) error {
+	if _, _, err := s.do(http.MethodHead, s.key(name), nil, nil, 0); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return notExist("remove", name)
+		}
+		return err
+	}
+	_, _, err := s.do(http.MethodDelete, s.key(name), nil, nil, 0)
+	return err
+}
+
+func (s *s3Storage) RemoveAll(name string) error {
+	objects, _, err := s.list(s.dirPrefix(name), "", 0)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if _, _, err := s.do(http.MethodDelete, obj.Key, nil, nil, 0); err != nil {
+			return err
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	_, _, err = s.do(http.MethodDelete, s.key(name), nil, nil, 0)
+	return err
+}
+
+func (s *s3Storage) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := s.dirPrefix(name)
+	objects, prefixes, err := s.list(prefix, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(objects)+len(prefixes))
+	for _, p := range prefixes {
+		entries = append(entries, fileInfo{name: strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/"), dir: true})
+	}
+	for _, obj := range objects {
+		if obj.Key == prefix {
+			// a folder placeholder left by other tools
+			continue
+		}
+		entries = append(entries, fileInfo{name: strings.TrimPrefix(obj.Key, prefix), size: obj.Size, modTime: obj.LastModified})
+	}
+	if len(entries) == 0 && name != "" {
+		return nil, notExist("readdir", name)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *s3Storage) Stat(name string) (fs.FileInfo, error) {
+	if name == "" {
+		return fileInfo{name: ".", dir: true}, nil
+	}
+	_, header, err := s.do(http.MethodHead, s.key(name), nil, nil, 0)
+	if err == nil {
+		size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		mod, _ := time.Parse(http.TimeFormat, header.Get("Last-Modified"))
+		return fileInfo{name: name, size: size, modTime: mod}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	objects, prefixes, err := s.list(s.dirPrefix(name), "/", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, notExist("stat", name)
+	}
+	return fileInfo{name: name, dir: true}, nil
+}
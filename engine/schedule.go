@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- SCHEDULED BACKUPS ---
+
+// BackupDestination stores the archives of a backup schedule
+type BackupDestination interface {
+	// Put stores size bytes read from r under name
+	Put(name string, r io.Reader, size int64) error
+	// List returns the names of the stored archives
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// Rotation is a grandfather-father-son policy: it keeps the newest backup
+// of each of the last Daily days, Weekly ISO weeks and Monthly months, all
+// in UTC. The newest backup is always kept.
+type Rotation struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// DefaultRotation keeps a week of daily backups, a month of weekly ones and
+// a year of monthly ones
+var DefaultRotation = Rotation{Daily: 7, Weekly: 4, Monthly: 12}
+
+// BackupSchedule configures ScheduleBackups
+type BackupSchedule struct {
+	Destination BackupDestination
+	// Every is the time between backups; zero means daily
+	Every time.Duration
+	// Rotation decides which backups to keep; the zero value means
+	// DefaultRotation
+	Rotation Rotation
+	// Archive sets compression and encryption, as for BackupArchive
+	Archive ArchiveOptions
+}
+
+// backupPrefix and backupStamp name scheduled backups so their time can be
+// read back from the name
+const (
+	backupPrefix = "backup-"
+	backupStamp  = "20060102T150405Z"
+)
+
+// ScheduleBackups starts taking backups in the background to s.Destination
+// until the Driver is closed, pruning older ones by s.Rotation after each.
+// The first backup is due one interval after the newest already stored, so
+// restarts don't delay or repeat backups. Failures are logged and retried
+// at the next interval.
+func (d *Driver) ScheduleBackups(s BackupSchedule) error {
+	if s.Destination == nil {
+		return fmt.Errorf("backup schedule has no destination")
+	}
+	if s.Every <= 0 {
+		s.Every = 24 * time.Hour
+	}
+	if s.Rotation == (Rotation{}) {
+		s.Rotation = DefaultRotation
+	}
+	if _, err := lookupCompression(s.Archive.Compression); err != nil {
+		return err
+	}
+	if err := d.life.enter(); err != nil {
+		return err
+	}
+	defer d.life.leave()
+
+	next := time.Now()
+	if last, ok := latestBackup(s.Destination); ok {
+		next = last.Add(s.Every)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+			if err := d.runScheduledBackup(s); err != nil && !errors.Is(err, ErrClosed) {
+				d.opts.logger.Error("scheduled backup failed", "err", err)
+			}
+			timer.Reset(s.Every)
+		}
+	}()
+
+	d.onClose(func() error {
+		close(stop)
+		wg.Wait()
+		return nil
+	})
+	return nil
+}
+
+// runScheduledBackup takes one backup of a schedule and rotates
+func (d *Driver) runScheduledBackup(s BackupSchedule) error {
+	tmp, err := os.CreateTemp(d.scratchDir(), ".backup-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	now := time.Now().UTC()
+	out, err := d.createFile(tmp.Name(), -1)
+	if err != nil {
+		return err
+	}
+	err = d.BackupArchive(out, s.Archive)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	name := backupPrefix + now.Format(backupStamp) + archiveExt(s.Archive)
+	if err := s.Destination.Put(name, tmp, size); err != nil {
+		return fmt.Errorf("storing %s: %w", name, err)
+	}
+	d.opts.logger.Info("scheduled backup stored", "name", name, "bytes", size)
+	return d.rotateBackups(s.Destination, s.Rotation)
+}
+
+// rotateBackups deletes the backups in dest that rotation doesn't keep.
+// Files not named like scheduled backups are left alone.
+func (d *Driver) rotateBackups(dest BackupDestination, rotation Rotation) error {
+	names, err := dest.List()
+	if err != nil {
+		return err
+	}
+
+	type backup struct {
+		name  string
+		taken time.Time
+	}
+	var backups []backup
+	for _, name := range names {
+		if t, ok := backupTime(name); ok {
+			backups = append(backups, backup{name, t})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].taken.After(backups[j].taken) })
+
+	type period struct {
+		kind string
+		key  int
+	}
+	keep := make(map[string]bool)
+	seen := make(map[period]bool)
+	counts := make(map[string]int)
+	limits := map[string]int{"day": rotation.Daily, "week": rotation.Weekly, "month": rotation.Monthly}
+	for i, b := range backups {
+		if i == 0 {
+			keep[b.name] = true
+		}
+		year, week := b.taken.ISOWeek()
+		for kind, key := range map[string]int{
+			"day":   b.taken.Year()*1000 + b.taken.YearDay(),
+			"week":  year*100 + week,
+			"month": b.taken.Year()*100 + int(b.taken.Month()),
+		} {
+			p := period{kind, key}
+			if seen[p] || counts[kind] >= limits[kind] {
+				continue
+			}
+			seen[p] = true
+			counts[kind]++
+			keep[b.name] = true
+		}
+	}
+
+	for _, b := range backups {
+		if keep[b.name] {
+			continue
+		}
+		if err := dest.Delete(b.name); err != nil {
+			return fmt.Errorf("rotating out %s: %w", b.name, err)
+		}
+		d.opts.logger.Info("rotated out backup", "name", b.name)
+	}
+	return nil
+}
+
+// latestBackup finds when the newest scheduled backup in dest was taken
+func latestBackup(dest BackupDestination) (time.Time, bool) {
+	names, err := dest.List()
+	if err != nil {
+		return time.Time{}, false
+	}
+	var latest time.Time
+	for _, name := range names {
+		if t, ok := backupTime(name); ok && t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+// backupTime reads the time a scheduled backup was taken from its name
+func backupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || len(name) < len(backupPrefix)+len(backupStamp) {
+		return time.Time{}, false
+	}
+	stamp := name[len(backupPrefix) : len(backupPrefix)+len(backupStamp)]
+	t, err := time.Parse(backupStamp, stamp)
+	return t, err == nil
+}
+
+// archiveExt is the file extension of an archive written with opts
+func archiveExt(opts ArchiveOptions) string {
+	ext := ".tar"
+	switch opts.Compression {
+	case "", "gzip":
+		ext += ".gz"
+	case "none":
+	default:
+		ext += "." + opts.Compression
+	}
+	if opts.Key != nil {
+		ext += ".enc"
+	}
+	return ext
+}
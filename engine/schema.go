@@ -0,0 +1,406 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- SCHEMA VALIDATION ---
+
+// ErrValidation is returned (wrapped) when a document is rejected by the
+// schema attached to its collection
+var ErrValidation = errors.New("document failed validation")
+
+// Schema validates a decoded JSON document before it is written. Documents
+// are decoded generically: objects as map[string]interface{}, arrays as
+// []interface{} and numbers as json.Number.
+type Schema interface {
+	Validate(doc interface{}) error
+}
+
+// SetSchema attaches a schema to a collection so Write rejects documents that
+// don't conform. Passing nil removes the schema.
+func (d *Driver) SetSchema(collection string, s Schema) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).schema = s
+}
+
+// toDocument converts any value into its generic JSON form
+func toDocument(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument(b)
+}
+
+// decodeDocument decodes raw JSON keeping numbers as json.Number
+func decodeDocument(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func validationError(path, format string, args ...interface{}) error {
+	if path == "" {
+		path = "$"
+	}
+	return fmt.Errorf("%w: %s: %s", ErrValidation, path, fmt.Sprintf(format, args...))
+}
+
+// --- STRUCT PROTOTYPES
+
+type structSchema struct {
+	typ reflect.Type
+}
+
+// StructSchema returns a Schema that accepts only documents decoding cleanly
+// into the type of prototype, with no unknown fields
+func StructSchema(prototype interface{}) Schema {
+	t := reflect.TypeOf(prototype)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return &structSchema{typ: t}
+}
+
+func (s *structSchema) Validate(doc interface{}) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(reflect.New(s.typ).Interface()); err != nil {
+		return validationError("", "does not match %s: %v", s.typ, err)
+	}
+	return nil
+}
+
+// --- JSON SCHEMA
+
+// JSONSchema is the subset of JSON Schema understood by the engine: type,
+// enum, const, properties, required, additionalProperties, items,
+// minimum/maximum (and their exclusive forms), minLength/maxLength,
+// pattern and minItems/maxItems
+type JSONSchema struct {
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Const                interface{}            `json:"const,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Minimum              *json.Number           `json:"minimum,omitempty"`
+	Maximum              *json.Number           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *json.Number           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *json.Number           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"]
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*t = many
+	return nil
+}
+
+// additionalProperties accepts either a boolean or a nested schema
+type additionalProperties struct {
+	Allowed bool
+	Schema  *JSONSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	a.Schema = &JSONSchema{}
+	return json.Unmarshal(b, a.Schema)
+}
+
+func (a additionalProperties) MarshalJSON() ([]byte, error) {
+	if a.Schema != nil {
+		return json.Marshal(a.Schema)
+	}
+	return json.Marshal(a.Allowed)
+}
+
+// ParseJSONSchema parses a JSON Schema document
+func ParseJSONSchema(raw []byte) (*JSONSchema, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var s JSONSchema
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *JSONSchema) compile() error {
+	if s.Pattern != "" && s.pattern == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(); err != nil {
+			return err
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		return s.AdditionalProperties.Schema.compile()
+	}
+	return nil
+}
+
+func (s *JSONSchema) Validate(doc interface{}) error {
+	return s.validate("", doc)
+}
+
+func (s *JSONSchema) validate(path string, v interface{}) error {
+	if len(s.Type) > 0 && !s.matchesType(v) {
+		return validationError(path, "expected %s, got %s", strings.Join(s.Type, " or "), jsonType(v))
+	}
+
+	if s.Const != nil && !jsonEqual(s.Const, v) {
+		return validationError(path, "must equal %v", s.Const)
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return validationError(path, "must be one of %v", s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return s.validateObject(path, val)
+	case []interface{}:
+		return s.validateArray(path, val)
+	case string:
+		return s.validateString(path, val)
+	case json.Number:
+		return s.validateNumber(path, val)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateObject(path string, obj map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return validationError(joinPath(path, name), "is required")
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if prop, ok := s.Properties[k]; ok {
+			if err := prop.validate(joinPath(path, k), obj[k]); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.Allowed {
+			return validationError(joinPath(path, k), "unknown field")
+		}
+		if extra := s.AdditionalProperties.Schema; extra != nil {
+			if err := extra.validate(joinPath(path, k), obj[k]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateArray(path string, arr []interface{}) error {
+	if s.MinItems != nil && len(arr) < *s.MinItems {
+		return validationError(path, "must have at least %d items", *s.MinItems)
+	}
+	if s.MaxItems != nil && len(arr) > *s.MaxItems {
+		return validationError(path, "must have at most %d items", *s.MaxItems)
+	}
+	if s.Items != nil {
+		for i, item := range arr {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateString(path, str string) error {
+	n := utf8.RuneCountInString(str)
+	if s.MinLength != nil && n < *s.MinLength {
+		return validationError(path, "must be at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return validationError(path, "must be at most %d characters", *s.MaxLength)
+	}
+	if s.Pattern == "" {
+		return nil
+	}
+
+	re := s.pattern
+	if re == nil {
+		// schema was built as a literal rather than through ParseJSONSchema
+		var err error
+		if re, err = regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("invalid schema pattern %q: %w", s.Pattern, err)
+		}
+	}
+	if !re.MatchString(str) {
+		return validationError(path, "must match %q", s.Pattern)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateNumber(path string, num json.Number) error {
+	n, ok := new(big.Float).SetString(num.String())
+	if !ok {
+		return validationError(path, "invalid number %q", num)
+	}
+
+	check := func(limit *json.Number, fails func(cmp int) bool, msg string) error {
+		if limit == nil {
+			return nil
+		}
+		l, ok := new(big.Float).SetString(limit.String())
+		if !ok {
+			return fmt.Errorf("invalid schema bound %q", *limit)
+		}
+		if fails(n.Cmp(l)) {
+			return validationError(path, "must be %s %s", msg, *limit)
+		}
+		return nil
+	}
+
+	if err := check(s.Minimum, func(c int) bool { return c < 0 }, ">="); err != nil {
+		return err
+	}
+	if err := check(s.Maximum, func(c int) bool { return c > 0 }, "<="); err != nil {
+		return err
+	}
+	if err := check(s.ExclusiveMinimum, func(c int) bool { return c <= 0 }, ">"); err != nil {
+		return err
+	}
+	return check(s.ExclusiveMaximum, func(c int) bool { return c >= 0 }, "<")
+}
+
+func (s *JSONSchema) matchesType(v interface{}) bool {
+	actual := jsonType(v)
+	for _, t := range s.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType reports the JSON Schema type name of a decoded value
+func jsonType(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			return "integer"
+		}
+		if f, ok := new(big.Float).SetString(val.String()); ok && f.IsInt() {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if val == float64(int64(val)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// jsonEqual compares two decoded JSON values, treating numbers by value
+func jsonEqual(a, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, ok1 := new(big.Float).SetString(an.String())
+		bf, ok2 := new(big.Float).SetString(bn.String())
+		return ok1 && ok2 && af.Cmp(bf) == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func joinPath(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
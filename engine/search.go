@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// --- FULL-TEXT SEARCH ---
+
+// BM25 parameters: bm25K1 is how quickly repeating a term stops adding to a
+// score, bm25B how much long documents are penalised
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchResult is a record found by Search with its relevance score
+type SearchResult struct {
+	Record
+	Score float64
+}
+
+// searchIndex is the inverted index of a collection's searchable fields.
+// Like the unique index it is built on first use from the stored records
+// and kept up to date by write and delete, under the collection's write
+// lock.
+type searchIndex struct {
+	fields   []string
+	postings map[string]map[string]int // term -> resource -> occurrences
+	terms    map[string]map[string]int // resource -> term -> occurrences
+	lengths  map[string]int            // resource -> terms indexed
+	total    int
+}
+
+// SearchField makes the text at the (dot separated) path of documents in
+// collection searchable with Search. Strings, and strings inside arrays,
+// are indexed; other values are ignored.
+func (d *Driver) SearchField(collection, path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	if !slices.Contains(cfg.search, path) {
+		cfg.search = append(slices.Clone(cfg.search), path)
+	}
+}
+
+// Search returns the records of collection whose searchable fields contain
+// any word of query, most relevant first, ranked by BM25 over all of the
+// fields. Words are split at anything that isn't a letter or digit and
+// compared case-insensitively. The index is built on the first search and
+// maintained by later writes and deletes. It is authorized as a read of
+// the collection and leaves out the records its read Policy hides.
+func (d *Driver) Search(collection, query string, opts ...ReadOption) ([]SearchResult, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+	fields := d.snapshotConfig(collection).search
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("collection %s has no searchable fields", collection)
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := d.searchIndexFor(collection, fields)
+	var hits []SearchResult
+	if err == nil {
+		hits = idx.rank(tokenize(query))
+	}
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	guard := d.readGuard(p.ctx, collection)
+	out := hits[:0]
+	for _, hit := range hits {
+		rec, err := d.readRecord(collection, hit.Resource)
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted since the index was consulted
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if guard != nil && !guard.Match(rec) {
+			continue
+		}
+		hit.Record = *rec
+		out = append(out, hit)
+	}
+	return out, nil
+}
+
+// searchIndexFor returns the index of collection for fields, building it
+// when missing or stale. Callers must hold the collection lock.
+func (d *Driver) searchIndexFor(collection string, fields []string) (*searchIndex, error) {
+	d.mutex.Lock()
+	idx := d.searches[collection]
+	d.mutex.Unlock()
+	if idx != nil && slices.Equal(idx.fields, fields) {
+		return idx, nil
+	}
+
+	idx = &searchIndex{
+		fields:   fields,
+		postings: make(map[string]map[string]int),
+		terms:    make(map[string]map[string]int),
+		lengths:  make(map[string]int),
+	}
+	err := d.walk(collection, func(rec *Record) error {
+		doc, err := rec.Document()
+		if err != nil {
+			return err
+		}
+		idx.add(rec.Resource, doc)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	d.searches[collection] = idx
+	d.mutex.Unlock()
+	return idx, nil
+}
+
+// invalidateSearch drops the search index of collection after its files
+// were replaced wholesale
+func (d *Driver) invalidateSearch(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.searches, collection)
+}
+
+// indexedSearch returns the search index of collection if one was built
+func (d *Driver) indexedSearch(collection string) *searchIndex {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.searches[collection]
+}
+
+// reindexSearch brings the search index of collection, if there is one,
+// up to date with the record just written. Callers must hold the
+// collection's write lock.
+func (d *Driver) reindexSearch(collection, resource string, v interface{}) {
+	idx := d.indexedSearch(collection)
+	if idx == nil {
+		return
+	}
+	doc, err := toDocument(v)
+	if err != nil {
+		// rebuilt from the files on the next search
+		d.invalidateSearch(collection)
+		return
+	}
+	idx.add(resource, doc)
+}
+
+func (idx *searchIndex) add(resource string, doc interface{}) {
+	idx.remove(resource)
+	obj, _ := doc.(map[string]interface{})
+	counts := make(map[string]int)
+	n := 0
+	for _, f := range idx.fields {
+		v, _ := lookupPath(obj, f)
+		for _, text := range searchText(v) {
+			for _, term := range tokenize(text) {
+				counts[term]++
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return
+	}
+	for term, c := range counts {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][resource] = c
+	}
+	idx.terms[resource] = counts
+	idx.lengths[resource] = n
+	idx.total += n
+}
+
+func (idx *searchIndex) remove(resource string) {
+	for term := range idx.terms[resource] {
+		delete(idx.postings[term], resource)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.total -= idx.lengths[resource]
+	delete(idx.terms, resource)
+	delete(idx.lengths, resource)
+}
+
+// rank scores every record holding any of terms, best first and ties in
+// name order
+func (idx *searchIndex) rank(terms []string) []SearchResult {
+	docs := len(idx.lengths)
+	if docs == 0 {
+		return nil
+	}
+	avg := float64(idx.total) / float64(docs)
+
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(docs)-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for resource, tf := range postings {
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(idx.lengths[resource])/avg)
+			scores[resource] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+		}
+	}
+
+	out := make([]SearchResult, 0, len(scores))
+	for resource, score := range scores {
+		out = append(out, SearchResult{Record: Record{Resource: resource}, Score: score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Resource < out[j].Resource
+	})
+	return out
+}
+
+// searchText lists the strings of a searchable field's value
+func searchText(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// tokenize splits text into lower cased words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
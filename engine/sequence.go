@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- SEQUENCE NUMBERS ---
+
+// sequenceFile holds, in each collection directory, the ceiling of the
+// sequence numbers reserved so far
+const sequenceFile = ".sequence"
+
+// sequenceBlock is how many sequence numbers are reserved on disk at a
+// time. Numbers reserved but not used before a restart are skipped, so
+// sequences have gaps but never repeat.
+const sequenceBlock = 1024
+
+// sequence numbers the committed changes of a collection
+type sequence struct {
+	next    uint64 // the number of the next change
+	ceiling uint64 // numbers below it are reserved on disk
+	last    uint64 // the number of the latest change, for its change event
+}
+
+// ChangedAfter matches records whose metadata shows a write numbered after
+// seq in their collection's sequence, for picking up where an incremental
+// process left off. Records stored without metadata never match.
+func ChangedAfter(seq uint64) Filter {
+	return FilterFunc(func(r *Record) bool {
+		return r.Meta != nil && r.Meta.Seq > seq
+	})
+}
+
+// nextSeq takes the next sequence number of collection, reserving another
+// block on disk at level when the reserved ones run out. Callers must hold
+// the collection's write lock.
+func (d *Driver) nextSeq(collection string, level Durability) (uint64, error) {
+	s, err := d.loadSequence(collection)
+	if err != nil {
+		return 0, err
+	}
+	if s.next >= s.ceiling {
+		ceiling := s.next + sequenceBlock
+		path := filepath.Join(d.collectionDir(collection), sequenceFile)
+		if err := d.fs.WriteFile(path, []byte(strconv.FormatUint(ceiling, 10)+"\n"), level); err != nil {
+			return 0, err
+		}
+		s.ceiling = ceiling
+	}
+	seq := s.next
+	s.next++
+	s.last = seq
+	return seq, nil
+}
+
+// currentSeq is a number at least that of every change committed to
+// collection so far and below that of every change to come. Callers must
+// hold the collection lock.
+func (d *Driver) currentSeq(collection string) (uint64, error) {
+	s, err := d.loadSequence(collection)
+	if err != nil {
+		return 0, err
+	}
+	return s.next - 1, nil
+}
+
+// loadSequence returns the sequence of collection, picking it up from its
+// sequence file on first use. Callers must hold the collection lock.
+func (d *Driver) loadSequence(collection string) (*sequence, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if s := d.sequences[collection]; s != nil {
+		return s, nil
+	}
+
+	s := &sequence{next: 1}
+	b, err := d.fs.ReadFile(filepath.Join(d.collectionDir(collection), sequenceFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		ceiling, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		s.next, s.ceiling = ceiling, ceiling
+	}
+	d.sequences[collection] = s
+	return s, nil
+}
+
+// lastSeq is the number of the latest change to collection. Callers must
+// hold the collection's write lock.
+func (d *Driver) lastSeq(collection string) uint64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if s := d.sequences[collection]; s != nil {
+		return s.last
+	}
+	return 0
+}
+
+// forgetSequence drops what is known of the sequence of collection after
+// its files, sequence file included, were replaced or removed wholesale, so
+// numbering carries on from what the new files say
+func (d *Driver) forgetSequence(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.sequences, collection)
+}
@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- SESSIONS ---
+
+// ErrStaleRead is returned (wrapped) by a causal session read when the
+// Driver, a replica, hasn't caught up with the session's token in time
+var ErrStaleRead = errors.New("replica has not caught up")
+
+// DefaultSessionTimeout is how long causal reads wait when SessionOptions
+// leaves Timeout zero
+const DefaultSessionTimeout = 5 * time.Second
+
+// sessionPoll is how often a causal read checks the replica's position
+const sessionPoll = 5 * time.Millisecond
+
+// Consistency is what a session's reads guarantee
+type Consistency int
+
+const (
+	// Eventual reads return whatever the Driver holds right now
+	Eventual Consistency = iota
+	// Causal reads wait until the Driver has applied everything up to the
+	// session's token, so a session reading from a replica sees its own
+	// writes and never goes back in time
+	Causal
+)
+
+// SessionOptions are the defaults a Session applies to every call
+type SessionOptions struct {
+	// Namespace, when set, is the record that every collection the session
+	// names is a sub-collection of: with Namespace "tenants/acme" the
+	// session's "users" is "tenants/acme/users"
+	Namespace   string
+	Consistency Consistency
+	// Timeout bounds how long a causal read waits; zero means
+	// DefaultSessionTimeout
+	Timeout time.Duration
+	// Token is the causal token to start from, as returned by Token of
+	// another session, typically one writing to the primary
+	Token FeedPosition
+	// Context, when set, is the context of the calls: the parent of their
+	// spans, and what their Authorizer sees; typically the context of the
+	// request being served
+	Context context.Context
+}
+
+// Session is a view of a Driver carrying settings for a series of calls,
+// such as one client's or one request's. It tracks a causal token: the
+// feed position of the latest change it wrote or observed. Sessions are
+// cheap and safe for concurrent use.
+type Session struct {
+	d        *Driver
+	opts     SessionOptions
+	readOnly bool // set by Authenticate for read-only keys
+
+	mu    sync.Mutex
+	token FeedPosition
+}
+
+// Session starts a session on the Driver
+func (d *Driver) Session(opts SessionOptions) *Session {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultSessionTimeout
+	}
+	return &Session{d: d, opts: opts, token: opts.Token}
+}
+
+// Collection is the name the Driver knows the session's collection by
+func (s *Session) Collection(collection string) string {
+	if s.opts.Namespace == "" {
+		return collection
+	}
+	return s.opts.Namespace + "/" + collection
+}
+
+// Token returns the session's causal token, to be handed to a session on
+// another Driver with SessionOptions.Token or Observe
+func (s *Session) Token() FeedPosition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Observe moves the session's token up to t, so causal reads also wait for
+// changes made elsewhere
+func (s *Session) Observe(t FeedPosition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.Epoch != s.token.Epoch || t.Seq > s.token.Seq {
+		s.token = t
+	}
+}
+
+// Write writes a record as Driver.Write does and advances the token
+func (s *Session) Write(collection, resource string, v interface{}, opts ...WriteOption) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := s.d.Write(s.Collection(collection), resource, v, s.writeOptions(opts)...); err != nil {
+		return err
+	}
+	s.Observe(s.d.FeedPosition())
+	return nil
+}
+
+// Delete deletes a record as Driver.Delete does and advances the token
+func (s *Session) Delete(collection, resource string, opts ...WriteOption) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := s.d.Delete(s.Collection(collection), resource, s.writeOptions(opts)...); err != nil {
+		return err
+	}
+	s.Observe(s.d.FeedPosition())
+	return nil
+}
+
+// Read reads a record as Driver.Read does, at the session's consistency
+func (s *Session) Read(collection, resource string, v interface{}, opts ...ReadOption) error {
+	if err := s.catchUp(); err != nil {
+		return err
+	}
+	return s.d.Read(s.Collection(collection), resource, v, s.readOptions(opts)...)
+}
+
+// Find finds records as Driver.Find does, at the session's consistency
+func (s *Session) Find(collection string, filter Filter, opts ...ReadOption) ([]Record, error) {
+	if err := s.catchUp(); err != nil {
+		return nil, err
+	}
+	return s.d.Find(s.Collection(collection), filter, s.readOptions(opts)...)
+}
+
+// ReadAll reads a collection as Driver.ReadAll does, at the session's
+// consistency
+func (s *Session) ReadAll(collection string) ([][]byte, error) {
+	if err := s.catchUp(); err != nil {
+		return nil, err
+	}
+	return s.d.readAll(s.opts.Context, s.Collection(collection))
+}
+
+// List lists records as Driver.List does, at the session's consistency
+func (s *Session) List(collection string) ([]string, error) {
+	if err := s.catchUp(); err != nil {
+		return nil, err
+	}
+	return s.d.list(s.opts.Context, s.Collection(collection))
+}
+
+// Watch watches a collection as Driver.Watch does. A session with a
+// Namespace must name a collection: it can't watch the whole Driver.
+func (s *Session) Watch(ctx context.Context, collection string, opts ...WatchOption) (<-chan Change, error) {
+	if collection == "" && s.opts.Namespace != "" {
+		return nil, fmt.Errorf("%w: a namespaced session can only watch its own collections", ErrPermissionDenied)
+	}
+	return s.d.Watch(ctx, s.Collection(collection), opts...)
+}
+
+// WatchFrom watches a collection from a feed position as Driver.WatchFrom
+// does, with the restriction of Watch
+func (s *Session) WatchFrom(ctx context.Context, collection string, from FeedPosition, opts ...WatchOption) (<-chan Change, error) {
+	if collection == "" && s.opts.Namespace != "" {
+		return nil, fmt.Errorf("%w: a namespaced session can only watch its own collections", ErrPermissionDenied)
+	}
+	return s.d.WatchFrom(ctx, s.Collection(collection), from, opts...)
+}
+
+// writeOptions puts the session's context before the call's options,
+// which may replace it
+func (s *Session) writeOptions(opts []WriteOption) []WriteOption {
+	if s.opts.Context == nil {
+		return opts
+	}
+	return append([]WriteOption{WriteContext(s.opts.Context)}, opts...)
+}
+
+// readOptions is writeOptions for reads
+func (s *Session) readOptions(opts []ReadOption) []ReadOption {
+	if s.opts.Context == nil {
+		return opts
+	}
+	return append([]ReadOption{ReadContext(s.opts.Context)}, opts...)
+}
+
+// writable fails for sessions of read-only access keys
+func (s *Session) writable() error {
+	if s.readOnly {
+		return fmt.Errorf("%w: the access key is read-only", ErrPermissionDenied)
+	}
+	return nil
+}
+
+// catchUp waits, for causal sessions, until the Driver has reached the
+// session's token
+func (s *Session) catchUp() error {
+	if s.opts.Consistency != Causal {
+		return nil
+	}
+	token := s.Token()
+	if token == (FeedPosition{}) {
+		return nil
+	}
+
+	deadline := time.Now().Add(s.opts.Timeout)
+	for {
+		pos := s.d.position()
+		if pos.Epoch == token.Epoch && pos.Seq >= token.Seq {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: at %s/%d, session needs %s/%d", ErrStaleRead, pos.Epoch, pos.Seq, token.Epoch, token.Seq)
+		}
+		time.Sleep(sessionPoll)
+	}
+}
+
+// position is how far the Driver's data has come: the primary's feed
+// position a replica has applied, or a primary's own
+func (d *Driver) position() FeedPosition {
+	if d.opts.replica {
+		return d.ReplicaPosition()
+	}
+	return d.FeedPosition()
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- SHARDED LAYOUT ---
+
+// shardMarker is the hidden file marking a collection's directory as laid
+// out in shards
+const shardMarker = ".sharded"
+
+// shardOf is the shard directory of a resource: a dot, which no record
+// or sub-collection name starts with, and the first byte of the name's
+// FNV-1a hash in hex, fanning a collection out over 256 directories
+func shardOf(resource string) string {
+	h := fnv.New32a()
+	h.Write([]byte(resource))
+	return "." + hex.EncodeToString(h.Sum(nil)[:1])
+}
+
+// isShardDir reports whether name is a shard directory's
+func isShardDir(name string) bool {
+	if len(name) != 3 || name[0] != '.' {
+		return false
+	}
+	_, err := hex.DecodeString(name[1:])
+	return err == nil && strings.ToLower(name) == name
+}
+
+// sharded reports whether collection is laid out in shards, looking for
+// its marker on first use
+func (d *Driver) sharded(collection string) bool {
+	if isSystemCollection(collection) {
+		return false
+	}
+	d.mutex.Lock()
+	s, ok := d.layouts[collection]
+	d.mutex.Unlock()
+	if ok {
+		return s
+	}
+
+	dir := d.collectionDir(collection)
+	_, err := d.fs.Stat(filepath.Join(dir, shardMarker))
+	s = err == nil
+	if !s {
+		// only collections that exist are remembered, so typos aren't
+		if _, err := d.fs.Stat(dir); err != nil {
+			return false
+		}
+	}
+	d.mutex.Lock()
+	d.layouts[collection] = s
+	d.mutex.Unlock()
+	return s
+}
+
+// forgetLayout lets the layout of a removed or replaced collection be
+// looked up again
+func (d *Driver) forgetLayout(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.layouts, collection)
+}
+
+// recordFile is a record's file found in a collection directory
+type recordFile struct {
+	resource string
+	path     string
+	entry    fs.DirEntry
+}
+
+// recordFiles lists the record files of collection in resource order,
+// whichever its layout. Callers must hold the collection lock.
+func (d *Driver) recordFiles(collection string, cfg *collectionConfig) ([]recordFile, error) {
+	dir := d.collectionDir(collection)
+	if !d.sharded(collection) {
+		return d.recordFilesIn(dir, d.recordExtension(collection, cfg))
+	}
+	entries, err := d.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []recordFile
+	for _, e := range entries {
+		if !e.IsDir() || !isShardDir(e.Name()) {
+			continue
+		}
+		in, err := d.recordFilesIn(filepath.Join(dir, e.Name()), d.recordExtension(collection, cfg))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, in...)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].resource < files[j].resource })
+	return files, nil
+}
+
+// recordFilesIn lists the files with the record extension ext directly in
+// dir
+func (d *Driver) recordFilesIn(dir, ext string) ([]recordFile, error) {
+	entries, err := d.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []recordFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ext {
+			continue
+		}
+		files = append(files, recordFile{resource: strings.TrimSuffix(name, ext), path: filepath.Join(dir, name), entry: e})
+	}
+	return files, nil
+}
+
+// ShardCollection lays collection out in shards, as users/.3f/alice.json
+// rather than users/alice.json, so a collection of millions of records
+// doesn't strain the file system with one huge directory. The layout is
+// invisible to the API and recorded in the collection's directory, so
+// every Driver opening the database uses it. Called on a collection that
+// doesn't exist yet it only sets the layout; on one with records it moves
+// them, holding the collection's write lock throughout. The move copies
+// every record into its shard, durably, before recording the layout and
+// removing the flat files, so a crash part way leaves the collection
+// readable in one layout or the other; calling ShardCollection again
+// finishes the job.
+func (d *Driver) ShardCollection(collection string) error {
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	cfg := d.snapshotConfig(collection)
+	dir := d.collectionDir(collection)
+	flat, err := d.recordFilesIn(dir, d.recordExtension(collection, cfg))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if _, err := d.fs.Stat(filepath.Join(dir, shardMarker)); err != nil {
+		for _, f := range flat {
+			b, err := d.fs.ReadFile(f.path)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(dir, shardOf(f.resource), f.entry.Name())
+			if err := d.fs.WriteFile(dst, b, FsyncDir); err != nil {
+				return fmt.Errorf("sharding %s/%s: %w", collection, f.resource, err)
+			}
+		}
+		if err := d.fs.MkdirAll(dir); err != nil {
+			return err
+		}
+		if err := d.fs.WriteFile(filepath.Join(dir, shardMarker), nil, FsyncDir); err != nil {
+			return err
+		}
+	}
+	d.mutex.Lock()
+	d.layouts[collection] = true
+	d.mutex.Unlock()
+
+	for _, f := range flat {
+		if err := d.fs.Remove(f.path, d.opts.durability); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if len(flat) > 0 {
+		d.opts.logger.Info("sharded collection", "collection", collection, "records", len(flat))
+	}
+	return nil
+}
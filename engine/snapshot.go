@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// --- SNAPSHOTS ---
+
+// SnapshotCut is the point in the change feed a Snapshot was taken at.
+// Every change to its collections is either in the snapshot or comes
+// after Position, numbered in its collection above the number recorded in
+// Sequences.
+type SnapshotCut struct {
+	Position  FeedPosition      `json:"position"`
+	Sequences map[string]uint64 `json:"sequences"`
+}
+
+// Snapshot is a consistent copy of a set of collections, as taken by
+// Snapshot or SnapshotCollections, to be read, written out and closed
+type Snapshot struct {
+	Cut SnapshotCut
+
+	d   *Driver
+	dir string
+
+	viewMu sync.Mutex
+	view   *Driver
+}
+
+// Snapshot takes a consistent copy of the whole database, every collection
+// at the same point of the change feed, for long-running reads such as
+// exports and scans that must not see the writes made while they run. The
+// copy is linked as for Backup, so writers are only held up briefly, and
+// read through View. Close the snapshot to drop the copy.
+func (d *Driver) Snapshot() (*Snapshot, error) {
+	collections, err := d.storedCollections()
+	if err != nil {
+		return nil, err
+	}
+	return d.snapshot(collections)
+}
+
+// SnapshotCollections takes a consistent copy of the named top-level
+// collections, all at the same point of the change feed, to seed another
+// service with: it restores or imports the archive the snapshot writes,
+// then tails the feed with WatchFrom at Cut.Position, or skips the changes
+// whose CollectionSeq is at most the one in Cut.Sequences. The collections
+// are read-locked together while their files are linked into the copy, as
+// for Backup, so writers are only held up briefly. Close the snapshot to
+// drop the copy.
+func (d *Driver) SnapshotCollections(collections ...string) (*Snapshot, error) {
+	if len(collections) == 0 {
+		return nil, fmt.Errorf("no collections to snapshot")
+	}
+	for _, c := range collections {
+		if err := validateCollection(c); err != nil {
+			return nil, err
+		}
+		if strings.Contains(c, "/") && !isSystemCollection(c) {
+			return nil, fmt.Errorf("%s: only top-level collections can be snapshotted", c)
+		}
+	}
+	return d.snapshot(collections)
+}
+
+func (d *Driver) snapshot(collections []string) (*Snapshot, error) {
+	dir, err := os.MkdirTemp(d.scratchDir(), ".snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{d: d, dir: dir, Cut: SnapshotCut{Sequences: make(map[string]uint64, len(collections))}}
+	if err := s.take(collections); err != nil {
+		s.Close()
+		return nil, err
+	}
+	d.opts.logger.Info("snapshot taken", "collections", len(collections), "epoch", s.Cut.Position.Epoch, "seq", s.Cut.Position.Seq)
+	return s, nil
+}
+
+// take copies the collections into the snapshot's directory under their
+// locks, which also hold still the feed position recorded
+func (s *Snapshot) take(collections []string) error {
+	d := s.d
+	unlock, err := d.lockCollections(collections, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s.Cut.Position = d.FeedPosition()
+	for _, c := range collections {
+		seq, err := d.currentSeq(c)
+		if err != nil {
+			return err
+		}
+		s.Cut.Sequences[c] = seq
+		if _, err := d.fs.Stat(d.collectionDir(c)); os.IsNotExist(err) {
+			continue
+		}
+		if err := d.copyTree(d.fs, d.collectionDir(c), localStorage{}, filepath.Join(s.dir, c), linkable(c)); err != nil {
+			return fmt.Errorf("snapshotting %s: %w", c, err)
+		}
+	}
+	return nil
+}
+
+// WriteArchive streams the snapshot to w as BackupArchive does, with the
+// cut recorded in the manifest. It may be called more than once.
+func (s *Snapshot) WriteArchive(w io.Writer, opts ArchiveOptions) error {
+	cut := s.Cut
+	return s.d.writeArchive(w, s.dir, &cut, opts)
+}
+
+// View returns a read-only Driver over the snapshot's copy, opened on the
+// first call. It reads with the collection settings of the Driver the
+// snapshot was taken from, as they are when View is first called, and is
+// closed with the snapshot.
+func (s *Snapshot) View() (*Driver, error) {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+	if s.view != nil {
+		return s.view, nil
+	}
+
+	d := s.d
+	view, err := New(s.dir, func(o *options) {
+		*o = d.opts
+		o.readOnly = true
+		o.replica = false
+		o.volumes = nil
+		o.storage = nil
+		o.groupCommit = false
+	})
+	if err != nil {
+		view.Close()
+		return nil, err
+	}
+	d.mutex.Lock()
+	for c, cfg := range d.collections {
+		cp := *cfg
+		view.collections[c] = &cp
+	}
+	d.mutex.Unlock()
+	s.view = view
+	return view, nil
+}
+
+// Close drops the snapshot's copy, closing its view first
+func (s *Snapshot) Close() error {
+	s.viewMu.Lock()
+	view := s.view
+	s.view = nil
+	s.viewMu.Unlock()
+
+	var err error
+	if view != nil {
+		err = view.Close()
+	}
+	return errors.Join(err, os.RemoveAll(s.dir))
+}
@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// --- STATS AND HEALTH ---
+
+// Stats is the size and state of a database, for capacity planning
+type Stats struct {
+	Collections map[string]CollectionStats `json:"collections"`
+	// DiskBytes is every file of the database on every volume, the
+	// engine's own included
+	DiskBytes int64      `json:"diskBytes"`
+	Cache     CacheStats `json:"cache"`
+	// CacheHitRate is the share of cached reads that hit, 0 before any
+	CacheHitRate float64 `json:"cacheHitRate"`
+}
+
+// CollectionStats is the size of a collection
+type CollectionStats struct {
+	Records int `json:"records"`
+	// RecordBytes is the live record files
+	RecordBytes int64 `json:"recordBytes"`
+	// DiskBytes is everything below the collection's directory: records,
+	// history, trash and sub-collections
+	DiskBytes int64        `json:"diskBytes"`
+	Indexes   []IndexStats `json:"indexes,omitempty"`
+	// LastCompaction is when CompactHistory last ran on the collection
+	// since the Driver was opened, zero if it hasn't
+	LastCompaction time.Time `json:"lastCompaction,omitzero"`
+}
+
+// IndexStats is the size of one of the in-memory indexes of a collection,
+// which are built on first use
+type IndexStats struct {
+	// Kind is unique, search, column or distinct
+	Kind   string   `json:"kind"`
+	Fields []string `json:"fields"`
+	// Entries is the values a unique index holds, the term postings of a
+	// search index and the records of a column or distinct index
+	Entries int `json:"entries"`
+}
+
+// Stats measures every collection, walking their directories, so it costs
+// a scan of the file names of the whole database
+func (d *Driver) Stats() (Stats, error) {
+	collections, err := d.Collections()
+	if err != nil {
+		return Stats{}, err
+	}
+	out := Stats{Collections: make(map[string]CollectionStats, len(collections)), Cache: d.cache.snapshot()}
+	if lookups := out.Cache.Hits + out.Cache.Misses; lookups > 0 {
+		out.CacheHitRate = float64(out.Cache.Hits) / float64(lookups)
+	}
+	for _, c := range collections {
+		s, err := d.collectionStats(c)
+		if err != nil {
+			return Stats{}, fmt.Errorf("%s: %w", c, err)
+		}
+		out.Collections[c] = s
+	}
+	if out.DiskBytes, err = d.databaseBytes(); err != nil {
+		return Stats{}, err
+	}
+	return out, nil
+}
+
+// collectionStats measures a collection under its read lock
+func (d *Driver) collectionStats(collection string) (CollectionStats, error) {
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return CollectionStats{}, err
+	}
+	defer release()
+
+	var s CollectionStats
+	dir := d.collectionDir(collection)
+	files, err := d.recordFiles(collection, d.snapshotConfig(collection))
+	if err != nil {
+		return s, err
+	}
+	for _, f := range files {
+		info, err := f.entry.Info()
+		if err != nil {
+			return s, err
+		}
+		s.Records++
+		s.RecordBytes += info.Size()
+	}
+	if s.DiskBytes, err = d.dirBytes(dir); err != nil {
+		return s, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	s.Indexes = d.indexStats(collection)
+	s.LastCompaction = d.compacted[collection]
+	return s, nil
+}
+
+// indexStats sizes the indexes built for collection. Callers must hold
+// the collection lock, which guards the indexes' contents, and d.mutex.
+func (d *Driver) indexStats(collection string) []IndexStats {
+	var out []IndexStats
+	if idx := d.uniques[collection]; idx != nil {
+		n := 0
+		for _, owners := range idx.owners {
+			n += len(owners)
+		}
+		out = append(out, IndexStats{Kind: "unique", Fields: idx.fields, Entries: n})
+	}
+	if idx := d.searches[collection]; idx != nil {
+		n := 0
+		for _, postings := range idx.postings {
+			n += len(postings)
+		}
+		out = append(out, IndexStats{Kind: "search", Fields: idx.fields, Entries: n})
+	}
+	if idx := d.columns[collection]; idx != nil {
+		out = append(out, IndexStats{Kind: "column", Fields: idx.fields, Entries: len(idx.resources)})
+	}
+	if idx := d.distincts[collection]; idx != nil {
+		out = append(out, IndexStats{Kind: "distinct", Fields: idx.fields, Entries: idx.records})
+	}
+	return out
+}
+
+// dirBytes adds up every file below dir
+func (d *Driver) dirBytes(dir string) (int64, error) {
+	entries, err := d.fs.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() {
+			n, err := d.dirBytes(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return 0, err
+			}
+			total += n
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// noteCompaction records that CompactHistory ran on collection
+func (d *Driver) noteCompaction(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.compacted[collection] = time.Now()
+}
+
+// Health checks that the Driver is open and can reach its data: that
+// every volume is a directory it can write, round-tripping a probe file
+// through the storage, or only read when the Driver is read-only. It is
+// meant for readiness probes and returns the first problem found.
+func (d *Driver) Health() error {
+	if err := d.life.enter(); err != nil {
+		return err
+	}
+	defer d.life.leave()
+
+	for _, vol := range d.volumes {
+		info, err := d.fs.Stat(vol)
+		if err != nil {
+			return fmt.Errorf("volume %s: %w", vol, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("volume %s is not a directory", vol)
+		}
+		if d.opts.readOnly {
+			if _, err := d.fs.ReadDir(vol); err != nil {
+				return fmt.Errorf("volume %s: %w", vol, err)
+			}
+			continue
+		}
+		if err := d.probe(vol); err != nil {
+			return fmt.Errorf("volume %s is not writable: %w", vol, err)
+		}
+	}
+	return nil
+}
+
+// probe writes, reads back and removes a hidden file in dir
+func (d *Driver) probe(dir string) error {
+	var id [8]byte
+	rand.Read(id[:])
+	want := []byte(hex.EncodeToString(id[:]))
+	path := filepath.Join(dir, ".health-"+string(want))
+	if err := d.fs.WriteFile(path, want, DurabilityNone); err != nil {
+		return err
+	}
+	got, err := d.fs.ReadFile(path)
+	if rmErr := d.fs.Remove(path, DurabilityNone); err == nil {
+		err = rmErr
+	}
+	if err != nil {
+		return err
+	}
+	if string(got) != string(want) {
+		return errors.New("probe file read back wrong")
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// --- STORAGE ---
+
+// Storage keeps the files of a Driver somewhere other than the local disk,
+// as set with WithStorage. Names are slash separated paths below the
+// database root, such as "users/alice.json" or "users/.history/alice";
+// directories exist while there are files below them. Missing files are
+// reported with errors wrapping fs.ErrNotExist.
+type Storage interface {
+	ReadFile(name string) ([]byte, error)
+	// WriteFile replaces name with data; readers see the old or the new
+	// contents, never a mix. Like io.Writer it must not keep data.
+	WriteFile(name string, data []byte) error
+	Remove(name string) error
+	// RemoveAll removes name and everything below it, succeeding when
+	// there is nothing to remove
+	RemoveAll(name string) error
+	// ReadDir lists the files and directories directly below name, in name
+	// order
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// WithStorage keeps the records, and everything else the Driver stores,
+// in s rather than in the directory given to New, which then only names
+// the database root that s is addressed relative to. Temporary files of
+// backups and restores still go to the local temporary directory.
+// WithStorage can't be combined with WithVolumes.
+func WithStorage(s Storage) Option {
+	return func(o *options) { o.storage = s }
+}
+
+// storage is what the Driver reads and writes its files through. Names
+// are local paths as the Driver builds them from its directory and
+// volumes.
+type storage interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, level Durability) error
+	Remove(name string, level Durability) error
+	RemoveAll(name string) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	MkdirAll(name string) error
+}
+
+// localStorage is the local disk, the default
+type localStorage struct{}
+
+func (localStorage) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+
+func (localStorage) WriteFile(name string, data []byte, level Durability) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(name, data, 0644, level)
+}
+
+func (localStorage) Remove(name string, level Durability) error { return removeFile(name, level) }
+func (localStorage) RemoveAll(name string) error                { return os.RemoveAll(name) }
+func (localStorage) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (localStorage) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (localStorage) MkdirAll(name string) error                 { return os.MkdirAll(name, 0755) }
+
+// rootedStorage addresses a Storage with the Driver's local paths, taken
+// relative to root
+type rootedStorage struct {
+	s    Storage
+	root string
+}
+
+func (r rootedStorage) name(p string) (string, error) {
+	rel, err := filepath.Rel(r.root, p)
+	if err != nil || !filepath.IsLocal(rel) && rel != "." {
+		return "", fmt.Errorf("%w: %s is outside the database", ErrInvalidName, p)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (r rootedStorage) ReadFile(p string) ([]byte, error) {
+	name, err := r.name(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.s.ReadFile(name)
+}
+
+func (r rootedStorage) WriteFile(p string, data []byte, level Durability) error {
+	name, err := r.name(p)
+	if err != nil {
+		return err
+	}
+	return r.s.WriteFile(name, data)
+}
+
+func (r rootedStorage) Remove(p string, level Durability) error {
+	name, err := r.name(p)
+	if err != nil {
+		return err
+	}
+	return r.s.Remove(name)
+}
+
+func (r rootedStorage) RemoveAll(p string) error {
+	name, err := r.name(p)
+	if err != nil {
+		return err
+	}
+	return r.s.RemoveAll(name)
+}
+
+func (r rootedStorage) ReadDir(p string) ([]fs.DirEntry, error) {
+	name, err := r.name(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.s.ReadDir(name)
+}
+
+func (r rootedStorage) Stat(p string) (fs.FileInfo, error) {
+	name, err := r.name(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.s.Stat(name)
+}
+
+// MkdirAll does nothing: a Storage has directories only by the files below
+// them
+func (r rootedStorage) MkdirAll(p string) error { return nil }
+
+// isLocal reports whether s is the local disk
+func isLocal(s storage) bool {
+	_, ok := s.(localStorage)
+	return ok
+}
+
+// scratchDir is where the Driver puts temporary files: its own directory
+// when it is on local disk, so they can be renamed or linked into place
+func (d *Driver) scratchDir() string {
+	if isLocal(d.fs) && !d.opts.readOnly {
+		return d.dir
+	}
+	return os.TempDir()
+}
+
+// walkFiles calls fn for every directory and regular file below root in s
+// with its path relative to root, parents first. Leftover temporary files
+// from interrupted writes are skipped.
+func walkFiles(s storage, root string, fn func(rel string, dir bool) error) error {
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := s.ReadDir(filepath.Join(root, rel))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			p := filepath.Join(rel, e.Name())
+			switch {
+			case e.IsDir():
+				if err := fn(p, true); err != nil {
+					return err
+				}
+				if err := walk(p); err != nil {
+					return err
+				}
+			case e.Type().IsRegular() && filepath.Ext(p) != ".tmp":
+				if err := fn(p, false); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := fn(".", true); err != nil {
+		return err
+	}
+	return walk(".")
+}
+
+// copyTree mirrors the files below src in from to dst in to. With link, on
+// local disk, files are hard linked where the filesystem allows instead of
+// copied.
+func (d *Driver) copyTree(from storage, src string, to storage, dst string, link bool) error {
+	local := isLocal(from) && isLocal(to)
+	return walkFiles(from, src, func(rel string, dir bool) error {
+		source, target := filepath.Join(src, rel), filepath.Join(dst, rel)
+		if dir {
+			return to.MkdirAll(target)
+		}
+		if local {
+			if link && os.Link(source, target) == nil {
+				return nil
+			}
+			return d.copyFile(source, target)
+		}
+		b, err := from.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		return to.WriteFile(target, b, d.opts.durability)
+	})
+}
+
+// fileInfo describes a file or directory of a Storage that has no other
+// way to, as for ReadDir and Stat of object stores
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi fileInfo) Name() string               { return path.Base(fi.name) }
+func (fi fileInfo) Size() int64                { return fi.size }
+func (fi fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi fileInfo) IsDir() bool                { return fi.dir }
+func (fi fileInfo) Sys() interface{}           { return nil }
+func (fi fileInfo) Type() fs.FileMode          { return fi.Mode().Type() }
+func (fi fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// notExist reports a missing name as fs.ErrNotExist wrapped in a PathError
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- SUB-COLLECTIONS ---
+
+// SubCollection names the collection called name grouped under a record,
+// e.g. SubCollection("users", "John Doe", "orders") is
+// "users/John Doe/orders". Such paths can be used anywhere a collection
+// name can; their files live in a directory beside the parent record's, so
+// backups, exports and restores carry them along with the top level
+// collection. Collections lists only top level collections.
+func SubCollection(collection, resource, name string) string {
+	return collection + "/" + resource + "/" + name
+}
+
+// SubCollections lists, by full path and in name order, the sub-collections
+// grouped under a record. The record itself need not exist.
+func (d *Driver) SubCollections(collection, resource string) ([]string, error) {
+	if err := validateNames(collection, resource); err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return d.subCollections(collection, resource)
+}
+
+// subCollections lists the sub-collections under a record. Callers must
+// hold the collection lock.
+func (d *Driver) subCollections(collection, resource string) ([]string, error) {
+	entries, err := d.fs.ReadDir(filepath.Join(d.collectionDir(collection), resource))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if validateName("collection", e.Name()) == nil {
+			names = append(names, SubCollection(collection, resource, e.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteCascade deletes a record together with everything grouped under
+// it: every record of its sub-collections, theirs in turn, and then the
+// sub-collections themselves, history and trash included. Each record is
+// deleted as with Delete, hooks and change events included, children
+// before their parent, so a failure part way leaves the parent in place
+// to retry the cascade. Deleting a record that is gone but still has
+// sub-collections succeeds.
+func (d *Driver) DeleteCascade(collection, resource string, opts ...WriteOption) error {
+	if err := validateNames(collection, resource); err != nil {
+		return err
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return err
+	}
+	subs, err := d.subCollections(collection, resource)
+	release()
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		if err := d.dropSubCollection(sub, opts); err != nil {
+			return err
+		}
+	}
+	err = d.Delete(collection, resource, opts...)
+	if len(subs) == 0 || err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	release, err = d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return d.fs.RemoveAll(filepath.Join(d.collectionDir(collection), resource))
+}
+
+// dropSubCollection cascade deletes every record of a sub-collection, then
+// removes what is left of its directory
+func (d *Driver) dropSubCollection(collection string, opts []WriteOption) error {
+	names, err := d.List(collection)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, name := range names {
+		if err := d.DeleteCascade(collection, name, opts...); err != nil {
+			return err
+		}
+	}
+
+	// sub-collections under records that were already gone
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return err
+	}
+	entries, err := d.fs.ReadDir(d.collectionDir(collection))
+	release()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || validateName("resource", e.Name()) != nil {
+			continue
+		}
+		if err := d.DeleteCascade(collection, e.Name(), opts...); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	release, err = d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	d.invalidateUnique(collection)
+	d.invalidateBloom(collection)
+	d.invalidateSearch(collection)
+	d.invalidateColumns(collection)
+	d.invalidateViews(collection)
+	d.invalidateDistinct(collection)
+	d.forgetSequence(collection)
+	d.forgetUsage(collection)
+	d.cache.invalidateCollection(collection)
+	d.forgetLayout(collection)
+	d.forgetDictionaries(collection)
+	return d.fs.RemoveAll(d.collectionDir(collection))
+}
@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// --- TEMPORARY COLLECTIONS ---
+
+// tempMarker is the hidden file marking a collection's directory as a
+// temporary collection's
+const tempMarker = ".temp"
+
+// TempCollection creates an empty collection named prefix followed by a
+// fresh ID, for staging a bulk transform or a session's scratch data, and
+// returns its name. It is a collection like any other until it is
+// dropped: after ttl, when ttl is positive, on DropTempCollection, or
+// when the Driver is closed. One left behind by a Driver that was never
+// closed is dropped by the next New on the directory.
+func (d *Driver) TempCollection(prefix string, ttl time.Duration) (string, error) {
+	if err := d.writable(); err != nil {
+		return "", err
+	}
+	if prefix == "" {
+		prefix = "tmp"
+	}
+	if err := validateName("collection prefix", prefix); err != nil {
+		return "", err
+	}
+	id, err := NewID()
+	if err != nil {
+		return "", err
+	}
+	name := prefix + "-" + id
+	if err := d.life.enter(); err != nil {
+		return "", err
+	}
+	defer d.life.leave()
+
+	dir := d.collectionDir(name)
+	if err := d.fs.MkdirAll(dir); err != nil {
+		return "", err
+	}
+	if err := d.fs.WriteFile(filepath.Join(dir, tempMarker), nil, d.opts.durability); err != nil {
+		return "", err
+	}
+
+	var timer *time.Timer
+	if ttl > 0 {
+		timer = time.AfterFunc(ttl, func() {
+			if err := d.DropTempCollection(name); err != nil && !errors.Is(err, ErrClosed) && !errors.Is(err, fs.ErrNotExist) {
+				d.opts.logger.Error("dropping expired temporary collection failed", "collection", name, "err", err)
+			}
+		})
+	}
+	d.mutex.Lock()
+	first := d.temps == nil
+	if first {
+		d.temps = make(map[string]*time.Timer)
+	}
+	d.temps[name] = timer
+	d.mutex.Unlock()
+	if first {
+		d.onClose(d.dropTempCollections)
+	}
+	return name, nil
+}
+
+// DropTempCollection drops a collection TempCollection created, with all
+// its records, before its time. Other collections can't be dropped this
+// way: they fail with an error wrapping fs.ErrNotExist.
+func (d *Driver) DropTempCollection(name string) error {
+	if err := validateCollection(name); err != nil {
+		return err
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+	release, err := d.acquire(name, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if _, err := d.fs.Stat(filepath.Join(d.collectionDir(name), tempMarker)); err != nil {
+		return fmt.Errorf("temporary collection %s: %w", name, err)
+	}
+	d.mutex.Lock()
+	if timer := d.temps[name]; timer != nil {
+		timer.Stop()
+	}
+	delete(d.temps, name)
+	d.mutex.Unlock()
+	return d.removeTempCollection(name)
+}
+
+// dropTempCollections drops the temporary collections still around when
+// the Driver closes
+func (d *Driver) dropTempCollections() error {
+	d.mutex.Lock()
+	temps := d.temps
+	d.temps = nil
+	d.mutex.Unlock()
+
+	var errs []error
+	for name, timer := range temps {
+		if timer != nil {
+			timer.Stop()
+		}
+		errs = append(errs, d.removeTempCollection(name))
+	}
+	return errors.Join(errs...)
+}
+
+// sweepTempCollections drops the temporary collections of a Driver that
+// was never closed
+func (d *Driver) sweepTempCollections() error {
+	collections, err := d.storedCollections()
+	if err != nil {
+		return err
+	}
+	for _, c := range collections {
+		if isSystemCollection(c) {
+			continue
+		}
+		if _, err := d.fs.Stat(filepath.Join(d.collectionDir(c), tempMarker)); err != nil {
+			continue
+		}
+		if err := d.removeTempCollection(c); err != nil {
+			return err
+		}
+		d.opts.logger.Info("dropped leftover temporary collection", "collection", c)
+	}
+	return nil
+}
+
+// removeTempCollection forgets everything about a collection and removes
+// its directory. Callers must hold the collection's write lock, or have
+// the Driver to themselves.
+func (d *Driver) removeTempCollection(name string) error {
+	d.invalidateUnique(name)
+	d.invalidateBloom(name)
+	d.invalidateSearch(name)
+	d.invalidateColumns(name)
+	d.invalidateViews(name)
+	d.invalidateDistinct(name)
+	d.forgetSequence(name)
+	d.forgetUsage(name)
+	d.cache.invalidateCollection(name)
+	if err := d.fs.RemoveAll(d.collectionDir(name)); err != nil {
+		return err
+	}
+	d.forgetPlacement(name)
+	d.forgetLayout(name)
+	d.forgetDictionaries(name)
+	d.mutex.Lock()
+	delete(d.collections, name)
+	d.mutex.Unlock()
+	return nil
+}
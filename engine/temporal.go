@@ -0,0 +1,333 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- HISTORY AND TIME TRAVEL ---
+
+// historyDir is the hidden directory inside a collection holding the
+// versions of its records. Resource names can't start with a dot, so it
+// never clashes with a record.
+const historyDir = ".history"
+
+// Version is one recorded state of a record in a collection keeping history
+type Version struct {
+	// TxTime is when the version was recorded (transaction time)
+	TxTime time.Time `json:"txTime"`
+	// ValidFrom is when the version took effect in the real world (valid
+	// time); it stays in effect until the next version by valid time
+	ValidFrom time.Time       `json:"validFrom"`
+	Deleted   bool            `json:"deleted,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// EnableBitemporal keeps every write and delete of collection as a Version
+// carrying both when it was recorded and when it took effect, so AsOf can
+// answer what the database knew at one time about the state at another.
+// Write and Delete take effect immediately; WriteValid and DeleteValid
+// record changes effective at another time, including corrections to the
+// past. Read returns the version in effect at the time of the latest
+// change. Records written before history was enabled have none until their
+// next write.
+func (d *Driver) EnableBitemporal(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	cfg := d.config(collection)
+	cfg.history = true
+	cfg.bitemporal = true
+}
+
+// KeepHistory records every write and delete of collection as a Version, so
+// ReadAsOf, ReadAllAsOf and FindAsOf can show the collection as it was at a past time.
+// Records written before history was kept have none until their next write.
+func (d *Driver) KeepHistory(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).history = true
+}
+
+// ReadAsOf decodes into v a record as it read at time t. In a bitemporal
+// collection that is the version in effect at t as known at t. It fails
+// with an error wrapping fs.ErrNotExist when the record didn't exist then.
+func (d *Driver) ReadAsOf(collection, resource string, t time.Time, v interface{}, opts ...ReadOption) error {
+	return d.AsOf(collection, resource, t, t, v, opts...)
+}
+
+// FindAsOf returns the records of collection, as they were at time t, that
+// match filter. Only records with history are considered. A nil filter
+// matches everything. Reading the past is authorized, and filtered by the
+// read Policy, as Find is.
+func (d *Driver) FindAsOf(collection string, t time.Time, filter Filter, opts ...ReadOption) ([]Record, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+	cfg := d.snapshotConfig(collection)
+	guard := d.readGuard(p.ctx, collection)
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	dirs, err := d.fs.ReadDir(filepath.Join(d.collectionDir(collection), historyDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Record
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		versions, err := d.loadHistory(collection, dir.Name())
+		if err != nil {
+			return nil, err
+		}
+		ver := resolveVersion(versions, t, t)
+		if ver == nil {
+			continue
+		}
+		rec := &Record{Resource: dir.Name(), Data: ver.Data}
+		if err := cfg.reshapeRead(rec); err != nil {
+			return nil, err
+		}
+		if guard != nil && !guard.Match(rec) {
+			continue
+		}
+		if filter == nil || filter.Match(rec) {
+			out = append(out, *rec)
+		}
+	}
+	return out, nil
+}
+
+// ReadAllAsOf returns the documents of collection as they were at time t,
+// in name order, as ReadAll would have then. Only records with history are
+// considered.
+func (d *Driver) ReadAllAsOf(collection string, t time.Time, opts ...ReadOption) ([][]byte, error) {
+	records, err := d.FindAsOf(collection, t, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([][]byte, len(records))
+	for i, rec := range records {
+		docs[i] = rec.Data
+	}
+	return docs, nil
+}
+
+// WriteValid writes a version of a record that is in effect from validFrom
+// on, in a collection with EnableBitemporal set
+func (d *Driver) WriteValid(collection, resource string, v interface{}, validFrom time.Time) error {
+	if err := d.requireBitemporal(collection); err != nil {
+		return err
+	}
+	return d.writeWith(collection, resource, v, writeParams{validFrom: validFrom})
+}
+
+// DeleteValid records that a record ceases to exist at validFrom, in a
+// collection with EnableBitemporal set
+func (d *Driver) DeleteValid(collection, resource string, validFrom time.Time) error {
+	if err := d.requireBitemporal(collection); err != nil {
+		return err
+	}
+	return d.deleteWith(collection, resource, writeParams{validFrom: validFrom})
+}
+
+// AsOf decodes into v the state of a record in effect at validTime, as the
+// database knew it at txTime. It fails with an error wrapping
+// fs.ErrNotExist when no version matches. It is authorized as Read is, a
+// version the read Policy hides reading as missing.
+func (d *Driver) AsOf(collection, resource string, validTime, txTime time.Time, v interface{}, opts ...ReadOption) error {
+	if err := validateNames(collection, resource); err != nil {
+		return err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, resource); err != nil {
+		return err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return err
+	}
+	versions, err := d.loadHistory(collection, resource)
+	release()
+	if err != nil {
+		return err
+	}
+
+	ver := resolveVersion(versions, validTime, txTime)
+	if ver == nil {
+		return fmt.Errorf("%s/%s as of %s (recorded by %s): %w", collection, resource,
+			validTime.Format(time.RFC3339), txTime.Format(time.RFC3339), fs.ErrNotExist)
+	}
+
+	rec := &Record{Resource: resource, Data: ver.Data}
+	if err := d.snapshotConfig(collection).reshapeRead(rec); err != nil {
+		return err
+	}
+	if guard := d.readGuard(p.ctx, collection); guard != nil && !guard.Match(rec) {
+		return fmt.Errorf("%s/%s: %w", collection, resource, fs.ErrNotExist)
+	}
+	return rec.Decode(v)
+}
+
+// History lists the recorded versions of a record in transaction time
+// order. It is authorized as a Read of the record and leaves out the
+// versions the read Policy hides; deletions are always listed.
+func (d *Driver) History(collection, resource string, opts ...ReadOption) ([]Version, error) {
+	if err := validateNames(collection, resource); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, resource); err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := d.loadHistory(collection, resource)
+	release()
+	guard := d.readGuard(p.ctx, collection)
+	if err != nil || guard == nil {
+		return versions, err
+	}
+	shown := versions[:0]
+	for _, ver := range versions {
+		if ver.Deleted || guard.Match(&Record{Resource: resource, Data: ver.Data}) {
+			shown = append(shown, ver)
+		}
+	}
+	return shown, nil
+}
+
+func (d *Driver) requireBitemporal(collection string) error {
+	if !d.snapshotConfig(collection).bitemporal {
+		return fmt.Errorf("collection %q is not bitemporal", collection)
+	}
+	return nil
+}
+
+func (d *Driver) historyPath(collection, resource string) string {
+	return filepath.Join(d.collectionDir(collection), historyDir, resource)
+}
+
+// hasHistory reports whether any version of a record was recorded
+func (d *Driver) hasHistory(collection, resource string) bool {
+	_, err := d.fs.Stat(d.historyPath(collection, resource))
+	return err == nil
+}
+
+// loadHistory reads the versions of a record in transaction time order.
+// Callers must hold the collection lock.
+func (d *Driver) loadHistory(collection, resource string) ([]Version, error) {
+	dir := d.historyPath(collection, resource)
+	files, err := d.fs.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Version, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		b, err := d.fs.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var ver Version
+		if err := json.Unmarshal(b, &ver); err != nil {
+			return nil, fmt.Errorf("history of %s/%s: %s: %w", collection, resource, file.Name(), err)
+		}
+		versions = append(versions, ver)
+	}
+	return versions, nil
+}
+
+// recordVersion appends a version of a record to its history and returns
+// the version now in effect, or nil when there is none. Callers must hold
+// the collection's write lock.
+func (d *Driver) recordVersion(collection, resource string, v interface{}, deleted bool, validFrom time.Time, level Durability) (*Version, error) {
+	versions, err := d.loadHistory(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	ver := Version{TxTime: now, ValidFrom: validFrom.UTC(), Deleted: deleted}
+	if n := len(versions); n > 0 && !ver.TxTime.After(versions[n-1].TxTime) {
+		// file names order the history, so keep transaction times distinct
+		ver.TxTime = versions[n-1].TxTime.Add(time.Nanosecond)
+	}
+	if validFrom.IsZero() {
+		ver.ValidFrom = ver.TxTime
+	}
+	if !deleted {
+		if ver.Data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := d.marshalIndented(ver)
+	if err != nil {
+		return nil, err
+	}
+	dir := d.historyPath(collection, resource)
+	if err := d.fs.WriteFile(filepath.Join(dir, versionName(ver)), b, level); err != nil {
+		return nil, err
+	}
+
+	versions = append(versions, ver)
+	return resolveVersion(versions, ver.TxTime, ver.TxTime), nil
+}
+
+// versionName is the file name of a version in its record's history; it
+// orders versions by transaction time
+func versionName(ver Version) string {
+	return fmt.Sprintf("%020d.json", ver.TxTime.UnixNano())
+}
+
+// resolveVersion picks the version in effect at validTime among those
+// recorded by txTime: the latest by valid time, and among equals the one
+// recorded last. A deletion in effect resolves to nil.
+func resolveVersion(versions []Version, validTime, txTime time.Time) *Version {
+	var best *Version
+	for i := range versions {
+		ver := &versions[i]
+		if ver.TxTime.After(txTime) {
+			break
+		}
+		if ver.ValidFrom.After(validTime) {
+			continue
+		}
+		if best == nil || !ver.ValidFrom.Before(best.ValidFrom) {
+			best = ver
+		}
+	}
+	if best == nil || best.Deleted {
+		return nil
+	}
+	return best
+}
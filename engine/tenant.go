@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// --- NAMESPACES AND ACCESS KEYS ---
+
+// NamespaceCollection holds one record per namespace, out of reach of
+// callers as the other _system collections are
+const NamespaceCollection = systemPrefix + "namespaces"
+
+// NamespaceRoot is the collection the namespaces' collections are
+// sub-collections of: namespace "acme" keeps its "users" in
+// "namespaces/acme/users"
+const NamespaceRoot = "namespaces"
+
+// KeyCollection holds the access keys issued for namespaces, one record
+// per key, named by the key's hash so the keys themselves are never stored
+const KeyCollection = systemPrefix + "keys"
+
+// ErrPermissionDenied is returned (wrapped) for an unknown or revoked
+// access key, and by session calls its access doesn't allow
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Access is what an access key allows in its namespace
+type Access int
+
+const (
+	// AccessReadWrite keys may read, write and delete
+	AccessReadWrite Access = iota
+	// AccessRead keys may only read
+	AccessRead
+)
+
+// Namespace is the stored record of a namespace
+type Namespace struct {
+	Created time.Time `json:"created"`
+}
+
+// accessKey is the stored record of an access key
+type accessKey struct {
+	Namespace string    `json:"namespace"`
+	Access    Access    `json:"access"`
+	Issued    time.Time `json:"issued"`
+}
+
+// CreateNamespace adds a namespace, failing with an error wrapping
+// ErrDuplicate if it exists. It is authorized as a write of the
+// namespace's record of NamespaceRoot.
+func (d *Driver) CreateNamespace(name string, opts ...WriteOption) error {
+	if err := validateName("namespace", name); err != nil {
+		return err
+	}
+	p := newWriteParams(opts)
+	if err := d.authorize(p.ctx, ActionWrite, NamespaceRoot, name); err != nil {
+		return err
+	}
+	err := d.applyWrite(NamespaceCollection, name, Namespace{Created: time.Now().UTC()}, writeParams{absent: true})
+	if errors.Is(err, errPrecondition) {
+		return fmt.Errorf("%w: namespace %s already exists", ErrDuplicate, name)
+	}
+	return err
+}
+
+// Namespaces lists the namespaces in name order
+func (d *Driver) Namespaces() ([]string, error) {
+	release, err := d.acquire(NamespaceCollection, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	names, err := d.recordNames(NamespaceCollection)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return names, err
+}
+
+// HasNamespaces reports whether any namespace exists
+func (d *Driver) HasNamespaces() (bool, error) {
+	names, err := d.Namespaces()
+	return len(names) > 0, err
+}
+
+// DropNamespace revokes every key of a namespace, then deletes it with all
+// its collections as DeleteCascade does. It is authorized as a Delete of
+// the namespace's record of NamespaceRoot.
+func (d *Driver) DropNamespace(name string, opts ...WriteOption) error {
+	if err := validateName("namespace", name); err != nil {
+		return err
+	}
+	p := newWriteParams(opts)
+	if err := d.authorize(p.ctx, ActionDelete, NamespaceRoot, name); err != nil {
+		return err
+	}
+	if _, err := d.readRecord(NamespaceCollection, name); err != nil {
+		return fmt.Errorf("namespace %s: %w", name, err)
+	}
+	var revoked []string
+	err := d.scan(KeyCollection, func(rec *Record) error {
+		var key accessKey
+		if err := json.Unmarshal(rec.Data, &key); err != nil {
+			return err
+		}
+		if key.Namespace == name {
+			revoked = append(revoked, rec.Resource)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, hash := range revoked {
+		if err := d.delete(KeyCollection, hash); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := d.DeleteCascade(NamespaceRoot, name, opts...); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return d.delete(NamespaceCollection, name)
+}
+
+// IssueKey creates an access key for a namespace, to be handed to the
+// application using it. Only its hash is stored: the key can't be
+// recovered later, only revoked. It is authorized as a write of the
+// namespace's record of NamespaceRoot.
+func (d *Driver) IssueKey(namespace string, access Access, opts ...WriteOption) (string, error) {
+	if err := validateName("namespace", namespace); err != nil {
+		return "", err
+	}
+	if access != AccessReadWrite && access != AccessRead {
+		return "", fmt.Errorf("unknown access %d", access)
+	}
+	p := newWriteParams(opts)
+	if err := d.authorize(p.ctx, ActionWrite, NamespaceRoot, namespace); err != nil {
+		return "", err
+	}
+	if _, err := d.readRecord(NamespaceCollection, namespace); err != nil {
+		return "", fmt.Errorf("namespace %s: %w", namespace, err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(b)
+	rec := accessKey{Namespace: namespace, Access: access, Issued: time.Now().UTC()}
+	if err := d.write(KeyCollection, keyHash(key), rec); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// RevokeKey makes an access key unusable from the next call on. It is
+// authorized as IssueKey is.
+func (d *Driver) RevokeKey(key string, opts ...WriteOption) error {
+	hash := keyHash(key)
+	rec, err := d.readKey(hash)
+	if err != nil {
+		return err
+	}
+	p := newWriteParams(opts)
+	if err := d.authorize(p.ctx, ActionWrite, NamespaceRoot, rec.Namespace); err != nil {
+		return err
+	}
+	return d.delete(KeyCollection, hash)
+}
+
+// Authenticate starts a session confined to the namespace of an access
+// key, and allowed only what the key allows. Collection names, opts's
+// Namespace included, are taken within the key's namespace. An unknown or
+// revoked key fails with an error wrapping ErrPermissionDenied.
+func (d *Driver) Authenticate(key string, opts SessionOptions) (*Session, error) {
+	rec, err := d.readKey(keyHash(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: unknown access key", ErrPermissionDenied)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ns := NamespaceRoot + "/" + rec.Namespace
+	if opts.Namespace != "" {
+		ns += "/" + opts.Namespace
+	}
+	opts.Namespace = ns
+	s := d.Session(opts)
+	s.readOnly = rec.Access == AccessRead
+	return s, nil
+}
+
+// readKey reads the stored record of the key with the given hash
+func (d *Driver) readKey(hash string) (*accessKey, error) {
+	rec, err := d.readRecord(KeyCollection, hash)
+	if err != nil {
+		return nil, err
+	}
+	var key accessKey
+	if err := json.Unmarshal(rec.Data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// keyHash is the name an access key is stored under
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestNamespaceRecordsOutOfReach(t *testing.T) {
+	d := openTest(t)
+	if err := d.CreateNamespace("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateNamespace("acme"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("creating acme again: %v, want ErrDuplicate", err)
+	}
+	if _, err := d.IssueKey("acme", AccessReadWrite); err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Read", func() error { return d.Read(NamespaceCollection, "acme", &v) }},
+		{"Write", func() error { return d.Write(NamespaceCollection, "acme", map[string]int{}) }},
+		{"Delete", func() error { return d.Delete(NamespaceCollection, "acme") }},
+		{"ReadAll keys", func() error { _, err := d.ReadAll(KeyCollection); return err }},
+		{"Write sub-collection", func() error { return d.Write(NamespaceCollection+"/acme/users", "u", map[string]int{}) }},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%s: %v, want ErrInvalidName", tt.name, err)
+		}
+	}
+	if names, err := d.Namespaces(); err != nil || len(names) != 1 || names[0] != "acme" {
+		t.Errorf("Namespaces = %v, %v, want [acme]", names, err)
+	}
+}
+
+func TestAccessKeys(t *testing.T) {
+	d := openTest(t)
+	for _, ns := range []string{"acme", "globex"} {
+		if err := d.CreateNamespace(ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rw, err := d.IssueKey("acme", AccessReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ro, err := d.IssueKey("acme", AccessRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.IssueKey("initech", AccessRead); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("IssueKey for a missing namespace: %v, want fs.ErrNotExist", err)
+	}
+
+	s, err := d.Authenticate(rw, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("users", "u", map[string]string{"name": "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := d.Read(NamespaceRoot+"/acme/users", "u", &got); err != nil || got["name"] != "Ann" {
+		t.Errorf("the session's write is at %v, %v", got, err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		op   func(s *Session) error
+		want error
+	}{
+		{"read-only key reads", ro, func(s *Session) error { return s.Read("users", "u", &got) }, nil},
+		{"read-only key writes", ro, func(s *Session) error { return s.Write("users", "v", map[string]int{}) }, ErrPermissionDenied},
+		{"read-only key deletes", ro, func(s *Session) error { return s.Delete("users", "u") }, ErrPermissionDenied},
+		{"unknown key", "nope", nil, ErrPermissionDenied},
+		{"no other namespace", rw, func(s *Session) error {
+			return s.Read("../globex/users", "u", &got)
+		}, ErrInvalidName},
+		{"no watching everything", rw, func(s *Session) error {
+			_, err := s.Watch(t.Context(), "")
+			return err
+		}, ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		s, err := d.Authenticate(tt.key, SessionOptions{})
+		if err == nil {
+			err = tt.op(s)
+		}
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if err := d.RevokeKey(ro); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authenticate(ro, SessionOptions{}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("revoked key: %v, want ErrPermissionDenied", err)
+	}
+
+	if err := d.DropNamespace("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authenticate(rw, SessionOptions{}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("key of a dropped namespace: %v, want ErrPermissionDenied", err)
+	}
+	if err := d.Read(NamespaceRoot+"/acme/users", "u", &got); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("data of a dropped namespace: %v, want fs.ErrNotExist", err)
+	}
+	if names, _ := d.Namespaces(); len(names) != 1 || names[0] != "globex" {
+		t.Errorf("Namespaces = %v, want [globex]", names)
+	}
+	if err := d.DropNamespace("acme"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("dropping acme again: %v, want fs.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// --- TRACING ---
+
+// Tracer starts the spans of a Driver's calls, set with WithTracer. It is
+// shaped to be a thin wrapper around an OpenTelemetry trace.Tracer, which
+// the engine doesn't depend on:
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, attrs ...engine.Attribute) engine.Span {
+//		_, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+//			trace.WithAttributes(toOtel(attrs)...))
+//		return otelSpan{span}
+//	}
+//
+// where otelSpan's End records err on the span before ending it.
+type Tracer interface {
+	// Start begins a span named name, a child of the span in ctx if any
+	Start(ctx context.Context, name string, attrs ...Attribute) Span
+}
+
+// Span is a call being traced
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// End finishes the span, with the error the call failed with or nil
+	End(err error)
+}
+
+// Attribute is a key and a value, a string, int64 or bool, on a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// WithTracer traces Write, Read, ReadAll, Delete and Find with t. Each
+// span is named after the operation and collection, such as "Write
+// users", and carries the collection, the resource, the bytes of the
+// documents written or read and the outcome: ok, not_found or error. The
+// parent span comes from the context given with WriteContext or
+// ReadContext, or by the SessionOptions the call went through.
+func WithTracer(t Tracer) Option {
+	return func(o *options) { o.tracer = t }
+}
+
+// WriteContext makes ctx the context of the call: the parent of its span
+// and what its Authorizer sees
+func WriteContext(ctx context.Context) WriteOption {
+	return func(p *writeParams) { p.ctx = ctx }
+}
+
+// ReadContext makes ctx the context of the call, as WriteContext does
+func ReadContext(ctx context.Context) ReadOption {
+	return func(p *readParams) { p.ctx = ctx }
+}
+
+// opTrace is the span of one call, nil when the Driver has no Tracer
+type opTrace struct {
+	span    Span
+	bytes   int64
+	records int64
+	counted bool // whether records applies to the operation
+}
+
+// startSpan begins the span of an operation on collection; resource is ""
+// for a whole collection
+func (d *Driver) startSpan(ctx context.Context, op, collection, resource string) *opTrace {
+	if d.opts.tracer == nil {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attrs := []Attribute{
+		{"db.system.name", "godb"},
+		{"db.operation.name", op},
+		{"db.collection.name", collection},
+	}
+	if resource != "" {
+		attrs = append(attrs, Attribute{"godb.resource", resource})
+	}
+	return &opTrace{span: d.opts.tracer.Start(ctx, op+" "+collection, attrs...)}
+}
+
+// addBytes counts a document of n bytes the call read or wrote
+func (t *opTrace) addBytes(n int) {
+	if t != nil {
+		t.bytes += int64(n)
+	}
+}
+
+// returned notes that the call returned n records
+func (t *opTrace) returned(n int) {
+	if t != nil {
+		t.records, t.counted = int64(n), true
+	}
+}
+
+func (t *opTrace) end(err error) {
+	if t == nil {
+		return
+	}
+	outcome := "ok"
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		outcome = "not_found"
+	case err != nil:
+		outcome = "error"
+	}
+	attrs := []Attribute{{"godb.bytes", t.bytes}, {"godb.outcome", outcome}}
+	if t.counted {
+		attrs = append(attrs, Attribute{"godb.records", t.records})
+	}
+	t.span.SetAttributes(attrs...)
+	t.span.End(err)
+}
@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// --- BATCH TRANSFORMS ---
+
+// CheckpointCollection holds the progress of named Transform runs
+const CheckpointCollection = systemPrefix + "checkpoints"
+
+// TransformFunc rewrites one record. It returns the new document, or nil to
+// leave the record as it is.
+type TransformFunc func(rec *Record) (interface{}, error)
+
+// TransformOptions tunes a Transform run
+type TransformOptions struct {
+	// RateLimit caps the records processed per second; zero means no limit
+	RateLimit int
+	// Checkpoint names the run. Progress is saved under that name so an
+	// interrupted or failed run picks up where it stopped when started again
+	// with the same name. The checkpoint is removed once the run completes.
+	Checkpoint string
+	// CheckpointEvery is how many records pass between saves (default 100)
+	CheckpointEvery int
+	// DryRun calls fn and validates its results without writing anything
+	DryRun bool
+	// Progress, when set, is called after every record
+	Progress func(TransformSummary)
+}
+
+// TransformSummary reports what a Transform run did
+type TransformSummary struct {
+	Scanned   int           `json:"scanned"`
+	Changed   int           `json:"changed"`
+	Unchanged int           `json:"unchanged"`
+	Resumed   int           `json:"resumed"` // records skipped as done by an earlier run
+	Elapsed   time.Duration `json:"elapsed"`
+}
+
+// transformCheckpoint is the saved progress of a named run
+type transformCheckpoint struct {
+	Collection string    `json:"collection"`
+	Last       string    `json:"last"`
+	Scanned    int       `json:"scanned"`
+	Changed    int       `json:"changed"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Transform streams every record of collection through fn, in resource name
+// order, and writes back the documents fn changes. Writes go through the
+// usual hooks, defaults and validation. The run stops at the first error;
+// with a Checkpoint set, running it again resumes after the last record
+// that was saved.
+func (d *Driver) Transform(collection string, fn TransformFunc, opts TransformOptions) (*TransformSummary, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	if opts.Checkpoint != "" {
+		if err := validateName("checkpoint", opts.Checkpoint); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CheckpointEvery <= 0 {
+		opts.CheckpointEvery = 100
+	}
+
+	resources, err := d.List(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	cp, err := d.loadCheckpoint(opts.Checkpoint, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var interval time.Duration
+	if opts.RateLimit > 0 {
+		interval = time.Second / time.Duration(opts.RateLimit)
+	}
+
+	d.opts.logger.Info("transform started", "collection", collection, "checkpoint", opts.Checkpoint, "resumeAfter", cp.Last, "dryRun", opts.DryRun)
+	start := time.Now()
+	summary := &TransformSummary{}
+	next := start
+	save := func() error {
+		if opts.Checkpoint == "" || opts.DryRun {
+			return nil
+		}
+		cp.UpdatedAt = time.Now().UTC()
+		d.opts.logger.Debug("transform checkpoint", "checkpoint", opts.Checkpoint, "last", cp.Last)
+		return d.write(CheckpointCollection, opts.Checkpoint, cp)
+	}
+
+	for _, res := range resources {
+		if cp.Last != "" && res <= cp.Last {
+			summary.Resumed++
+			continue
+		}
+		if interval > 0 {
+			time.Sleep(time.Until(next))
+			next = maxTime(next, time.Now()).Add(interval)
+		}
+
+		changed, err := d.transformRecord(collection, res, fn, opts.DryRun)
+		if err != nil {
+			summary.Elapsed = time.Since(start)
+			d.opts.logger.Warn("transform stopped", "collection", collection, "resource", res, "err", err)
+			if serr := save(); serr != nil {
+				err = errors.Join(err, serr)
+			}
+			return summary, fmt.Errorf("transforming %q: %w", res, err)
+		}
+
+		summary.Scanned++
+		cp.Scanned++
+		if changed {
+			summary.Changed++
+			cp.Changed++
+		} else {
+			summary.Unchanged++
+		}
+		cp.Last = res
+
+		if summary.Scanned%opts.CheckpointEvery == 0 {
+			if err := save(); err != nil {
+				return summary, err
+			}
+		}
+		if opts.Progress != nil {
+			summary.Elapsed = time.Since(start)
+			opts.Progress(*summary)
+		}
+	}
+
+	summary.Elapsed = time.Since(start)
+	d.opts.logger.Info("transform finished", "collection", collection, "scanned", summary.Scanned, "changed", summary.Changed, "elapsed", summary.Elapsed)
+	if opts.Checkpoint != "" && !opts.DryRun {
+		if err := d.delete(CheckpointCollection, opts.Checkpoint); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// transformRecord runs fn over one record and stores the result, reporting
+// whether the document changed. Records deleted since the listing are
+// treated as unchanged.
+func (d *Driver) transformRecord(collection, resource string, fn TransformFunc, dryRun bool) (bool, error) {
+	rec, err := d.readRecord(collection, resource)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	out, err := fn(rec)
+	if err != nil || out == nil {
+		return false, err
+	}
+
+	before, err := rec.Document()
+	if err != nil {
+		return false, err
+	}
+	after, err := toDocument(out)
+	if err != nil {
+		return false, err
+	}
+	if jsonEqual(before, after) {
+		return false, nil
+	}
+
+	if dryRun {
+		v, err := d.snapshotConfig(collection).reshapeWrite(after)
+		if err != nil {
+			return false, err
+		}
+		return true, d.validate(collection, v)
+	}
+	return true, d.Write(collection, resource, after)
+}
+
+// loadCheckpoint returns the saved progress of a named run, or an empty
+// checkpoint when there is none
+func (d *Driver) loadCheckpoint(name, collection string) (*transformCheckpoint, error) {
+	cp := &transformCheckpoint{Collection: collection}
+	if name == "" {
+		return cp, nil
+	}
+
+	rec, err := d.readRecord(CheckpointCollection, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := rec.Decode(cp); err != nil {
+		return nil, err
+	}
+	if cp.Collection != collection {
+		return nil, fmt.Errorf("checkpoint %q belongs to collection %q", name, cp.Collection)
+	}
+	return cp, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- SOFT DELETE ---
+
+// trashDir is the hidden directory inside a collection holding soft
+// deleted records
+const trashDir = ".trash"
+
+// TrashEntry describes a soft deleted record
+type TrashEntry struct {
+	Resource  string    `json:"resource"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// trashed is the on-disk form of a trash entry; Record holds the deleted
+// record exactly as it was stored, envelope included, in JSON whatever the
+// collection's Codec
+type trashed struct {
+	DeletedAt time.Time       `json:"deletedAt"`
+	Record    json.RawMessage `json:"record"`
+}
+
+// DeleteSoft deletes a record like Delete, but keeps it in the collection's
+// trash so RestoreDeleted can bring it back until PurgeTrash removes it.
+// Soft deleting a name again replaces the earlier trash entry.
+func (d *Driver) DeleteSoft(collection, resource string, opts ...WriteOption) error {
+	p := newWriteParams(opts)
+	p.soft = true
+	return d.deleteWith(collection, resource, p)
+}
+
+// RestoreDeleted puts a soft deleted record back under its name, exactly as
+// it was stored. It fails with an error wrapping fs.ErrExist if a record
+// of that name has been written since. It is authorized as a Write of the
+// record, the document it brings back checked against the write Policy.
+func (d *Driver) RestoreDeleted(collection, resource string, opts ...WriteOption) error {
+	if err := validateNames(collection, resource); err != nil {
+		return err
+	}
+	p := newWriteParams(opts)
+	if err := d.admit(&p, ActionWrite, collection, resource); err != nil {
+		return err
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+	if err := d.notView(collection); err != nil {
+		return err
+	}
+	cfg := d.snapshotConfig(collection)
+	across, unlock := lockAcross(cfg, nil)
+	defer unlock()
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	path := d.recordPath(collection, resource, cfg)
+	if _, err := d.fs.Stat(path); err == nil {
+		return fmt.Errorf("restoring %s/%s: %w", collection, resource, fs.ErrExist)
+	}
+
+	trashPath := d.trashPath(collection, resource)
+	b, err := d.fs.ReadFile(trashPath)
+	if err != nil {
+		return err
+	}
+	var t trashed
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+
+	if p.guard != nil {
+		rec, err := decodeRecord(resource, t.Record)
+		if err != nil {
+			return err
+		}
+		if err := checkNew(p.guard, collection, resource, rec.Data); err != nil {
+			return err
+		}
+	}
+	if len(cfg.unique) > 0 {
+		rec, err := decodeRecord(resource, t.Record)
+		if err != nil {
+			return err
+		}
+		doc, err := rec.Document()
+		if err != nil {
+			return err
+		}
+		idx, err := d.uniqueIndexFor(collection, cfg.unique)
+		if err != nil {
+			return err
+		}
+		keys := uniqueKeys(cfg.unique, doc)
+		if err := idx.check(resource, keys); err != nil {
+			return err
+		}
+		if err := d.checkAcross(across, collection, keys); err != nil {
+			return err
+		}
+		defer idx.add(resource, keys)
+	}
+
+	data, err := d.encodeFile(collection, cfg, t.Record)
+	if err != nil {
+		return err
+	}
+	d.cache.invalidate(collection, resource)
+	if err := d.bloomAdd(collection, resource); err != nil {
+		return err
+	}
+	if err := d.fs.WriteFile(path, data, d.opts.durability); err != nil {
+		return err
+	}
+	d.forgetUsage(collection)
+	if rec, err := decodeRecord(resource, t.Record); err == nil {
+		d.reindexSearch(collection, resource, json.RawMessage(rec.Data))
+		d.reindexColumns(collection, resource, json.RawMessage(rec.Data))
+		d.reindexDistinct(collection, json.RawMessage(rec.Data))
+		d.refreshViews(collection, resource, t.Record)
+	} else {
+		d.invalidateSearch(collection)
+		d.invalidateColumns(collection)
+		d.invalidateDistinct(collection)
+		d.invalidateViews(collection)
+	}
+	d.opts.logger.Debug("restore deleted", "collection", collection, "resource", resource)
+	return d.fs.Remove(trashPath, d.opts.durability)
+}
+
+// Trash lists the soft deleted records of a collection in name order, as
+// far as the read Policy shows them
+func (d *Driver) Trash(collection string, opts ...ReadOption) ([]TrashEntry, error) {
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+	p := newReadParams(opts)
+	if err := d.authorize(p.ctx, ActionRead, collection, ""); err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return d.readTrash(collection, d.readGuard(p.ctx, collection))
+}
+
+// PurgeTrash permanently removes soft deleted records, in every collection,
+// that were deleted more than olderThan ago, and reports how many it
+// removed
+func (d *Driver) PurgeTrash(olderThan time.Duration) (int, error) {
+	if err := d.writable(); err != nil {
+		return 0, err
+	}
+	collections, err := d.storedCollections()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+	for _, c := range collections {
+		if isSystemCollection(c) {
+			continue
+		}
+		n, err := d.purgeCollectionTrash(c, cutoff)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+func (d *Driver) purgeCollectionTrash(collection string, cutoff time.Time) (int, error) {
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	entries, err := d.readTrash(collection, nil)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, e := range entries {
+		if !e.DeletedAt.Before(cutoff) {
+			continue
+		}
+		if err := d.fs.Remove(d.trashPath(collection, e.Resource), DurabilityNone); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	if purged > 0 {
+		d.opts.logger.Info("purged trash", "collection", collection, "records", purged)
+	}
+	return purged, nil
+}
+
+func (d *Driver) trashPath(collection, resource string) string {
+	return filepath.Join(d.collectionDir(collection), trashDir, resource+".json")
+}
+
+// moveToTrash copies a record's file into the trash ahead of its removal.
+// Callers must hold the collection's write lock.
+func (d *Driver) moveToTrash(collection, resource, path string, level Durability) error {
+	b, err := d.readLive(collection, path, d.snapshotConfig(collection))
+	if err != nil {
+		return err
+	}
+	out, err := d.marshalIndented(trashed{DeletedAt: time.Now().UTC(), Record: b})
+	if err != nil {
+		return err
+	}
+
+	return d.fs.WriteFile(d.trashPath(collection, resource), out, level)
+}
+
+// readTrash lists the trash of a collection, leaving out the records guard
+// doesn't match unless it is nil. Callers must hold the collection lock.
+func (d *Driver) readTrash(collection string, guard Filter) ([]TrashEntry, error) {
+	dir := filepath.Join(d.collectionDir(collection), trashDir)
+	files, err := d.fs.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TrashEntry
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		b, err := d.fs.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var t trashed
+		if err := json.Unmarshal(b, &t); err != nil {
+			return nil, fmt.Errorf("trash of %s: %s: %w", collection, file.Name(), err)
+		}
+		resource := strings.TrimSuffix(file.Name(), ".json")
+		if guard != nil {
+			if rec, err := decodeRecord(resource, t.Record); err != nil || !guard.Match(rec) {
+				continue
+			}
+		}
+		entries = append(entries, TrashEntry{Resource: resource, DeletedAt: t.DeletedAt})
+	}
+	return entries, nil
+}
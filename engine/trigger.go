@@ -0,0 +1,378 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sync"
+	"time"
+)
+
+// --- TRIGGERS ---
+
+// TriggerCollection holds the definitions of the triggers, one record per
+// trigger naming the collection it watches
+const TriggerCollection = systemPrefix + "triggers"
+
+// triggerQueuePrefix starts the names of the system collections holding
+// each trigger's pending events
+const triggerQueuePrefix = systemPrefix + "trigger-queue."
+
+// triggerMaxBackoff is the longest a trigger waits before running a
+// failed event again
+const triggerMaxBackoff = time.Minute
+
+// TriggerEvent is a change a trigger runs for
+type TriggerEvent struct {
+	ID         string    `json:"id"`
+	Trigger    string    `json:"trigger"`
+	Kind       OpKind    `json:"kind"`
+	Collection string    `json:"collection"`
+	Resource   string    `json:"resource"`
+	Time       time.Time `json:"time"`
+	// Before is the document as stored before the change, empty if the
+	// record didn't exist; After the document as stored after it, empty
+	// for deletes
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	// Attempts counts the earlier runs of the event that failed
+	Attempts int `json:"attempts"`
+}
+
+// TriggerFunc is the work a trigger does for each change, such as writing
+// to another collection. An error has the event run again later.
+type TriggerFunc func(ctx context.Context, e TriggerEvent) error
+
+// triggerDefinition is the stored record of a trigger
+type triggerDefinition struct {
+	Collection string `json:"collection"`
+}
+
+// trigger is a trigger whose function runs in this process
+type trigger struct {
+	name string
+	fn   TriggerFunc
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once // closes stop
+}
+
+// Trigger has fn run for every write and delete to collection, once the
+// change is applied, so that one collection can keep another up to date:
+// a trigger on users can maintain the employee count of each company, say,
+// decrementing the count of the Before document's company and
+// incrementing that of the After one.
+//
+// Triggers are persisted. The change, along with the document before and
+// after it, is queued in the database under the collection's write lock,
+// and fn runs for the queued changes in the background, in the order they
+// were applied, one at a time; an event fn fails is retried, with
+// growing pauses, before any later one runs. Only the function lives in
+// memory: call Trigger with every start of the process. Changes made
+// while it isn't registered are queued all the same, and run once it is.
+// An event is removed once fn returns, so one interrupted by a crash runs
+// again: fn should tolerate seeing a change twice.
+//
+// Changes applied from a primary's feed don't run the triggers of a
+// replica, as the primary's triggers already did their work. Calling
+// Trigger again with the same name needs the same collection.
+func (d *Driver) Trigger(name, collection string, fn TriggerFunc) error {
+	if err := validateName("trigger", name); err != nil {
+		return err
+	}
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	if fn == nil {
+		return fmt.Errorf("trigger %s has no function", name)
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+
+	var def triggerDefinition
+	rec, err := d.readRecord(TriggerCollection, name)
+	if err == nil {
+		err = json.Unmarshal(rec.Data, &def)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if def.Collection != "" && def.Collection != collection {
+		return fmt.Errorf("trigger %s watches %s, not %s", name, def.Collection, collection)
+	}
+
+	t := &trigger{name: name, fn: fn, wake: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	d.mutex.Lock()
+	if d.triggers[name] != nil {
+		d.mutex.Unlock()
+		return fmt.Errorf("trigger %s: %w: its function is registered already", name, fs.ErrExist)
+	}
+	d.triggers[name] = t
+	d.mutex.Unlock()
+
+	if def.Collection == "" {
+		if err := d.write(TriggerCollection, name, triggerDefinition{Collection: collection}); err != nil {
+			d.forgetTrigger(t)
+			return err
+		}
+		d.linkTrigger(name, collection)
+	}
+	d.opts.logger.Info("trigger registered", "trigger", name, "collection", collection)
+
+	go d.runTrigger(t)
+	d.onClose(func() error {
+		t.halt()
+		return nil
+	})
+	return nil
+}
+
+// DropTrigger removes a trigger along with its pending events. Dropping a
+// name that isn't a trigger does nothing.
+func (d *Driver) DropTrigger(name string) error {
+	if err := validateName("trigger", name); err != nil {
+		return err
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+	var def triggerDefinition
+	rec, err := d.readRecord(TriggerCollection, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		err = json.Unmarshal(rec.Data, &def)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	t := d.triggers[name]
+	d.mutex.Unlock()
+	if t != nil {
+		t.halt()
+		d.forgetTrigger(t)
+	}
+	d.unlinkTrigger(name, def.Collection)
+
+	queue := triggerQueue(name)
+	release, err := d.acquire(queue, true)
+	if err != nil {
+		return err
+	}
+	err = d.fs.RemoveAll(d.collectionDir(queue))
+	d.cache.invalidateCollection(queue)
+	release()
+	if err != nil {
+		return err
+	}
+	return d.remove(TriggerCollection, name, writeParams{})
+}
+
+// loadTriggers links the stored triggers to the collections they watch,
+// so that changes are queued for them before their functions are
+// registered
+func (d *Driver) loadTriggers() error {
+	err := d.walk(TriggerCollection, func(rec *Record) error {
+		var def triggerDefinition
+		if err := json.Unmarshal(rec.Data, &def); err != nil {
+			return fmt.Errorf("trigger %s: %w", rec.Resource, err)
+		}
+		d.linkTrigger(rec.Resource, def.Collection)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (d *Driver) linkTrigger(name, collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	cfg := d.config(collection)
+	if !slices.Contains(cfg.triggers, name) {
+		cfg.triggers = append(slices.Clone(cfg.triggers), name)
+	}
+}
+
+func (d *Driver) unlinkTrigger(name, collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	cfg := d.config(collection)
+	cfg.triggers = slices.DeleteFunc(slices.Clone(cfg.triggers), func(n string) bool { return n == name })
+}
+
+// forgetTrigger unregisters t's function
+func (d *Driver) forgetTrigger(t *trigger) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.triggers[t.name] == t {
+		delete(d.triggers, t.name)
+	}
+}
+
+// triggerQueue is the collection of the pending events of trigger name
+func triggerQueue(name string) string {
+	return triggerQueuePrefix + name
+}
+
+// fires reports whether a change made with params p runs the triggers of
+// a collection configured as cfg
+func fires(cfg *collectionConfig, p writeParams) bool {
+	return len(cfg.triggers) > 0 && !p.replicated && !p.quiet
+}
+
+// triggerBefore returns the document of the record at path for the
+// triggers of its collection, nil when the record doesn't exist or no
+// trigger fires. Callers must hold the collection's write lock.
+func (d *Driver) triggerBefore(collection, resource, path string, cfg *collectionConfig, p writeParams) (json.RawMessage, error) {
+	if !fires(cfg, p) {
+		return nil, nil
+	}
+	b, err := d.readLive(collection, path, cfg)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec, err := decodeRecord(resource, b)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Data, nil
+}
+
+// queueTriggers queues a change for every trigger of collection. Callers
+// must hold the collection's write lock.
+func (d *Driver) queueTriggers(kind OpKind, collection, resource string, cfg *collectionConfig, p writeParams, before json.RawMessage, after interface{}) error {
+	if !fires(cfg, p) {
+		return nil
+	}
+	e := TriggerEvent{Kind: kind, Collection: collection, Resource: resource, Time: time.Now().UTC(), Before: before}
+	switch v := after.(type) {
+	case nil:
+	case json.RawMessage:
+		e.After = v
+	case []byte:
+		e.After = v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		e.After = b
+	}
+
+	for _, name := range cfg.triggers {
+		id, err := NewID()
+		if err != nil {
+			return err
+		}
+		e.ID, e.Trigger = id, name
+		if _, err := d.store(triggerQueue(name), id, e, writeParams{durability: d.durability(p), durable: true}); err != nil {
+			return fmt.Errorf("queueing trigger %s: %w", name, err)
+		}
+		d.mutex.Lock()
+		t := d.triggers[name]
+		d.mutex.Unlock()
+		if t != nil {
+			select {
+			case t.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// runTrigger runs t's function for its pending events until t is halted
+func (d *Driver) runTrigger(t *trigger) {
+	defer close(t.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-t.stop
+		cancel()
+	}()
+
+	queue := triggerQueue(t.name)
+	for {
+		e, err := d.nextTriggerEvent(queue)
+		if err != nil && !errors.Is(err, ErrClosed) {
+			d.opts.logger.Error("reading trigger events failed", "trigger", t.name, "err", err)
+		}
+		if e == nil {
+			select {
+			case <-t.stop:
+				return
+			case <-t.wake:
+			}
+			continue
+		}
+
+		err = t.fn(ctx, *e)
+		if err == nil {
+			err = d.remove(queue, e.ID, writeParams{})
+			if err == nil || errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+		if errors.Is(err, ErrClosed) {
+			<-t.stop
+			return
+		}
+		e.Attempts++
+		d.opts.logger.Warn("trigger failed", "trigger", t.name, "collection", e.Collection, "resource", e.Resource, "attempts", e.Attempts, "err", err)
+		if err := d.write(queue, e.ID, e); err != nil && !errors.Is(err, ErrClosed) {
+			d.opts.logger.Error("recording trigger attempt failed", "trigger", t.name, "err", err)
+		}
+		pause := min(time.Second<<min(e.Attempts-1, 6), triggerMaxBackoff)
+		select {
+		case <-t.stop:
+			return
+		case <-time.After(pause):
+		}
+	}
+}
+
+// nextTriggerEvent returns the oldest pending event of queue, nil for none
+func (d *Driver) nextTriggerEvent(queue string) (*TriggerEvent, error) {
+	release, err := d.acquire(queue, false)
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.recordNames(queue)
+	release()
+	if errors.Is(err, fs.ErrNotExist) || err == nil && len(names) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// IDs sort in the order the events were queued
+	rec, err := d.readRecord(queue, names[0])
+	if err != nil {
+		return nil, err
+	}
+	e := &TriggerEvent{}
+	if err := json.Unmarshal(rec.Data, e); err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", queue, names[0], err)
+	}
+	return e, nil
+}
+
+// halt stops the trigger's function and waits for it to return. Halting
+// twice does nothing.
+func (t *trigger) halt() {
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+}
@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// --- UNIQUE CONSTRAINTS ---
+
+// ErrDuplicate is returned by Write when a unique field's value is already
+// used by another record
+var ErrDuplicate = errors.New("duplicate value")
+
+// uniqueIndex maps the values of a collection's unique fields to the
+// records holding them. It is built on first use from the stored records
+// and kept up to date by write and delete; callers must hold the
+// collection's write lock.
+type uniqueIndex struct {
+	fields  []string
+	owners  map[string]map[string]string // field -> value key -> resource
+	byOwner map[string]map[string]string // resource -> field -> value key
+	// arrays holds the records with an array at a field, which a lookup of
+	// one value can't find by key but the query planner must not miss
+	arrays map[string]map[string]bool // field -> resource
+}
+
+// UniqueField makes Write reject a document in collection whose value at
+// the (dot separated) path is already held by a different record, with an
+// error wrapping ErrDuplicate. Missing and null values are not checked, so
+// any number of records may leave the field out. Find looks up records
+// by the field in the index kept for this, as Plan describes.
+func (d *Driver) UniqueField(collection, path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	if !slices.Contains(cfg.unique, path) {
+		cfg.unique = append(slices.Clone(cfg.unique), path)
+	}
+}
+
+// uniqueIndexFor returns the index of collection for fields, building it
+// when missing or stale. Callers must hold the collection's write lock.
+func (d *Driver) uniqueIndexFor(collection string, fields []string) (*uniqueIndex, error) {
+	d.mutex.Lock()
+	idx := d.uniques[collection]
+	d.mutex.Unlock()
+	if idx != nil && slices.Equal(idx.fields, fields) {
+		return idx, nil
+	}
+
+	idx = &uniqueIndex{
+		fields:  fields,
+		owners:  make(map[string]map[string]string),
+		byOwner: make(map[string]map[string]string),
+		arrays:  make(map[string]map[string]bool),
+	}
+	for _, f := range fields {
+		idx.owners[f] = make(map[string]string)
+		idx.arrays[f] = make(map[string]bool)
+	}
+	err := d.walk(collection, func(rec *Record) error {
+		doc, err := rec.Document()
+		if err != nil {
+			return err
+		}
+		idx.add(rec.Resource, uniqueKeys(fields, doc))
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	d.uniques[collection] = idx
+	d.mutex.Unlock()
+	return idx, nil
+}
+
+// invalidateUnique drops the index of collection after its files were
+// replaced wholesale
+func (d *Driver) invalidateUnique(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.uniques, collection)
+}
+
+// check reports the first unique field whose value belongs to another record
+func (idx *uniqueIndex) check(resource string, keys map[string]string) error {
+	for _, f := range idx.fields {
+		key, ok := keys[f]
+		if !ok {
+			continue
+		}
+		if owner, taken := idx.owners[f][key]; taken && owner != resource {
+			return fmt.Errorf("%w: %s %s is already used by %q", ErrDuplicate, f, key, owner)
+		}
+	}
+	return nil
+}
+
+func (idx *uniqueIndex) add(resource string, keys map[string]string) {
+	idx.remove(resource)
+	for f, key := range keys {
+		idx.owners[f][key] = resource
+		if strings.HasPrefix(key, "[") {
+			idx.arrays[f][resource] = true
+		}
+	}
+	idx.byOwner[resource] = keys
+}
+
+func (idx *uniqueIndex) remove(resource string) {
+	for f, key := range idx.byOwner[resource] {
+		if idx.owners[f][key] == resource {
+			delete(idx.owners[f], key)
+		}
+		delete(idx.arrays[f], resource)
+	}
+	delete(idx.byOwner, resource)
+}
+
+// uniqueKeys returns the comparable form of each unique field present in doc
+func uniqueKeys(fields []string, doc interface{}) map[string]string {
+	obj, _ := doc.(map[string]interface{})
+	keys := make(map[string]string, len(fields))
+	for _, f := range fields {
+		v, ok := lookupPath(obj, f)
+		if !ok || v == nil {
+			continue
+		}
+		keys[f] = uniqueKey(v)
+	}
+	return keys
+}
+
+// uniqueKey encodes a generic value so equal values, numbers included,
+// encode identically
+func uniqueKey(v interface{}) string {
+	if n, ok := v.(json.Number); ok {
+		if f, ok := new(big.Float).SetString(n.String()); ok {
+			return f.Text('g', -1)
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// --- UNIQUE ACROSS COLLECTIONS ---
+
+// uniqueGroup is a field kept unique across several collections. Writes
+// to its collections that set the field hold mu, taken before any
+// collection lock and in id order, while they check the other
+// collections' unique indexes and store the record.
+type uniqueGroup struct {
+	mu          sync.Mutex
+	id          int
+	path        string
+	collections []string
+}
+
+// UniqueAcross makes the value at the (dot separated) path unique across
+// all of collections, as UniqueField does within one: an email held by a
+// record of "customers" can't be written to "staff" either. The field is
+// made unique within each collection too, and each collection's unique
+// index serves the others' checks.
+func (d *Driver) UniqueAcross(path string, collections ...string) error {
+	if path == "" {
+		return fmt.Errorf("missing unique path")
+	}
+	if len(collections) < 2 {
+		return fmt.Errorf("unique across needs two collections or more, got %d", len(collections))
+	}
+	for _, c := range collections {
+		if err := validateCollection(c); err != nil {
+			return err
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	g := &uniqueGroup{id: len(d.uniqueGroups), path: path, collections: slices.Clone(collections)}
+	d.uniqueGroups = append(d.uniqueGroups, g)
+	for _, c := range collections {
+		cfg := d.config(c)
+		if !slices.Contains(cfg.unique, path) {
+			cfg.unique = append(slices.Clone(cfg.unique), path)
+		}
+		cfg.across = append(slices.Clone(cfg.across), g)
+	}
+	return nil
+}
+
+// lockAcross locks the groups of cfg whose field keys sets, every group
+// when keys is nil, in id order, and returns the groups with the function
+// unlocking them
+func lockAcross(cfg *collectionConfig, keys map[string]string) ([]*uniqueGroup, func()) {
+	var groups []*uniqueGroup
+	for _, g := range cfg.across {
+		if _, ok := keys[g.path]; ok || keys == nil {
+			groups = append(groups, g)
+		}
+	}
+	slices.SortFunc(groups, func(a, b *uniqueGroup) int { return a.id - b.id })
+	for _, g := range groups {
+		g.mu.Lock()
+	}
+	return groups, func() {
+		for i := len(groups) - 1; i >= 0; i-- {
+			groups[i].mu.Unlock()
+		}
+	}
+}
+
+// checkAcross reports a value of keys that a record of another collection
+// of groups already holds. Callers must hold the groups' locks, which keep
+// those collections' values from changing, and may hold the write lock of
+// collection but of no other.
+func (d *Driver) checkAcross(groups []*uniqueGroup, collection string, keys map[string]string) error {
+	for _, g := range groups {
+		key, ok := keys[g.path]
+		if !ok {
+			continue
+		}
+		for _, c := range g.collections {
+			if c == collection {
+				continue
+			}
+			if err := d.checkHeld(c, g.path, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkHeld fails if a record of collection holds key at the unique path
+func (d *Driver) checkHeld(collection, path, key string) error {
+	cfg := d.snapshotConfig(collection)
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+	idx, err := d.uniqueIndexFor(collection, cfg.unique)
+	if err != nil {
+		return err
+	}
+	if owner, taken := idx.owners[path][key]; taken {
+		return fmt.Errorf("%w: %s %s is already used by %q in %s", ErrDuplicate, path, key, owner, collection)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// --- UPGRADE ON READ ---
+
+// UpgradeFunc migrates a document one schema version forward, editing it in
+// place
+type UpgradeFunc func(doc map[string]interface{}) error
+
+// RegisterUpgrade attaches fn as the step taking documents of collection
+// from schema version from to from+1. When a record stamped with an older
+// version than the one set by SetSchemaVersion is read, every step up to
+// the current version runs in order before the document reaches the
+// reader; versions without a registered step are passed through unchanged.
+func (d *Driver) RegisterUpgrade(collection string, from int, fn UpgradeFunc) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	upgrades := make(map[int]UpgradeFunc, len(cfg.upgrades)+1)
+	for v, f := range cfg.upgrades {
+		upgrades[v] = f
+	}
+	upgrades[from] = fn
+	cfg.upgrades = upgrades
+}
+
+// PersistUpgrades makes reads of collection write upgraded documents back,
+// so the stored data converges on the current schema version under normal
+// traffic. The write back is skipped when the record changed since it was
+// read.
+func (d *Driver) PersistUpgrades(collection string, persist bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config(collection).persistUpgrades = persist
+}
+
+// needsUpgrade reports whether rec is stamped with an outdated version that
+// has upgrade steps registered
+func (c *collectionConfig) needsUpgrade(rec *Record) bool {
+	return len(c.upgrades) > 0 && rec.Version < c.version
+}
+
+// upgrade runs the registered steps from the record's version to the
+// current one
+func (c *collectionConfig) upgrade(rec *Record, doc map[string]interface{}) error {
+	for v := rec.Version; v < c.version; v++ {
+		fn, ok := c.upgrades[v]
+		if !ok {
+			continue
+		}
+		if err := fn(doc); err != nil {
+			return fmt.Errorf("upgrading %q from version %d: %w", rec.Resource, v, err)
+		}
+	}
+	rec.Version = c.version
+
+	if c.persistUpgrades {
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		rec.upgraded = b
+	}
+	return nil
+}
+
+// persistUpgrade writes back a document upgraded on read, unless the stored
+// record changed in the meantime. Failures are only logged: the reader
+// already has the upgraded document and the next read will try again.
+func (d *Driver) persistUpgrade(collection string, rec *Record) {
+	if rec.upgraded == nil || d.opts.readOnly {
+		return
+	}
+	written, err := d.store(collection, rec.Resource, json.RawMessage(rec.upgraded), writeParams{expect: rec.raw, quiet: true})
+	d.opts.logger.Debug("persist upgrade", "collection", collection, "resource", rec.Resource, "version", rec.Version, "written", written, "err", err)
+}
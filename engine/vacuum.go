@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- VACUUM ---
+
+// defaultTempAge is how old a temporary file must be, when VacuumOptions
+// leaves TempOlderThan zero, before Vacuum takes it for a leftover
+const defaultTempAge = time.Hour
+
+// VacuumOptions choose what Vacuum reclaims. The zero value only removes
+// leftover temporary files: history and trash are kept unless asked for.
+type VacuumOptions struct {
+	// History thins the history of every collection as CompactHistory
+	// does, tombstones of bitemporal deletes included; nil keeps it all
+	History RetentionPolicy
+	// Trash purges soft deleted records deleted more than TrashOlderThan
+	// ago, as PurgeTrash does
+	Trash          bool
+	TrashOlderThan time.Duration
+	// TempOlderThan is how old the hidden temporary files of interrupted
+	// writes and backups must be to be removed; zero means an hour
+	TempOlderThan time.Duration
+}
+
+// VacuumReport is what a Vacuum removed
+type VacuumReport struct {
+	Versions  int `json:"versions"`
+	Trashed   int `json:"trashed"`
+	TempFiles int `json:"tempFiles"`
+	// Bytes is how much the database shrank, which writes made meanwhile
+	// throw off
+	Bytes int64 `json:"bytes"`
+}
+
+// Vacuum reclaims space: old history versions and trashed records as
+// opts asks, then temporary files left behind by crashes. It runs one
+// collection at a time, each under its lock, so the database stays in
+// use.
+func (d *Driver) Vacuum(opts VacuumOptions) (report VacuumReport, err error) {
+	if err := d.writable(); err != nil {
+		return report, err
+	}
+	if opts.TempOlderThan <= 0 {
+		opts.TempOlderThan = defaultTempAge
+	}
+	before, err := d.databaseBytes()
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		if after, err := d.databaseBytes(); err == nil {
+			report.Bytes = before - after
+		}
+	}()
+
+	if opts.History != nil {
+		collections, err := d.storedCollections()
+		if err != nil {
+			return report, err
+		}
+		for _, c := range collections {
+			if isSystemCollection(c) {
+				continue
+			}
+			n, err := d.CompactHistory(c, opts.History)
+			report.Versions += n
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	if opts.Trash {
+		n, err := d.PurgeTrash(opts.TrashOlderThan)
+		report.Trashed += n
+		if err != nil {
+			return report, err
+		}
+	}
+
+	cutoff := time.Now().Add(-opts.TempOlderThan)
+	for _, vol := range d.volumes {
+		n, err := d.removeTempFiles(vol, cutoff)
+		report.TempFiles += n
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// databaseBytes is every file on every volume
+func (d *Driver) databaseBytes() (int64, error) {
+	var total int64
+	for _, vol := range d.volumes {
+		n, err := d.dirBytes(vol)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// isTempFile reports whether name is one of the hidden temporary files
+// the engine writes and renames or removes, or a Health probe
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && (strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, ".health-"))
+}
+
+// removeTempFiles removes the temporary files below dir last modified
+// before cutoff, which no write in progress still holds
+func (d *Driver) removeTempFiles(dir string, cutoff time.Time) (int, error) {
+	entries, err := d.fs.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			n, err := d.removeTempFiles(path, cutoff)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if !isTempFile(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := d.fs.Remove(path, DurabilityNone); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// ScheduleVacuum runs Vacuum with opts in the background every interval
+// until the Driver is closed. Failures are logged and retried at the
+// next interval.
+func (d *Driver) ScheduleVacuum(every time.Duration, opts VacuumOptions) error {
+	if every <= 0 {
+		every = 24 * time.Hour
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+	if err := d.life.enter(); err != nil {
+		return err
+	}
+	defer d.life.leave()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			report, err := d.Vacuum(opts)
+			if err != nil && !errors.Is(err, ErrClosed) {
+				d.opts.logger.Error("scheduled vacuum failed", "err", err)
+				continue
+			}
+			d.opts.logger.Info("vacuumed", "versions", report.Versions, "trashed", report.Trashed,
+				"tempFiles", report.TempFiles, "bytes", report.Bytes)
+		}
+	}()
+
+	d.onClose(func() error {
+		close(stop)
+		wg.Wait()
+		return nil
+	})
+	return nil
+}
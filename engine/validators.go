@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// --- FIELD VALIDATORS ---
+
+// Validator checks a single field value. Values arrive in generic JSON form
+// (string, json.Number, bool, map or slice). The returned message is wrapped
+// into an ErrValidation error naming the field.
+type Validator func(v interface{}) error
+
+type fieldValidator struct {
+	path  string
+	check []Validator
+}
+
+// ValidateField attaches validators to a (dot separated) field of collection.
+// They run on every Write after defaults are applied; absent or null fields
+// are skipped, use RequireFields to demand presence.
+func (d *Driver) ValidateField(collection, path string, validators ...Validator) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	list := append([]fieldValidator(nil), cfg.validators...)
+	cfg.validators = append(list, fieldValidator{path: path, check: validators})
+}
+
+// checkFields runs the field validators against a decoded document
+func checkFields(validators []fieldValidator, doc interface{}) error {
+	obj, _ := doc.(map[string]interface{})
+	for _, fv := range validators {
+		v, ok := lookupPath(obj, fv.path)
+		if !ok || v == nil {
+			continue
+		}
+		for _, check := range fv.check {
+			if err := check(v); err != nil {
+				return validationError(fv.path, "%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// scalarString returns strings and numbers in their textual form
+func scalarString(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case json.Number:
+		return val.String(), true
+	}
+	return "", false
+}
+
+// Email accepts a bare address such as "name@example.com"
+func Email() Validator {
+	return func(v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s || !strings.Contains(s[strings.LastIndex(s, "@"):], ".") {
+			return fmt.Errorf("%q is not a valid email address", s)
+		}
+		return nil
+	}
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[1-9][0-9]{7,14}$`)
+
+// Phone accepts numbers in E.164 shape: an optional leading "+" followed by
+// 8 to 15 digits, the first of which isn't zero. Spaces, dashes and
+// parentheses are not allowed; for national formats use Pattern.
+func Phone() Validator {
+	return func(v interface{}) error {
+		s, ok := scalarString(v)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if !phonePattern.MatchString(s) {
+			return fmt.Errorf("%q is not a valid phone number", s)
+		}
+		return nil
+	}
+}
+
+var pincodePattern = regexp.MustCompile(`^[1-9][0-9]{5}$`)
+
+// Pincode accepts six digit Indian postal codes, stored as text or a number
+func Pincode() Validator {
+	return func(v interface{}) error {
+		s, ok := scalarString(v)
+		if !ok || !pincodePattern.MatchString(s) {
+			return fmt.Errorf("%v is not a valid pincode", v)
+		}
+		return nil
+	}
+}
+
+// Pattern accepts strings (and numbers, by their text) matching expr. It
+// panics if expr doesn't compile, like regexp.MustCompile.
+func Pattern(expr string) Validator {
+	re := regexp.MustCompile(expr)
+	return func(v interface{}) error {
+		s, ok := scalarString(v)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if !re.MatchString(s) {
+			return fmt.Errorf("%q must match %s", s, expr)
+		}
+		return nil
+	}
+}
+
+// Range accepts numbers between min and max inclusive. Numeric strings such
+// as "23" are accepted too, since older records often store numbers as text.
+func Range(min, max float64) Validator {
+	lo, hi := big.NewFloat(min), big.NewFloat(max)
+	return func(v interface{}) error {
+		s, ok := scalarString(v)
+		if !ok {
+			return fmt.Errorf("must be a number")
+		}
+		n, ok := new(big.Float).SetString(s)
+		if !ok {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		if n.Cmp(lo) < 0 || n.Cmp(hi) > 0 {
+			return fmt.Errorf("%s is outside the range %g to %g", s, min, max)
+		}
+		return nil
+	}
+}
+
+// OneOf accepts only the listed values. Numbers compare by value.
+func OneOf(values ...interface{}) Validator {
+	allowed := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if doc, err := toDocument(v); err == nil {
+			allowed = append(allowed, doc)
+		}
+	}
+	return func(v interface{}) error {
+		for _, a := range allowed {
+			if jsonEqual(a, v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of %v", v, values)
+	}
+}
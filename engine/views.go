@@ -0,0 +1,320 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// --- MATERIALIZED VIEWS ---
+
+// ViewQuery is the stored query of a materialized view
+type ViewQuery struct {
+	// Filter selects the records of the view; nil keeps every one
+	Filter Filter
+	// Fields cuts the documents down as the Fields read option does; nil
+	// keeps them whole
+	Fields []string
+	// Sort is the fields ReadAll orders the view by, each ascending unless
+	// prefixed with "-", with ties in name order. Records lacking a field,
+	// or holding anything but a number or a string there, come first, and
+	// numbers before strings.
+	Sort []string
+}
+
+// view is a materialized view over a collection. Its rows are built from
+// the collection on first use and kept up to date by write and delete,
+// under the collection's write lock, like the column index.
+type view struct {
+	name       string
+	collection string
+	query      ViewQuery
+	rows       map[string]*viewRow // resource -> row, nil until built; guarded by d.mutex
+}
+
+// viewRow is one record of a view
+type viewRow struct {
+	rec  *Record       // as read from the collection, for policies to check
+	data []byte        // the document as the view holds it
+	keys []interface{} // the values of the sort fields
+}
+
+// DefineView makes name a materialized view of the records of collection
+// that query selects: Read and ReadAll called with name answer from the
+// view, which the engine keeps up to date on every write and delete to
+// collection instead of running the query each time. Views live in
+// memory, as indexes do, and are built from the collection on first read;
+// the name can't be that of a stored collection, and writes to it fail.
+// Their definitions aren't stored either, as a Filter can be any Go
+// function: like ColumnField or SetPolicy, DefineView has to be called
+// again every time the database is opened, before the name is used, or
+// it reads as a missing collection and a write to it makes it one.
+// Reading a view takes the read permissions of both its name and, through
+// the collection's policy, of the records behind it. Redefining a view
+// replaces it.
+func (d *Driver) DefineView(name, collection string, query ViewQuery) error {
+	if err := validateCollection(name); err != nil {
+		return err
+	}
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	if name == collection || isSystemCollection(name) {
+		return fmt.Errorf("%w: view name %q", ErrInvalidName, name)
+	}
+	for _, f := range query.Sort {
+		if strings.TrimPrefix(f, "-") == "" {
+			return fmt.Errorf("view %s: empty sort field", name)
+		}
+	}
+	if _, err := d.fs.Stat(d.collectionDir(name)); err == nil {
+		return fmt.Errorf("view %s: %w: a collection has that name", name, fs.ErrExist)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if old := d.views[name]; old != nil {
+		d.unlinkView(old)
+	}
+	v := &view{name: name, collection: collection, query: query}
+	d.views[name] = v
+	cfg := d.config(collection)
+	cfg.views = append(slices.Clone(cfg.views), v)
+	return nil
+}
+
+// DropView forgets a view. Dropping a name that isn't a view does nothing.
+func (d *Driver) DropView(name string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if v := d.views[name]; v != nil {
+		d.unlinkView(v)
+		delete(d.views, name)
+	}
+}
+
+// unlinkView takes v off its collection. Callers must hold d.mutex.
+func (d *Driver) unlinkView(v *view) {
+	cfg := d.config(v.collection)
+	cfg.views = slices.DeleteFunc(slices.Clone(cfg.views), func(o *view) bool { return o == v })
+}
+
+// viewNamed returns the view called name, nil when it is none
+func (d *Driver) viewNamed(name string) *view {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.views[name]
+}
+
+// notView fails for the name of a view, which can't be written to
+func (d *Driver) notView(collection string) error {
+	if d.viewNamed(collection) != nil {
+		return fmt.Errorf("%w: %s is a view", ErrReadOnly, collection)
+	}
+	return nil
+}
+
+// viewRows returns the rows of v, building them when missing. Callers
+// must hold the lock of v's collection.
+func (d *Driver) viewRows(v *view) (map[string]*viewRow, error) {
+	d.mutex.Lock()
+	rows := v.rows
+	d.mutex.Unlock()
+	if rows != nil {
+		return rows, nil
+	}
+
+	rows = make(map[string]*viewRow)
+	err := d.walk(v.collection, func(rec *Record) error {
+		return v.add(rows, rec)
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	v.rows = rows
+	d.mutex.Unlock()
+	return rows, nil
+}
+
+// add puts rec in rows if the query of v selects it, and takes it out
+// otherwise
+func (v *view) add(rows map[string]*viewRow, rec *Record) error {
+	if v.query.Filter != nil && !v.query.Filter.Match(rec) {
+		delete(rows, rec.Resource)
+		return nil
+	}
+	row := &viewRow{rec: &Record{Resource: rec.Resource, Data: rec.Data, Meta: rec.Meta, Version: rec.Version}, data: rec.Data}
+	for _, f := range v.query.Sort {
+		key, _ := rec.Field(strings.TrimPrefix(f, "-"))
+		row.keys = append(row.keys, key)
+	}
+	if len(v.query.Fields) > 0 {
+		cut := *row.rec
+		if err := project(&cut, v.query.Fields); err != nil {
+			return err
+		}
+		row.data = cut.Data
+	}
+	rows[rec.Resource] = row
+	return nil
+}
+
+// refreshViews brings the built views of collection up to date with the
+// record just stored, raw as it was written. Callers must hold the
+// collection's write lock.
+func (d *Driver) refreshViews(collection, resource string, raw []byte) {
+	cfg := d.snapshotConfig(collection)
+	if len(cfg.views) == 0 {
+		return
+	}
+	rec, err := decodeRecord(resource, bytes.Clone(raw))
+	if err == nil {
+		err = cfg.reshapeRead(rec)
+	}
+	for _, v := range cfg.views {
+		d.mutex.Lock()
+		rows := v.rows
+		d.mutex.Unlock()
+		if rows == nil {
+			continue
+		}
+		if err != nil || v.add(rows, rec) != nil {
+			// rebuilt on the next read
+			d.mutex.Lock()
+			v.rows = nil
+			d.mutex.Unlock()
+		}
+	}
+}
+
+// dropFromViews takes a deleted record out of the views of collection.
+// Callers must hold the collection's write lock.
+func (d *Driver) dropFromViews(collection, resource string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if cfg := d.collections[collection]; cfg != nil {
+		for _, v := range cfg.views {
+			delete(v.rows, resource)
+		}
+	}
+}
+
+// invalidateViews drops the rows of the views of collection after its
+// files were replaced wholesale
+func (d *Driver) invalidateViews(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if cfg := d.collections[collection]; cfg != nil {
+		for _, v := range cfg.views {
+			v.rows = nil
+		}
+	}
+}
+
+// readView does the work of Read for a view
+func (d *Driver) readView(v *view, resource string, out interface{}, p readParams) error {
+	if len(p.populate) > 0 {
+		return fmt.Errorf("view %s: Populate doesn't apply to views", v.name)
+	}
+	guard := d.readGuard(p.ctx, v.collection)
+	release, err := d.acquire(v.collection, false)
+	if err != nil {
+		return err
+	}
+	rows, err := d.viewRows(v)
+	var row *viewRow
+	if err == nil {
+		row = rows[resource]
+	}
+	release()
+	if err != nil {
+		return err
+	}
+	if row == nil || guard != nil && !guard.Match(row.checked()) {
+		return fmt.Errorf("%s/%s: %w", v.name, resource, fs.ErrNotExist)
+	}
+	rec := &Record{Resource: resource, Data: row.data}
+	if len(p.fields) > 0 {
+		if err := project(rec, p.fields); err != nil {
+			return err
+		}
+	}
+	p.trace.addBytes(len(rec.Data))
+	return json.Unmarshal(rec.Data, &out)
+}
+
+// readAllView does the work of ReadAll for a view
+func (d *Driver) readAllView(v *view, guard Filter, trace *opTrace) ([][]byte, error) {
+	release, err := d.acquire(v.collection, false)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.viewRows(v)
+	var sorted []*viewRow
+	if err == nil {
+		sorted = make([]*viewRow, 0, len(rows))
+		for _, row := range rows {
+			sorted = append(sorted, row)
+		}
+	}
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		for k, f := range v.query.Sort {
+			c := compareViewKeys(a.keys[k], b.keys[k])
+			if strings.HasPrefix(f, "-") {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return a.rec.Resource < b.rec.Resource
+	})
+	var out [][]byte
+	for _, row := range sorted {
+		if guard != nil && !guard.Match(row.checked()) {
+			continue
+		}
+		out = append(out, row.data)
+		trace.addBytes(len(row.data))
+	}
+	return out, nil
+}
+
+// checked is a copy of the row's record for a filter to match, which may
+// cache the decoded document in it
+func (row *viewRow) checked() *Record {
+	rec := *row.rec
+	return &rec
+}
+
+// compareViewKeys orders sort values: anything but numbers and strings
+// first, then numbers, then strings
+func compareViewKeys(a, b interface{}) int {
+	if c, ok := compareJSON(a, b); ok {
+		return c
+	}
+	return viewKeyRank(a) - viewKeyRank(b)
+}
+
+func viewKeyRank(v interface{}) int {
+	switch v.(type) {
+	case json.Number:
+		return 1
+	case string:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+func TestViewsRefuseChanges(t *testing.T) {
+	d := openTest(t)
+	if err := d.Write("docs", "a", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DefineView("ones", "docs", ViewQuery{Filter: Equal("n", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Write", func() error { return d.Write("ones", "b", map[string]int{"n": 1}) }},
+		{"Delete", func() error { return d.Delete("ones", "a") }},
+		{"DeleteWhere", func() error { _, err := d.DeleteWhere("ones", nil); return err }},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); err == nil {
+			t.Errorf("%s on a view succeeded", tt.name)
+		}
+	}
+	if ok, err := d.Exists("docs", "a"); err != nil || !ok {
+		t.Errorf("docs/a is gone: %v", err)
+	}
+}
+
+// viewDocs reads every document of a view
+func viewDocs(t *testing.T, d *Driver, name string) []string {
+	t.Helper()
+	docs, err := d.ReadAll(name)
+	if err != nil {
+		t.Fatalf("ReadAll(%s): %v", name, err)
+	}
+	out := []string{}
+	for _, doc := range docs {
+		out = append(out, string(doc))
+	}
+	return out
+}
+
+func TestViewRefresh(t *testing.T) {
+	d := openTest(t)
+	write := func(name string, n int) {
+		t.Helper()
+		if err := d.Write("docs", name, map[string]interface{}{"n": n, "name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", 3)
+	write("b", 1)
+	err := d.DefineView("big", "docs", ViewQuery{
+		Filter: mustParse(t, `{"n": {"$gte": 2}}`),
+		Fields: []string{"name"},
+		Sort:   []string{"-n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := viewDocs(t, d, "big"), []string{`{"name":"a"}`}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("view = %v, want %v", got, want)
+	}
+
+	write("c", 5) // a new record the view selects
+	write("b", 2) // an update bringing one in
+	write("a", 0) // and one taking another out
+	if got, want := viewDocs(t, d, "big"), []string{`{"name":"c"}`, `{"name":"b"}`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("view after writes = %v, want %v", got, want)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("big", "a", &doc); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Read of a record the view left = %v, want fs.ErrNotExist", err)
+	}
+
+	if err := d.Delete("docs", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := viewDocs(t, d, "big"), []string{`{"name":"b"}`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("view after the delete = %v, want %v", got, want)
+	}
+}
+
+func TestViewAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	d := openAt(t, dir)
+	if err := d.Write("docs", "a", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	query := ViewQuery{Filter: Equal("n", 1)}
+	if err := d.DefineView("ones", "docs", query); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the definition is gone with the Driver that had it
+	d = openAt(t, dir)
+	if _, err := d.ReadAll("ones"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadAll of the view before redefining it = %v, want fs.ErrNotExist", err)
+	}
+	if err := d.DefineView("ones", "docs", query); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("docs", "b", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := viewDocs(t, d, "ones"); len(got) != 2 {
+		t.Errorf("redefined view = %v, want docs/a and docs/b", got)
+	}
+}
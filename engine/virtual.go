@@ -0,0 +1,46 @@
+package engine
+
+// --- VIRTUAL FIELDS ---
+
+// VirtualFunc computes the value of a virtual field from a decoded document.
+// Returning nil leaves the field out.
+type VirtualFunc func(doc map[string]interface{}) interface{}
+
+type virtualField struct {
+	name string
+	fn   VirtualFunc
+}
+
+// DefineVirtual adds a computed field to every document read from collection.
+// Virtual fields are evaluated on Read, ReadAll and Find (so filters can use
+// them) and are stripped from documents on Write, so they are never stored.
+// Fields are computed in definition order; redefining a name replaces it.
+func (d *Driver) DefineVirtual(collection, name string, fn VirtualFunc) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cfg := d.config(collection)
+	virtuals := make([]virtualField, 0, len(cfg.virtuals)+1)
+	for _, v := range cfg.virtuals {
+		if v.name != name {
+			virtuals = append(virtuals, v)
+		}
+	}
+	cfg.virtuals = append(virtuals, virtualField{name: name, fn: fn})
+}
+
+// addVirtuals evaluates the collection's virtual fields into doc
+func addVirtuals(virtuals []virtualField, doc map[string]interface{}) {
+	for _, v := range virtuals {
+		if val := v.fn(doc); val != nil {
+			doc[v.name] = val
+		}
+	}
+}
+
+// stripVirtuals removes virtual fields from a document about to be written
+func stripVirtuals(virtuals []virtualField, doc map[string]interface{}) {
+	for _, v := range virtuals {
+		delete(doc, v.name)
+	}
+}
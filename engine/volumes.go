@@ -0,0 +1,256 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// --- STORAGE VOLUMES ---
+
+// PlacementPolicy picks, by index, the volume a new collection is created
+// on. It is only consulted for collections not found on any volume.
+type PlacementPolicy func(collection string, volumes []string) int
+
+// WithVolumes spreads collections over more data directories, typically on
+// separate disks, besides the one given to New. Each collection lives
+// wholly on one volume: where it already exists, where PinCollection put
+// it, or where the placement policy puts it. The directory given to New stays the home of the
+// engine's _system collections and temporary files.
+func WithVolumes(dirs ...string) Option {
+	return func(o *options) { o.volumes = append(o.volumes, dirs...) }
+}
+
+// WithPlacement sets how new collections not pinned by PinCollection are
+// spread over the volumes; the default is HashPlacement
+func WithPlacement(policy PlacementPolicy) Option {
+	return func(o *options) { o.placement = policy }
+}
+
+// HashPlacement places a collection by a hash of its name, spreading
+// collections evenly and always the same way for a given set of volumes
+func HashPlacement(collection string, volumes []string) int {
+	h := fnv.New32a()
+	h.Write([]byte(collection))
+	return int(h.Sum32() % uint32(len(volumes)))
+}
+
+// FreeSpacePlacement places a collection on the volume with the most free
+// space. Where free space can't be measured it falls back to HashPlacement.
+func FreeSpacePlacement(collection string, volumes []string) int {
+	best, most := -1, uint64(0)
+	for i, v := range volumes {
+		free, err := diskFree(v)
+		if err != nil {
+			return HashPlacement(collection, volumes)
+		}
+		if best < 0 || free > most {
+			best, most = i, free
+		}
+	}
+	return best
+}
+
+// placementFile is the collection manifest, kept in the primary data
+// directory, recording which volume each pinned collection belongs on
+const placementFile = "collections.json"
+
+type placementManifest struct {
+	Collections map[string]placementHint `json:"collections"`
+}
+
+type placementHint struct {
+	Volume string `json:"volume"`
+}
+
+// PinCollection keeps collection on volume, one of the directories given
+// to New and WithVolumes, moving its files there if they are elsewhere,
+// e.g. a time series on a large, slow disk and users on a fast one. The
+// pin is recorded in the collections.json manifest of the primary data
+// directory, which can also be edited while the database is closed; a
+// collection that already exists stays where it is until pinned here. An
+// empty volume removes the pin. Sub-collections go wherever their top
+// level collection is and can't be pinned themselves.
+func (d *Driver) PinCollection(collection, volume string) error {
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+	if strings.Contains(collection, "/") {
+		return fmt.Errorf("sub-collection %s can't be pinned apart from its parent", collection)
+	}
+	if err := d.writable(); err != nil {
+		return err
+	}
+	if volume != "" {
+		volume = filepath.Clean(volume)
+		if !slices.Contains(d.volumes, volume) {
+			return fmt.Errorf("%q is not a volume of this database", volume)
+		}
+	}
+
+	release, err := d.acquire(collection, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if volume != "" {
+		if err := d.moveCollection(collection, volume); err != nil {
+			return fmt.Errorf("moving %s to %s: %w", collection, volume, err)
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if volume == "" {
+		delete(d.pins, collection)
+	} else {
+		d.pins[collection] = volume
+	}
+	return d.savePins()
+}
+
+// VolumeOf is the data directory holding collection, or that will hold it
+// once it is first written
+func (d *Driver) VolumeOf(collection string) string {
+	return d.volume(collection)
+}
+
+// moveCollection copies a collection's files to volume and removes them
+// from where they were. Callers must hold the collection lock.
+func (d *Driver) moveCollection(collection, volume string) error {
+	from := d.volume(collection)
+	if from == volume {
+		return nil
+	}
+	src, dst := filepath.Join(from, collection), filepath.Join(volume, collection)
+	if _, err := d.fs.Stat(src); err == nil {
+		if err := d.copyTree(d.fs, src, d.fs, dst, false); err != nil {
+			d.fs.RemoveAll(dst)
+			return err
+		}
+	}
+
+	d.mutex.Lock()
+	d.placed[collection] = volume
+	d.mutex.Unlock()
+	d.cache.invalidateCollection(collection)
+	d.opts.logger.Info("collection moved", "collection", collection, "from", from, "to", volume)
+	return d.fs.RemoveAll(src)
+}
+
+// loadPins reads the collection manifest, refusing pins to directories
+// that are not volumes of the Driver
+func (d *Driver) loadPins() error {
+	d.pins = make(map[string]string)
+	b, err := d.fs.ReadFile(filepath.Join(d.dir, placementFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m placementManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("%s: %w", placementFile, err)
+	}
+	for c, hint := range m.Collections {
+		v := filepath.Clean(hint.Volume)
+		if !slices.Contains(d.volumes, v) {
+			return fmt.Errorf("%s: %s is pinned to %q, which is not a volume", placementFile, c, hint.Volume)
+		}
+		d.pins[c] = v
+	}
+	return nil
+}
+
+// savePins writes the collection manifest. Callers must hold d.mutex.
+func (d *Driver) savePins() error {
+	m := placementManifest{Collections: make(map[string]placementHint, len(d.pins))}
+	for c, v := range d.pins {
+		m.Collections[c] = placementHint{Volume: v}
+	}
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return d.fs.WriteFile(filepath.Join(d.dir, placementFile), b, d.opts.durability)
+}
+
+// volume returns the data directory holding collection
+func (d *Driver) volume(collection string) string {
+	if len(d.volumes) <= 1 || isSystemCollection(collection) {
+		return d.dir
+	}
+	if top, _, nested := strings.Cut(collection, "/"); nested {
+		// sub-collections live inside their parent's directory
+		return d.volume(top)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if v, ok := d.placed[collection]; ok {
+		return v
+	}
+	v := ""
+	for _, vol := range d.volumes {
+		if info, err := d.fs.Stat(filepath.Join(vol, collection)); err == nil && info.IsDir() {
+			v = vol
+			break
+		}
+	}
+	if v == "" {
+		v = d.pins[collection]
+	}
+	if v == "" {
+		policy := d.opts.placement
+		if policy == nil {
+			policy = HashPlacement
+		}
+		i := policy(collection, d.volumes)
+		if i < 0 || i >= len(d.volumes) {
+			i = 0
+		}
+		v = d.volumes[i]
+	}
+	d.placed[collection] = v
+	return v
+}
+
+// collectionDir is the directory of a collection's files
+func (d *Driver) collectionDir(collection string) string {
+	return filepath.Join(d.volume(collection), collection)
+}
+
+// forgetPlacement lets a removed collection be placed afresh
+func (d *Driver) forgetPlacement(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.placed, collection)
+}
+
+// storedCollections lists every collection directory on every volume,
+// including the engine's own _system collections, in name order
+func (d *Driver) storedCollections() ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, vol := range d.volumes {
+		dirs, err := collectionDirs(d.fs, vol)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range dirs {
+			if !seen[c] {
+				seen[c] = true
+				names = append(names, c)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// --- WARM-UP ---
+
+// WarmOptions configures Warm
+type WarmOptions struct {
+	// Documents also loads record files into the read cache set up with
+	// WithCache, stopping before it would evict anything
+	Documents bool
+	// Resources, when set, are the records Documents loads, hottest first;
+	// otherwise records are loaded in name order
+	Resources []string
+}
+
+// Warm prepares collection for its first requests in the background: it
+// lists the records, builds every index the collection has (unique,
+// search, column and distinct fields) and, with opts.Documents, fills the
+// read cache. The returned channel delivers the outcome once finished.
+// Warming is only an optimisation; requests served meanwhile work as
+// usual, and a Driver closed before warming finishes ends it with
+// ErrClosed.
+func (d *Driver) Warm(collection string, opts WarmOptions) <-chan error {
+	done := make(chan error, 1)
+	if err := validateCollection(collection); err != nil {
+		done <- err
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		start := time.Now()
+		loaded, err := d.warm(collection, opts)
+		if err != nil {
+			d.opts.logger.Debug("warm-up failed", "collection", collection, "err", err)
+		} else {
+			d.opts.logger.Info("collection warmed", "collection", collection, "documents", loaded, "took", time.Since(start))
+		}
+		done <- err
+	}()
+	return done
+}
+
+// warm does the work of Warm and reports how many documents it loaded
+func (d *Driver) warm(collection string, opts WarmOptions) (int, error) {
+	cfg := d.snapshotConfig(collection)
+
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return 0, err
+	}
+	names, err := d.recordNames(collection)
+	if err == nil && len(cfg.search) > 0 {
+		_, err = d.searchIndexFor(collection, cfg.search)
+	}
+	if err == nil && len(cfg.columns) > 0 {
+		_, err = d.columnIndexFor(collection, cfg.columns)
+	}
+	if err == nil && len(cfg.distinct) > 0 {
+		_, err = d.distinctIndexFor(collection, cfg.distinct)
+	}
+	release()
+	if errors.Is(err, fs.ErrNotExist) {
+		// nothing stored yet
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(cfg.unique) > 0 {
+		// the unique index is only ever built under the write lock
+		release, err := d.acquire(collection, true)
+		if err != nil {
+			return 0, err
+		}
+		_, err = d.uniqueIndexFor(collection, cfg.unique)
+		release()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if !opts.Documents || d.cache == nil {
+		return 0, nil
+	}
+	if opts.Resources != nil {
+		names = opts.Resources
+	}
+	loaded := 0
+	for _, name := range names {
+		if validateName("resource", name) != nil {
+			continue
+		}
+		ok, full, err := d.warmDocument(collection, name, d.recordPath(collection, name, cfg))
+		if err != nil || full {
+			return loaded, err
+		}
+		if ok {
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
+// warmDocument puts one record file into the read cache, reporting full
+// instead once it doesn't fit anymore. Missing records are skipped.
+func (d *Driver) warmDocument(collection, resource, path string) (ok, full bool, err error) {
+	release, err := d.acquire(collection, false)
+	if err != nil {
+		return false, false, err
+	}
+	defer release()
+
+	b, err := d.readLive(collection, path, d.snapshotConfig(collection))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if !d.cache.room(len(b)) {
+		return false, true, nil
+	}
+	d.cache.put(collection, resource, b)
+	return true, false, nil
+}
@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- CHANGE FEED ---
+
+// watchBuffer is how many changes a watcher may fall behind before it is
+// dropped
+const watchBuffer = 256
+
+// Change is a write or delete observed through Watch
+type Change struct {
+	// Seq numbers the changes of a Driver in the order they were applied.
+	// It restarts with the process, and with it Epoch.
+	Seq uint64 `json:"seq"`
+	// Epoch names the run of the Driver that numbered Seq
+	Epoch      string    `json:"epoch"`
+	Kind       OpKind    `json:"kind"`
+	Collection string    `json:"collection"`
+	Resource   string    `json:"resource"`
+	Time       time.Time `json:"time"`
+	// Document is the document as stored; empty for deletes
+	Document json.RawMessage `json:"document,omitempty"`
+	// CollectionSeq is the change's number in its collection's sequence,
+	// which unlike Seq survives restarts. It matches Meta.Seq of the
+	// record written.
+	CollectionSeq uint64 `json:"collectionSeq,omitempty"`
+}
+
+type watcher struct {
+	collection string
+	filter     Filter
+	fields     []string
+	ch         chan Change
+	// done closes with ch, releasing the goroutine waiting on the context
+	done chan struct{}
+}
+
+// WatchOption narrows what a Watch or WatchFrom subscription receives
+type WatchOption func(*watcher)
+
+// WatchFilter passes on only the writes whose document matches f, which
+// sees the document as stored and the record's name. Deletes carry no
+// document and are passed on whatever f says. Filters run as each change
+// is published, so they should be cheap.
+func WatchFilter(f Filter) WatchOption {
+	return func(w *watcher) { w.filter = f }
+}
+
+// WatchFields cuts the documents of the changes down to the fields at the
+// given paths, as Fields does for Read. Filters see the whole document.
+func WatchFields(paths ...string) WatchOption {
+	return func(w *watcher) { w.fields = append(w.fields, paths...) }
+}
+
+// admit reports whether the watcher wants c, and returns it as the
+// watcher receives it
+func (w *watcher) admit(c Change) (Change, bool) {
+	if w.collection != "" && w.collection != c.Collection {
+		return c, false
+	}
+	if c.Kind != OpWrite || (w.filter == nil && len(w.fields) == 0) {
+		return c, true
+	}
+	rec := &Record{Resource: c.Resource, Data: c.Document}
+	if w.filter != nil && !w.filter.Match(rec) {
+		return c, false
+	}
+	if len(w.fields) > 0 {
+		if err := project(rec, w.fields); err != nil {
+			return c, false
+		}
+		c.Document = rec.Data
+	}
+	return c, true
+}
+
+// watchers fans changes out to the channels returned by Watch
+type watchers struct {
+	mu    sync.Mutex
+	epoch string
+	seq   uint64
+	subs  map[*watcher]struct{}
+	once  sync.Once
+	// backlog holds the latest changes, oldest first, for WatchFrom to
+	// replay; limit is how many are kept, zero for none
+	backlog []Change
+	limit   int
+}
+
+// FeedPosition is a point in the change feed of a Driver: just after the
+// change numbered Seq in Epoch
+type FeedPosition struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+// Watch streams the changes made through the Driver to collection, or to
+// every collection when it is empty, until ctx is done, the Driver is
+// closed or the database is restored, at which point the channel is
+// closed. Changes are sent in the
+// order they were applied to each record. A watcher that falls more than
+// a few hundred changes behind is dropped and its channel closed early; it
+// should re-read what it cares about and watch again.
+func (d *Driver) Watch(ctx context.Context, collection string, opts ...WatchOption) (<-chan Change, error) {
+	if collection != "" {
+		if err := validateCollection(collection); err != nil {
+			return nil, err
+		}
+	}
+	return d.subscribe(ctx, collection, nil, opts)
+}
+
+// WatchFrom is Watch resumed at a position of the feed, typically the last
+// change a consumer saw before it lost its stream: the changes after from
+// that the Driver still keeps, see WithChangeBacklog, are sent first. It
+// fails with ErrResyncNeeded when some of them are no longer kept or from
+// belongs to another epoch, so the consumer has to start over from a
+// fresh copy of the data.
+func (d *Driver) WatchFrom(ctx context.Context, collection string, from FeedPosition, opts ...WatchOption) (<-chan Change, error) {
+	if collection != "" {
+		if err := validateCollection(collection); err != nil {
+			return nil, err
+		}
+	}
+	return d.subscribe(ctx, collection, &from, opts)
+}
+
+// FeedPosition is the position of the latest change in the feed
+func (d *Driver) FeedPosition() FeedPosition {
+	ws := &d.watchers
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return FeedPosition{Epoch: ws.currentEpoch(), Seq: ws.seq}
+}
+
+// subscribe registers a watcher, replaying the backlog after from first
+// when it is set
+func (d *Driver) subscribe(ctx context.Context, collection string, from *FeedPosition, opts []WatchOption) (<-chan Change, error) {
+	if err := d.authorize(ctx, ActionWatch, collection, ""); err != nil {
+		return nil, err
+	}
+	if err := d.life.enter(); err != nil {
+		return nil, err
+	}
+	defer d.life.leave()
+
+	ws := &d.watchers
+	ws.once.Do(func() { d.onClose(ws.closeAll) })
+
+	w := &watcher{collection: collection, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if guard := d.readGuard(ctx, collection); guard != nil {
+		if w.filter != nil {
+			guard = And(guard, w.filter)
+		}
+		w.filter = guard
+	}
+
+	ws.mu.Lock()
+	var replay []Change
+	if from != nil {
+		var err error
+		if replay, err = ws.since(w, *from); err != nil {
+			ws.mu.Unlock()
+			return nil, err
+		}
+	}
+	w.ch = make(chan Change, len(replay)+watchBuffer)
+	for _, c := range replay {
+		w.ch <- c
+	}
+	if ws.subs == nil {
+		ws.subs = make(map[*watcher]struct{})
+	}
+	ws.subs[w] = struct{}{}
+	ws.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.drop(w)
+		case <-w.done:
+		}
+	}()
+	return w.ch, nil
+}
+
+// since returns the backlogged changes w wants after from. Callers must
+// hold ws.mu.
+func (ws *watchers) since(w *watcher, from FeedPosition) ([]Change, error) {
+	if from.Epoch != ws.currentEpoch() || from.Seq > ws.seq {
+		return nil, fmt.Errorf("%w: position %s/%d is not in this feed", ErrResyncNeeded, from.Epoch, from.Seq)
+	}
+	if from.Seq == ws.seq {
+		return nil, nil
+	}
+	if len(ws.backlog) == 0 || ws.backlog[0].Seq > from.Seq+1 {
+		return nil, fmt.Errorf("%w: changes after %d are no longer kept", ErrResyncNeeded, from.Seq)
+	}
+	var out []Change
+	for _, c := range ws.backlog {
+		if c.Seq <= from.Seq {
+			continue
+		}
+		if c, ok := w.admit(c); ok {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// currentEpoch names the feed, starting a new one on first use. Callers
+// must hold ws.mu.
+func (ws *watchers) currentEpoch() string {
+	if ws.epoch == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		ws.epoch = hex.EncodeToString(b)
+	}
+	return ws.epoch
+}
+
+// reset starts a new epoch after the data changed wholesale, as by a
+// restore, ending every watch: no position in the old feed leads to the
+// new contents
+func (ws *watchers) reset() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.epoch, ws.seq, ws.backlog = "", 0, nil
+	for w := range ws.subs {
+		ws.remove(w)
+	}
+}
+
+// active reports whether anyone is watching or the backlog is kept, so
+// writers can skip encoding documents nobody will read
+func (ws *watchers) active() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.subs) > 0 || ws.limit > 0
+}
+
+// publish hands a change to every interested watcher without blocking.
+// Callers hold the collection's write lock, which keeps the changes of a
+// record in order.
+func (ws *watchers) publish(c Change) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.seq++
+	c.Seq = ws.seq
+	c.Epoch = ws.currentEpoch()
+	if ws.limit > 0 {
+		if len(ws.backlog) >= 2*ws.limit {
+			ws.backlog = append([]Change(nil), ws.backlog[len(ws.backlog)-ws.limit+1:]...)
+		}
+		ws.backlog = append(ws.backlog, c)
+	}
+	for w := range ws.subs {
+		c, ok := w.admit(c)
+		if !ok {
+			continue
+		}
+		select {
+		case w.ch <- c:
+		default:
+			ws.remove(w)
+		}
+	}
+}
+
+func (ws *watchers) drop(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, ok := ws.subs[w]; ok {
+		ws.remove(w)
+	}
+}
+
+// remove unsubscribes a watcher. Callers must hold ws.mu.
+func (ws *watchers) remove(w *watcher) {
+	delete(ws.subs, w)
+	close(w.ch)
+	close(w.done)
+}
+
+func (ws *watchers) closeAll() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.subs {
+		ws.remove(w)
+	}
+	return nil
+}
+
+// notify publishes a change of a user collection. Callers must hold the
+// collection's write lock.
+func (d *Driver) notify(kind OpKind, collection, resource string, v interface{}) {
+	if isSystemCollection(collection) || !d.watchers.active() {
+		return
+	}
+	c := Change{Kind: kind, Collection: collection, Resource: resource, Time: time.Now().UTC(), CollectionSeq: d.lastSeq(collection)}
+	if kind == OpWrite {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		c.Document = b
+	}
+	d.watchers.publish(c)
+}
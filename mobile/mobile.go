@@ -0,0 +1,199 @@
+// Package mobile is the engine's binding for Android and iOS apps, built
+// with gomobile:
+//
+//	gomobile bind -target=android ./mobile
+//	gomobile bind -target=ios ./mobile
+//
+// It only uses the types gomobile can carry across: documents go in and
+// out as JSON bytes, and lists as JSON arrays. Databases open with
+// defaults sized for a phone: a small read cache, serial scans, and no
+// background goroutines unless Config.ReplicaOf asks for one to keep the
+// database in step with a server.
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/RakshitNotFound/Golang-database/dbrpc"
+	"github.com/RakshitNotFound/Golang-database/engine"
+)
+
+// defaultCacheBytes is the read cache a database gets by default
+const defaultCacheBytes = 256 << 10
+
+// Config is how Open sets up a database; start from NewConfig
+type Config struct {
+	// CacheBytes bounds the read cache; 0 turns it off
+	CacheBytes int64
+	// ReadConcurrency is how many files a scan loads at once
+	ReadConcurrency int
+	ReadOnly        bool
+	Metadata        bool
+	// ReplicaOf, a host:port, makes the database a read-only replica of
+	// the dbrpc server there, followed by a goroutine until Close
+	ReplicaOf string
+}
+
+// NewConfig returns the defaults for a phone
+func NewConfig() *Config {
+	return &Config{CacheBytes: defaultCacheBytes, ReadConcurrency: 1}
+}
+
+// Database is an open database
+type Database struct {
+	d *engine.Driver
+
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	replica *dbrpc.Replica
+}
+
+// Open opens the database in dir, typically below the app's files
+// directory, with cfg or, when it is nil, the defaults of NewConfig
+func Open(dir string, cfg *Config) (*Database, error) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	opts := []engine.Option{engine.WithReadConcurrency(max(cfg.ReadConcurrency, 1))}
+	if cfg.CacheBytes > 0 {
+		opts = append(opts, engine.WithCache(cfg.CacheBytes))
+	}
+	if cfg.ReadOnly {
+		opts = append(opts, engine.ReadOnly())
+	}
+	if cfg.Metadata {
+		opts = append(opts, engine.WithMetadata())
+	}
+	if cfg.ReplicaOf != "" {
+		opts = append(opts, engine.AsReplica())
+	}
+	d, err := engine.New(dir, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Database{d: d}
+	if cfg.ReplicaOf != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		db.cancel = cancel
+		db.replica = dbrpc.NewReplica(d, cfg.ReplicaOf)
+		db.wg.Add(1)
+		go func() {
+			defer db.wg.Done()
+			db.replica.Run(ctx)
+		}()
+	}
+	return db, nil
+}
+
+// Close stops replication, if any, and closes the database
+func (db *Database) Close() error {
+	if db.cancel != nil {
+		db.cancel()
+		db.wg.Wait()
+	}
+	return db.d.Close()
+}
+
+// Put writes the JSON document doc under collection/resource
+func (db *Database) Put(collection, resource string, doc []byte) error {
+	if !json.Valid(doc) {
+		return fmt.Errorf("%w: document is not valid JSON", engine.ErrValidation)
+	}
+	return db.d.Write(collection, resource, json.RawMessage(doc))
+}
+
+// Get returns the JSON document at collection/resource
+func (db *Database) Get(collection, resource string) ([]byte, error) {
+	var doc json.RawMessage
+	if err := db.d.Read(collection, resource, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Delete removes the record at collection/resource
+func (db *Database) Delete(collection, resource string) error {
+	return db.d.Delete(collection, resource)
+}
+
+// List returns the resources of collection as a JSON array of strings
+func (db *Database) List(collection string) ([]byte, error) {
+	resources, err := db.d.List(collection)
+	if err != nil {
+		return nil, err
+	}
+	if resources == nil {
+		resources = []string{}
+	}
+	return json.Marshal(resources)
+}
+
+// findResult is an element of the array Find returns
+type findResult struct {
+	Resource string          `json:"resource"`
+	Document json.RawMessage `json:"document"`
+}
+
+// Find returns the records of collection whose fields equal every one of
+// conditions, a JSON object of dotted field paths and values, as a JSON
+// array of {"resource": ..., "document": ...} objects in resource order.
+// Empty conditions match every record.
+func (db *Database) Find(collection string, conditions []byte) ([]byte, error) {
+	filter, err := conditionFilter(conditions)
+	if err != nil {
+		return nil, err
+	}
+	records, err := db.d.Find(collection, filter)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]findResult, 0, len(records))
+	for _, rec := range records {
+		results = append(results, findResult{Resource: rec.Resource, Document: rec.Data})
+	}
+	return json.Marshal(results)
+}
+
+// Synced reports whether the replica is connected to its server; false
+// for a database that isn't one
+func (db *Database) Synced() bool {
+	return db.replica != nil && db.replica.Status().Connected
+}
+
+// IsNotFound reports whether err is a missing record or collection
+func IsNotFound(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsReadOnly reports whether err is a change refused by a read-only
+// database or replica
+func IsReadOnly(err error) bool {
+	return errors.Is(err, engine.ErrReadOnly)
+}
+
+// conditionFilter is the filter matching documents whose fields equal the
+// values of a JSON object of conditions
+func conditionFilter(conditions []byte) (engine.Filter, error) {
+	raw := strings.TrimSpace(string(conditions))
+	if raw == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("%w: conditions: %v", engine.ErrValidation, err)
+	}
+	filters := make([]engine.Filter, 0, len(fields))
+	for path, v := range fields {
+		filters = append(filters, engine.Equal(path, v))
+	}
+	return engine.And(filters...), nil
+}